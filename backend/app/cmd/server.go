@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,7 +12,9 @@ import (
 	"os/signal"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,6 +24,7 @@ import (
 	log "github.com/go-pkgz/lgr"
 	"github.com/kyokomi/emoji/v2"
 	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
 	bolt "go.etcd.io/bbolt"
 
 	"github.com/go-pkgz/auth"
@@ -29,15 +34,19 @@ import (
 	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw"
 
+	"github.com/umputun/remark42/backend/app/inbound"
 	"github.com/umputun/remark42/backend/app/migrator"
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest/api"
+	"github.com/umputun/remark42/backend/app/rest/oidc"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
+	"github.com/umputun/remark42/backend/app/rest/sso"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -53,8 +62,10 @@ type ServerCommand struct {
 	Image      ImageGroup      `group:"image" namespace:"image" env-namespace:"IMAGE"`
 	SSL        SSLGroup        `group:"ssl" namespace:"ssl" env-namespace:"SSL"`
 	ImageProxy ImageProxyGroup `group:"image-proxy" namespace:"image-proxy" env-namespace:"IMAGE_PROXY"`
+	Spam       SpamGroup       `group:"spam" namespace:"spam" env-namespace:"SPAM"`
 
 	Sites            []string      `long:"site" env:"SITE" default:"remark" description:"site names" env-delim:","`
+	SitesFile        string        `long:"sites-file" env:"SITES_FILE" default:"./var/sites.json" description:"file with sites added at runtime"`
 	AnonymousVote    bool          `long:"anon-vote" env:"ANON_VOTE" description:"enable anonymous votes (works only with VOTES_IP enabled)"`
 	AdminPasswd      string        `long:"admin-passwd" env:"ADMIN_PASSWD" default:"" description:"admin basic auth password"`
 	BackupLocation   string        `long:"backup" env:"BACKUP_PATH" default:"./var/backup" description:"backups location"`
@@ -70,6 +81,8 @@ type ServerCommand struct {
 	ReadOnlyAge      int           `long:"read-age" env:"READONLY_AGE" default:"0" description:"read-only age of comments, days"`
 	EditDuration     time.Duration `long:"edit-time" env:"EDIT_TIME" default:"5m" description:"edit window"`
 	AdminEdit        bool          `long:"admin-edit" env:"ADMIN_EDIT" description:"unlimited edit for admins"`
+	DeletedRetention time.Duration `long:"deleted-retention" env:"DELETED_RETENTION" default:"720h" description:"keep content of deleted comments for restore"`
+	PreModeration    bool          `long:"pre-moderation" env:"PRE_MODERATION" description:"keep new comments pending till admin approval"`
 	Port             int           `long:"port" env:"REMARK_PORT" default:"8080" description:"port"`
 	Address          string        `long:"address" env:"REMARK_ADDRESS" default:"" description:"listening address"`
 	WebRoot          string        `long:"web-root" env:"REMARK_WEB_ROOT" default:"./web" description:"web root directory"`
@@ -98,7 +111,11 @@ type ServerCommand struct {
 		Twitter   AuthGroup `group:"twitter" namespace:"twitter" env-namespace:"TWITTER" description:"Twitter OAuth"`
 		Dev       bool      `long:"dev" env:"DEV" description:"enable dev (local) oauth2"`
 		Anonymous bool      `long:"anon" env:"ANON" description:"enable anonymous login"`
-		Email     struct {
+		SSO       bool      `long:"sso" env:"SSO" description:"enable login with tokens signed by host site"`
+		OIDC      struct {
+			Config string `long:"config" env:"CONFIG" description:"yaml or json file with generic OpenID Connect and OAuth2 providers"`
+		} `group:"oidc" namespace:"oidc" env-namespace:"OIDC"`
+		Email struct {
 			Enable       bool          `long:"enable" env:"ENABLE" description:"enable auth via email"`
 			From         string        `long:"from" env:"FROM" description:"from email address"`
 			Subject      string        `long:"subj" env:"SUBJ" default:"remark42 confirmation" description:"email's subject"`
@@ -125,6 +142,19 @@ type ImageProxyGroup struct {
 	CacheExternal bool `long:"cache-external" env:"CACHE_EXTERNAL" description:"enable caching for external images"`
 }
 
+// SpamGroup defines options group for spam checker
+type SpamGroup struct {
+	Type    string `long:"type" env:"TYPE" description:"type of spam checker" choice:"none" choice:"akismet" choice:"rpc" default:"none"`               //nolint
+	Action  string `long:"action" env:"ACTION" description:"action for detected spam" choice:"reject" choice:"pending" choice:"mark" default:"pending"` //nolint
+	Akismet struct {
+		Key     string        `long:"key" env:"KEY" description:"akismet api key"`
+		URL     string        `long:"url" env:"URL" default:"https://rest.akismet.com" description:"akismet-compatible api base url"`
+		Blog    string        `long:"blog" env:"BLOG" description:"blog url, taken from post url if not set"`
+		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"akismet timeout"`
+	} `group:"akismet" namespace:"akismet" env-namespace:"AKISMET"`
+	RPC RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+}
+
 // AuthGroup defines options group for auth params
 type AuthGroup struct {
 	CID  string `long:"cid" env:"CID" description:"OAuth client ID"`
@@ -133,11 +163,19 @@ type AuthGroup struct {
 
 // StoreGroup defines options group for store params
 type StoreGroup struct {
-	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"bolt" choice:"rpc" default:"bolt"` // nolint
+	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"bolt" choice:"sqlite" choice:"mongo" choice:"rpc" default:"bolt"` // nolint
 	Bolt struct {
 		Path    string        `long:"path" env:"PATH" default:"./var" description:"parent dir for bolt files"`
 		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"30s" description:"bolt timeout"`
 	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+	SQLite struct {
+		File string `long:"file" env:"FILE" default:"./var/remark.sqlite" description:"sqlite file location"`
+	} `group:"sqlite" namespace:"sqlite" env-namespace:"SQLITE"`
+	Mongo struct {
+		URL     string        `long:"url" env:"URL" default:"mongodb://localhost:27017" description:"mongo url"`
+		DB      string        `long:"db" env:"DB" default:"remark42" description:"mongo database name"`
+		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"mongo operations timeout"`
+	} `group:"mongo" namespace:"mongo" env-namespace:"MONGO"`
 	RPC RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
 }
 
@@ -184,12 +222,18 @@ type CacheGroup struct {
 
 // AdminGroup defines options group for admin params
 type AdminGroup struct {
-	Type   string `long:"type" env:"TYPE" description:"type of admin store" choice:"shared" choice:"rpc" default:"shared"` //nolint
-	Shared struct {
-		Admins []string `long:"id" env:"ID" description:"admin(s) ids" env-delim:","`
-		Email  []string `long:"email" env:"EMAIL" description:"admin emails" env-delim:","`
+	Type           string        `long:"type" env:"TYPE" description:"type of admin store" choice:"shared" choice:"rpc" choice:"file" default:"shared"` //nolint
+	ModeratorBlock time.Duration `long:"moderator-block" env:"MODERATOR_BLOCK" default:"168h" description:"max block duration allowed for moderators"`
+	Shared         struct {
+		Admins     []string `long:"id" env:"ID" description:"admin(s) ids" env-delim:","`
+		Moderators []string `long:"moderator" env:"MODERATOR" description:"moderator(s) ids" env-delim:","`
+		Email      []string `long:"email" env:"EMAIL" description:"admin emails" env-delim:","`
 	} `group:"shared" namespace:"shared" env-namespace:"SHARED"`
-	RPC RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+	RPC  RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+	File struct {
+		Path     string `long:"path" env:"PATH" default:"./var/admin.yml" description:"admin store file, yaml or json"`
+		EventLog string `long:"event-log" env:"EVENT_LOG" description:"events log file, disabled if empty"`
+	} `group:"file" namespace:"file" env-namespace:"FILE"`
 }
 
 // TelegramGroup defines token for Telegram used in notify and auth modules
@@ -210,25 +254,57 @@ type SMTPGroup struct {
 
 // NotifyGroup defines options for notification
 type NotifyGroup struct {
-	Type      []string `long:"type" env:"TYPE" description:"[deprecated, use user and admin types instead] types of notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","` //nolint
-	Users     []string `long:"users" env:"USERS" description:"types of user notifications" choice:"none" choice:"email" choice:"telegram" default:"none" env-delim:","`                                                        //nolint
-	Admins    []string `long:"admins" env:"ADMINS" description:"types of admin notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","`                                      //nolint
-	QueueSize int      `long:"queue" env:"QUEUE" description:"size of notification queue" default:"100"`
-	Telegram  struct {
+	Type            []string `long:"type" env:"TYPE" description:"[deprecated, use user and admin types instead] types of notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","` //nolint
+	Users           []string `long:"users" env:"USERS" description:"types of user notifications" choice:"none" choice:"email" choice:"telegram" default:"none" env-delim:","`                                                        //nolint
+	Admins          []string `long:"admins" env:"ADMINS" description:"types of admin notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" choice:"webhook" default:"none" env-delim:","`                     //nolint
+	QueueSize       int      `long:"queue" env:"QUEUE" description:"size of notification queue" default:"100"`
+	ReportThreshold int      `long:"report-threshold" env:"REPORT_THRESHOLD" description:"number of user reports to notify admins about comment" default:"3"`
+	Telegram        struct {
 		Channel string        `long:"chan" env:"CHAN" description:"telegram channel for admin notifications"`
 		API     string        `long:"api" env:"API" default:"https://api.telegram.org/bot" description:"[deprecated, not used] telegram api prefix"`
 		Token   string        `long:"token" env:"TOKEN" description:"[deprecated, use --telegram.token] telegram token"`
 		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"[deprecated, use --telegram.timeout] telegram timeout"`
+		Buttons bool          `long:"buttons" env:"BUTTONS" description:"attach moderation buttons to admin channel messages"`
+		Admin   []string      `long:"admin" env:"ADMIN" description:"telegram users allowed to moderate, telegram_id:user_id" env-delim:","`
+		Updates string        `long:"updates" env:"UPDATES" description:"how to receive button presses" choice:"poll" choice:"webhook" default:"poll"`
 	} `group:"telegram" namespace:"telegram" env-namespace:"TELEGRAM"`
 	Email struct {
 		From                string `long:"from_address" env:"FROM" description:"from email address"`
 		VerificationSubject string `long:"verification_subj" env:"VERIFICATION_SUBJ" description:"verification message subject"`
 		AdminNotifications  bool   `long:"notify_admin" env:"ADMIN" description:"[deprecated, use --notify.admins=email] notify admin on new comments via ADMIN_SHARED_EMAIL"`
+		Digest              bool   `long:"digest" env:"DIGEST" description:"allow users to get hourly or daily digest instead of email per reply"`
+		AdminDigest         bool   `long:"admin_digest" env:"ADMIN_DIGEST" description:"send daily digest of new comments to admins instead of email per comment"`
+		DigestHour          int    `long:"digest_hour" env:"DIGEST_HOUR" default:"8" description:"hour of the day to send daily digests"`
+		DigestFile          string `long:"digest_file" env:"DIGEST_FILE" default:"./var/digest.db" description:"digest bolt file location"`
+		ReplyAddress        string `long:"reply_address" env:"REPLY_ADDRESS" description:"base address for replies by email, i.e. reply@example.com"`
+		ReplyListen         string `long:"reply_listen" env:"REPLY_LISTEN" default:":2525" description:"listen address of inbound smtp server for replies"`
+		ReplyFile           string `long:"reply_file" env:"REPLY_FILE" default:"./var/reply.db" description:"reply tokens bolt file location"`
 	} `group:"email" namespace:"email" env-namespace:"EMAIL"`
 	Slack struct {
-		Token   string `long:"token" env:"TOKEN" description:"slack token"`
-		Channel string `long:"chan" env:"CHAN" description:"slack channel"`
+		Token         string   `long:"token" env:"TOKEN" description:"slack token"`
+		Channel       string   `long:"chan" env:"CHAN" description:"slack channel"`
+		API           string   `long:"api" env:"API" default:"https://slack.com/api/" description:"slack api base url"`
+		Buttons       bool     `long:"buttons" env:"BUTTONS" description:"attach moderation buttons to slack messages"`
+		SigningSecret string   `long:"signing-secret" env:"SIGNING_SECRET" description:"signing secret of slack app, verifies button presses"`
+		Admin         []string `long:"admin" env:"ADMIN" description:"slack users allowed to moderate, slack_id:user_id" env-delim:","`
 	} `group:"slack" namespace:"slack" env-namespace:"SLACK"`
+	Webhook struct {
+		URL        []string      `long:"url" env:"URL" description:"webhook urls for admin notifications" env-delim:","`
+		Secret     string        `long:"secret" env:"SECRET" description:"secret to sign webhook body with hmac-sha256"`
+		Template   string        `long:"template" env:"TEMPLATE" description:"path to webhook body template, json if not set"`
+		Headers    []string      `long:"header" env:"HEADER" description:"additional webhook headers, name:value" env-delim:","`
+		Timeout    time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"webhook timeout"`
+		Retries    int           `long:"retries" env:"RETRIES" default:"5" description:"webhook attempts, used without outbox only"`
+		RetryDelay time.Duration `long:"retry-delay" env:"RETRY_DELAY" default:"1s" description:"delay before the first retry, doubled for next ones"`
+	} `group:"webhook" namespace:"webhook" env-namespace:"WEBHOOK"`
+	Outbox struct {
+		Type          string        `long:"type" env:"TYPE" description:"type of notification outbox" choice:"none" choice:"bolt" default:"bolt"`
+		File          string        `long:"file" env:"FILE" default:"./var/notify.db" description:"outbox bolt file location"`
+		MaxAttempts   int           `long:"max-attempts" env:"MAX_ATTEMPTS" default:"10" description:"delivery attempts before notification marked as failed"`
+		RetryDelay    time.Duration `long:"retry-delay" env:"RETRY_DELAY" default:"1m" description:"delay before the first retry, doubled for next ones"`
+		MaxRetryDelay time.Duration `long:"max-retry-delay" env:"MAX_RETRY_DELAY" default:"6h" description:"max delay between retries"`
+		DeadRetention time.Duration `long:"dead-retention" env:"DEAD_RETENTION" default:"720h" description:"how long failed notifications kept for resend"`
+	} `group:"outbox" namespace:"outbox" env-namespace:"OUTBOX"`
 }
 
 // SSLGroup defines options group for server ssl params
@@ -266,10 +342,18 @@ type serverApp struct {
 	dataService   *service.DataStore
 	avatarStore   avatar.Store
 	notifyService *notify.Service
+	emailService  *notify.Email    // set if email notifications enabled, runs email digest
+	replyGateway  *inbound.Gateway // set if reply by email enabled
+	telegram      *notify.Telegram // set if telegram moderation buttons enabled, receives button presses
 	imageService  *image.Service
 	authenticator *auth.Service
+	sites         *admin.Sites
+	provisioner   *siteProvisioner // set if data store supports runtime sites
 	terminated    chan struct{}
 
+	siteJobs     map[string]context.CancelFunc // cancel functions of per-site background jobs
+	siteJobsLock sync.Mutex
+
 	authRefreshCache *authRefreshCache // stored only to close it properly on shutdown
 }
 
@@ -400,12 +484,17 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 	}
 	log.Printf("[INFO] root url=%s", s.RemarkURL)
 
-	storeEngine, err := s.makeDataStore()
+	sites, err := admin.NewSites(s.SitesFile, s.Sites...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load sites")
+	}
+
+	storeEngine, err := s.makeDataStore(sites.EnabledIDs())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make data store engine")
 	}
 
-	adminStore, err := s.makeAdminStore()
+	adminStore, err := s.makeAdminStore(sites)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make admin store")
 	}
@@ -420,6 +509,8 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		Engine:                 storeEngine,
 		EditDuration:           s.EditDuration,
 		AdminEdits:             s.AdminEdit,
+		PreModeration:          s.PreModeration,
+		DeletedRetention:       s.DeletedRetention,
 		AdminStore:             adminStore,
 		MaxCommentSize:         s.MaxCommentSize,
 		MaxVotes:               s.MaxVotes,
@@ -428,6 +519,10 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		TitleExtractor:         service.NewTitleExtractor(http.Client{Timeout: time.Second * 5}),
 		RestrictedWordsMatcher: service.NewRestrictedWordsMatcher(service.StaticRestrictedWordsLister{Words: s.RestrictedWords}),
 	}
+	if dataService.SpamChecker, err = s.makeSpamChecker(); err != nil {
+		return nil, errors.Wrap(err, "failed to make spam checker")
+	}
+	dataService.SpamAction = service.SpamAction(s.Spam.Action)
 	dataService.RestrictSameIPVotes.Enabled = s.RestrictVoteIP
 	dataService.RestrictSameIPVotes.Duration = s.DurationVoteIP
 
@@ -459,18 +554,20 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		NativeExporter:    &migrator.Native{DataStore: dataService},
 		URLMapperMaker:    migrator.NewURLMapper,
 		KeyStore:          adminStore,
+		AuditStore:        dataService,
 	}
 
 	var emailNotifications bool
-	notifyService, telegramBotUsername, err := s.makeNotify(dataService, authenticator)
+	notifyService, emailService, telegram, slackDest, err := s.makeNotify(dataService, authenticator)
 
 	if contains("email", s.Notify.Users) {
 		emailNotifications = true
 	}
 
 	// we pass telegramBotUsername to Rest server only if user notifications are enabled
-	if !contains("telegram", s.Notify.Users) {
-		telegramBotUsername = ""
+	var telegramBotUsername string
+	if telegram != nil && contains("telegram", s.Notify.Users) {
+		telegramBotUsername = telegram.BotUsername
 	}
 
 	if err != nil {
@@ -478,6 +575,9 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		notifyService = notify.NopService // disable notifier
 		emailNotifications = false        // email notifications are not available in this case
 		telegramBotUsername = ""          // telegram notifications are not available in this case either
+		emailService = nil
+		telegram = nil
+		slackDest = nil
 	}
 
 	imgProxy := &proxy.Image{
@@ -499,6 +599,12 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		return nil, errors.Wrap(err, "failed to make config of ssl server params")
 	}
 
+	streamBroker, err := s.makeStreamBroker()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, errors.Wrap(err, "failed to make stream broker")
+	}
+
 	srv := &api.Rest{
 		Version:             s.Revision,
 		DataService:         dataService,
@@ -508,12 +614,14 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		CommentFormatter:    commentFormatter,
 		Migrator:            migr,
 		ReadOnlyAge:         s.ReadOnlyAge,
+		ModeratorBlock:      s.Admin.ModeratorBlock,
 		SharedSecret:        s.SharedSecret,
 		Authenticator:       authenticator,
 		Cache:               loadingCache,
 		NotifyService:       notifyService,
 		SSLConfig:           sslConfig,
 		UpdateLimiter:       s.UpdateLimit,
+		ReportThreshold:     s.Notify.ReportThreshold,
 		ImageService:        imageService,
 		EmailNotifications:  emailNotifications,
 		TelegramBotUsername: telegramBotUsername,
@@ -523,10 +631,69 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		ProxyCORS:           s.ProxyCORS,
 		AllowedAncestors:    s.AllowedHosts,
 		SendJWTHeader:       s.Auth.SendJWTHeader,
+		StreamBroker:        streamBroker,
+	}
+	if emailService != nil && emailService.Digest != nil {
+		srv.EmailDigest = emailService.Digest
+	}
+
+	// moderation buttons of admin notifications, shared by messengers
+	moderator := &api.Moderator{DataService: dataService, Cache: loadingCache, NotifyService: notifyService,
+		StreamBroker: streamBroker}
+
+	if telegram != nil && s.Notify.Telegram.Buttons {
+		admins, errAdmins := parseTelegramAdmins(s.Notify.Telegram.Admin)
+		if errAdmins != nil {
+			_ = dataService.Close()
+			return nil, errors.Wrap(errAdmins, "failed to parse telegram admins")
+		}
+		telegram.Admins, telegram.Moderator = admins, moderator
+		if s.Notify.Telegram.Updates == "webhook" {
+			srv.TelegramBot, srv.TelegramSecret = telegram, s.telegramWebhookSecret()
+		}
+	} else {
+		telegram = nil // updates are not needed without buttons
+	}
+
+	if slackDest != nil && s.Notify.Slack.Buttons {
+		if s.Notify.Slack.SigningSecret == "" {
+			_ = dataService.Close()
+			return nil, errors.New("--notify.slack.signing-secret must be set for moderation buttons to work")
+		}
+		admins, errAdmins := parseSlackAdmins(s.Notify.Slack.Admin)
+		if errAdmins != nil {
+			_ = dataService.Close()
+			return nil, errors.Wrap(errAdmins, "failed to parse slack admins")
+		}
+		slackDest.Buttons, slackDest.Admins, slackDest.Moderator = true, admins, moderator
+		srv.SlackBot, srv.SlackSecret = slackDest, s.Notify.Slack.SigningSecret
+	}
+
+	var replyGateway *inbound.Gateway
+	if emailService != nil && s.Notify.Email.ReplyAddress != "" {
+		replyGateway = &inbound.Gateway{
+			GatewayParams: inbound.GatewayParams{
+				Address:     s.Notify.Email.ReplyListen,
+				Domain:      s.Notify.Email.ReplyAddress[strings.LastIndex(s.Notify.Email.ReplyAddress, "@")+1:],
+				ReadOnlyAge: s.ReadOnlyAge,
+			},
+			DataStore:        dataService,
+			ReplyStore:       emailService.ReplyStore,
+			CommentFormatter: commentFormatter,
+			NotifyService:    notifyService,
+			Cache:            loadingCache,
+			StreamBroker:     streamBroker,
+		}
 	}
 
 	srv.ScoreThresholds.Low, srv.ScoreThresholds.Critical = s.LowScore, s.CriticalScore
 
+	var provisioner *siteProvisioner
+	if s.Store.Type == "bolt" || s.Store.Type == "sqlite" || s.Store.Type == "mongo" {
+		provisioner = &siteProvisioner{sites: sites, engine: storeEngine, boltPath: s.Store.Bolt.Path}
+		srv.SiteProvisioner = provisioner
+	}
+
 	var devAuth *provider.DevAuthServer
 	if s.Auth.Dev {
 		da, errDevAuth := authenticator.DevAuth()
@@ -546,9 +713,15 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		dataService:      dataService,
 		avatarStore:      avatarStore,
 		notifyService:    notifyService,
+		emailService:     emailService,
+		replyGateway:     replyGateway,
+		telegram:         telegram,
 		imageService:     imageService,
 		authenticator:    authenticator,
+		sites:            sites,
+		provisioner:      provisioner,
 		terminated:       make(chan struct{}),
+		siteJobs:         map[string]context.CancelFunc{},
 		authRefreshCache: authRefreshCache,
 	}, nil
 }
@@ -566,18 +739,47 @@ func (a *serverApp) run(ctx context.Context) error {
 		a.restSrv.Shutdown()
 	}()
 
-	a.activateBackup(ctx) // runs in goroutine for each site
+	for _, siteID := range a.sites.EnabledIDs() {
+		a.startSiteJobs(ctx, siteID) // runs in goroutines for each site
+	}
+	if a.provisioner != nil {
+		// sites added or removed at runtime start and stop their own background jobs
+		a.provisioner.onOpen = func(siteID string) { a.startSiteJobs(ctx, siteID) }
+		a.provisioner.onClose = a.stopSiteJobs
+	}
 	if a.Auth.Dev {
 		go a.devAuth.Run(ctx) // dev oauth2 server on :8084
 	}
 
 	// staging images resubmit after restart of the app
-	if e := a.dataService.ResubmitStagingImages(a.Sites); e != nil {
+	if e := a.dataService.ResubmitStagingImages(a.sites.EnabledIDs()); e != nil {
 		log.Printf("[WARN] failed to resubmit comments with staging images, %s", e)
 	}
 
 	go a.imageService.Cleanup(ctx) // pictures cleanup for staging images
 
+	if a.emailService != nil {
+		go a.emailService.RunDigest(ctx) // send hourly and daily email digests
+	}
+
+	if a.telegram != nil {
+		if a.Notify.Telegram.Updates == "webhook" {
+			if e := a.telegram.SetWebhook(ctx, a.RemarkURL+"/api/v1/telegram/webhook", a.telegramWebhookSecret()); e != nil {
+				log.Printf("[WARN] failed to set telegram webhook, %s", e)
+			}
+		} else {
+			go a.telegram.RunUpdates(ctx) // receive moderation button presses
+		}
+	}
+
+	if a.replyGateway != nil {
+		go func() {
+			if e := a.replyGateway.Run(ctx); e != nil {
+				log.Printf("[WARN] reply by email gateway failed, %s", e)
+			}
+		}()
+	}
+
 	a.restSrv.Run(a.Address, a.Port)
 
 	// shutdown procedures after HTTP server is stopped
@@ -597,6 +799,16 @@ func (a *serverApp) run(ctx context.Context) error {
 		log.Printf("[WARN] failed to close auth authRefreshCache, %s", e)
 	}
 	a.notifyService.Close()
+	if a.emailService != nil && a.emailService.Digest != nil {
+		if e := a.emailService.Digest.Close(); e != nil {
+			log.Printf("[WARN] failed to close email digest store, %s", e)
+		}
+	}
+	if a.emailService != nil && a.emailService.ReplyStore != nil {
+		if e := a.emailService.ReplyStore.Close(); e != nil {
+			log.Printf("[WARN] failed to close email reply store, %s", e)
+		}
+	}
 	// call potentially infinite loop with cancellation after a minute as a safeguard
 	minuteCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -611,22 +823,40 @@ func (a *serverApp) Wait() {
 	<-a.terminated
 }
 
-// activateBackup runs background backups for each site
-func (a *serverApp) activateBackup(ctx context.Context) {
-	for _, siteID := range a.Sites {
-		backup := migrator.AutoBackup{
-			Exporter:       a.exporter,
-			BackupLocation: a.BackupLocation,
-			SiteID:         siteID,
-			KeepMax:        a.MaxBackupFiles,
-			Duration:       24 * time.Hour,
-		}
-		go backup.Do(ctx)
+// startSiteJobs runs background backups and purge of deleted comments content for the site,
+// till context canceled or stopSiteJobs called
+func (a *serverApp) startSiteJobs(ctx context.Context, siteID string) {
+	a.siteJobsLock.Lock()
+	defer a.siteJobsLock.Unlock()
+	if _, ok := a.siteJobs[siteID]; ok {
+		return
+	}
+	siteCtx, cancel := context.WithCancel(ctx)
+	a.siteJobs[siteID] = cancel
+
+	backup := migrator.AutoBackup{
+		Exporter:       a.exporter,
+		BackupLocation: a.BackupLocation,
+		SiteID:         siteID,
+		KeepMax:        a.MaxBackupFiles,
+		Duration:       24 * time.Hour,
+	}
+	go backup.Do(siteCtx)
+	go a.dataService.CleanupRetained(siteCtx, []string{siteID}) // purge content of deleted comments after retention window
+}
+
+// stopSiteJobs terminates background jobs of the site
+func (a *serverApp) stopSiteJobs(siteID string) {
+	a.siteJobsLock.Lock()
+	defer a.siteJobsLock.Unlock()
+	if cancel, ok := a.siteJobs[siteID]; ok {
+		cancel()
+		delete(a.siteJobs, siteID)
 	}
 }
 
-// makeDataStore creates store for all sites
-func (s *ServerCommand) makeDataStore() (result engine.Interface, err error) {
+// makeDataStore creates store for all given sites
+func (s *ServerCommand) makeDataStore(siteIDs []string) (result engine.Interface, err error) {
 	log.Printf("[INFO] make data store, type=%s", s.Store.Type)
 
 	switch s.Store.Type {
@@ -635,10 +865,17 @@ func (s *ServerCommand) makeDataStore() (result engine.Interface, err error) {
 			return nil, errors.Wrap(err, "failed to create bolt store")
 		}
 		sites := []engine.BoltSite{}
-		for _, site := range s.Sites {
+		for _, site := range siteIDs {
 			sites = append(sites, engine.BoltSite{SiteID: site, FileName: fmt.Sprintf("%s/%s.db", s.Store.Bolt.Path, site)})
 		}
 		result, err = engine.NewBoltDB(bolt.Options{Timeout: s.Store.Bolt.Timeout}, sites...)
+	case "sqlite":
+		if err = makeDirs(path.Dir(s.Store.SQLite.File)); err != nil {
+			return nil, errors.Wrap(err, "failed to create sqlite store")
+		}
+		result, err = engine.NewSQLite(s.Store.SQLite.File, siteIDs...)
+	case "mongo":
+		result, err = engine.NewMongo(s.Store.Mongo.URL, s.Store.Mongo.DB, s.Store.Mongo.Timeout, siteIDs...)
 	case "rpc":
 		r := &engine.RPC{Client: jrpc.Client{
 			API:        s.Store.RPC.API,
@@ -710,7 +947,7 @@ func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 	return nil, errors.Errorf("unsupported pictures store type %s", s.Image.Type)
 }
 
-func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
+func (s *ServerCommand) makeAdminStore(sites *admin.Sites) (admin.Store, error) {
 	log.Printf("[INFO] make admin store, type=%s", s.Admin.Type)
 
 	switch s.Admin.Type {
@@ -723,7 +960,10 @@ func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
 		} else {
 			sharedAdminEmail = s.Admin.Shared.Email[0]
 		}
-		return admin.NewStaticStore(s.SharedSecret, s.Sites, s.Admin.Shared.Admins, sharedAdminEmail), nil
+		res := admin.NewStaticStore(s.SharedSecret, s.Sites, s.Admin.Shared.Admins, sharedAdminEmail)
+		res.SetModerators(s.Admin.Shared.Moderators)
+		res.SetSites(sites)
+		return res, nil
 	case "rpc":
 		r := &admin.RPC{Client: jrpc.Client{
 			API:        s.Admin.RPC.API,
@@ -732,11 +972,53 @@ func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
 			AuthPasswd: s.Admin.RPC.AuthPassword,
 		}}
 		return r, nil
+	case "file":
+		return admin.NewFileStore(s.Admin.File.Path, s.SharedSecret, s.Admin.File.EventLog)
 	default:
 		return nil, errors.Errorf("unsupported admin store type %s", s.Admin.Type)
 	}
 }
 
+// makeSpamChecker returns nil spam checker for "none" type
+func (s *ServerCommand) makeSpamChecker() (service.SpamChecker, error) {
+	log.Printf("[INFO] make spam checker, type=%s, action=%s", s.Spam.Type, s.Spam.Action)
+	switch s.Spam.Type {
+	case "none", "":
+		return nil, nil
+	case "akismet":
+		if s.Spam.Akismet.Key == "" {
+			return nil, errors.New("akismet key is not set")
+		}
+		return &service.Akismet{
+			BaseURL: s.Spam.Akismet.URL,
+			Key:     s.Spam.Akismet.Key,
+			Blog:    s.Spam.Akismet.Blog,
+			Client:  http.Client{Timeout: s.Spam.Akismet.Timeout},
+		}, nil
+	case "rpc":
+		return &service.SpamRPC{Client: jrpc.Client{
+			API:        s.Spam.RPC.API,
+			Client:     http.Client{Timeout: s.Spam.RPC.TimeOut},
+			AuthUser:   s.Spam.RPC.AuthUser,
+			AuthPasswd: s.Spam.RPC.AuthPassword,
+		}}, nil
+	default:
+		return nil, errors.Errorf("unsupported spam checker type %s", s.Spam.Type)
+	}
+}
+
+// makeStreamBroker makes broker of real-time updates, shared between instances via redis for redis_pub_sub cache
+func (s *ServerCommand) makeStreamBroker() (*stream.Broker, error) {
+	if s.Cache.Type != "redis_pub_sub" {
+		return stream.NewBroker(nil)
+	}
+	redisPubSub, err := eventbus.NewRedisPubSub(s.Cache.RedisAddr, "remark42-stream")
+	if err != nil {
+		return nil, errors.Wrap(err, "stream broker initialization, redis PubSub initialisation")
+	}
+	return stream.NewBroker(redisPubSub)
+}
+
 func (s *ServerCommand) makeCache() (LoadingCache, error) {
 	log.Printf("[INFO] make cache, type=%s", s.Cache.Type)
 	switch s.Cache.Type {
@@ -792,6 +1074,21 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 		providers++
 	}
 
+	if s.Auth.OIDC.Config != "" {
+		oidcProviders, err := oidc.LoadProviders(s.Auth.OIDC.Config)
+		if err != nil {
+			return errors.Wrap(err, "failed to load generic auth providers")
+		}
+		for _, p := range oidcProviders {
+			opts, err := p.HandlerOpt(nil)
+			if err != nil {
+				return errors.Wrapf(err, "failed to make auth provider %s", p.Name)
+			}
+			authenticator.AddCustomProvider(p.Name, auth.Client{Cid: p.CID, Csecret: p.CSecret}, opts)
+			providers++
+		}
+	}
+
 	if s.Auth.Dev {
 		log.Print("[INFO] dev access enabled")
 		authenticator.AddProvider("dev", "", "")
@@ -875,36 +1172,80 @@ func (s *ServerCommand) loadEmailTemplate() (string, error) {
 	return string(file), nil
 }
 
-// aside from notify.Service and error, returns telegram bot name which will be passed to the frontend
-func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *auth.Service) (*notify.Service, string, error) {
+// aside from notify.Service and error, returns email destination running digests,
+// telegram destination with bot name which will be passed to the frontend and slack destination
+func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *auth.Service) (*notify.Service, *notify.Email, *notify.Telegram, *notify.Slack, error) {
 	notifyService := notify.NopService
 	var destinations []notify.Destination
-	var telegramBotUsername string
+	var emailService *notify.Email
+	var telegram *notify.Telegram
+	var slackDest *notify.Slack
+
+	// per-site destinations of admin notifications, replacing ones set by options for the site
+	notifyStore, hasSiteNotify := dataStore.AdminStore.(admin.NotifyStore)
+	siteNotify := func(siteID string) admin.Notify {
+		dest, err := notifyStore.Notify(siteID)
+		if err != nil {
+			log.Printf("[WARN] can't get admin notification destinations for %s, %v", siteID, err)
+		}
+		return dest
+	}
 
 	if contains("slack", s.Notify.Admins) {
-		slack, err := notify.NewSlack(s.Notify.Slack.Token, s.Notify.Slack.Channel)
+		sl, err := notify.NewSlack(s.Notify.Slack.Token, s.Notify.Slack.Channel, slack.OptionAPIURL(s.Notify.Slack.API))
 		if err != nil {
-			return nil, "", errors.Wrap(err, "failed to create slack notification destination")
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create slack notification destination")
+		}
+		destinations = append(destinations, sl)
+		slackDest = sl
+	}
+
+	if contains("webhook", s.Notify.Admins) {
+		webhookParams := notify.WebhookParams{
+			URLs:       s.Notify.Webhook.URL,
+			Secret:     s.Notify.Webhook.Secret,
+			Headers:    s.Notify.Webhook.Headers,
+			Timeout:    s.Notify.Webhook.Timeout,
+			Retries:    s.Notify.Webhook.Retries,
+			RetryDelay: s.Notify.Webhook.RetryDelay,
+		}
+		if hasSiteNotify {
+			webhookParams.SiteURLs = func(siteID string) []string { return siteNotify(siteID).Webhooks }
+		}
+		if s.Notify.Webhook.Template != "" {
+			tmpl, err := ioutil.ReadFile(s.Notify.Webhook.Template)
+			if err != nil {
+				return nil, nil, nil, nil, errors.Wrapf(err, "failed to read webhook template %s", s.Notify.Webhook.Template)
+			}
+			webhookParams.Template = string(tmpl)
 		}
-		destinations = append(destinations, slack)
+		webhook, err := notify.NewWebhook(webhookParams)
+		if err != nil {
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create webhook notification destination")
+		}
+		destinations = append(destinations, webhook)
 	}
 
 	if contains("telegram", s.Notify.Users) || contains("telegram", s.Notify.Admins) {
 		if contains("telegram", s.Notify.Admins) && s.Notify.Telegram.Channel == "" {
-			return nil, "", errors.New("--notify.telegram.channel must be set for admin notifications to work")
+			return nil, nil, nil, nil, errors.New("--notify.telegram.channel must be set for admin notifications to work")
 		}
 		telegramParams := notify.TelegramParams{
 			AdminChannelID:    s.Notify.Telegram.Channel,
 			UserNotifications: contains("telegram", s.Notify.Users),
 			Token:             s.Telegram.Token,
 			Timeout:           s.Telegram.Timeout,
+			AdminButtons:      s.Notify.Telegram.Buttons,
+		}
+		if hasSiteNotify && contains("telegram", s.Notify.Admins) {
+			telegramParams.SiteAdminChannel = func(siteID string) string { return siteNotify(siteID).Telegram }
 		}
 		tg, err := notify.NewTelegram(telegramParams)
 		if err != nil {
-			return nil, "", errors.Wrap(err, "failed to create telegram notification destination")
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create telegram notification destination")
 		}
 		destinations = append(destinations, tg)
-		telegramBotUsername = tg.BotUsername
+		telegram = tg
 	}
 
 	// with logic below admin notifications enable notifications for users on the backend even if they
@@ -937,6 +1278,35 @@ func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *
 		}
 		if contains("email", s.Notify.Admins) {
 			emailParams.AdminEmails = s.Admin.Shared.Email
+			if hasSiteNotify {
+				emailParams.SiteAdminEmails = func(siteID string) []string { return siteNotify(siteID).Emails }
+			}
+		}
+		if s.Notify.Email.Digest || s.Notify.Email.AdminDigest {
+			if err := makeDirs(path.Dir(s.Notify.Email.DigestFile)); err != nil {
+				return nil, nil, nil, nil, errors.Wrap(err, "failed to create email digest directory")
+			}
+			digest, err := notify.NewBoltDigest(s.Notify.Email.DigestFile, bolt.Options{Timeout: 30 * time.Second})
+			if err != nil {
+				return nil, nil, nil, nil, errors.Wrap(err, "failed to create email digest store")
+			}
+			emailParams.Digest = digest
+			emailParams.CommentFn = func(locator store.Locator, id string) (store.Comment, error) {
+				return dataStore.Get(locator, id, store.User{})
+			}
+			emailParams.AdminDigest = s.Notify.Email.AdminDigest
+			emailParams.DigestHour = s.Notify.Email.DigestHour
+		}
+		if s.Notify.Email.ReplyAddress != "" {
+			if err := makeDirs(path.Dir(s.Notify.Email.ReplyFile)); err != nil {
+				return nil, nil, nil, nil, errors.Wrap(err, "failed to create email reply directory")
+			}
+			replyStore, err := notify.NewBoltReply(s.Notify.Email.ReplyFile, bolt.Options{Timeout: 30 * time.Second})
+			if err != nil {
+				return nil, nil, nil, nil, errors.Wrap(err, "failed to create email reply store")
+			}
+			emailParams.ReplyAddress = s.Notify.Email.ReplyAddress
+			emailParams.ReplyStore = replyStore
 		}
 		smtpParams := notify.SMTPParams{
 			Host:     s.SMTP.Host,
@@ -946,18 +1316,78 @@ func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *
 			Password: s.SMTP.Password,
 			TimeOut:  s.SMTP.TimeOut,
 		}
-		emailService, err := notify.NewEmail(emailParams, smtpParams)
+		var err error
+		emailService, err = notify.NewEmail(emailParams, smtpParams)
 		if err != nil {
-			return nil, "", errors.Wrap(err, "failed to create email notification destination")
+			if emailParams.Digest != nil {
+				_ = emailParams.Digest.Close()
+			}
+			if emailParams.ReplyStore != nil {
+				_ = emailParams.ReplyStore.Close()
+			}
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create email notification destination")
 		}
 		destinations = append(destinations, emailService)
 	}
 
 	if len(destinations) > 0 {
 		log.Printf("[INFO] make notify, for users: %s, for admins: %s", s.Notify.Users, s.Notify.Admins)
-		notifyService = notify.NewService(dataStore, s.Notify.QueueSize, destinations...)
+		if s.Notify.Outbox.Type == "none" {
+			notifyService = notify.NewService(dataStore, s.Notify.QueueSize, destinations...)
+			return notifyService, emailService, telegram, slackDest, nil
+		}
+		if err := makeDirs(path.Dir(s.Notify.Outbox.File)); err != nil {
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create notification outbox directory")
+		}
+		outbox, err := notify.NewBoltOutbox(s.Notify.Outbox.File, bolt.Options{Timeout: 30 * time.Second})
+		if err != nil {
+			return nil, nil, nil, nil, errors.Wrap(err, "failed to create notification outbox")
+		}
+		params := notify.OutboxParams{
+			MaxAttempts:   s.Notify.Outbox.MaxAttempts,
+			RetryDelay:    s.Notify.Outbox.RetryDelay,
+			MaxRetryDelay: s.Notify.Outbox.MaxRetryDelay,
+			DeadRetention: s.Notify.Outbox.DeadRetention,
+		}
+		notifyService = notify.NewOutboxService(dataStore, outbox, params, destinations...)
 	}
-	return notifyService, telegramBotUsername, nil
+	return notifyService, emailService, telegram, slackDest, nil
+}
+
+// parseTelegramAdmins makes map of telegram user ids to remark42 user ids from the list of telegram_id:user_id
+func parseTelegramAdmins(list []string) (map[int64]string, error) {
+	res := make(map[int64]string, len(list))
+	for _, elem := range list {
+		elems := strings.SplitN(elem, ":", 2)
+		if len(elems) != 2 || elems[1] == "" {
+			return nil, errors.Errorf("invalid telegram admin %q, expected telegram_id:user_id", elem)
+		}
+		tgID, err := strconv.ParseInt(strings.TrimSpace(elems[0]), 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid telegram id in %q", elem)
+		}
+		res[tgID] = strings.TrimSpace(elems[1])
+	}
+	return res, nil
+}
+
+// parseSlackAdmins makes map of slack user ids to remark42 user ids from the list of slack_id:user_id
+func parseSlackAdmins(list []string) (map[string]string, error) {
+	res := make(map[string]string, len(list))
+	for _, elem := range list {
+		elems := strings.SplitN(elem, ":", 2)
+		if len(elems) != 2 || strings.TrimSpace(elems[0]) == "" || strings.TrimSpace(elems[1]) == "" {
+			return nil, errors.Errorf("invalid slack admin %q, expected slack_id:user_id", elem)
+		}
+		res[strings.TrimSpace(elems[0])] = strings.TrimSpace(elems[1])
+	}
+	return res, nil
+}
+
+// telegramWebhookSecret derives secret of telegram webhook from shared secret
+func (s *ServerCommand) telegramWebhookSecret() string {
+	h := sha256.Sum256([]byte("telegram-webhook:" + s.SharedSecret))
+	return hex.EncodeToString(h[:])
 }
 
 func (s *ServerCommand) makeSSLConfig() (config api.SSLConfig, err error) {
@@ -991,6 +1421,7 @@ func (s *ServerCommand) makeSSLConfig() (config api.SSLConfig, err error) {
 }
 
 func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Store, admns admin.Store, authRefreshCache *authRefreshCache) (*auth.Service, error) {
+	audSecrets := s.Admin.Type == "file" // file admin store defines secret per site and rejects unknown sites
 	authenticator := auth.NewService(auth.Opts{
 		URL:            strings.TrimSuffix(s.RemarkURL, "/"),
 		Issuer:         "remark42",
@@ -999,20 +1430,27 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 		SendJWTHeader:  s.Auth.SendJWTHeader,
 		SameSiteCookie: s.parseSameSite(s.Auth.SameSite),
 		SecureCookies:  strings.HasPrefix(s.RemarkURL, "https://"),
+		AudSecrets:     audSecrets,
 		SecretReader: token.SecretFunc(func(aud string) (string, error) { // get secret per site
-			return admns.Key("")
+			if !audSecrets {
+				return admns.Key("") // secret shared across sites
+			}
+			return admns.Key(aud)
 		}),
 		ClaimsUpd: token.ClaimsUpdFunc(func(c token.Claims) token.Claims { // set attributes, on new token or refresh
 			if c.User == nil {
 				return c
 			}
-			c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID))
+			c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID) || sso.IsAdmin(c.Audience, *c.User))
 			c.User.SetBoolAttr("blocked", ds.IsBlocked(c.Audience, c.User.ID))
-			var err error
-			c.User.Email, err = ds.GetUserEmail(c.Audience, c.User.ID)
+			email, err := ds.GetUserEmail(c.Audience, c.User.ID)
 			if err != nil {
 				log.Printf("[WARN] can't read email for %s, %v", c.User.ID, err)
 			}
+			// email passed by host site kept for sso user without own email
+			if email != "" || !strings.HasPrefix(c.User.ID, sso.ProviderName+"_") {
+				c.User.Email = email
+			}
 
 			// don't allow anonymous and email with admins names
 			// exclude admin from impersonation detection over email, it prevents a valid admin to login with RestrictedNames
@@ -1030,7 +1468,7 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 		}),
 		AdminPasswd: s.AdminPasswd,
 		Validator: token.ValidatorFunc(func(token string, claims token.Claims) bool { // check on each auth call (in middleware)
-			if claims.User == nil {
+			if claims.User == nil || claims.User.ID == "" { // empty id made by generic provider without id claim
 				return false
 			}
 			if claims.User.Audience == "" { // reject empty aud, made with old (pre 0.8.x) version of auth package
@@ -1051,6 +1489,13 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 		return nil, err
 	}
 
+	if s.Auth.SSO {
+		log.Print("[INFO] sso login with host site tokens enabled")
+		authenticator.AddCustomHandler(sso.Handler{KeyStore: admns, TokenService: authenticator.TokenService(),
+			AvatarSaver: authenticator.AvatarProxy(), Issuer: "remark42",
+			AllowedHosts: append([]string{s.RemarkURL}, s.AllowedHosts...)})
+	}
+
 	return authenticator, nil
 }
 
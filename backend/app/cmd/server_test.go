@@ -22,6 +22,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
 )
 
 func TestServerApp(t *testing.T) {
@@ -65,6 +67,142 @@ func TestServerApp(t *testing.T) {
 	app.Wait()
 }
 
+func TestServerApp_Sites(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		return o
+	})
+	defer os.Remove(app.Store.Bolt.Path + "/blog.db")
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	defer client.CloseIdleConnections()
+	send := func(method, url, body string) (string, int) {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", port, url), strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b), resp.StatusCode
+	}
+	comment := `{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "blog"}}`
+
+	_, code := send("POST", "/api/v1/comment", comment)
+	assert.Equal(t, http.StatusInternalServerError, code, "unknown site")
+
+	_, code = send("POST", "/api/v1/admin/sites/blog", "")
+	require.Equal(t, http.StatusCreated, code)
+	_, code = send("POST", "/api/v1/comment", comment)
+	assert.Equal(t, http.StatusCreated, code, "site added without restart")
+	assert.FileExists(t, app.Store.Bolt.Path+"/blog.db")
+	assert.FileExists(t, app.SitesFile)
+
+	body, code := send("GET", "/api/v1/admin/sites", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"id":"blog","enabled":true`)
+	assert.Contains(t, body, `{"id":"remark","enabled":true,"static":true`)
+
+	_, code = send("DELETE", "/api/v1/admin/sites/remark", "")
+	assert.Equal(t, http.StatusBadRequest, code, "static site can't be removed")
+
+	_, code = send("DELETE", "/api/v1/admin/sites/blog?disable=1", "")
+	assert.Equal(t, http.StatusOK, code)
+	_, code = send("POST", "/api/v1/comment", comment)
+	assert.Equal(t, http.StatusInternalServerError, code, "disabled site")
+	assert.FileExists(t, app.Store.Bolt.Path+"/blog.db", "data of disabled site kept")
+
+	_, code = send("POST", "/api/v1/admin/sites/blog", "")
+	require.Equal(t, http.StatusCreated, code)
+	body, code = send("GET", "/api/v1/find?site=blog&url=https://radio-t.com/blah1&format=plain", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "test 123", "comments of re-enabled site available")
+
+	_, code = send("DELETE", "/api/v1/admin/sites/blog?purge=1", "")
+	assert.Equal(t, http.StatusOK, code)
+	_, err := os.Stat(app.Store.Bolt.Path + "/blog.db")
+	assert.True(t, os.IsNotExist(err), "data of purged site removed")
+
+	sites, err := admin.NewSites(app.SitesFile)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(sites.List()))
+
+	cancel()
+	app.Wait()
+}
+
+func TestServerApp_FileAdminStore(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	adminFile, eventLog := fmt.Sprintf("/tmp/%d/admin.yml", port), fmt.Sprintf("/tmp/%d/events.log", port)
+	require.NoError(t, os.MkdirAll(fmt.Sprintf("/tmp/%d", port), 0700))
+	defer os.Remove(adminFile)
+	defer os.Remove(eventLog)
+	require.NoError(t, ioutil.WriteFile(adminFile, []byte(`
+sites:
+  remark:
+    key: remark-key
+    admins: [github_admin1]
+    emails: [remark@example.com]
+`), 0600))
+
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Admin.Type = "file"
+		o.Admin.File.Path, o.Admin.File.EventLog = adminFile, eventLog
+		return o
+	})
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/api/v1/config?site=remark", port))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"admins":["github_admin1"]`)
+	assert.Contains(t, string(body), `"admin_email":"remark@example.com"`)
+
+	postComment := func(key string) int {
+		claims := token.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark",
+				Issuer:    "remark42",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			},
+			User: &token.User{ID: "dev", Name: "developer one"},
+		}
+		tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api/v1/comment", port),
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark"}}`))
+		require.NoError(t, err)
+		req.Header.Set("X-JWT", tk)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, postComment("secret"), "token signed with shared secret rejected")
+	assert.Equal(t, http.StatusCreated, postComment("remark-key"), "token signed with site key")
+
+	data, err := ioutil.ReadFile(eventLog)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"site":"remark","event":"create"`)
+
+	cancel()
+	app.Wait()
+}
+
 func TestServerApp_DevMode(t *testing.T) {
 	port := chooseRandomUnusedPort()
 	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
@@ -93,6 +231,86 @@ func TestServerApp_DevMode(t *testing.T) {
 	app.Wait()
 }
 
+func TestServerApp_OIDC(t *testing.T) {
+	providersFile := fmt.Sprintf("/tmp/oidc-%d.yml", time.Now().UnixNano())
+	err := ioutil.WriteFile(providersFile, []byte(`{"providers": [{"name": "keycloak", "cid": "cid", "csecret": "csec",
+		"auth_url": "https://sso.example.com/auth", "token_url": "https://sso.example.com/token", "info_url": "https://sso.example.com/userinfo"}]}`), 0600)
+	require.NoError(t, err)
+	defer os.Remove(providersFile)
+
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Auth.OIDC.Config = providersFile
+		return o
+	})
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	names := []string{}
+	for _, p := range app.restSrv.Authenticator.Providers() {
+		names = append(names, p.Name())
+	}
+	assert.Contains(t, names, "keycloak", "generic auth provider")
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/auth/keycloak/login?site=remark", port))
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Location"), "https://sso.example.com/auth?client_id=cid", "redirected to provider")
+
+	cancel()
+	app.Wait()
+}
+
+func TestServerApp_SSO(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Auth.SSO = true
+		return o
+	})
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	hostToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "42", "name": "host user",
+		"admin": true, "aud": "remark", "exp": time.Now().Add(time.Minute).Unix()}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/auth/sso/login?site=remark&token=%s", port, hostToken))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tkn, claims := getAuthFromCookie(t, app, resp)
+	require.NotEmpty(t, tkn)
+	assert.Equal(t, "sso_remark_42", claims.User.ID)
+	assert.True(t, claims.User.IsAdmin(), "admin by host site claim")
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/api/v1/user?site=remark", port), nil)
+	require.NoError(t, err)
+	req.Header.Set("X-JWT", tkn)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"id":"sso_remark_42"`)
+	assert.Contains(t, string(body), `"admin":true`)
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/api/v1/config?site=remark", port))
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.NotContains(t, string(body), `"sso"`, "sso is not shown as login option")
+
+	cancel()
+	app.Wait()
+}
+
 func TestServerApp_AnonMode(t *testing.T) {
 	port := chooseRandomUnusedPort()
 	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
@@ -615,6 +833,28 @@ func TestServerCommand_parseSameSite(t *testing.T) {
 	}
 }
 
+func Test_parseTelegramAdmins(t *testing.T) {
+	res, err := parseTelegramAdmins([]string{"123:github_abc", " 456 : dev"})
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{123: "github_abc", 456: "dev"}, res)
+
+	_, err = parseTelegramAdmins([]string{"123"})
+	assert.EqualError(t, err, `invalid telegram admin "123", expected telegram_id:user_id`)
+	_, err = parseTelegramAdmins([]string{"abc:dev"})
+	assert.Error(t, err)
+}
+
+func Test_parseSlackAdmins(t *testing.T) {
+	res, err := parseSlackAdmins([]string{"U123:github_abc", " U456 : dev"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"U123": "github_abc", "U456": "dev"}, res)
+
+	_, err = parseSlackAdmins([]string{"U123"})
+	assert.EqualError(t, err, `invalid slack admin "U123", expected slack_id:user_id`)
+	_, err = parseSlackAdmins([]string{":dev"})
+	assert.Error(t, err)
+}
+
 func chooseRandomUnusedPort() (port int) {
 	for i := 0; i < 10; i++ {
 		port = 40000 + int(rand.Int31n(10000))
@@ -675,6 +915,8 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	cmd.Notify.Admins = []string{"email"}
 	cmd.Notify.Email.From = "from@example.org"
 	cmd.Notify.Email.VerificationSubject = "test verification email subject"
+	cmd.Notify.Outbox.File = fmt.Sprintf("/tmp/%d/notify.db", cmd.Port)
+	cmd.SitesFile = fmt.Sprintf("/tmp/%d/sites.json", cmd.Port)
 	cmd.SMTP.Host = "127.0.0.1"
 	cmd.SMTP.Port = 25
 	cmd.SMTP.Username = "test_user"
@@ -689,6 +931,7 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	cmd = fn(cmd)
 
 	os.Remove(cmd.Store.Bolt.Path + "/remark.db")
+	os.Remove(cmd.SitesFile)
 
 	return createAppFromCmd(t, cmd)
 }
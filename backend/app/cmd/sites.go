@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SitesCommand set of subcommands to manage sites of the running server
+type SitesCommand struct {
+	Add    SitesAddCommand    `command:"add" description:"add new site or enable disabled one"`
+	Remove SitesRemoveCommand `command:"remove" description:"disable or remove site"`
+	List   SitesListCommand   `command:"list" description:"list all sites"`
+}
+
+// SitesOpts defines options shared by all sites subcommands
+type SitesOpts struct {
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Timeout     time.Duration `long:"timeout" default:"15s" description:"request timeout"`
+	CommonOpts
+}
+
+// SitesAddCommand set of flags and command for sites add
+type SitesAddCommand struct {
+	Site string `short:"s" long:"site" required:"true" description:"site name"`
+	SitesOpts
+}
+
+// SitesRemoveCommand set of flags and command for sites remove
+type SitesRemoveCommand struct {
+	Site    string `short:"s" long:"site" required:"true" description:"site name"`
+	Disable bool   `long:"disable" description:"disable site, keep its definition and data"`
+	Purge   bool   `long:"purge" description:"remove all data of the site"`
+	SitesOpts
+}
+
+// SitesListCommand set of flags and command for sites list
+type SitesListCommand struct {
+	SitesOpts
+}
+
+// Execute runs sites add with SitesAddCommand parameters, entry point for "sites add" command
+func (sc *SitesAddCommand) Execute(_ []string) error {
+	log.Printf("[INFO] add site %s", sc.Site)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	body, err := sc.request(http.MethodPost, fmt.Sprintf("%s/api/v1/admin/sites/%s", sc.RemarkURL, sc.Site))
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] completed, %s", string(body))
+	return nil
+}
+
+// Execute runs sites remove with SitesRemoveCommand parameters, entry point for "sites remove" command
+func (sc *SitesRemoveCommand) Execute(_ []string) error {
+	log.Printf("[INFO] remove site %s, disable=%v, purge=%v", sc.Site, sc.Disable, sc.Purge)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	if sc.Disable && sc.Purge {
+		return errors.New("disable and purge can't be used together")
+	}
+	removeURL := fmt.Sprintf("%s/api/v1/admin/sites/%s", sc.RemarkURL, sc.Site)
+	switch {
+	case sc.Disable:
+		removeURL += "?disable=1"
+	case sc.Purge:
+		removeURL += "?purge=1"
+	}
+
+	body, err := sc.request(http.MethodDelete, removeURL)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] completed, %s", string(body))
+	return nil
+}
+
+// Execute runs sites list with SitesListCommand parameters, entry point for "sites list" command
+func (sc *SitesListCommand) Execute(_ []string) error {
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	body, err := sc.request(http.MethodGet, sc.RemarkURL+"/api/v1/admin/sites")
+	if err != nil {
+		return err
+	}
+	sites := []admin.Site{}
+	if err = json.Unmarshal(body, &sites); err != nil {
+		return errors.Wrap(err, "can't decode sites")
+	}
+	for _, site := range sites {
+		status := "enabled"
+		if !site.Enabled {
+			status = "disabled"
+		}
+		if site.Static {
+			status += ", static"
+		}
+		fmt.Printf("%s (%s)\n", site.ID, status)
+	}
+	return nil
+}
+
+// request makes basic auth request to sites api and returns body of successful response
+func (so *SitesOpts) request(method, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), so.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make request for %s", url)
+	}
+	req.SetBasicAuth("admin", so.AdminPasswd)
+
+	client := http.Client{}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "request failed for %s", url)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return nil, responseError(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, errors.Wrap(err, "can't read response")
+}
+
+// sharedSitesEngine implemented by engines keeping all sites in the same db
+type sharedSitesEngine interface {
+	AddSite(siteID string) error
+	RemoveSite(siteID string) error
+}
+
+// siteProvisioner implements api.SiteProvisioner. Opens and closes sites of the data store at runtime,
+// site definitions persisted by admin.Sites
+type siteProvisioner struct {
+	sites    *admin.Sites
+	engine   engine.Interface
+	boltPath string              // location of per-site bolt files, used by bolt engine only
+	onOpen   func(siteID string) // optional, called after the site opened
+	onClose  func(siteID string) // optional, called before the site closed
+
+	lock sync.Mutex
+}
+
+// Sites returns all sites
+func (p *siteProvisioner) Sites() []admin.Site {
+	return p.sites.List()
+}
+
+// AddSite makes new site, or enables disabled one, and opens it
+func (p *siteProvisioner) AddSite(siteID string) (admin.Site, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if site, ok := p.sites.Get(siteID); ok {
+		if site.Enabled {
+			return admin.Site{}, errors.Errorf("site %q already exists", siteID)
+		}
+		if err := p.open(siteID); err != nil {
+			return admin.Site{}, err
+		}
+		if err := p.sites.SetEnabled(siteID, true); err != nil {
+			if e := p.close(siteID); e != nil {
+				log.Printf("[WARN] can't close site %s, %v", siteID, e)
+			}
+			return admin.Site{}, err
+		}
+		site.Enabled = true
+		return site, nil
+	}
+
+	site, err := p.sites.Add(siteID)
+	if err != nil {
+		return admin.Site{}, err
+	}
+	if err = p.open(siteID); err != nil {
+		if e := p.sites.Remove(siteID); e != nil {
+			log.Printf("[WARN] can't remove site %s, %v", siteID, e)
+		}
+		return admin.Site{}, err
+	}
+	return site, nil
+}
+
+// DisableSite closes the site, its definition and data kept
+func (p *siteProvisioner) DisableSite(siteID string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if site, ok := p.sites.Get(siteID); ok && !site.Enabled {
+		return errors.Errorf("site %q already disabled", siteID)
+	}
+	if err := p.sites.SetEnabled(siteID, false); err != nil {
+		return err
+	}
+	return p.close(siteID)
+}
+
+// RemoveSite closes the site and removes its definition. All data of the site removed with purge
+func (p *siteProvisioner) RemoveSite(siteID string, purge bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	site, ok := p.sites.Get(siteID)
+	if !ok {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	if err := p.sites.Remove(siteID); err != nil {
+		return err
+	}
+	if site.Enabled {
+		if err := p.close(siteID); err != nil {
+			return err
+		}
+	}
+	if !purge {
+		return nil
+	}
+	return p.purge(siteID)
+}
+
+// open makes the site available in the data store
+func (p *siteProvisioner) open(siteID string) (err error) {
+	switch e := p.engine.(type) {
+	case *engine.BoltDB:
+		err = e.AddSite(engine.BoltSite{SiteID: siteID, FileName: p.boltFile(siteID)})
+	case sharedSitesEngine:
+		err = e.AddSite(siteID)
+	default:
+		return errors.Errorf("runtime sites not supported by %T", p.engine)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't open site %s", siteID)
+	}
+	log.Printf("[INFO] site %s opened", siteID)
+	if p.onOpen != nil {
+		p.onOpen(siteID)
+	}
+	return nil
+}
+
+// close makes the site unavailable in the data store, data of the site kept
+func (p *siteProvisioner) close(siteID string) (err error) {
+	if p.onClose != nil {
+		p.onClose(siteID)
+	}
+	switch e := p.engine.(type) {
+	case *engine.BoltDB:
+		_, err = e.RemoveSite(siteID)
+	case sharedSitesEngine:
+		err = e.RemoveSite(siteID)
+	default:
+		return errors.Errorf("runtime sites not supported by %T", p.engine)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't close site %s", siteID)
+	}
+	log.Printf("[INFO] site %s closed", siteID)
+	return nil
+}
+
+// purge removes all data of the closed site
+func (p *siteProvisioner) purge(siteID string) error {
+	switch e := p.engine.(type) {
+	case *engine.BoltDB:
+		if err := os.Remove(p.boltFile(siteID)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "can't remove data of site %s", siteID)
+		}
+	case sharedSitesEngine:
+		// site data can be removed from opened site only
+		if err := e.AddSite(siteID); err != nil {
+			return errors.Wrapf(err, "can't open site %s", siteID)
+		}
+		err := p.engine.Delete(engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}})
+		if errClose := e.RemoveSite(siteID); errClose != nil {
+			log.Printf("[WARN] can't close site %s, %v", siteID, errClose)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can't remove data of site %s", siteID)
+		}
+	default:
+		return errors.Errorf("runtime sites not supported by %T", p.engine)
+	}
+	log.Printf("[INFO] data of site %s removed", siteID)
+	return nil
+}
+
+func (p *siteProvisioner) boltFile(siteID string) string {
+	return fmt.Sprintf("%s/%s.db", p.boltPath, siteID)
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestSites_Execute(t *testing.T) {
+	var reqs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		reqs = append(reqs, r.Method+" "+r.URL.String())
+		if r.URL.Path == "/api/v1/admin/sites/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, `{"error": "invalid site id"}`)
+			return
+		}
+		if r.Method == "GET" {
+			fmt.Fprintln(w, `[{"id":"blog","enabled":false},{"id":"remark","enabled":true,"static":true}]`)
+			return
+		}
+		fmt.Fprintln(w, `{"id":"blog","enabled":true}`)
+	}))
+	defer ts.Close()
+
+	parse := func(c flags.Commander, args ...string) {
+		p := flags.NewParser(c, flags.Default)
+		_, err := p.ParseArgs(append(args, "--admin-passwd=secret"))
+		require.NoError(t, err)
+	}
+
+	addCmd := SitesAddCommand{}
+	addCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&addCmd, "--site=blog")
+	assert.NoError(t, addCmd.Execute(nil))
+
+	removeCmd := SitesRemoveCommand{}
+	removeCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&removeCmd, "--site=blog", "--disable")
+	assert.NoError(t, removeCmd.Execute(nil))
+
+	removeCmd = SitesRemoveCommand{}
+	removeCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&removeCmd, "--site=blog", "--purge")
+	assert.NoError(t, removeCmd.Execute(nil))
+
+	removeCmd = SitesRemoveCommand{}
+	removeCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&removeCmd, "--site=blog", "--purge", "--disable")
+	assert.EqualError(t, removeCmd.Execute(nil), "disable and purge can't be used together")
+
+	listCmd := SitesListCommand{}
+	listCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&listCmd)
+	assert.NoError(t, listCmd.Execute(nil))
+
+	addCmd = SitesAddCommand{}
+	addCmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	parse(&addCmd, "--site=bad")
+	err := addCmd.Execute(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request")
+
+	assert.Equal(t, []string{"POST /api/v1/admin/sites/blog", "DELETE /api/v1/admin/sites/blog?disable=1",
+		"DELETE /api/v1/admin/sites/blog?purge=1", "GET /api/v1/admin/sites", "POST /api/v1/admin/sites/bad"}, reqs)
+}
+
+func TestSiteProvisioner_SQLite(t *testing.T) {
+	dbFile, sitesFile := "/tmp/test-remark-sites.sqlite", "/tmp/test-remark-sites.json"
+	_ = os.Remove(dbFile)
+	_ = os.Remove(sitesFile)
+	defer func() {
+		_ = os.Remove(dbFile)
+		_ = os.Remove(sitesFile)
+	}()
+
+	eng, err := engine.NewSQLite(dbFile, "remark")
+	require.NoError(t, err)
+	defer eng.Close()
+	sites, err := admin.NewSites(sitesFile, "remark")
+	require.NoError(t, err)
+
+	var opened, closed []string
+	p := siteProvisioner{sites: sites, engine: eng,
+		onOpen:  func(siteID string) { opened = append(opened, siteID) },
+		onClose: func(siteID string) { closed = append(closed, siteID) },
+	}
+
+	_, err = p.AddSite("remark")
+	assert.EqualError(t, err, `site "remark" already exists`)
+	site, err := p.AddSite("blog")
+	require.NoError(t, err)
+	assert.Equal(t, "blog", site.ID)
+	assert.True(t, site.Enabled)
+
+	comment := store.Comment{ID: "c1", Text: "some text", Timestamp: time.Now(),
+		Locator: store.Locator{SiteID: "blog", URL: "https://example.com/post1"}, User: store.User{ID: "user1"}}
+	_, err = eng.Create(comment)
+	require.NoError(t, err)
+
+	assert.EqualError(t, p.DisableSite("remark"), `site "remark" defined by server's options, can't be changed`)
+	require.NoError(t, p.DisableSite("blog"))
+	assert.EqualError(t, p.DisableSite("blog"), `site "blog" already disabled`)
+	_, err = eng.Count(engine.FindRequest{Locator: comment.Locator})
+	assert.EqualError(t, err, `site "blog" not found`)
+
+	_, err = p.AddSite("blog")
+	require.NoError(t, err)
+	count, err := eng.Count(engine.FindRequest{Locator: comment.Locator})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "data of disabled site kept")
+
+	require.NoError(t, p.RemoveSite("blog", false))
+	_, err = p.AddSite("blog")
+	require.NoError(t, err)
+	count, err = eng.Count(engine.FindRequest{Locator: comment.Locator})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "data of removed site kept without purge")
+
+	require.NoError(t, p.DisableSite("blog"))
+	require.NoError(t, p.RemoveSite("blog", true))
+	assert.EqualError(t, p.RemoveSite("blog", true), `site "blog" not found`)
+	_, err = p.AddSite("blog")
+	require.NoError(t, err)
+	count, err = eng.Count(engine.FindRequest{Locator: comment.Locator})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "data of purged site removed")
+
+	assert.Equal(t, []string{"blog", "blog", "blog", "blog"}, opened)
+	assert.Equal(t, []string{"blog", "blog", "blog"}, closed)
+	assert.Equal(t, []admin.Site{{ID: "remark", Enabled: true, Static: true}}, p.Sites()[1:])
+}
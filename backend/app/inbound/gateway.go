@@ -0,0 +1,325 @@
+// Package inbound implements mail gateway accepting replies to notification emails.
+// Reply posted as a comment on behalf of the user the notification was sent to, the recipient
+// address of the reply keeps random token of notify.ReplyToken with the comment and the user.
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/stream"
+)
+
+// Store defines methods of data store used to post replies
+type Store interface {
+	Create(comment store.Comment) (commentID string, err error)
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+	GetUserEmail(siteID string, userID string) (string, error)
+	ValidateComment(c *store.Comment) error
+	IsBlocked(siteID string, userID string) bool
+	IsReadOnly(locator store.Locator) bool
+	IsShadowBanned(siteID, userID string) bool
+	IsAdmin(siteID, userID string) bool
+	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
+}
+
+// Flusher evicts cached responses of changed post
+type Flusher interface {
+	Flush(req lcw.FlusherRequest)
+}
+
+// GatewayParams contain settings of inbound smtp server
+type GatewayParams struct {
+	Address     string        // listen address, i.e. ":2525"
+	Domain      string        // server name used in greeting
+	ReadOnlyAge int           // posts older than this number of days are read-only
+	MaxSize     int           // max message size in bytes
+	Timeout     time.Duration // max duration of smtp session
+}
+
+// Gateway is a minimal smtp server accepting replies to notification emails.
+// It has no TLS and auth support and intended to run behind MTA of the reply domain
+// or to be exposed directly as MX of dedicated reply domain.
+type Gateway struct {
+	GatewayParams
+	DataStore        Store
+	ReplyStore       notify.ReplyStore // keeps reply tokens of sent notifications
+	CommentFormatter *store.CommentFormatter
+	NotifyService    *notify.Service // optional, notifications of posted replies disabled if nil
+	Cache            Flusher         // optional
+	StreamBroker     *stream.Broker  // optional
+}
+
+const (
+	defaultMaxSize    = 1024 * 1024
+	defaultTimeout    = 5 * time.Minute
+	lastCommentsScope = "last" // same as in rest api
+)
+
+// Run starts smtp server, blocks till context canceled
+func (g *Gateway) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", g.Address)
+	if err != nil {
+		return errors.Wrapf(err, "can't listen on %s", g.Address)
+	}
+	log.Printf("[INFO] activate reply by email gateway on %s", g.Address)
+	return g.serve(ctx, ln)
+}
+
+func (g *Gateway) serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		if err := ln.Close(); err != nil {
+			log.Printf("[WARN] can't close reply gateway listener, %v", err)
+		}
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("[INFO] reply gateway terminated, %v", ctx.Err())
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Printf("[WARN] reply gateway accept failed, %v", err)
+				continue
+			}
+			return errors.Wrap(err, "can't accept connection")
+		}
+		go g.session(conn)
+	}
+}
+
+// session handles single smtp connection. Recipients verified on RCPT command, so the sending server
+// bounces mail to unknown address immediately. Reply posted to each recipient on DATA command.
+func (g *Gateway) session(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("[DEBUG] can't close smtp connection, %v", err)
+		}
+	}()
+	timeout, maxSize := g.Timeout, g.MaxSize
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	domain := g.Domain
+	if domain == "" {
+		domain = "localhost"
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		log.Printf("[WARN] can't set smtp session deadline, %v", err)
+	}
+
+	tp := textproto.NewConn(conn)
+	reply := func(code int, msg string) {
+		if err := tp.PrintfLine("%d %s", code, msg); err != nil {
+			log.Printf("[DEBUG] can't write smtp reply, %v", err)
+		}
+	}
+
+	reply(220, domain+" ESMTP remark42")
+	var mailFrom bool
+	var tokens []notify.ReplyToken
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			reply(250, domain)
+		case "EHLO":
+			_ = tp.PrintfLine("250-%s", domain)
+			_ = tp.PrintfLine("250-SIZE %d", maxSize)
+			reply(250, "8BITMIME")
+		case "MAIL":
+			mailFrom, tokens = true, nil
+			reply(250, "OK")
+		case "RCPT":
+			if !mailFrom {
+				reply(503, "need MAIL command")
+				continue
+			}
+			tkn, e := g.token(smtpPath(arg))
+			if e != nil {
+				log.Printf("[WARN] reply to unknown address %s rejected, %v", arg, e)
+				reply(550, "no such reply address")
+				continue
+			}
+			tokens = append(tokens, tkn)
+			reply(250, "OK")
+		case "DATA":
+			if len(tokens) == 0 {
+				reply(503, "need RCPT command")
+				continue
+			}
+			reply(354, "start mail input, end with <CRLF>.<CRLF>")
+			dr := tp.DotReader()
+			data, e := ioutil.ReadAll(io.LimitReader(dr, int64(maxSize)+1))
+			if e != nil {
+				return
+			}
+			if len(data) > maxSize {
+				_, _ = io.Copy(ioutil.Discard, dr)
+				reply(552, "message too big")
+				mailFrom, tokens = false, nil
+				continue
+			}
+			if e = g.postAll(tokens, data); e != nil {
+				log.Printf("[WARN] reply by email rejected, %v", e)
+				reply(554, "reply rejected, "+e.Error())
+			} else {
+				reply(250, "reply posted")
+			}
+			mailFrom, tokens = false, nil
+		case "RSET":
+			mailFrom, tokens = false, nil
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// token extracts reply token from address, i.e. reply+token@example.com
+func (g *Gateway) token(address string) (notify.ReplyToken, error) {
+	local := address
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		local = address[:at]
+	}
+	plus := strings.LastIndex(local, "+")
+	if plus < 0 {
+		return notify.ReplyToken{}, errors.New("no reply token")
+	}
+	return g.ReplyStore.Get(local[plus+1:])
+}
+
+func (g *Gateway) postAll(tokens []notify.ReplyToken, data []byte) error {
+	for _, tkn := range tokens {
+		if _, err := g.post(tkn, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post makes comment from the message as answer to the comment in the token,
+// on behalf of the token's user. The stored token authorizes the reply, From: header is not authenticated
+// and compared with the user's subscription email only to drop replies forwarded by someone else.
+func (g *Gateway) post(tkn notify.ReplyToken, data []byte) (store.Comment, error) {
+	from, text, err := readMessage(bytes.NewReader(data))
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if text == "" {
+		return store.Comment{}, errors.New("empty reply")
+	}
+
+	siteID := tkn.Locator.SiteID
+	email, err := g.DataStore.GetUserEmail(siteID, tkn.UserID)
+	if err != nil || email == "" || !strings.EqualFold(email, from) {
+		return store.Comment{}, errors.Errorf("sender %s is not subscribed user", from)
+	}
+
+	parent, err := g.DataStore.Get(tkn.Locator, tkn.ParentID, store.User{})
+	if err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't get comment %s", tkn.ParentID)
+	}
+	if parent.Deleted {
+		return store.Comment{}, errors.Errorf("comment %s deleted", tkn.ParentID)
+	}
+
+	// name and picture taken from the last comment of the user, as only id kept in the token,
+	// admin status is the current one, like set by auth middleware for rest api
+	comments, err := g.DataStore.User(siteID, tkn.UserID, 1, 0, store.User{})
+	if err != nil || len(comments) == 0 {
+		return store.Comment{}, errors.Errorf("unknown user %s", tkn.UserID)
+	}
+	user := store.User{ID: tkn.UserID, Name: comments[0].User.Name, Picture: comments[0].User.Picture, SiteID: siteID,
+		Admin: g.DataStore.IsAdmin(siteID, tkn.UserID)}
+
+	if g.DataStore.IsBlocked(siteID, user.ID) {
+		return store.Comment{}, errors.New("user blocked")
+	}
+	if g.isReadOnly(tkn.Locator) {
+		return store.Comment{}, errors.New("old post, read-only")
+	}
+
+	comment := store.Comment{ParentID: tkn.ParentID, Locator: tkn.Locator, Text: text, Orig: text, User: user,
+		PostTitle: parent.PostTitle}
+	if err = g.DataStore.ValidateComment(&comment); err != nil {
+		return store.Comment{}, errors.Wrap(err, "invalid comment")
+	}
+	comment = g.CommentFormatter.Format(comment)
+
+	id, err := g.DataStore.Create(comment)
+	if err != nil {
+		return store.Comment{}, errors.Wrap(err, "can't save comment")
+	}
+	finalComment, err := g.DataStore.Get(comment.Locator, id, user)
+	if err != nil {
+		return store.Comment{}, errors.Wrap(err, "can't load created comment")
+	}
+	log.Printf("[INFO] reply by email %s from %s posted to %s", id, user.ID, tkn.ParentID)
+
+	if g.Cache != nil {
+		g.Cache.Flush(lcw.Flusher(siteID).Scopes(comment.Locator.URL, lastCommentsScope, user.ID, siteID))
+	}
+	shadowed := !user.Admin && g.DataStore.IsShadowBanned(siteID, user.ID)
+	if g.NotifyService != nil && !shadowed { // pending comment sent to admins only
+		g.NotifyService.Submit(notify.Request{Comment: finalComment})
+	}
+	if g.StreamBroker != nil && !finalComment.Pending && !shadowed {
+		if c, e := g.DataStore.Get(comment.Locator, id, store.User{}); e == nil {
+			g.StreamBroker.Publish(stream.Event{Type: stream.EvCreated, Locator: comment.Locator, CommentID: id, Comment: &c})
+		}
+	}
+	return finalComment, nil
+}
+
+func (g *Gateway) isReadOnly(locator store.Locator) bool {
+	if g.ReadOnlyAge > 0 {
+		if info, e := g.DataStore.Info(locator, g.ReadOnlyAge); e == nil && info.ReadOnly {
+			return true
+		}
+	}
+	return g.DataStore.IsReadOnly(locator)
+}
+
+// smtpPath extracts address from MAIL and RCPT argument, i.e. "TO:<user@example.com> PARAM=1"
+func smtpPath(arg string) string {
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	arg = strings.TrimSpace(arg)
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
@@ -0,0 +1,212 @@
+package inbound
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestGateway_Reply(t *testing.T) {
+	g, addr, teardown := prepGateway(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	tkn, err := g.ReplyStore.Add(notify.ReplyToken{Locator: locator, ParentID: "id-1", UserID: "user1", Issued: time.Now()})
+	require.NoError(t, err)
+	rcpt := notify.ReplyAddress("reply@example.com", tkn)
+	assert.True(t, len(rcpt[:strings.LastIndex(rcpt, "@")]) <= 64, "local part fits into 64 characters")
+
+	msg := "From: User One <User1@example.com>\r\nTo: " + rcpt + "\r\nSubject: Re: new reply\r\n\r\n" +
+		"thanks, **agree**\r\n\r\nOn Mon, 1 Jun 2020 at 10:00, Remark42 <noreply@example.com> wrote:\r\n> some text\r\n"
+	require.NoError(t, smtp.SendMail(addr, nil, "user1@example.com", []string{rcpt}, []byte(msg)))
+
+	comments, err := g.DataStore.(*service.DataStore).Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "id-1", comments[1].ParentID)
+	assert.Equal(t, "user1", comments[1].User.ID)
+	assert.Equal(t, "user name", comments[1].User.Name)
+	assert.False(t, comments[1].User.Admin, "admin status is the current one, not from the last comment")
+	assert.Equal(t, "thanks, **agree**", comments[1].Orig)
+	assert.Equal(t, "<p>thanks, <strong>agree</strong></p>\n", comments[1].Text)
+}
+
+func TestGateway_Rejected(t *testing.T) {
+	g, addr, teardown := prepGateway(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	tkn, err := g.ReplyStore.Add(notify.ReplyToken{Locator: locator, ParentID: "id-1", UserID: "user1", Issued: time.Now()})
+	require.NoError(t, err)
+	rcpt := notify.ReplyAddress("reply@example.com", tkn)
+
+	expiredTkn, err := g.ReplyStore.Add(notify.ReplyToken{Locator: locator, ParentID: "id-1", UserID: "user1",
+		Issued: time.Now().Add(-notify.ReplyTokenTTL - time.Hour)})
+	require.NoError(t, err)
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{notify.ReplyAddress("reply@example.com", expiredTkn)},
+		[]byte("From: user1@example.com\r\n\r\nsome reply\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such reply address")
+
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{"reply+abcdefghijklmnopqrstuvwx@example.com"},
+		[]byte("From: user1@example.com\r\n\r\nsome reply\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such reply address")
+
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{"reply@example.com"},
+		[]byte("From: user1@example.com\r\n\r\nsome reply\r\n"))
+	require.Error(t, err, "no token")
+
+	err = smtp.SendMail(addr, nil, "user2@example.com", []string{rcpt}, []byte("From: user2@example.com\r\n\r\nsome reply\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reply rejected, sender user2@example.com is not subscribed user")
+
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{rcpt}, []byte("From: user1@example.com\r\n\r\n> quote only\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "empty reply")
+
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{rcpt},
+		[]byte("From: user1@example.com\r\nAuto-Submitted: auto-replied\r\n\r\nI'm on vacation\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "auto-submitted message ignored")
+
+	require.NoError(t, g.DataStore.(*service.DataStore).SetReadOnly(locator, true))
+	err = smtp.SendMail(addr, nil, "user1@example.com", []string{rcpt}, []byte("From: user1@example.com\r\n\r\nsome reply\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "read-only")
+
+	comments, err := g.DataStore.(*service.DataStore).Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(comments), "nothing posted")
+}
+
+func TestGateway_PendingNotify(t *testing.T) {
+	g, addr, teardown := prepGateway(t)
+	defer teardown()
+
+	dest := &mockDest{}
+	g.NotifyService = notify.NewService(g.DataStore.(*service.DataStore), 1, dest)
+	locator := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	require.NoError(t, g.DataStore.(*service.DataStore).SetModerated(locator, true))
+
+	tkn, err := g.ReplyStore.Add(notify.ReplyToken{Locator: locator, ParentID: "id-1", UserID: "user1", Issued: time.Now()})
+	require.NoError(t, err)
+	rcpt := notify.ReplyAddress("reply@example.com", tkn)
+	require.NoError(t, smtp.SendMail(addr, nil, "user1@example.com", []string{rcpt},
+		[]byte("From: user1@example.com\r\n\r\nsome reply\r\n")))
+	g.NotifyService.Close()
+
+	dest.Lock()
+	defer dest.Unlock()
+	require.Equal(t, 1, len(dest.reqs), "pending reply sent to admins")
+	assert.True(t, dest.reqs[0].Comment.Pending)
+	assert.Equal(t, "some reply", dest.reqs[0].Comment.Orig)
+}
+
+func TestGateway_SessionErrors(t *testing.T) {
+	_, addr, teardown := prepGateway(t)
+	defer teardown()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 1024)
+	read := func() string {
+		n, e := conn.Read(buf)
+		require.NoError(t, e)
+		return string(buf[:n])
+	}
+	assert.Contains(t, read(), "220 example.com ESMTP remark42")
+	for _, tt := range []struct{ cmd, resp string }{
+		{"HELO client", "250 example.com"},
+		{"RCPT TO:<reply+abc@example.com>", "503 need MAIL command"},
+		{"MAIL FROM:<user1@example.com> BODY=8BITMIME", "250 OK"},
+		{"DATA", "503 need RCPT command"},
+		{"VRFY user1", "502 command not implemented"},
+		{"QUIT", "221 bye"},
+	} {
+		_, err = fmt.Fprintf(conn, "%s\r\n", tt.cmd)
+		require.NoError(t, err)
+		assert.Equal(t, tt.resp+"\r\n", read(), tt.cmd)
+	}
+}
+
+func TestSMTPPath(t *testing.T) {
+	assert.Equal(t, "user@example.com", smtpPath("FROM:<user@example.com> BODY=8BITMIME"))
+	assert.Equal(t, "user@example.com", smtpPath("TO: <user@example.com>"))
+	assert.Equal(t, "", smtpPath("FROM:<>"))
+}
+
+func prepGateway(t *testing.T) (g *Gateway, addr string, teardown func()) {
+	tmpDir, err := ioutil.TempDir("", "inbound")
+	require.NoError(t, err)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: path.Join(tmpDir, "test.db"), SiteID: "remark42"})
+	require.NoError(t, err)
+	dataStore := &service.DataStore{Engine: b, EditDuration: 5 * time.Minute, MaxCommentSize: 4000,
+		AdminStore: admin.NewStaticStore("secret", []string{"remark42"}, []string{"admin"}, "admin@example.com"),
+		MaxVotes:   service.UnlimitedVotes}
+
+	_, err = dataStore.Create(store.Comment{ID: "id-1", Text: "some text",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://example.com/post1"},
+		User:    store.User{ID: "user1", Name: "user name", IP: "127.0.0.1", Admin: true}})
+	require.NoError(t, err)
+	_, err = dataStore.SetUserEmail("remark42", "user1", "user1@example.com")
+	require.NoError(t, err)
+
+	replyStore, err := notify.NewBoltReply(path.Join(tmpDir, "replies.db"), bolt.Options{})
+	require.NoError(t, err)
+
+	g = &Gateway{GatewayParams: GatewayParams{Domain: "example.com"},
+		DataStore: dataStore, ReplyStore: replyStore, CommentFormatter: store.NewCommentFormatter()}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, g.serve(ctx, ln))
+		close(done)
+	}()
+
+	return g, ln.Addr().String(), func() {
+		cancel()
+		<-done
+		_ = dataStore.Close()
+		_ = replyStore.Close()
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+type mockDest struct {
+	sync.Mutex
+	reqs []notify.Request
+}
+
+func (m *mockDest) Send(_ context.Context, req notify.Request) error {
+	m.Lock()
+	defer m.Unlock()
+	m.reqs = append(m.reqs, req)
+	return nil
+}
+
+func (m *mockDest) SendVerification(context.Context, notify.VerificationRequest) error { return nil }
+
+func (m *mockDest) String() string { return "mock" }
@@ -0,0 +1,132 @@
+package inbound
+
+import (
+	"encoding/base64"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
+)
+
+var (
+	reWrote       = regexp.MustCompile(`(?i)^on\s.+\swrote:$`)                               // On Mon, 1 Jun 2020 at 10:00, User <user@example.com> wrote:
+	reOriginalMsg = regexp.MustCompile(`(?i)^-{2,}\s*(original|forwarded) message\s*-{2,}$`) // ----- Original Message -----
+	reSentFrom    = regexp.MustCompile(`(?i)^sent from my\s`)                                // Sent from my iPhone
+	reBlockquote  = regexp.MustCompile(`(?is)<blockquote.*?</blockquote>`)
+	reHTMLBreak   = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+)
+
+// readMessage parses mail message, returns sender address and text of the reply without quotes and signature
+func readMessage(r io.Reader) (from, text string, err error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return "", "", errors.Wrap(err, "can't parse message")
+	}
+	if auto := msg.Header.Get("Auto-Submitted"); auto != "" && !strings.EqualFold(auto, "no") {
+		return "", "", errors.Errorf("auto-submitted message ignored, %s", auto)
+	}
+	addr, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return "", "", errors.Wrap(err, "can't parse from address")
+	}
+
+	plain, htmlText, err := messageBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if plain == "" && htmlText != "" {
+		plain = htmlToText(htmlText)
+	}
+	return addr.Address, ExtractReply(plain), nil
+}
+
+// messageBody returns text/plain and text/html content of the body, looking into all parts of multipart message
+func messageBody(contentType, encoding string, body io.Reader) (plain, htmlText string, err error) {
+	mediaType, params := "text/plain", map[string]string{}
+	if contentType != "" {
+		if mediaType, params, err = mime.ParseMediaType(contentType); err != nil {
+			return "", "", errors.Wrapf(err, "can't parse content type %q", contentType)
+		}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, e := mr.NextPart()
+			if e == io.EOF {
+				return plain, htmlText, nil
+			}
+			if e != nil {
+				return "", "", errors.Wrap(e, "can't read message part")
+			}
+			p, h, e := messageBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if e != nil {
+				return "", "", e
+			}
+			if plain == "" {
+				plain = p
+			}
+			if htmlText == "" {
+				htmlText = h
+			}
+		}
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", "", nil // attachments ignored
+	}
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "can't read %s body", mediaType)
+	}
+	if mediaType == "text/html" {
+		return "", string(data), nil
+	}
+	return string(data), "", nil
+}
+
+// htmlToText drops quoted blocks and all tags, keeping line breaks
+func htmlToText(h string) string {
+	h = reBlockquote.ReplaceAllString(h, "")
+	h = reHTMLBreak.ReplaceAllString(h, "\n")
+	return html.UnescapeString(bluemonday.StrictPolicy().Sanitize(h))
+}
+
+// ExtractReply returns text of the reply, without quoted message, attribution line and signature
+func ExtractReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	res := make([]string, 0, len(lines))
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if line == "-- " || trimmed == "--" || reOriginalMsg.MatchString(trimmed) || reSentFrom.MatchString(trimmed) {
+			break // signature or quoted message follows
+		}
+		if reWrote.MatchString(trimmed) {
+			break
+		}
+		// attribution line wrapped by the mail client, i.e. "On Mon, 1 Jun 2020, User\n<user@example.com> wrote:"
+		if strings.HasPrefix(strings.ToLower(trimmed), "on ") && i+1 < len(lines) &&
+			reWrote.MatchString(trimmed+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		res = append(res, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(strings.Join(res, "\n"))
+}
@@ -0,0 +1,49 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractReply(t *testing.T) {
+	tbl := []struct {
+		in, out string
+	}{
+		{"simple reply", "simple reply"},
+		{"reply\r\n\r\nOn Mon, 1 Jun 2020 at 10:00, Remark42 <noreply@example.com> wrote:\r\n> quoted\r\n", "reply"},
+		{"reply\n\nOn Mon, 1 Jun 2020 at 10:00, Remark42\n<noreply@example.com> wrote:\n> quoted\n", "reply"},
+		{"line 1\n> inline quote\nline 2  \n", "line 1\nline 2"},
+		{"reply\n-- \nJohn Doe\nsome company", "reply"},
+		{"reply\n\nSent from my iPhone", "reply"},
+		{"reply\n\n-----Original Message-----\nFrom: someone", "reply"},
+		{"\n\n> only quote\n", ""},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.out, ExtractReply(tt.in), "case #%d", i)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	multipartMsg := "From: User <user@example.com>\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"b1\"\r\n\r\n" +
+		"--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"reply with =C3=BC\r\n\r\n> quote\r\n" +
+		"--b1\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>reply html</p>\r\n" +
+		"--b1--\r\n"
+	from, text, err := readMessage(strings.NewReader(multipartMsg))
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", from)
+	assert.Equal(t, "reply with ü", text)
+
+	htmlMsg := "From: user@example.com\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		"PGRpdj5odG1sICZhbXA7IHJlcGx5PC9kaXY+PGJsb2NrcXVvdGU+cXVvdGU8L2Jsb2NrcXVvdGU+\r\n"
+	_, text, err = readMessage(strings.NewReader(htmlMsg))
+	require.NoError(t, err)
+	assert.Equal(t, "html & reply", text)
+
+	_, _, err = readMessage(strings.NewReader("From: bad address\r\n\r\ntext"))
+	assert.Error(t, err)
+}
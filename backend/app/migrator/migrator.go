@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -42,6 +43,8 @@ type Store interface {
 	DeleteAll(siteID string) error
 	Metas(siteID string) (umetas []service.UserMetaData, pmetas []service.PostMetaData, err error)
 	SetMetas(siteID string, umetas []service.UserMetaData, pmetas []service.PostMetaData) error
+	AuditLog(req engine.AuditRequest) ([]store.AuditRecord, error)
+	AddAudit(rec store.AuditRecord) error
 }
 
 // ImportParams defines everything needed to run import
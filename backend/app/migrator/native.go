@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -19,7 +20,7 @@ const nativeVersion = 1
 const defaultConcurrent = 8
 
 // Native implements exporter and importer for internal store format
-// {"version": 1, comments:[{...}\n,{}], meta: {meta}}
+// {"version": 1, comments:[{...}\n,{}], meta: {meta}}, meta includes audit log of admin actions
 // each comments starts from the new line
 type Native struct {
 	DataStore  Store
@@ -30,6 +31,7 @@ type meta struct {
 	Version int                    `json:"version"`
 	Users   []service.UserMetaData `json:"users"`
 	Posts   []service.PostMetaData `json:"posts"`
+	Audit   []store.AuditRecord    `json:"audit,omitempty"`
 }
 
 // Export all comments to writer as json strings. Each comment is one string, separated by "\n"
@@ -55,6 +57,7 @@ func (n *Native) Export(w io.Writer, siteID string) (size int, err error) {
 		}
 
 		for _, comment := range comments {
+			comment.Retained = nil // content of deleted comment never exported
 
 			buf := &bytes.Buffer{}
 			enc := json.NewEncoder(buf)
@@ -80,6 +83,9 @@ func (n *Native) exportMeta(siteID string, w io.Writer) (err error) {
 	if err != nil {
 		return errors.Wrap(err, "can't get meta")
 	}
+	if m.Audit, err = n.DataStore.AuditLog(engine.AuditRequest{SiteID: siteID}); err != nil {
+		return errors.Wrap(err, "can't get audit log")
+	}
 
 	if err = json.NewEncoder(w).Encode(m); err != nil {
 		return errors.Wrap(err, "can't encode meta")
@@ -190,7 +196,16 @@ func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
 	}
 	log.Printf("[INFO] imported %d comments from %d records", comments, total)
 
-	err = n.DataStore.SetMetas(siteID, m.Users, m.Posts)
+	if err = n.DataStore.SetMetas(siteID, m.Users, m.Posts); err != nil {
+		return int(comments), err
+	}
 
-	return int(comments), err
+	// audit log kept on import, records with the same id replaced
+	for _, rec := range m.Audit {
+		rec.SiteID = siteID
+		if err = n.DataStore.AddAudit(rec); err != nil {
+			return int(comments), errors.Wrapf(err, "failed to import audit record %s", rec.ID)
+		}
+	}
+	return int(comments), nil
 }
@@ -26,12 +26,18 @@ func TestNative_Export(t *testing.T) {
 	assert.NoError(t, b.SetReadOnly(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, true))
 	assert.NoError(t, b.SetVerified("radio-t", "user1", true))
 	assert.NoError(t, b.SetBlock("radio-t", "user2", true, time.Hour))
+	loc2 := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	cc, err := b.Find(loc2, "time", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cc))
+	assert.NoError(t, b.Delete(loc2, cc[0].ID, store.SoftDelete))
 	r := Native{DataStore: b}
 
 	buf := &bytes.Buffer{}
 	size, err := r.Export(buf, "radio-t")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, size)
+	assert.NotContains(t, buf.String(), `"retained"`, "content of deleted comment not exported")
 
 	c1 := buf.String()
 	t.Log(c1)
@@ -101,6 +107,37 @@ func TestNative_Import(t *testing.T) {
 	assert.Equal(t, false, b.IsVerified("radio-t", "user2"))
 }
 
+func TestNative_ExportImportAudit(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	ts := time.Date(2017, 12, 20, 15, 18, 24, 0, time.UTC)
+	require.NoError(t, b.AddAudit(store.AuditRecord{ID: "r1", SiteID: "radio-t", Actor: "admin1",
+		Action: store.AuditBlock, Target: "user2", Params: map[string]string{"ttl": "1h"}, Timestamp: ts}))
+	r := Native{DataStore: b}
+
+	buf := &bytes.Buffer{}
+	_, err := r.Export(buf, "radio-t")
+	require.NoError(t, err)
+
+	m := meta{}
+	require.NoError(t, json.NewDecoder(strings.NewReader(buf.String())).Decode(&m), "decode meta")
+	require.Equal(t, 1, len(m.Audit))
+	assert.Equal(t, "r1", m.Audit[0].ID)
+	assert.Equal(t, store.AuditBlock, m.Audit[0].Action)
+
+	// import to the same site keeps audit log without duplicates
+	require.NoError(t, b.AddAudit(store.AuditRecord{ID: "r2", SiteID: "radio-t", Actor: "admin2",
+		Action: store.AuditPin, Target: "id-1", Timestamp: ts.Add(time.Second)}))
+	_, err = r.Import(strings.NewReader(buf.String()), "radio-t")
+	require.NoError(t, err)
+	records, err := b.AuditLog(engine.AuditRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(records))
+	assert.Equal(t, "r2", records[0].ID)
+	assert.Equal(t, "r1", records[1].ID)
+	assert.Equal(t, map[string]string{"ttl": "1h"}, records[1].Params)
+}
+
 func TestNative_ImportWithMapper(t *testing.T) {
 	b, teardown := prep(t) // write 2 comments
 	defer teardown()
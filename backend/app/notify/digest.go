@@ -0,0 +1,409 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/repeater"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// DigestMode defines how user gets email notifications
+type DigestMode string
+
+// DigestMode enum
+const (
+	DigestImmediate DigestMode = "immediate" // email per notification, default
+	DigestHourly    DigestMode = "hourly"    // single email with all notifications of the last hour
+	DigestDaily     DigestMode = "daily"     // single email with all notifications of the day, sent at EmailParams.DigestHour
+)
+
+// Valid checks if digest mode is known
+func (m DigestMode) Valid() bool {
+	return m == DigestImmediate || m == DigestHourly || m == DigestDaily
+}
+
+// DigestStore keeps users' digest modes and notifications waiting for the digest
+type DigestStore interface {
+	Mode(siteID, userID string) (DigestMode, error)       // mode of the user, DigestImmediate if not set
+	SetMode(siteID, userID string, mode DigestMode) error // set mode, DigestImmediate removes stored one
+	Add(item DigestItem) error                            // add pending notification, replaces one with the same id
+	Due(till time.Time) ([]DigestItem, error)             // pending notifications due till given time, oldest first
+	Delete(ids ...string) error                           // remove sent notifications
+	Close() error
+}
+
+// DigestItem is a single notification waiting for the digest
+type DigestItem struct {
+	ID            string        `json:"id"`
+	SiteID        string        `json:"site"`
+	UserID        string        `json:"user_id,omitempty"` // empty for admin digest
+	Email         string        `json:"email"`
+	Due           time.Time     `json:"due"`
+	ForAdmin      bool          `json:"for_admin,omitempty"`
+	ForSubscriber bool          `json:"for_subscriber,omitempty"`
+	Comment       store.Comment `json:"comment"`
+	Parent        store.Comment `json:"parent,omitempty"`
+	Attempts      int           `json:"attempts,omitempty"` // failed sending attempts
+}
+
+// digestTmplData store data for digest template execution
+type digestTmplData struct {
+	Email           string
+	ForAdmin        bool
+	Count           int
+	Posts           []digestPost
+	UnsubscribeLink string
+}
+
+// digestPost is a group of digest comments for the same post
+type digestPost struct {
+	PostTitle string
+	PostURL   string
+	Comments  []msgTmplData
+}
+
+const (
+	digestCheckInterval = time.Minute
+	digestMaxAttempts   = 60 // notification dropped after an hour of failed attempts
+)
+
+// digestDue returns time to send notification in given mode created at now
+func (e *Email) digestDue(mode DigestMode, now time.Time) time.Time {
+	if mode == DigestHourly {
+		return now.Truncate(time.Hour).Add(time.Hour)
+	}
+	due := time.Date(now.Year(), now.Month(), now.Day(), e.DigestHour, 0, 0, 0, now.Location())
+	if !due.After(now) {
+		due = due.AddDate(0, 0, 1)
+	}
+	return due
+}
+
+// addToDigest puts notification to digest store if recipient uses digest mode, returns true if added.
+// Reports are never delayed
+func (e *Email) addToDigest(req Request, email string, forAdmin, forSubscriber bool) (bool, error) {
+	if e.Digest == nil || len(req.Reports) > 0 {
+		return false, nil
+	}
+	item := DigestItem{SiteID: req.Comment.Locator.SiteID, Email: email, ForAdmin: forAdmin, ForSubscriber: forSubscriber,
+		Comment: req.Comment, Parent: req.parent}
+
+	mode := DigestDaily
+	if !forAdmin {
+		item.UserID = req.parent.User.ID
+		if id, ok := req.EmailUsers[email]; ok {
+			item.UserID = id
+		}
+		m, err := e.Digest.Mode(item.SiteID, item.UserID)
+		if err != nil {
+			return false, errors.Wrapf(err, "can't get digest mode of %s", item.UserID)
+		}
+		mode = m
+	}
+	if mode == DigestImmediate || (forAdmin && !e.AdminDigest) {
+		return false, nil
+	}
+
+	now := time.Now()
+	item.Due = e.digestDue(mode, now)
+	item.ID = fmt.Sprintf("%020d-%s", item.Due.UnixNano(), uuid.New().String())
+	log.Printf("[DEBUG] add comment %s for %s to %s digest, due %s", req.Comment.ID, email, mode, item.Due.Format(time.RFC3339))
+	return true, errors.Wrapf(e.Digest.Add(item), "can't add comment %s to digest", req.Comment.ID)
+}
+
+// RunDigest sends due digests every minute, till context canceled. Does nothing if digest store not set
+func (e *Email) RunDigest(ctx context.Context) {
+	if e.Digest == nil {
+		return
+	}
+	log.Printf("[INFO] email digest activated, daily digest at %02d:00", e.DigestHour)
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] email digest terminated, %v", ctx.Err())
+			return
+		case <-ticker.C:
+			if err := e.sendDigests(ctx, time.Now()); err != nil {
+				log.Printf("[WARN] failed to send email digests, %v", err)
+			}
+		}
+	}
+}
+
+// sendDigests makes single email for each recipient and site from all due notifications.
+// Sent notifications removed from the store, failed kept for the next run till digestMaxAttempts
+func (e *Email) sendDigests(ctx context.Context, now time.Time) error {
+	due, err := e.Digest.Due(now)
+	if err != nil {
+		return errors.Wrap(err, "can't get due digest notifications")
+	}
+	items, dropped := e.refreshDigestItems(due)
+	if len(dropped) > 0 {
+		log.Printf("[DEBUG] %d deleted or pending comments dropped from digest", len(dropped))
+		if err = e.Digest.Delete(dropped...); err != nil {
+			return errors.Wrap(err, "can't delete dropped digest notifications")
+		}
+	}
+
+	type digestKey struct {
+		siteID, email string
+		forAdmin      bool
+	}
+	groups := map[digestKey][]DigestItem{}
+	keys := []digestKey{} // keep order of the oldest notification
+	for _, item := range items {
+		k := digestKey{siteID: item.SiteID, email: item.Email, forAdmin: item.ForAdmin}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], item)
+	}
+
+	for _, k := range keys {
+		msg, err := e.buildDigestMessage(groups[k])
+		if err == nil {
+			err = repeater.NewDefault(5, time.Millisecond*250).Do(ctx, func() error {
+				return e.sendMessage(emailMessage{from: e.From, to: k.email, message: msg})
+			})
+		}
+		if err != nil {
+			log.Printf("[WARN] can't send digest to %s, %v", k.email, err)
+			if err = e.failDigest(groups[k]); err != nil {
+				return errors.Wrapf(err, "can't update failed digest notifications of %s", k.email)
+			}
+			continue
+		}
+		ids := make([]string, 0, len(groups[k]))
+		for _, item := range groups[k] {
+			ids = append(ids, item.ID)
+		}
+		log.Printf("[DEBUG] digest with %d comments sent to %s", len(ids), k.email)
+		if err = e.Digest.Delete(ids...); err != nil {
+			return errors.Wrapf(err, "can't delete sent digest notifications of %s", k.email)
+		}
+	}
+	return nil
+}
+
+// refreshDigestItems re-reads comments of notifications, returns notifications to send and ids of dropped ones.
+// Deleted comments dropped, pending ones dropped for users and kept for admins waiting to moderate them
+func (e *Email) refreshDigestItems(items []DigestItem) (res []DigestItem, dropped []string) {
+	if e.CommentFn == nil {
+		return items, nil
+	}
+	res = make([]DigestItem, 0, len(items))
+	for _, item := range items {
+		c, err := e.CommentFn(item.Comment.Locator, item.Comment.ID)
+		if err != nil || c.Deleted || (c.Pending && !item.ForAdmin) {
+			dropped = append(dropped, item.ID)
+			continue
+		}
+		item.Comment = c
+		if item.Parent.ID != "" {
+			if p, perr := e.CommentFn(item.Parent.Locator, item.Parent.ID); perr == nil {
+				item.Parent = p
+			}
+		}
+		res = append(res, item)
+	}
+	return res, dropped
+}
+
+// failDigest counts failed attempt of notifications, drops ones failed digestMaxAttempts times
+func (e *Email) failDigest(items []DigestItem) error {
+	var dropped []string
+	for _, item := range items {
+		item.Attempts++
+		if item.Attempts >= digestMaxAttempts {
+			log.Printf("[WARN] digest notification of comment %s for %s dropped after %d attempts", item.Comment.ID, item.Email, item.Attempts)
+			dropped = append(dropped, item.ID)
+			continue
+		}
+		if err := e.Digest.Add(item); err != nil {
+			return err
+		}
+	}
+	return e.Digest.Delete(dropped...)
+}
+
+// buildDigestMessage generates digest email message from notifications of the same recipient, grouped by post
+func (e *Email) buildDigestMessage(items []DigestItem) (string, error) {
+	first := items[0]
+	data := digestTmplData{Email: first.Email, ForAdmin: first.ForAdmin, Count: len(items)}
+	subject := fmt.Sprintf("%d new comments for you", len(items))
+	if first.ForAdmin {
+		subject = fmt.Sprintf("%d new comments to your site", len(items))
+	}
+
+	if !first.ForAdmin {
+		token, err := e.TokenGenFn(first.UserID, first.Email, first.SiteID)
+		if err != nil {
+			return "", errors.Wrapf(err, "error creating token for unsubscribe link")
+		}
+		data.UnsubscribeLink = e.UnsubscribeURL + "?site=" + first.SiteID + "&tkn=" + token
+	}
+
+	posts := map[string]int{} // index of post in data.Posts by url
+	for _, item := range items {
+		c := item.Comment
+		idx, ok := posts[c.Locator.URL]
+		if !ok {
+			idx = len(data.Posts)
+			posts[c.Locator.URL] = idx
+			data.Posts = append(data.Posts, digestPost{PostTitle: c.PostTitle, PostURL: c.Locator.URL})
+		}
+		commentURLPrefix := c.Locator.URL + uiNav
+		comment := msgTmplData{
+			UserName:      c.User.Name,
+			UserPicture:   c.User.Picture,
+			CommentText:   c.Text,
+			CommentLink:   commentURLPrefix + c.ID,
+			CommentDate:   c.Timestamp,
+			PostTitle:     c.PostTitle,
+			Email:         item.Email,
+			ForAdmin:      item.ForAdmin,
+			ForSubscriber: item.ForSubscriber,
+		}
+		if data.UnsubscribeLink != "" {
+			comment.UnsubscribePostLink = data.UnsubscribeLink + "&url=" + url.QueryEscape(c.Locator.URL)
+		}
+		if c.ParentID != "" {
+			comment.ParentUserName = item.Parent.User.Name
+			comment.ParentUserPicture = item.Parent.User.Picture
+			comment.ParentCommentText = item.Parent.Text
+			comment.ParentCommentLink = commentURLPrefix + item.Parent.ID
+			comment.ParentCommentDate = item.Parent.Timestamp
+		}
+		data.Posts[idx].Comments = append(data.Posts[idx].Comments, comment)
+	}
+
+	msg := bytes.Buffer{}
+	if err := e.digestTmpl.Execute(&msg, data); err != nil {
+		return "", errors.Wrapf(err, "error executing template to build digest message")
+	}
+	return e.buildMessage(subject, msg.String(), first.Email, "text/html", data.UnsubscribeLink, "")
+}
+
+// BoltDigest implements DigestStore with bolt DB
+type BoltDigest struct {
+	db *bolt.DB
+}
+
+const (
+	digestModesBktName   = "digest_modes"   // nested bucket per site, key is user id
+	digestPendingBktName = "digest_pending" // key is due time and uuid
+)
+
+// NewBoltDigest makes persistent digest store in bolt file
+func NewBoltDigest(fileName string, options bolt.Options) (*BoltDigest, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{digestModesBktName, digestPendingBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltDigest{db: db}, nil
+}
+
+// Mode returns digest mode of the user, DigestImmediate if not set
+func (b *BoltDigest) Mode(siteID, userID string) (mode DigestMode, err error) {
+	mode = DigestImmediate
+	err = b.db.View(func(tx *bolt.Tx) error {
+		siteBkt := tx.Bucket([]byte(digestModesBktName)).Bucket([]byte(siteID))
+		if siteBkt == nil {
+			return nil
+		}
+		if v := siteBkt.Get([]byte(userID)); v != nil {
+			mode = DigestMode(v)
+		}
+		return nil
+	})
+	return mode, err
+}
+
+// SetMode sets digest mode of the user
+func (b *BoltDigest) SetMode(siteID, userID string, mode DigestMode) error {
+	if !mode.Valid() {
+		return errors.Errorf("unknown digest mode %q", mode)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		siteBkt, err := tx.Bucket([]byte(digestModesBktName)).CreateBucketIfNotExists([]byte(siteID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make digest modes bucket for %s", siteID)
+		}
+		if mode == DigestImmediate {
+			return errors.Wrapf(siteBkt.Delete([]byte(userID)), "can't delete digest mode of %s", userID)
+		}
+		return errors.Wrapf(siteBkt.Put([]byte(userID), []byte(mode)), "can't put digest mode of %s", userID)
+	})
+}
+
+// Add puts pending notification
+func (b *BoltDigest) Add(item DigestItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal digest item %s", item.ID)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return errors.Wrapf(tx.Bucket([]byte(digestPendingBktName)).Put([]byte(item.ID), data), "can't put digest item %s", item.ID)
+	})
+}
+
+// Due returns pending notifications with due time before or equal till
+func (b *BoltDigest) Due(till time.Time) (res []DigestItem, err error) {
+	res = []DigestItem{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(digestPendingBktName)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item := DigestItem{}
+			if e := json.Unmarshal(v, &item); e != nil {
+				return errors.Wrapf(e, "can't unmarshal digest item %s", string(k))
+			}
+			if item.Due.After(till) {
+				break // keys sorted by due time
+			}
+			res = append(res, item)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Delete removes notifications
+func (b *BoltDigest) Delete(ids ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(digestPendingBktName))
+		for _, id := range ids {
+			if err := bkt.Delete([]byte(id)); err != nil {
+				return errors.Wrapf(err, "can't delete digest item %s", id)
+			}
+		}
+		return nil
+	})
+}
+
+// Close bolt DB
+func (b *BoltDigest) Close() error {
+	return b.db.Close()
+}
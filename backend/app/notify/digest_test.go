@@ -0,0 +1,228 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltDigest(t *testing.T) {
+	d, teardown := prepBoltDigest(t)
+	defer teardown()
+
+	mode, err := d.Mode("remark42", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, DigestImmediate, mode, "default mode")
+
+	require.NoError(t, d.SetMode("remark42", "user1", DigestDaily))
+	mode, err = d.Mode("remark42", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, DigestDaily, mode)
+	mode, err = d.Mode("other", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, DigestImmediate, mode, "mode set per site")
+
+	assert.EqualError(t, d.SetMode("remark42", "user1", "weekly"), `unknown digest mode "weekly"`)
+	require.NoError(t, d.SetMode("remark42", "user1", DigestImmediate))
+	mode, err = d.Mode("remark42", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, DigestImmediate, mode)
+
+	now := time.Date(2020, 5, 1, 10, 30, 0, 0, time.Local)
+	require.NoError(t, d.Add(DigestItem{ID: "2", Due: now.Add(time.Hour), Comment: store.Comment{ID: "c2"}}))
+	require.NoError(t, d.Add(DigestItem{ID: "1", Due: now, Comment: store.Comment{ID: "c1"}}))
+	require.NoError(t, d.Add(DigestItem{ID: "3", Due: now.Add(2 * time.Hour), Comment: store.Comment{ID: "c3"}}))
+
+	items, err := d.Due(now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+
+	items, err = d.Due(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(items))
+	assert.Equal(t, "c1", items[0].Comment.ID)
+	assert.Equal(t, "c2", items[1].Comment.ID)
+
+	require.NoError(t, d.Delete("1", "2"))
+	items, err = d.Due(now.Add(24 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "c3", items[0].Comment.ID)
+}
+
+func TestEmail_DigestDue(t *testing.T) {
+	e := Email{EmailParams: EmailParams{DigestHour: 8}}
+	now := time.Date(2020, 5, 1, 10, 30, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2020, 5, 1, 11, 0, 0, 0, time.Local), e.digestDue(DigestHourly, now))
+	assert.Equal(t, time.Date(2020, 5, 2, 8, 0, 0, 0, time.Local), e.digestDue(DigestDaily, now))
+	assert.Equal(t, time.Date(2020, 5, 1, 8, 0, 0, 0, time.Local), e.digestDue(DigestDaily, now.Add(-3*time.Hour)))
+	assert.Equal(t, time.Date(2020, 5, 2, 8, 0, 0, 0, time.Local), e.digestDue(DigestDaily, now.Add(-150*time.Minute)))
+}
+
+func TestEmail_SendDigest(t *testing.T) {
+	d, teardown := prepBoltDigest(t)
+	defer teardown()
+	require.NoError(t, d.SetMode("remark42", "u2", DigestHourly))
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", AdminEmails: []string{"admin@example.org"},
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl",
+		DigestTemplatePath: "testdata/digest.html.tmpl", Digest: d, AdminDigest: true,
+		UnsubscribeURL: "https://remark42.com/email/unsubscribe.html"}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = TokenGenFn
+
+	post1 := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	post2 := store.Locator{SiteID: "remark42", URL: "https://example.com/post2"}
+	parent := store.Comment{ID: "p1", Text: "parent text", User: store.User{ID: "u2", Name: "user2"}, Locator: post1}
+	reqs := []Request{
+		{Comment: store.Comment{ID: "c1", ParentID: "p1", Text: "reply 1", User: store.User{Name: "user1"}, Locator: post1},
+			parent: parent, Emails: []string{"u2@example.com", "u3@example.com"},
+			EmailUsers: map[string]string{"u2@example.com": "u2", "u3@example.com": "u3"}},
+		{Comment: store.Comment{ID: "c2", Text: "new comment", User: store.User{Name: "user1"}, Locator: post2, PostTitle: "Post 2"},
+			Emails: []string{"u2@example.com"}, EmailUsers: map[string]string{"u2@example.com": "u2"}, Subscribers: []string{"u2@example.com"}},
+	}
+	for _, req := range reqs {
+		require.NoError(t, email.Send(context.Background(), req))
+	}
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "only u3 in immediate mode")
+	assert.Equal(t, "u3@example.com", fakeSMTP.readRcpt())
+
+	require.NoError(t, email.Send(context.Background(), Request{Comment: store.Comment{ID: "c3", Locator: post1},
+		Reports: []store.Report{{Reason: store.ReportSpam}}}))
+	assert.Equal(t, 2, fakeSMTP.readQuitCount(), "reports sent to admin immediately")
+
+	items, err := d.Due(time.Now().Add(25 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(items), "two for u2 and two for admin")
+
+	require.NoError(t, email.sendDigests(context.Background(), time.Now()))
+	assert.Equal(t, 2, fakeSMTP.readQuitCount(), "nothing due yet")
+
+	require.NoError(t, email.sendDigests(context.Background(), time.Now().Add(25*time.Hour)))
+	assert.Equal(t, 4, fakeSMTP.readQuitCount(), "one digest for u2 and one for admin")
+	items, err = d.Due(time.Now().Add(25 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(items), "sent notifications removed")
+
+	msg, err := email.buildDigestMessage([]DigestItem{
+		{SiteID: "remark42", UserID: "u2", Email: "u2@example.com", Comment: reqs[0].Comment, Parent: parent},
+		{SiteID: "remark42", UserID: "u2", Email: "u2@example.com", Comment: reqs[1].Comment, ForSubscriber: true},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, msg, "Subject: 2 new comments for you")
+	assert.Contains(t, msg, "List-Unsubscribe: <https://remark42.com/email/unsubscribe.html?site=remark42&tkn=token>")
+	assert.Contains(t, msg, "Post: https://example.com/post1=20\r\n\tParent: user2: parent text\r\n\tuser1: reply 1")
+	assert.Contains(t, msg, "Post: https://example.com/post2 Post 2\r\n\tuser1: new comment")
+
+	msg, err = email.buildDigestMessage([]DigestItem{{SiteID: "remark42", Email: "admin@example.org", ForAdmin: true,
+		Comment: reqs[1].Comment}})
+	require.NoError(t, err)
+	assert.Contains(t, msg, "1 new comments on your site")
+	assert.NotContains(t, msg, "List-Unsubscribe")
+}
+
+func TestEmail_SendDigestRefresh(t *testing.T) {
+	d, teardown := prepBoltDigest(t)
+	defer teardown()
+
+	post := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	comments := map[string]store.Comment{
+		"c1": {ID: "c1", Text: "edited text", User: store.User{Name: "user1"}, Locator: post},
+		"c2": {ID: "c2", Text: "deleted", Deleted: true, Locator: post},
+		"c3": {ID: "c3", Text: "pending text", Pending: true, Locator: post},
+	}
+	email, err := NewEmail(EmailParams{From: "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl",
+		DigestTemplatePath: "testdata/digest.html.tmpl", Digest: d,
+		CommentFn: func(locator store.Locator, id string) (store.Comment, error) {
+			c, ok := comments[id]
+			if !ok {
+				return store.Comment{}, errors.New("not found")
+			}
+			return c, nil
+		}}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = TokenGenFn
+
+	due := time.Now().Add(-time.Minute)
+	for i, id := range []string{"c1", "c2", "c3", "c4"} {
+		require.NoError(t, d.Add(DigestItem{ID: fmt.Sprintf("%d-u", i), SiteID: "remark42", UserID: "u2", Email: "u2@example.com",
+			Due: due, Comment: store.Comment{ID: id, Text: "old text", Locator: post}}))
+	}
+	require.NoError(t, d.Add(DigestItem{ID: "4-a", SiteID: "remark42", Email: "admin@example.org", ForAdmin: true,
+		Due: due, Comment: store.Comment{ID: "c3", Text: "old text", Locator: post}}))
+
+	require.NoError(t, email.sendDigests(context.Background(), time.Now()))
+	assert.Equal(t, 2, fakeSMTP.readQuitCount(), "digest for user and for admin")
+	msg := fakeSMTP.buff.String()
+	assert.Contains(t, msg, "Subject: 1 new comments for you", "deleted, pending and missing comments dropped")
+	assert.Contains(t, msg, "user1: edited text")
+	assert.Contains(t, msg, "Subject: 1 new comments to your site", "pending comment kept for admin")
+	assert.Contains(t, msg, "pending text")
+	assert.NotContains(t, msg, "old text")
+	items, err := d.Due(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+}
+
+func TestEmail_SendDigestFailed(t *testing.T) {
+	d, teardown := prepBoltDigest(t)
+	defer teardown()
+
+	email, err := NewEmail(EmailParams{From: "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl",
+		DigestTemplatePath: "testdata/digest.html.tmpl", Digest: d}, SMTPParams{})
+	require.NoError(t, err)
+	email.smtp = &fakeTestSMTP{fail: map[string]bool{"create": true}}
+	email.TokenGenFn = TokenGenFn
+
+	post := store.Locator{SiteID: "remark42", URL: "https://example.com/post1"}
+	due := time.Now().Add(-time.Minute)
+	require.NoError(t, d.Add(DigestItem{ID: "1", SiteID: "remark42", UserID: "u2", Email: "u2@example.com", Due: due,
+		Comment: store.Comment{ID: "c1", Locator: post}}))
+	require.NoError(t, d.Add(DigestItem{ID: "2", SiteID: "remark42", UserID: "u3", Email: "u3@example.com", Due: due,
+		Comment: store.Comment{ID: "c1", Locator: post}, Attempts: digestMaxAttempts - 1}))
+
+	require.NoError(t, email.sendDigests(context.Background(), time.Now()))
+	items, err := d.Due(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items), "notification failed max attempts dropped")
+	assert.Equal(t, "1", items[0].ID)
+	assert.Equal(t, 1, items[0].Attempts, "failed attempt counted")
+}
+
+func TestEmail_RunDigest(t *testing.T) {
+	email := Email{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	email.RunDigest(ctx) // returns immediately without digest store
+
+	d, teardown := prepBoltDigest(t)
+	defer teardown()
+	email.Digest = d
+	email.RunDigest(ctx) // returns on context cancellation
+	assert.Error(t, ctx.Err())
+}
+
+func prepBoltDigest(t *testing.T) (d *BoltDigest, teardown func()) {
+	tmpFile := prepOutboxFile(t)
+	d, err := NewBoltDigest(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	return d, func() {
+		_ = d.Close()
+		_ = os.Remove(tmpFile)
+	}
+}
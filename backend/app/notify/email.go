@@ -10,6 +10,7 @@ import (
 	"mime/quotedprintable"
 	"net"
 	"net/smtp"
+	"net/url"
 	"text/template"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -30,8 +32,21 @@ type EmailParams struct {
 	VerificationTemplatePath string   // path to verification template
 	SubscribeURL             string   // full subscribe handler URL
 	UnsubscribeURL           string   // full unsubscribe handler URL
+	DigestTemplatePath       string   // path to digest template, used with Digest only
+
+	Digest      DigestStore // keeps digest modes and pending notifications, digest disabled if nil
+	AdminDigest bool        // send daily digest of new comments to admins instead of email per comment, requires Digest
+	DigestHour  int         // hour of the day (local time) to send daily digests
+
+	ReplyAddress string     // base Reply-To address for replies by email, i.e. reply@example.com, replies disabled if empty
+	ReplyStore   ReplyStore // keeps reply tokens, required for replies by email
 
 	TokenGenFn func(userID, email, site string) (string, error) // Unsubscribe token generation function
+
+	SiteAdminEmails func(siteID string) []string // optional, per-site admin emails, replace AdminEmails if not empty
+
+	// optional, re-reads digest comments before sending to use current text and to drop deleted ones
+	CommentFn func(locator store.Locator, id string) (store.Comment, error)
 }
 
 // SMTPParams contain settings for smtp server connection
@@ -52,6 +67,7 @@ type Email struct {
 	smtp       smtpClientCreator
 	msgTmpl    *template.Template // parsed request message template
 	verifyTmpl *template.Template // parsed verification message template
+	digestTmpl *template.Template // parsed digest message template
 }
 
 // default email client implementation
@@ -80,20 +96,24 @@ type emailMessage struct {
 
 // msgTmplData store data for message from request template execution
 type msgTmplData struct {
-	UserName          string
-	UserPicture       string
-	CommentText       string
-	CommentLink       string
-	CommentDate       time.Time
-	ParentUserName    string
-	ParentUserPicture string
-	ParentCommentText string
-	ParentCommentLink string
-	ParentCommentDate time.Time
-	PostTitle         string
-	Email             string
-	UnsubscribeLink   string
-	ForAdmin          bool
+	UserName            string
+	UserPicture         string
+	CommentText         string
+	CommentLink         string
+	CommentDate         time.Time
+	ParentUserName      string
+	ParentUserPicture   string
+	ParentCommentText   string
+	ParentCommentLink   string
+	ParentCommentDate   time.Time
+	PostTitle           string
+	Email               string
+	UnsubscribeLink     string
+	UnsubscribePostLink string // unsubscribe from the post only
+	ForAdmin            bool
+	ForSubscriber       bool   // recipient subscribed to the post and not replied directly
+	CanReply            bool   // reply to the email posted as answer to the comment
+	ReportReasons       string // summary of users' reports, set for reported comment notification only
 }
 
 // verifyTmplData store data for verification message template execution
@@ -110,6 +130,7 @@ const (
 	defaultEmailTimeout                  = 10 * time.Second
 	defaultEmailTemplatePath             = "email_reply.html.tmpl"
 	defaultEmailVerificationTemplatePath = "email_confirmation_subscription.html.tmpl"
+	defaultEmailDigestTemplatePath       = "email_digest.html.tmpl"
 )
 
 // NewEmail makes new Email object, returns error in case of e.MsgTemplate or e.VerificationTemplate parsing error
@@ -164,11 +185,25 @@ func (e *Email) setTemplates() error {
 		return errors.Wrapf(err, "can't parse verification template")
 	}
 
+	if e.Digest == nil {
+		return nil
+	}
+	if e.DigestTemplatePath == "" {
+		e.DigestTemplatePath = defaultEmailDigestTemplatePath
+	}
+	digestTmplFile, err := fs.ReadFile(e.DigestTemplatePath)
+	if err != nil {
+		return errors.Wrapf(err, "can't read digest template")
+	}
+	if e.digestTmpl, err = template.New("digestTmpl").Parse(string(digestTmplFile)); err != nil {
+		return errors.Wrapf(err, "can't parse digest template")
+	}
+
 	return nil
 }
 
-// Send email about comment reply to Request.Emails and Email.AdminEmails
-// if they're set.
+// Send email about comment reply to Request.Emails and admin emails of the site
+// if they're set. Notification put to digest store instead for recipients with digest mode.
 // Thread safe
 func (e *Email) Send(ctx context.Context, req Request) error {
 	select {
@@ -180,11 +215,23 @@ func (e *Email) Send(ctx context.Context, req Request) error {
 	result := new(multierror.Error)
 
 	for _, email := range req.Emails {
+		if added, err := e.addToDigest(req, email, false, isSubscriber(req, email)); added || err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
 		err := e.buildAndSendMessage(ctx, req, email, false)
 		result = multierror.Append(errors.Wrapf(err, "problem sending user email notification to %q", email))
 	}
 
-	for _, email := range e.AdminEmails {
+	adminEmails := e.adminEmails(req.Comment.Locator.SiteID)
+	if req.Approved {
+		adminEmails = nil // admins notified about pending comment on creation
+	}
+	for _, email := range adminEmails {
+		if added, err := e.addToDigest(req, email, true, false); added || err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
 		err := e.buildAndSendMessage(ctx, req, email, true)
 		result = multierror.Append(errors.Wrapf(err, "problem sending admin email notification to %q", email))
 	}
@@ -192,6 +239,16 @@ func (e *Email) Send(ctx context.Context, req Request) error {
 	return result.ErrorOrNil()
 }
 
+// adminEmails returns admin emails of the site, AdminEmails used for sites without own emails
+func (e *Email) adminEmails(siteID string) []string {
+	if e.SiteAdminEmails != nil {
+		if emails := e.SiteAdminEmails(siteID); len(emails) > 0 {
+			return emails
+		}
+	}
+	return e.AdminEmails
+}
+
 func (e *Email) buildAndSendMessage(ctx context.Context, req Request, email string, forAdmin bool) error {
 	log.Printf("[DEBUG] send notification via %s, comment id %s", e, req.Comment.ID)
 	msg, err := e.buildMessageFromRequest(req, email, forAdmin)
@@ -246,40 +303,68 @@ func (e *Email) buildVerificationMessage(user, email, token, site string) (strin
 	if err != nil {
 		return "", errors.Wrapf(err, "error executing template to build verification message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", "")
+	return e.buildMessage(subject, msg.String(), email, "text/html", "", "")
 }
 
 // buildMessageFromRequest generates email message based on Request using e.MsgTemplate
 func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool) (string, error) {
+	forSubscriber := !forAdmin && isSubscriber(req, email)
 	subject := "New reply to your comment"
+	if forSubscriber {
+		subject = "New comment to the post you follow"
+	}
 	if forAdmin {
 		subject = "New comment to your site"
 	}
+	if len(req.Reports) > 0 {
+		subject = "Comment reported on your site"
+	}
 	if req.Comment.PostTitle != "" {
 		subject += fmt.Sprintf(" for %q", req.Comment.PostTitle)
 	}
 
-	token, err := e.TokenGenFn(req.parent.User.ID, email, req.Comment.Locator.SiteID)
+	userID := req.parent.User.ID
+	if id, ok := req.EmailUsers[email]; ok {
+		userID = id
+	}
+	token, err := e.TokenGenFn(userID, email, req.Comment.Locator.SiteID)
 	if err != nil {
 		return "", errors.Wrapf(err, "error creating token for unsubscribe link")
 	}
 	unsubscribeLink := e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
+	unsubscribePostLink := unsubscribeLink + "&url=" + url.QueryEscape(req.Comment.Locator.URL)
 	if forAdmin {
-		unsubscribeLink = ""
+		unsubscribeLink, unsubscribePostLink = "", ""
+	}
+
+	replyTo := ""
+	if e.ReplyAddress != "" && e.ReplyStore != nil && !forAdmin && len(req.Reports) == 0 {
+		tkn, err := e.ReplyStore.Add(ReplyToken{Locator: req.Comment.Locator, ParentID: req.Comment.ID, UserID: userID,
+			Issued: time.Now()})
+		if err != nil {
+			return "", errors.Wrapf(err, "error creating reply token")
+		}
+		replyTo = ReplyAddress(e.ReplyAddress, tkn)
 	}
 
 	commentURLPrefix := req.Comment.Locator.URL + uiNav
 	msg := bytes.Buffer{}
 	tmplData := msgTmplData{
-		UserName:        req.Comment.User.Name,
-		UserPicture:     req.Comment.User.Picture,
-		CommentText:     req.Comment.Text,
-		CommentLink:     commentURLPrefix + req.Comment.ID,
-		CommentDate:     req.Comment.Timestamp,
-		PostTitle:       req.Comment.PostTitle,
-		Email:           email,
-		UnsubscribeLink: unsubscribeLink,
-		ForAdmin:        forAdmin,
+		UserName:            req.Comment.User.Name,
+		UserPicture:         req.Comment.User.Picture,
+		CommentText:         req.Comment.Text,
+		CommentLink:         commentURLPrefix + req.Comment.ID,
+		CommentDate:         req.Comment.Timestamp,
+		PostTitle:           req.Comment.PostTitle,
+		Email:               email,
+		UnsubscribeLink:     unsubscribeLink,
+		UnsubscribePostLink: unsubscribePostLink,
+		ForAdmin:            forAdmin,
+		ForSubscriber:       forSubscriber,
+		CanReply:            replyTo != "",
+	}
+	if len(req.Reports) > 0 {
+		tmplData.ReportReasons = reportReasons(req.Reports)
 	}
 	// in case of message to admin, parent message might be empty
 	if req.Comment.ParentID != "" {
@@ -293,17 +378,30 @@ func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool
 	if err != nil {
 		return "", errors.Wrapf(err, "error executing template to build comment reply message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", unsubscribeLink)
+	return e.buildMessage(subject, msg.String(), email, "text/html", unsubscribeLink, replyTo)
+}
+
+// isSubscriber checks if email belongs to post subscriber and not to the parent comment author
+func isSubscriber(req Request, email string) bool {
+	for _, subscriber := range req.Subscribers {
+		if subscriber == email {
+			return true
+		}
+	}
+	return false
 }
 
 // buildMessage generates email message to send using net/smtp.Data()
-func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink string) (message string, err error) {
+func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink, replyTo string) (message string, err error) {
 	addHeader := func(msg, h, v string) string {
 		msg += fmt.Sprintf("%s: %s\n", h, v)
 		return msg
 	}
 	message = addHeader(message, "From", e.From)
 	message = addHeader(message, "To", to)
+	if replyTo != "" {
+		message = addHeader(message, "Reply-To", replyTo)
+	}
 	message = addHeader(message, "Subject", mime.BEncoding.Encode("utf-8", subject))
 	message = addHeader(message, "Content-Transfer-Encoding", "quoted-printable")
 
@@ -381,6 +479,9 @@ func (e *Email) sendMessage(m emailMessage) error {
 }
 
 // String representation of Email object
+// Name of the destination, doesn't depend on settings
+func (e *Email) Name() string { return "email" }
+
 func (e *Email) String() string {
 	return fmt.Sprintf("email: from %q with username '%s' at server %s:%d", e.From, e.Username, e.Host, e.Port)
 }
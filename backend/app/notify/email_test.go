@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net/smtp"
+	"regexp"
 	"sync"
 	"testing"
 	"text/template"
@@ -248,6 +249,103 @@ Content-Transfer-Encoding: quoted-printable
 MIME-version: 1.0
 Content-Type: text/html; charset="UTF-8"
 Date: `)
+
+	// per-site admin emails replace AdminEmails
+	email.SiteAdminEmails = func(siteID string) []string {
+		if siteID == "blog" {
+			return []string{"blog-admin@example.org"}
+		}
+		return nil
+	}
+	req = Request{Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title",
+		Locator: store.Locator{SiteID: "blog"}}}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 4, fakeSMTP.readQuitCount(), "one email for site admin")
+	assert.Equal(t, "blog-admin@example.org", fakeSMTP.readRcpt())
+	req.Comment.Locator.SiteID = "remark"
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 5, fakeSMTP.readQuitCount(), "one email for admin")
+	assert.Equal(t, "admin@example.org", fakeSMTP.readRcpt(), "site without own emails")
+
+	// approved comment sent to users only, admins notified about it on creation
+	req = Request{Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title"},
+		Emails: []string{"test@example.org"}, Approved: true}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 6, fakeSMTP.readQuitCount(), "one email for user")
+	assert.Equal(t, "test@example.org", fakeSMTP.readRcpt())
+}
+
+func TestEmail_SendSubscriber(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	email.UnsubscribeURL = "https://remark42.com/api/v1/email/unsubscribe"
+	var tokenUser string
+	email.TokenGenFn = func(user, _, _ string) (string, error) {
+		tokenUser = user
+		return "token", nil
+	}
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", PostTitle: "test_title",
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post?id=1"}},
+		parent:      store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails:      []string{"test@example.org"},
+		EmailUsers:  map[string]string{"test@example.org": "u3"},
+		Subscribers: []string{"test@example.org"},
+	}
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, "u3", tokenUser, "token made for recipient, not for parent comment's author")
+	assert.Contains(t, res, `Subject: New comment to the post you follow for "test_title"`)
+	assert.Contains(t, res, "&tkn=3Dtoken&url=3Dhttps%3A%2F%2Fexample.com%2Fpost%3Fid%3D", "quoted-printable post link")
+	assert.Contains(t, res, "List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=remark&tkn=token>", "global unsubscribe in header")
+}
+
+func TestEmail_SendReplyTo(t *testing.T) {
+	replyStore, teardown := prepBoltReply(t)
+	defer teardown()
+	email, err := NewEmail(EmailParams{From: "from@example.org", ReplyAddress: "reply@example.org", ReplyStore: replyStore,
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = TokenGenFn
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1",
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent:     store.Comment{ID: "1", User: store.User{ID: "u2", Name: "parent_user"}},
+		Emails:     []string{"test@example.org"},
+		EmailUsers: map[string]string{"test@example.org": "u2"},
+	}
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	m := regexp.MustCompile(`\nReply-To: reply\+([a-z2-7]+)@example\.org\n`).FindStringSubmatch(res)
+	require.Len(t, m, 2, "reply-to with token")
+	tkn, err := replyStore.Get(m[1])
+	require.NoError(t, err)
+	assert.Equal(t, ReplyToken{Locator: req.Comment.Locator, ParentID: "999", UserID: "u2", Issued: tkn.Issued}, tkn)
+	assert.WithinDuration(t, time.Now(), tkn.Issued, time.Minute)
+
+	res, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "Reply-To:", "admin can't reply by email")
+}
+
+func TestEmail_SendReport(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", AdminEmails: []string{"admin@example.org"},
+		VerificationTemplatePath: "testdata/verification.html.tmpl", MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = TokenGenFn
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title"},
+		Reports: []store.Report{{Reason: store.ReportSpam}, {Reason: store.ReportAbusive}},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, "admin@example.org", fakeSMTP.readRcpt())
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "admin only")
+	res, err := email.buildMessageFromRequest(req, email.AdminEmails[0], true)
+	require.NoError(t, err)
+	assert.Contains(t, res, `Subject: Comment reported on your site for "test_title"`)
 }
 
 func TestEmail_SendWithUnicodeInSubject(t *testing.T) {
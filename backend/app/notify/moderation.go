@@ -0,0 +1,26 @@
+package notify
+
+import "github.com/umputun/remark42/backend/app/store"
+
+// ModerationAction is moderation action requested with button of admin notification, in telegram or slack
+type ModerationAction struct {
+	Action    string // one of Moderation* constants
+	Locator   store.Locator
+	CommentID string
+	Via       string // messenger the action requested from, i.e. telegram
+}
+
+// moderation actions of notification buttons, used as a part of callback data
+const (
+	ModerationDelete       = "del"
+	ModerationBlockDay     = "b1d"
+	ModerationBlockForever = "bf"
+	ModerationPin          = "pin"
+	ModerationReadOnly     = "ro"
+	ModerationApprove      = "ok"
+)
+
+// Moderator applies moderation action on behalf of remark42 user, returns text shown to the user
+type Moderator interface {
+	Moderate(userID string, act ModerationAction) (result string, err error)
+}
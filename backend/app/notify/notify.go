@@ -4,6 +4,8 @@ package notify
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -19,6 +21,12 @@ type Service struct {
 	queue             chan Request
 	verificationQueue chan VerificationRequest
 
+	outbox       Outbox // optional, notifications kept till delivered if set
+	outboxParams OutboxParams
+	outboxLock   sync.Mutex    // serializes updates of outbox records
+	outboxWakeup chan struct{} // triggers outbox processing
+	outboxDone   chan struct{} // closed on outbox worker termination
+
 	closed uint32 // non-zero means closed. uses uint instead of bool for atomic
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -36,6 +44,7 @@ type Store interface {
 	Get(locator store.Locator, id string, user store.User) (store.Comment, error)
 	GetUserEmail(siteID string, userID string) (string, error)
 	GetUserTelegram(siteID string, userID string) (string, error)
+	Subscriptions(locator store.Locator) ([]store.Subscription, error)
 }
 
 // used for email and telegram retrieval from user details
@@ -47,15 +56,20 @@ type Request struct {
 	parent    store.Comment
 	Emails    []string
 	Telegrams []string
+	Reports   []store.Report // set for admin-only notification about reported comment
+	Approved  bool           // set for approved pending comment, admins notified about it on creation already
+
+	EmailUsers  map[string]string // user id by email, used in personal unsubscribe links
+	Subscribers []string          // emails of users notified as post subscribers, not as authors of parent comments
 }
 
 // VerificationRequest notification for user
 type VerificationRequest struct {
-	SiteID   string
-	User     string
-	Email    string // if set, send email only
-	Telegram string // if set, send telegram only
-	Token    string
+	SiteID   string `json:"site"`
+	User     string `json:"user"`
+	Email    string `json:"email,omitempty"`    // if set, send email only
+	Telegram string `json:"telegram,omitempty"` // if set, send telegram only
+	Token    string `json:"token"`
 }
 
 const defaultQueueSize = 100
@@ -82,17 +96,28 @@ func NewService(dataService Store, size int, destinations ...Destination) *Servi
 	return &res
 }
 
-// Submit Request to internal channel if not busy, drop if can't send
+// Submit Request to outbox if enabled, otherwise to internal channel if not busy, drop if can't send
 func (s *Service) Submit(req Request) {
 	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
 		return
 	}
-	if s.dataService != nil && req.Comment.ParentID != "" {
-		if p, err := s.dataService.Get(req.Comment.Locator, req.Comment.ParentID, store.User{}); err == nil {
-			req.parent = p
-			req.Emails = s.getNotificationTargets(req, p, s.dataService.GetUserEmail)
-			req.Telegrams = s.getNotificationTargets(req, p, s.dataService.GetUserTelegram)
+	// notifications about reported and pending comments are for admins only, no need to find users for them
+	if s.dataService != nil && len(req.Reports) == 0 && !req.Comment.Pending {
+		if req.Comment.ParentID != "" {
+			if p, err := s.dataService.Get(req.Comment.Locator, req.Comment.ParentID, store.User{}); err == nil {
+				req.parent = p
+			}
+		}
+		s.setRecipients(&req)
+	}
+	if s.outbox != nil {
+		oreq := OutboxRequest{Comment: req.Comment, Parent: req.parent, Emails: req.Emails, Telegrams: req.Telegrams,
+			Reports: req.Reports, Approved: req.Approved, EmailUsers: req.EmailUsers, Subscribers: req.Subscribers}
+		err := s.putOutbox(req.Comment.Locator.SiteID, &oreq, nil)
+		if err == nil {
+			return
 		}
+		log.Printf("[WARN] can't put notification to outbox, fallback to queue, %v", err)
 	}
 	select {
 	case s.queue <- req:
@@ -101,39 +126,115 @@ func (s *Service) Submit(req Request) {
 	}
 }
 
-// getNotificationTargets returns list of notification targets (like email or telegram username) for users
-// interested in notifications for provided comment.
-// Targets are not added to the returned list in case the original message
-// is from the same user as the notification receiver.
-// Results are deduplicated.
-func (s *Service) getNotificationTargets(
-	req Request,
-	notifyComment store.Comment,
-	getUserDetail getUserDetail,
-) (result []string) {
-	// add current user email only if the user is not the one who wrote the original comment
-	if notifyComment.User.ID != req.Comment.User.ID {
-		detail, err := getUserDetail(req.Comment.Locator.SiteID, notifyComment.User.ID)
-		if err != nil {
-			log.Printf("[WARN] can't read notification detail for %s, %v", notifyComment.User.ID, err)
+// setRecipients sets emails and telegrams of users interested in the comment: authors of parent comments
+// and users subscribed to the post. Author of the comment and users muted the post or the comment's thread
+// are not notified. Results are deduplicated.
+func (s *Service) setRecipients(req *Request) {
+	users := []string{}
+	if req.parent.ID != "" {
+		users = s.getNotificationTargets(*req, req.parent)
+	}
+	replied := map[string]bool{}
+	for _, userID := range users {
+		replied[userID] = true
+	}
+
+	muted := map[string]bool{}
+	subs, err := s.dataService.Subscriptions(req.Comment.Locator)
+	if err != nil {
+		log.Printf("[WARN] can't read subscriptions for %s, %v", req.Comment.Locator.URL, err)
+	}
+	threadID := ""
+	for _, sub := range subs {
+		switch {
+		case sub.Mode == store.SubscriptionPost:
+			users = append(users, sub.UserID)
+		case sub.Mode == store.SubscriptionMute && sub.ThreadID == "":
+			muted[sub.UserID] = true
+		case sub.Mode == store.SubscriptionMute:
+			if threadID == "" {
+				threadID = s.threadRoot(*req)
+			}
+			if sub.ThreadID == threadID {
+				muted[sub.UserID] = true
+			}
 		}
-		if detail != "" {
-			result = append(result, detail)
+	}
+
+	for _, userID := range deduplicateStrings(users) {
+		if userID == req.Comment.User.ID || muted[userID] {
+			continue
 		}
+		if email := s.getUserDetail(req.Comment.Locator.SiteID, userID, s.dataService.GetUserEmail); email != "" {
+			req.Emails = append(req.Emails, email)
+			if req.EmailUsers == nil {
+				req.EmailUsers = map[string]string{}
+			}
+			req.EmailUsers[email] = userID
+			if !replied[userID] {
+				req.Subscribers = append(req.Subscribers, email)
+			}
+		}
+		if telegram := s.getUserDetail(req.Comment.Locator.SiteID, userID, s.dataService.GetUserTelegram); telegram != "" {
+			req.Telegrams = append(req.Telegrams, telegram)
+		}
+	}
+	req.Emails = deduplicateStrings(req.Emails)
+	req.Telegrams = deduplicateStrings(req.Telegrams)
+}
+
+// getNotificationTargets returns ids of users wrote provided comment and all its parents,
+// except the author of the notified comment
+func (s *Service) getNotificationTargets(req Request, notifyComment store.Comment) (result []string) {
+	if notifyComment.User.ID != req.Comment.User.ID {
+		result = append(result, notifyComment.User.ID)
 	}
 	if notifyComment.ParentID != "" {
 		if p, err := s.dataService.Get(req.Comment.Locator, notifyComment.ParentID, store.User{}); err == nil {
-			result = append(result, s.getNotificationTargets(req, p, getUserDetail)...)
+			result = append(result, s.getNotificationTargets(req, p)...)
 		}
 	}
-	return deduplicateStrings(result)
+	return result
+}
+
+// threadRoot returns id of the root comment of the comment's thread
+func (s *Service) threadRoot(req Request) string {
+	if req.Comment.ParentID == "" {
+		return req.Comment.ID
+	}
+	root := req.parent
+	for root.ParentID != "" {
+		p, err := s.dataService.Get(req.Comment.Locator, root.ParentID, store.User{})
+		if err != nil {
+			log.Printf("[WARN] can't get parent %s of comment %s, %v", root.ParentID, req.Comment.ID, err)
+			return root.ID
+		}
+		root = p
+	}
+	return root.ID
+}
+
+// getUserDetail returns user's detail like email or telegram, empty if not set
+func (s *Service) getUserDetail(siteID, userID string, fn getUserDetail) string {
+	detail, err := fn(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read notification detail for %s, %v", userID, err)
+	}
+	return detail
 }
 
-// SubmitVerification to internal channel if not busy, drop if can't send
+// SubmitVerification to outbox if enabled, otherwise to internal channel if not busy, drop if can't send
 func (s *Service) SubmitVerification(req VerificationRequest) {
 	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
 		return
 	}
+	if s.outbox != nil {
+		err := s.putOutbox(req.SiteID, nil, &req)
+		if err == nil {
+			return
+		}
+		log.Printf("[WARN] can't put verification to outbox, fallback to queue, %v", err)
+	}
 	select {
 	case s.verificationQueue <- req:
 	default:
@@ -150,6 +251,14 @@ func (s *Service) Close() {
 		s.cancel()
 		<-s.ctx.Done()
 	}
+	if s.outbox != nil {
+		if s.outboxDone != nil {
+			<-s.outboxDone
+		}
+		if err := s.outbox.Close(); err != nil {
+			log.Printf("[WARN] can't close notification outbox, %v", err)
+		}
+	}
 	atomic.StoreUint32(&s.closed, 1)
 }
 
@@ -192,6 +301,20 @@ func (s *Service) do() {
 	}
 }
 
+// reportReasons makes human-readable summary of reports, like "2 reports: spam, abusive"
+func reportReasons(reports []store.Report) string {
+	reasons := []string{}
+	for _, r := range reports {
+		reasons = append(reasons, string(r.Reason))
+	}
+	reasons = deduplicateStrings(reasons)
+	sort.Strings(reasons)
+	if len(reports) == 1 {
+		return "1 report: " + strings.Join(reasons, ", ")
+	}
+	return fmt.Sprintf("%d reports: %s", len(reports), strings.Join(reasons, ", "))
+}
+
 // NopService is do-nothing notifier, without destinations
 var NopService = &Service{}
 
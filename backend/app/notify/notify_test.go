@@ -196,6 +196,139 @@ func TestService_EmailRetrieval(t *testing.T) {
 	s.Close()
 }
 
+func TestService_Subscriptions(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", User: store.User{ID: "u4"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u2"] = "u2@example.com"
+	dataStore.userDetails["u3"] = "u3@example.com"
+	dataStore.subscriptions = []store.Subscription{
+		{UserID: "u3", Mode: store.SubscriptionPost},
+		{UserID: "u2", Mode: store.SubscriptionPost},
+		{UserID: "u1", Mode: store.SubscriptionMute},
+	}
+
+	s := NewService(dataStore, 1, dest)
+
+	// reply to muted u1, notified subscribed u3 only, u2 is the author
+	s.Submit(Request{Comment: dataStore.data["p2"]})
+	time.Sleep(time.Millisecond * 110)
+
+	// top-level comment, both subscribers notified
+	s.Submit(Request{Comment: dataStore.data["p3"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 2, len(destRes))
+	assert.Equal(t, "p1", destRes[0].parent.ID)
+	assert.Equal(t, []string{"u3@example.com"}, destRes[0].Emails)
+	assert.Equal(t, []string{"u3@example.com"}, destRes[0].Subscribers)
+	assert.Equal(t, map[string]string{"u3@example.com": "u3"}, destRes[0].EmailUsers)
+	assert.ElementsMatch(t, []string{"u3@example.com", "u2@example.com"}, destRes[1].Emails)
+	assert.ElementsMatch(t, []string{"u3@example.com", "u2@example.com"}, destRes[1].Telegrams)
+	assert.Equal(t, "u2", destRes[1].EmailUsers["u2@example.com"])
+}
+
+func TestService_MutedThread(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", ParentID: "p2", User: store.User{ID: "u3"}}
+	dataStore.data["p4"] = store.Comment{ID: "p4", User: store.User{ID: "u3"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u2"] = "u2@example.com"
+	dataStore.subscriptions = []store.Subscription{
+		{UserID: "u1", Mode: store.SubscriptionPost},
+		{UserID: "u1", ThreadID: "p1", Mode: store.SubscriptionMute},
+	}
+
+	s := NewService(dataStore, 1, dest)
+
+	// reply deep in the muted thread, u1 skipped even as author of the root, u2 notified as parent
+	s.Submit(Request{Comment: dataStore.data["p3"]})
+	time.Sleep(time.Millisecond * 110)
+
+	// comment outside of the muted thread, u1 notified as post subscriber
+	s.Submit(Request{Comment: dataStore.data["p4"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 2, len(destRes))
+	assert.Equal(t, []string{"u2@example.com"}, destRes[0].Emails)
+	assert.Equal(t, []string{"u1@example.com"}, destRes[1].Emails)
+	assert.Equal(t, []string{"u1@example.com"}, destRes[1].Subscribers)
+}
+
+func TestService_SubscriberRepliedTo(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.subscriptions = []store.Subscription{{UserID: "u1", Mode: store.SubscriptionPost}}
+
+	s := NewService(dataStore, 1, dest)
+	s.Submit(Request{Comment: dataStore.data["p2"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []string{"u1@example.com"}, destRes[0].Emails, "notified once")
+	assert.Empty(t, destRes[0].Subscribers, "notified as author of the parent comment")
+}
+
+func TestService_WithReports(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+
+	s := NewService(dataStore, 1, dest)
+	reports := []store.Report{{CommentID: "p2", UserID: "u3", Reason: store.ReportSpam}}
+	s.Submit(Request{Comment: dataStore.data["p2"], Reports: reports})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, "p2", destRes[0].Comment.ID)
+	assert.Equal(t, reports, destRes[0].Reports)
+	assert.Empty(t, destRes[0].Emails, "users not notified about reports")
+	assert.Empty(t, destRes[0].parent)
+}
+
+func TestService_Pending(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}, Pending: true}
+	dataStore.userDetails["u1"] = "u1@example.com"
+
+	s := NewService(dataStore, 1, dest)
+	s.Submit(Request{Comment: dataStore.data["p2"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, "p2", destRes[0].Comment.ID)
+	assert.Empty(t, destRes[0].Emails, "users not notified about pending comment")
+}
+
+func Test_reportReasons(t *testing.T) {
+	assert.Equal(t, "1 report: spam", reportReasons([]store.Report{{Reason: store.ReportSpam}}))
+	assert.Equal(t, "3 reports: abusive, spam", reportReasons([]store.Report{{Reason: store.ReportSpam},
+		{Reason: store.ReportAbusive}, {Reason: store.ReportSpam}}))
+}
+
 func TestService_Recursive(t *testing.T) {
 	dest := &MockDest{id: 1}
 	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
@@ -277,8 +410,9 @@ func TestService_Nop(t *testing.T) {
 }
 
 type mockStore struct {
-	data        map[string]store.Comment
-	userDetails map[string]string
+	data          map[string]store.Comment
+	userDetails   map[string]string
+	subscriptions []store.Subscription
 }
 
 func (m mockStore) getUserDetail(userID string) (string, error) {
@@ -304,3 +438,7 @@ func (m mockStore) GetUserEmail(_, userID string) (string, error) {
 func (m mockStore) GetUserTelegram(_, userID string) (string, error) {
 	return m.getUserDetail(userID)
 }
+
+func (m mockStore) Subscriptions(store.Locator) ([]store.Subscription, error) {
+	return m.subscriptions, nil
+}
@@ -0,0 +1,659 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Outbox keeps notifications till delivered to all destinations
+type Outbox interface {
+	Put(rec OutboxRecord) error                                        // add or update record
+	Get(id string) (OutboxRecord, error)                               // get record by id
+	Delete(id string) error                                            // remove delivered record
+	List(siteID string, status DeliveryStatus) ([]OutboxRecord, error) // records with at least one delivery in status, all sites for empty siteID
+	Due(until time.Time) ([]OutboxRecord, error)                       // records with pending deliveries due by until
+	Prune(before time.Time) (int, error)                               // remove failed records not updated since before
+	Close() error
+}
+
+// namedDestination has a stable name used as the key of its deliveries in outbox, so pending deliveries
+// survive changes of the destination's settings. String used as the key for destinations without name
+type namedDestination interface {
+	Name() string
+}
+
+// targetedDestination sends to several independent targets, like webhook urls. Outbox delivers and retries
+// each target separately with SendTo, targets don't retry by themselves
+type targetedDestination interface {
+	Targets(siteID string) []string
+	SendTo(ctx context.Context, target string, req Request) error
+	SendVerificationTo(ctx context.Context, target string, req VerificationRequest) error
+}
+
+// outboxTarget is a destination, or a single target of the destination, with its own delivery in outbox
+type outboxTarget struct {
+	dest   Destination
+	target string // empty for destinations without targets
+}
+
+// DeliveryStatus of notification for a single destination
+type DeliveryStatus string
+
+// DeliveryStatus enum
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // waiting for the next attempt
+	DeliveryDelivered DeliveryStatus = "delivered" // sent successfully
+	DeliveryDead      DeliveryStatus = "dead"      // attempts exhausted, resent by admin only
+)
+
+// OutboxRecord is a notification with delivery state for each destination.
+// Either Request or Verification set
+type OutboxRecord struct {
+	ID           string               `json:"id"`
+	SiteID       string               `json:"site"`
+	Request      *OutboxRequest       `json:"request,omitempty"`
+	Verification *VerificationRequest `json:"verification,omitempty"`
+	Deliveries   map[string]*Delivery `json:"deliveries"` // keyed by destination name, name:target for targeted ones
+	Created      time.Time            `json:"created"`
+}
+
+// OutboxRequest is a storable Request, with parent comment resolved on submit
+type OutboxRequest struct {
+	Comment   store.Comment  `json:"comment"`
+	Parent    store.Comment  `json:"parent,omitempty"`
+	Emails    []string       `json:"emails,omitempty"`
+	Telegrams []string       `json:"telegrams,omitempty"`
+	Reports   []store.Report `json:"reports,omitempty"`
+	Approved  bool           `json:"approved,omitempty"`
+
+	EmailUsers  map[string]string `json:"email_users,omitempty"`
+	Subscribers []string          `json:"subscribers,omitempty"`
+}
+
+// Delivery is a state of notification for a single destination
+type Delivery struct {
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"next_attempt"`
+	LastError   string         `json:"last_error,omitempty"`
+	Updated     time.Time      `json:"updated"`
+}
+
+// OutboxParams defines retries of notifications in outbox
+type OutboxParams struct {
+	MaxAttempts   int           // delivery marked as dead after
+	RetryDelay    time.Duration // delay before the first retry, doubled for each next one
+	MaxRetryDelay time.Duration // max delay between retries
+	PollInterval  time.Duration // how often outbox checked for due deliveries
+	DeadRetention time.Duration // failed notifications removed if not resent during this time
+}
+
+const (
+	outboxMaxAttempts   = 10
+	outboxRetryDelay    = time.Minute
+	outboxMaxRetryDelay = 6 * time.Hour
+	outboxPollInterval  = 5 * time.Second
+	outboxDeadRetention = 30 * 24 * time.Hour
+)
+
+// errNoDestination set for deliveries to destinations not configured anymore, never retried
+var errNoDestination = errors.New("destination not configured")
+
+// NewOutboxService makes notification service keeping notifications in outbox till delivered to all destinations.
+// Failed deliveries retried with exponential backoff and marked as dead after MaxAttempts, notifications
+// without pending deliveries removed after DeadRetention
+func NewOutboxService(dataService Store, outbox Outbox, params OutboxParams, destinations ...Destination) *Service {
+	if params.MaxAttempts <= 0 {
+		params.MaxAttempts = outboxMaxAttempts
+	}
+	if params.RetryDelay <= 0 {
+		params.RetryDelay = outboxRetryDelay
+	}
+	if params.MaxRetryDelay <= 0 {
+		params.MaxRetryDelay = outboxMaxRetryDelay
+	}
+	if params.PollInterval <= 0 {
+		params.PollInterval = outboxPollInterval
+	}
+	if params.DeadRetention <= 0 {
+		params.DeadRetention = outboxDeadRetention
+	}
+	res := NewService(dataService, defaultQueueSize, destinations...)
+	res.outbox = outbox
+	res.outboxParams = params
+	res.outboxWakeup = make(chan struct{}, 1)
+	res.outboxDone = make(chan struct{})
+	if len(destinations) == 0 {
+		close(res.outboxDone)
+		return res
+	}
+	go res.doOutbox()
+	log.Printf("[INFO] notifier outbox enabled, max attempts=%d, retry delay=%s, max retry delay=%s, dead retention=%s",
+		params.MaxAttempts, params.RetryDelay, params.MaxRetryDelay, params.DeadRetention)
+	return res
+}
+
+// Failed returns notifications of the site with dead deliveries
+func (s *Service) Failed(siteID string) ([]OutboxRecord, error) {
+	if s == nil || s.outbox == nil {
+		return nil, errors.New("notification outbox disabled")
+	}
+	return s.outbox.List(siteID, DeliveryDead)
+}
+
+// Resend makes dead deliveries of the notification pending again, with reset attempts.
+// All failed notifications of the site resent for empty id. Returns number of resent notifications
+func (s *Service) Resend(siteID, id string) (int, error) {
+	if s == nil || s.outbox == nil {
+		return 0, errors.New("notification outbox disabled")
+	}
+	s.outboxLock.Lock()
+	defer s.outboxLock.Unlock()
+
+	var recs []OutboxRecord
+	var err error
+	switch id {
+	case "":
+		if recs, err = s.outbox.List(siteID, DeliveryDead); err != nil {
+			return 0, err
+		}
+	default:
+		rec, e := s.outbox.Get(id)
+		if e != nil || rec.SiteID != siteID {
+			return 0, errors.Errorf("notification %s not found", id)
+		}
+		recs = []OutboxRecord{rec}
+	}
+
+	count, now := 0, time.Now()
+	for _, rec := range recs {
+		if !rec.hasStatus(DeliveryDead) {
+			continue
+		}
+		for _, d := range rec.Deliveries {
+			if d.Status == DeliveryDead {
+				d.Status, d.Attempts, d.NextAttempt, d.Updated = DeliveryPending, 0, now, now
+			}
+		}
+		if err = s.outbox.Put(rec); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if count > 0 {
+		s.wakeOutbox()
+	}
+	return count, nil
+}
+
+func (s *Service) putOutbox(siteID string, req *OutboxRequest, vreq *VerificationRequest) error {
+	rec := newOutboxRecord(siteID, s.destinations)
+	rec.Request, rec.Verification = req, vreq
+	if err := s.outbox.Put(rec); err != nil {
+		return err
+	}
+	s.wakeOutbox()
+	return nil
+}
+
+func (s *Service) wakeOutbox() {
+	select {
+	case s.outboxWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// doOutbox sends due deliveries on each poll interval or new notification
+func (s *Service) doOutbox() {
+	defer close(s.outboxDone)
+	ticker := time.NewTicker(s.outboxParams.PollInterval)
+	defer ticker.Stop()
+	s.processOutbox() // pick up notifications left from the previous run
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.outboxWakeup:
+		}
+		s.processOutbox()
+	}
+}
+
+func (s *Service) processOutbox() {
+	s.pruneOutbox()
+	recs, err := s.outbox.Due(time.Now())
+	if err != nil {
+		log.Printf("[WARN] can't list notification outbox, %v", err)
+		return
+	}
+	for _, rec := range recs {
+		results := s.deliver(rec, time.Now())
+		if s.ctx.Err() != nil {
+			return // interrupted sends retried on the next start
+		}
+		if len(results) > 0 {
+			s.updateOutbox(rec.ID, results)
+		}
+	}
+}
+
+// pruneOutbox removes failed notifications not resent during DeadRetention
+func (s *Service) pruneOutbox() {
+	s.outboxLock.Lock()
+	defer s.outboxLock.Unlock()
+	n, err := s.outbox.Prune(time.Now().Add(-s.outboxParams.DeadRetention))
+	if err != nil {
+		log.Printf("[WARN] can't prune notification outbox, %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[INFO] removed %d failed notifications older than %s", n, s.outboxParams.DeadRetention)
+	}
+}
+
+// deliver sends notification to targets with due pending deliveries, returns errors by delivery key.
+// Deliveries to targets not configured anymore fail with errNoDestination
+func (s *Service) deliver(rec OutboxRecord, now time.Time) map[string]error {
+	res := map[string]error{}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	targets := outboxTargets(rec.SiteID, s.destinations)
+	for key, dl := range rec.Deliveries {
+		if dl.Status != DeliveryPending {
+			continue
+		}
+		t, ok := targets[key]
+		if !ok {
+			res[key] = errNoDestination
+			continue
+		}
+		if dl.NextAttempt.After(now) {
+			continue
+		}
+		wg.Add(1)
+		go func(key string, t outboxTarget) {
+			defer wg.Done()
+			err := t.send(s.ctx, rec)
+			lock.Lock()
+			res[key] = err
+			lock.Unlock()
+		}(key, t)
+	}
+	wg.Wait()
+	return res
+}
+
+// updateOutbox sets delivery results, schedules retries and removes notification delivered everywhere
+func (s *Service) updateOutbox(id string, results map[string]error) {
+	s.outboxLock.Lock()
+	defer s.outboxLock.Unlock()
+
+	rec, err := s.outbox.Get(id)
+	if err != nil {
+		log.Printf("[WARN] can't get notification %s from outbox, %v", id, err)
+		return
+	}
+	now := time.Now()
+	for name, e := range results {
+		dl, ok := rec.Deliveries[name]
+		if !ok || dl.Status != DeliveryPending {
+			continue
+		}
+		dl.Attempts++
+		dl.Updated = now
+		if e == nil {
+			dl.Status, dl.LastError = DeliveryDelivered, ""
+			continue
+		}
+		dl.LastError = e.Error()
+		if e == errNoDestination || dl.Attempts >= s.outboxParams.MaxAttempts {
+			dl.Status = DeliveryDead
+			log.Printf("[WARN] notification %s to %s failed after %d attempts, %v", id, name, dl.Attempts, e)
+			continue
+		}
+		dl.NextAttempt = now.Add(s.retryDelay(dl.Attempts))
+		log.Printf("[DEBUG] notification %s to %s failed, attempt %d, next at %s, %v",
+			id, name, dl.Attempts, dl.NextAttempt.Format(time.RFC3339), e)
+	}
+
+	if rec.done() {
+		err = s.outbox.Delete(id)
+	} else {
+		err = s.outbox.Put(rec)
+	}
+	if err != nil {
+		log.Printf("[WARN] can't update notification %s in outbox, %v", id, err)
+	}
+}
+
+// retryDelay doubles for each attempt, up to MaxRetryDelay
+func (s *Service) retryDelay(attempts int) time.Duration {
+	delay := s.outboxParams.RetryDelay
+	for i := 1; i < attempts && delay < s.outboxParams.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.outboxParams.MaxRetryDelay {
+		delay = s.outboxParams.MaxRetryDelay
+	}
+	return delay
+}
+
+// outboxTargets returns targets of the site's deliveries by key, destination's name for destinations without
+// targets and name:target for each target of targeted destinations
+func outboxTargets(siteID string, destinations []Destination) map[string]outboxTarget {
+	res := map[string]outboxTarget{}
+	for _, d := range destinations {
+		name := d.String()
+		if nd, ok := d.(namedDestination); ok {
+			name = nd.Name()
+		}
+		td, ok := d.(targetedDestination)
+		if !ok {
+			res[name] = outboxTarget{dest: d}
+			continue
+		}
+		for _, t := range td.Targets(siteID) {
+			res[name+":"+t] = outboxTarget{dest: d, target: t}
+		}
+	}
+	return res
+}
+
+// send notification of the record to the target
+func (t outboxTarget) send(ctx context.Context, rec OutboxRecord) error {
+	td, targeted := t.dest.(targetedDestination)
+	switch {
+	case rec.Verification != nil && targeted:
+		return td.SendVerificationTo(ctx, t.target, *rec.Verification)
+	case rec.Verification != nil:
+		return t.dest.SendVerification(ctx, *rec.Verification)
+	case rec.Request != nil && targeted:
+		return td.SendTo(ctx, t.target, rec.Request.request())
+	case rec.Request != nil:
+		return t.dest.Send(ctx, rec.Request.request())
+	}
+	return nil
+}
+
+func newOutboxRecord(siteID string, destinations []Destination) OutboxRecord {
+	now := time.Now()
+	res := OutboxRecord{
+		// time prefix keeps records in order of creation
+		ID:         fmt.Sprintf("%020d-%s", now.UnixNano(), uuid.New().String()),
+		SiteID:     siteID,
+		Deliveries: map[string]*Delivery{},
+		Created:    now,
+	}
+	for key := range outboxTargets(siteID, destinations) {
+		res.Deliveries[key] = &Delivery{Status: DeliveryPending, NextAttempt: now, Updated: now}
+	}
+	return res
+}
+
+// request makes Request from stored one
+func (r OutboxRequest) request() Request {
+	return Request{Comment: r.Comment, parent: r.Parent, Emails: r.Emails, Telegrams: r.Telegrams, Reports: r.Reports,
+		Approved: r.Approved, EmailUsers: r.EmailUsers, Subscribers: r.Subscribers}
+}
+
+// hasStatus checks if any delivery of the record in given status
+func (r OutboxRecord) hasStatus(status DeliveryStatus) bool {
+	for _, d := range r.Deliveries {
+		if d.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// done checks if all deliveries completed successfully
+func (r OutboxRecord) done() bool {
+	for _, d := range r.Deliveries {
+		if d.Status != DeliveryDelivered {
+			return false
+		}
+	}
+	return true
+}
+
+// indexKeys returns keys of the record in due and dead indexes, nil if the record not in the index.
+// Record with pending deliveries is due at the earliest next attempt. Record without pending and
+// with dead deliveries is dead since the latest update
+func (r OutboxRecord) indexKeys() (due, dead []byte) {
+	var next, updated time.Time
+	pending, failed := false, false
+	for _, d := range r.Deliveries {
+		switch d.Status {
+		case DeliveryPending:
+			if !pending || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+			pending = true
+		case DeliveryDead:
+			failed = true
+		}
+		if d.Updated.After(updated) {
+			updated = d.Updated
+		}
+	}
+	if pending {
+		return outboxIndexKey(next, r.ID), nil
+	}
+	if failed {
+		return nil, outboxIndexKey(updated, r.ID)
+	}
+	return nil, nil
+}
+
+// outboxIndexKey makes sortable index key as ts!id, times before unix epoch use 0 ts
+func outboxIndexKey(ts time.Time, id string) []byte {
+	return []byte(outboxIndexTime(ts) + "!" + id)
+}
+
+func outboxIndexTime(ts time.Time) string {
+	var ns int64
+	if ts.After(time.Unix(0, 0)) {
+		ns = ts.UnixNano()
+	}
+	return fmt.Sprintf("%020d", ns)
+}
+
+const (
+	outboxBktName     = "outbox"
+	outboxDueBktName  = "outbox_due"  // index of records with pending deliveries, key is next attempt ts!id
+	outboxDeadBktName = "outbox_dead" // index of failed records without pending deliveries, key is updated ts!id
+)
+
+// BoltOutbox implements Outbox with bolt DB. Records kept in "outbox" bucket keyed by id,
+// due and dead indexes used to find records for delivery and records to prune without reading all of them
+type BoltOutbox struct {
+	db *bolt.DB
+}
+
+// NewBoltOutbox makes persistent outbox in bolt file
+func NewBoltOutbox(fileName string, options bolt.Options) (*BoltOutbox, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		reindex := tx.Bucket([]byte(outboxDueBktName)) == nil // outbox made before indexes added
+		for _, bktName := range []string{outboxBktName, outboxDueBktName, outboxDeadBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		if !reindex {
+			return nil
+		}
+		return tx.Bucket([]byte(outboxBktName)).ForEach(func(k, v []byte) error {
+			rec := OutboxRecord{}
+			if e := json.Unmarshal(v, &rec); e != nil {
+				return errors.Wrapf(e, "can't unmarshal outbox record %s", string(k))
+			}
+			return indexOutbox(tx, rec)
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltOutbox{db: db}, nil
+}
+
+// Put adds or updates record
+func (b *BoltOutbox) Put(rec OutboxRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal outbox record %s", rec.ID)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if e := unindexOutbox(tx, rec.ID); e != nil {
+			return e
+		}
+		if e := tx.Bucket([]byte(outboxBktName)).Put([]byte(rec.ID), data); e != nil {
+			return errors.Wrapf(e, "can't put outbox record %s", rec.ID)
+		}
+		return indexOutbox(tx, rec)
+	})
+}
+
+// Get returns record by id
+func (b *BoltOutbox) Get(id string) (rec OutboxRecord, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(outboxBktName)).Get([]byte(id))
+		if data == nil {
+			return errors.Errorf("outbox record %s not found", id)
+		}
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, err
+}
+
+// Delete removes record
+func (b *BoltOutbox) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if e := unindexOutbox(tx, id); e != nil {
+			return e
+		}
+		return errors.Wrapf(tx.Bucket([]byte(outboxBktName)).Delete([]byte(id)), "can't delete outbox record %s", id)
+	})
+}
+
+// List returns records of the site with at least one delivery in given status, oldest first
+func (b *BoltOutbox) List(siteID string, status DeliveryStatus) (res []OutboxRecord, err error) {
+	res = []OutboxRecord{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(outboxBktName)).ForEach(func(k, v []byte) error {
+			rec := OutboxRecord{}
+			if e := json.Unmarshal(v, &rec); e != nil {
+				return errors.Wrapf(e, "can't unmarshal outbox record %s", string(k))
+			}
+			if (siteID == "" || rec.SiteID == siteID) && rec.hasStatus(status) {
+				res = append(res, rec)
+			}
+			return nil
+		})
+	})
+	return res, err
+}
+
+// Due returns records with pending deliveries due by until, earliest first. Reads due index up to until only
+func (b *BoltOutbox) Due(until time.Time) (res []OutboxRecord, err error) {
+	res = []OutboxRecord{}
+	limit := []byte(outboxIndexTime(until))
+	err = b.db.View(func(tx *bolt.Tx) error {
+		outboxBkt := tx.Bucket([]byte(outboxBktName))
+		c := tx.Bucket([]byte(outboxDueBktName)).Cursor()
+		for k, id := c.First(); k != nil && bytes.Compare(k[:len(limit)], limit) <= 0; k, id = c.Next() {
+			rec := OutboxRecord{}
+			if e := json.Unmarshal(outboxBkt.Get(id), &rec); e != nil {
+				return errors.Wrapf(e, "can't unmarshal outbox record %s", string(id))
+			}
+			res = append(res, rec)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Prune removes records without pending deliveries which have dead ones and not updated since before.
+// Returns number of removed records
+func (b *BoltOutbox) Prune(before time.Time) (count int, err error) {
+	limit := []byte(outboxIndexTime(before))
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		outboxBkt, deadBkt := tx.Bucket([]byte(outboxBktName)), tx.Bucket([]byte(outboxDeadBktName))
+		keys, ids := [][]byte{}, [][]byte{}
+		c := deadBkt.Cursor()
+		for k, id := c.First(); k != nil && bytes.Compare(k[:len(limit)], limit) < 0; k, id = c.Next() {
+			keys, ids = append(keys, k), append(ids, id)
+		}
+		for i := range keys {
+			if e := deadBkt.Delete(keys[i]); e != nil {
+				return errors.Wrapf(e, "can't delete dead index of %s", string(ids[i]))
+			}
+			if e := outboxBkt.Delete(ids[i]); e != nil {
+				return errors.Wrapf(e, "can't delete outbox record %s", string(ids[i]))
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	return count, err
+}
+
+// indexOutbox adds the record to due or dead index
+func indexOutbox(tx *bolt.Tx, rec OutboxRecord) error {
+	due, dead := rec.indexKeys()
+	if due != nil {
+		if err := tx.Bucket([]byte(outboxDueBktName)).Put(due, []byte(rec.ID)); err != nil {
+			return errors.Wrapf(err, "can't put due index of %s", rec.ID)
+		}
+	}
+	if dead != nil {
+		if err := tx.Bucket([]byte(outboxDeadBktName)).Put(dead, []byte(rec.ID)); err != nil {
+			return errors.Wrapf(err, "can't put dead index of %s", rec.ID)
+		}
+	}
+	return nil
+}
+
+// unindexOutbox removes stored record from indexes, does nothing if the record not stored
+func unindexOutbox(tx *bolt.Tx, id string) error {
+	data := tx.Bucket([]byte(outboxBktName)).Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+	rec := OutboxRecord{}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return errors.Wrapf(err, "can't unmarshal outbox record %s", id)
+	}
+	due, dead := rec.indexKeys()
+	if due != nil {
+		if err := tx.Bucket([]byte(outboxDueBktName)).Delete(due); err != nil {
+			return errors.Wrapf(err, "can't delete due index of %s", id)
+		}
+	}
+	if dead != nil {
+		if err := tx.Bucket([]byte(outboxDeadBktName)).Delete(dead); err != nil {
+			return errors.Wrapf(err, "can't delete dead index of %s", id)
+		}
+	}
+	return nil
+}
+
+// Close bolt DB
+func (b *BoltOutbox) Close() error {
+	return b.db.Close()
+}
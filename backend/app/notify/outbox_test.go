@@ -0,0 +1,401 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltOutbox(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2}
+	rec1 := newOutboxRecord("remark42", []Destination{d1, d2})
+	rec1.Request = &OutboxRequest{Comment: store.Comment{ID: "c1"}, Emails: []string{"a@example.com"}}
+	rec2 := newOutboxRecord("other", []Destination{d1})
+	rec2.Verification = &VerificationRequest{SiteID: "other", User: "user1"}
+	require.NoError(t, ob.Put(rec1))
+	require.NoError(t, ob.Put(rec2))
+
+	res, err := ob.Get(rec1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", res.Request.Comment.ID)
+	assert.Equal(t, []string{"a@example.com"}, res.Request.Emails)
+	assert.Equal(t, 2, len(res.Deliveries))
+	assert.Equal(t, DeliveryPending, res.Deliveries[d1.String()].Status)
+
+	recs, err := ob.List("", DeliveryPending)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, rec1.ID, recs[0].ID, "oldest first")
+	assert.Equal(t, "user1", recs[1].Verification.User)
+
+	recs, err = ob.List("other", DeliveryPending)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, rec2.ID, recs[0].ID)
+
+	rec1.Deliveries[d2.String()].Status = DeliveryDead
+	require.NoError(t, ob.Put(rec1))
+	recs, err = ob.List("remark42", DeliveryDead)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+
+	require.NoError(t, ob.Delete(rec1.ID))
+	_, err = ob.Get(rec1.ID)
+	assert.EqualError(t, err, fmt.Sprintf("outbox record %s not found", rec1.ID))
+}
+
+func TestBoltOutbox_DueAndPrune(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2}
+	now := time.Now()
+	rec1 := newOutboxRecord("remark42", []Destination{d1, d2})
+	rec2 := newOutboxRecord("remark42", []Destination{d1})
+	rec2.Deliveries[d1.String()].NextAttempt = now.Add(time.Hour)
+	require.NoError(t, ob.Put(rec1))
+	require.NoError(t, ob.Put(rec2))
+
+	recs, err := ob.Due(now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs), "rec2 not due yet")
+	assert.Equal(t, rec1.ID, recs[0].ID)
+	recs, err = ob.Due(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(recs))
+
+	// failed and delivered, not due anymore and old enough to prune
+	rec1.Deliveries[d1.String()].Status = DeliveryDelivered
+	rec1.Deliveries[d2.String()].Status = DeliveryDead
+	rec1.Deliveries[d1.String()].Updated = now.Add(-2 * time.Hour)
+	rec1.Deliveries[d2.String()].Updated = now.Add(-2 * time.Hour)
+	require.NoError(t, ob.Put(rec1))
+	// failed with pending delivery left, never pruned
+	rec3 := newOutboxRecord("remark42", []Destination{d1, d2})
+	rec3.Deliveries[d2.String()].Status = DeliveryDead
+	rec3.Deliveries[d2.String()].Updated = now.Add(-2 * time.Hour)
+	require.NoError(t, ob.Put(rec3))
+
+	recs, err = ob.Due(now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, rec3.ID, recs[0].ID)
+
+	n, err := ob.Prune(now.Add(-3 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "updated after retention")
+	n, err = ob.Prune(now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = ob.Get(rec1.ID)
+	assert.Error(t, err, "pruned")
+	recs, err = ob.List("", DeliveryDead)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, rec3.ID, recs[0].ID)
+
+	require.NoError(t, ob.Delete(rec3.ID))
+	recs, err = ob.Due(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(recs), "removed from index on delete")
+}
+
+func TestBoltOutbox_Reindex(t *testing.T) {
+	tmpFile := prepOutboxFile(t)
+	defer os.Remove(tmpFile)
+
+	// outbox made without indexes
+	rec := newOutboxRecord("remark42", []Destination{&MockDest{id: 1}})
+	db, err := bolt.Open(tmpFile, 0600, &bolt.Options{})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, e := tx.CreateBucket([]byte(outboxBktName))
+		require.NoError(t, e)
+		data, e := json.Marshal(rec)
+		require.NoError(t, e)
+		return bkt.Put([]byte(rec.ID), data)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	ob, err := NewBoltOutbox(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	defer ob.Close()
+	recs, err := ob.Due(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, rec.ID, recs[0].ID)
+}
+
+func TestOutboxService_Deliver(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2}
+	s := NewOutboxService(nil, ob, OutboxParams{PollInterval: 10 * time.Millisecond}, d1, d2)
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "remark42"}}})
+	s.SubmitVerification(VerificationRequest{SiteID: "remark42", User: "user1"})
+
+	assert.Eventually(t, func() bool { return len(d1.Get()) == 1 && len(d2.GetVerify()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		recs, err := ob.List("", DeliveryPending)
+		return err == nil && len(recs) == 0
+	}, time.Second, 10*time.Millisecond, "delivered notifications removed")
+	s.Close()
+	s.Submit(Request{Comment: store.Comment{ID: "111"}}) // safe to send after close
+}
+
+func TestOutboxService_Restart(t *testing.T) {
+	tmpFile := prepOutboxFile(t)
+	defer os.Remove(tmpFile)
+
+	// notification left in outbox from the previous run
+	ob, err := NewBoltOutbox(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	d1 := &MockDest{id: 1}
+	rec := newOutboxRecord("remark42", []Destination{d1})
+	rec.Request = &OutboxRequest{Comment: store.Comment{ID: "100"}, Parent: store.Comment{ID: "99"}}
+	require.NoError(t, ob.Put(rec))
+	require.NoError(t, ob.Close())
+
+	ob, err = NewBoltOutbox(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	s := NewOutboxService(nil, ob, OutboxParams{PollInterval: time.Hour}, d1)
+	defer s.Close()
+	assert.Eventually(t, func() bool { return len(d1.Get()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "99", d1.Get()[0].parent.ID)
+}
+
+func TestOutboxService_RetryAndResend(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	good, bad := &MockDest{id: 1}, &failingDest{failures: 100}
+	params := OutboxParams{MaxAttempts: 3, RetryDelay: time.Millisecond, MaxRetryDelay: 2 * time.Millisecond,
+		PollInterval: 5 * time.Millisecond}
+	s := NewOutboxService(nil, ob, params, good, bad)
+	defer s.Close()
+
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "remark42"}}})
+	var failed []OutboxRecord
+	require.Eventually(t, func() bool {
+		var err error
+		failed, err = s.Failed("remark42")
+		return err == nil && len(failed) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, bad.calls())
+	assert.Equal(t, 1, len(good.Get()), "delivered once to working destination")
+
+	dl := failed[0].Deliveries[bad.String()]
+	assert.Equal(t, DeliveryDead, dl.Status)
+	assert.Equal(t, 3, dl.Attempts)
+	assert.Equal(t, "send failed", dl.LastError)
+	assert.Equal(t, DeliveryDelivered, failed[0].Deliveries[good.String()].Status)
+
+	_, err := s.Resend("other", failed[0].ID)
+	assert.Error(t, err, "notification of another site")
+
+	other, err := s.Failed("other")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(other))
+
+	bad.setFailures(0)
+	n, err := s.Resend("remark42", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Eventually(t, func() bool {
+		recs, e := ob.List("", DeliveryDead)
+		pending, e2 := ob.List("", DeliveryPending)
+		return e == nil && e2 == nil && len(recs) == 0 && len(pending) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 4, bad.calls())
+	assert.Equal(t, 1, len(good.Get()), "not resent to working destination")
+}
+
+func TestOutboxService_DeadRetention(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	d1 := &MockDest{id: 1}
+	rec := newOutboxRecord("remark42", []Destination{d1})
+	rec.Request = &OutboxRequest{Comment: store.Comment{ID: "100"}}
+	rec.Deliveries[d1.String()].Status = DeliveryDead
+	rec.Deliveries[d1.String()].Updated = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, ob.Put(rec))
+
+	s := NewOutboxService(nil, ob, OutboxParams{PollInterval: 5 * time.Millisecond, DeadRetention: time.Hour}, d1)
+	defer s.Close()
+	assert.Eventually(t, func() bool {
+		failed, err := s.Failed("remark42")
+		return err == nil && len(failed) == 0
+	}, time.Second, 10*time.Millisecond, "failed notification removed after retention")
+	assert.Equal(t, 0, len(d1.Get()), "not resent")
+}
+
+func TestOutboxService_WebhookTargets(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	var goodCalls, badCalls int32
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&goodCalls, 1)
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&badCalls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{good.URL, bad.URL}, Retries: 5, RetryDelay: time.Millisecond})
+	require.NoError(t, err)
+	params := OutboxParams{MaxAttempts: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond,
+		PollInterval: 5 * time.Millisecond}
+	s := NewOutboxService(nil, ob, params, wh)
+	defer s.Close()
+
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "remark42"}}})
+	var failed []OutboxRecord
+	require.Eventually(t, func() bool {
+		failed, err = s.Failed("remark42")
+		return err == nil && len(failed) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&goodCalls), "delivered url not resent")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&badCalls), "no retries of webhook itself")
+	assert.Equal(t, DeliveryDelivered, failed[0].Deliveries["webhook:"+good.URL].Status)
+	assert.Equal(t, DeliveryDead, failed[0].Deliveries["webhook:"+bad.URL].Status)
+}
+
+func TestOutboxService_StableDestinationName(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer ts.Close()
+
+	// record made with another settings of the webhook
+	old, err := NewWebhook(WebhookParams{URLs: []string{ts.URL}})
+	require.NoError(t, err)
+	rec := newOutboxRecord("remark42", []Destination{old})
+	rec.Request = &OutboxRequest{Comment: store.Comment{ID: "100"}}
+	require.NoError(t, ob.Put(rec))
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{ts.URL}, Template: `{"id": {{json .Comment.ID}}}`})
+	require.NoError(t, err)
+	s := NewOutboxService(nil, ob, OutboxParams{PollInterval: 5 * time.Millisecond}, wh)
+	defer s.Close()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, e := ob.Get(rec.ID)
+		return e != nil
+	}, time.Second, 10*time.Millisecond, "delivered and removed")
+}
+
+func TestOutboxService_UnknownDestination(t *testing.T) {
+	ob, teardown := prepBoltOutbox(t)
+	defer teardown()
+
+	rec := newOutboxRecord("remark42", []Destination{&MockDest{id: 2}})
+	rec.Request = &OutboxRequest{Comment: store.Comment{ID: "100"}}
+	require.NoError(t, ob.Put(rec))
+
+	s := NewOutboxService(nil, ob, OutboxParams{PollInterval: 5 * time.Millisecond}, &MockDest{id: 1})
+	defer s.Close()
+	require.Eventually(t, func() bool {
+		failed, err := s.Failed("remark42")
+		return err == nil && len(failed) == 1
+	}, time.Second, 10*time.Millisecond)
+	failed, err := s.Failed("remark42")
+	require.NoError(t, err)
+	assert.Equal(t, "destination not configured", failed[0].Deliveries[(&MockDest{id: 2}).String()].LastError)
+}
+
+func TestOutboxService_Disabled(t *testing.T) {
+	_, err := NopService.Failed("remark42")
+	assert.EqualError(t, err, "notification outbox disabled")
+	_, err = NopService.Resend("remark42", "")
+	assert.EqualError(t, err, "notification outbox disabled")
+}
+
+func TestOutboxService_RetryDelay(t *testing.T) {
+	s := Service{outboxParams: OutboxParams{RetryDelay: time.Minute, MaxRetryDelay: 10 * time.Minute}}
+	assert.Equal(t, time.Minute, s.retryDelay(1))
+	assert.Equal(t, 2*time.Minute, s.retryDelay(2))
+	assert.Equal(t, 8*time.Minute, s.retryDelay(4))
+	assert.Equal(t, 10*time.Minute, s.retryDelay(5))
+	assert.Equal(t, 10*time.Minute, s.retryDelay(100))
+}
+
+// failingDest fails first failures sends
+type failingDest struct {
+	lock     sync.Mutex
+	failures int
+	count    int
+}
+
+func (f *failingDest) Send(context.Context, Request) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.count++
+	if f.count <= f.failures {
+		return errors.New("send failed")
+	}
+	return nil
+}
+
+func (f *failingDest) SendVerification(ctx context.Context, _ VerificationRequest) error {
+	return f.Send(ctx, Request{})
+}
+
+func (f *failingDest) String() string { return "failing" }
+
+func (f *failingDest) calls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.count
+}
+
+func (f *failingDest) setFailures(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failures = n
+}
+
+func prepOutboxFile(t *testing.T) string {
+	f, err := ioutil.TempFile("", "outbox")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(f.Name()))
+	return f.Name()
+}
+
+func prepBoltOutbox(t *testing.T) (ob *BoltOutbox, teardown func()) {
+	tmpFile := prepOutboxFile(t)
+	ob, err := NewBoltOutbox(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	return ob, func() {
+		_ = ob.Close()
+		_ = os.Remove(tmpFile)
+	}
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ReplyToken identifies comment and recipient of notification email. Kept in ReplyStore under short random
+// token embedded into Reply-To address as reply+token@domain. Replies to this address posted as answers
+// to the comment on behalf of the user
+type ReplyToken struct {
+	Locator  store.Locator `json:"locator"`
+	ParentID string        `json:"parent_id"` // comment to answer to
+	UserID   string        `json:"user_id"`   // recipient of notification
+	Issued   time.Time     `json:"issued"`    // time of the notification
+}
+
+// ReplyStore keeps reply tokens, the address has only random token fitting into 64 characters of local part
+type ReplyStore interface {
+	Add(tkn ReplyToken) (token string, err error) // store token, returns random token for the address
+	Get(token string) (ReplyToken, error)         // stored token, error if unknown or expired
+	Close() error
+}
+
+// ReplyTokenTTL defines how long reply token stays valid after the notification
+const ReplyTokenTTL = 30 * 24 * time.Hour
+
+// random part of the token, 15 bytes encoded to 24 base32 characters
+const replyTokenSize = 15
+
+// base32 without padding, lowercased in the address as some mail servers don't keep the case of local part
+var replyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ReplyAddress makes reply address with token from base address, i.e. reply@example.com -> reply+token@example.com
+func ReplyAddress(address, token string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address + "+" + token
+	}
+	return address[:at] + "+" + token + address[at:]
+}
+
+// BoltReply implements ReplyStore with bolt DB
+type BoltReply struct {
+	db *bolt.DB
+}
+
+const (
+	replyTokensBktName = "reply_tokens" // key is token
+	replyIssuedBktName = "reply_issued" // key is issue time and token, used to remove expired tokens
+)
+
+// NewBoltReply makes persistent reply token store in bolt file
+func NewBoltReply(fileName string, options bolt.Options) (*BoltReply, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{replyTokensBktName, replyIssuedBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltReply{db: db}, nil
+}
+
+// Add stores reply token with random key and removes expired ones
+func (b *BoltReply) Add(tkn ReplyToken) (string, error) {
+	rnd := make([]byte, replyTokenSize)
+	if _, err := rand.Read(rnd); err != nil {
+		return "", errors.Wrap(err, "can't make reply token")
+	}
+	token := strings.ToLower(replyEncoding.EncodeToString(rnd))
+	data, err := json.Marshal(tkn)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't marshal reply token for %s", tkn.ParentID)
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		tokensBkt, issuedBkt := tx.Bucket([]byte(replyTokensBktName)), tx.Bucket([]byte(replyIssuedBktName))
+		if e := tokensBkt.Put([]byte(token), data); e != nil {
+			return errors.Wrapf(e, "can't put reply token %s", token)
+		}
+		if e := issuedBkt.Put(replyIssuedKey(tkn.Issued, token), []byte(token)); e != nil {
+			return errors.Wrapf(e, "can't put reply token %s issue time", token)
+		}
+		// remove expired tokens, keys sorted by issue time
+		expired := replyIssuedKey(time.Now().Add(-ReplyTokenTTL), "")
+		c := issuedBkt.Cursor()
+		for k, v := c.First(); k != nil && string(k) < string(expired); k, v = c.First() {
+			if e := tokensBkt.Delete(v); e != nil {
+				return errors.Wrapf(e, "can't delete expired reply token %s", string(v))
+			}
+			if e := c.Delete(); e != nil {
+				return errors.Wrapf(e, "can't delete expired reply token %s issue time", string(v))
+			}
+		}
+		return nil
+	})
+	return token, err
+}
+
+// Get returns stored reply token, token is case insensitive
+func (b *BoltReply) Get(token string) (tkn ReplyToken, err error) {
+	token = strings.ToLower(token)
+	err = b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(replyTokensBktName)).Get([]byte(token))
+		if data == nil {
+			return errors.New("unknown reply token")
+		}
+		return errors.Wrapf(json.Unmarshal(data, &tkn), "can't unmarshal reply token %s", token)
+	})
+	if err != nil {
+		return ReplyToken{}, err
+	}
+	if time.Since(tkn.Issued) > ReplyTokenTTL {
+		return ReplyToken{}, errors.Errorf("reply token expired, issued %s", tkn.Issued.Format(time.RFC3339))
+	}
+	return tkn, nil
+}
+
+// Close bolt DB
+func (b *BoltReply) Close() error {
+	return b.db.Close()
+}
+
+func replyIssuedKey(issued time.Time, token string) []byte {
+	return []byte(fmt.Sprintf("%020d!%s", issued.UnixNano(), token))
+}
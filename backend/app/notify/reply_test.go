@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltReply(t *testing.T) {
+	r, teardown := prepBoltReply(t)
+	defer teardown()
+
+	tkn := ReplyToken{Locator: store.Locator{SiteID: "remark42", URL: "https://example.com/post?id=1"}, ParentID: "c1", UserID: "github_123",
+		Issued: time.Now().Round(0)}
+	s, err := r.Add(tkn)
+	require.NoError(t, err)
+	assert.Regexp(t, "^[a-z2-7]{24}$", s, "short lowercase base32")
+	s2, err := r.Add(tkn)
+	require.NoError(t, err)
+	assert.NotEqual(t, s, s2, "random token for each notification")
+
+	res, err := r.Get(s)
+	require.NoError(t, err)
+	assert.Equal(t, tkn.Locator, res.Locator)
+	assert.Equal(t, tkn.ParentID, res.ParentID)
+	assert.Equal(t, tkn.UserID, res.UserID)
+	assert.True(t, tkn.Issued.Equal(res.Issued))
+
+	res, err = r.Get(strings.ToUpper(s))
+	require.NoError(t, err)
+	assert.Equal(t, "c1", res.ParentID, "case insensitive")
+
+	_, err = r.Get("aaaa")
+	assert.EqualError(t, err, "unknown reply token")
+
+	tkn.Issued = time.Now().Add(-ReplyTokenTTL + time.Hour)
+	old, err := r.Add(tkn)
+	require.NoError(t, err)
+	_, err = r.Get(old)
+	assert.NoError(t, err, "not expired yet")
+
+	tkn.Issued = time.Now().Add(-ReplyTokenTTL - time.Hour)
+	expired, err := r.Add(tkn)
+	require.NoError(t, err)
+	_, err = r.Get(expired)
+	assert.EqualError(t, err, "unknown reply token", "expired token removed")
+	_, err = r.Get(old)
+	assert.NoError(t, err, "valid token kept")
+}
+
+func TestReplyAddress(t *testing.T) {
+	assert.Equal(t, "reply+tkn@example.com", ReplyAddress("reply@example.com", "tkn"))
+	assert.Equal(t, "reply+tkn", ReplyAddress("reply", "tkn"))
+}
+
+func prepBoltReply(t *testing.T) (r *BoltReply, teardown func()) {
+	tmpFile := prepOutboxFile(t)
+	r, err := NewBoltReply(tmpFile, bolt.Options{})
+	require.NoError(t, err)
+	return r, func() {
+		_ = r.Close()
+		_ = os.Remove(tmpFile)
+	}
+}
@@ -2,19 +2,32 @@ package notify
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
 	"github.com/slack-go/slack"
+
+	"github.com/umputun/remark42/backend/app/store"
 )
 
 // Slack implements notify.Destination for Slack
 type Slack struct {
+	SlackParams
+	Moderator Moderator // applies actions of moderation buttons
+
 	channelID   string
 	channelName string
 	client      *slack.Client
 }
 
+// SlackParams contain moderation settings of Slack notifications
+type SlackParams struct {
+	Buttons bool              // add moderation buttons to messages
+	Admins  map[string]string // slack user id to remark42 user id, allowed to use moderation buttons
+}
+
 // NewSlack makes Slack bot for notifications
 func NewSlack(token, channelName string, opts ...slack.Option) (*Slack, error) {
 
@@ -39,6 +52,9 @@ func NewSlack(token, channelName string, opts ...slack.Option) (*Slack, error) {
 // Send to Slack channel
 func (t *Slack) Send(ctx context.Context, req Request) error {
 
+	if req.Approved {
+		return nil // admins notified about pending comment on creation
+	}
 	log.Printf("[DEBUG] send slack notification, comment id %s", req.Comment.ID)
 
 	user := req.Comment.User.Name
@@ -51,8 +67,16 @@ func (t *Slack) Send(ctx context.Context, req Request) error {
 		title = "↦ " + req.Comment.PostTitle
 	}
 
-	_, _, err := t.client.PostMessageContext(ctx, t.channelID,
-		slack.MsgOptionText("New comment from "+user, false),
+	text := "New comment from " + user
+	if len(req.Reports) > 0 {
+		text = "Comment from " + req.Comment.User.Name + " reported, " + reportReasons(req.Reports)
+	}
+	if req.Comment.Pending {
+		text += ", awaiting approval"
+	}
+
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(text, false),
 		slack.MsgOptionAttachments(
 			slack.Attachment{
 				TitleLink: req.Comment.Locator.URL + uiNav + req.Comment.ID,
@@ -60,17 +84,99 @@ func (t *Slack) Send(ctx context.Context, req Request) error {
 				Text:      req.Comment.Orig,
 			},
 		),
-	)
+	}
+	if t.Buttons {
+		opts = append(opts, slack.MsgOptionBlocks(slackBlocks(text, req.Comment)...))
+	}
+	_, _, err := t.client.PostMessageContext(ctx, t.channelID, opts...)
 
 	return err
 
 }
 
+// slackBlocks makes message text with moderation buttons. Button value keeps site, comment id and post url.
+// Approve button added for pending comments only, destructive actions confirmed.
+func slackBlocks(text string, c store.Comment) []slack.Block {
+	value := strings.Join([]string{c.Locator.SiteID, c.ID, c.Locator.URL}, "|")
+	plain := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+	confirm := func(s string) *slack.ConfirmationBlockObject {
+		return slack.NewConfirmationBlockObject(plain("Are you sure?"), plain(s), plain("Yes"), plain("Cancel"))
+	}
+
+	buttons := []slack.BlockElement{}
+	if c.Pending {
+		approve := slack.NewButtonBlockElement(ModerationApprove, value, plain("Approve")).WithStyle(slack.StylePrimary)
+		buttons = append(buttons, approve)
+	}
+	del := slack.NewButtonBlockElement(ModerationDelete, value, plain("Delete")).WithStyle(slack.StyleDanger)
+	del.Confirm = confirm("Delete comment from " + c.User.Name + "?")
+	block := slack.NewButtonBlockElement(ModerationBlockForever, value, plain("Block user")).WithStyle(slack.StyleDanger)
+	block.Confirm = confirm("Block " + c.User.Name + " permanently and delete all comments of the user?")
+	buttons = append(buttons, del, block)
+
+	return []slack.Block{
+		slack.NewSectionBlock(plain(text), nil, nil),
+		slack.NewActionBlock("moderation", buttons...),
+	}
+}
+
+// ProcessInteraction handles press of moderation button, received from interactivity endpoint.
+// Result of the action sent back to the channel with response url, failures visible to the user only.
+func (t *Slack) ProcessInteraction(ctx context.Context, cb slack.InteractionCallback) {
+	if cb.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+	for _, action := range cb.ActionCallback.BlockActions {
+		answer, ok := t.moderate(cb.User, *action)
+		respType := slack.ResponseTypeInChannel
+		if !ok {
+			respType = slack.ResponseTypeEphemeral
+		}
+		_, _, err := t.client.PostMessageContext(ctx, cb.Channel.ID, slack.MsgOptionText(answer, false),
+			slack.MsgOptionResponseURL(cb.ResponseURL, respType))
+		if err != nil {
+			log.Printf("[WARN] can't answer slack interaction, %v", err)
+		}
+	}
+}
+
+// moderate applies action of the button, returns answer to the user and success flag
+func (t *Slack) moderate(user slack.User, action slack.BlockAction) (answer string, ok bool) {
+	if t.Moderator == nil {
+		return "moderation disabled", false
+	}
+	elems := strings.SplitN(action.Value, "|", 3)
+	if len(elems) != 3 {
+		log.Printf("[WARN] bad slack action value %q from %s", action.Value, user.ID)
+		return "unknown action", false
+	}
+	act := ModerationAction{Action: action.ActionID, Locator: store.Locator{SiteID: elems[0], URL: elems[2]},
+		CommentID: elems[1], Via: "slack"}
+
+	userID, ok := t.Admins[user.ID]
+	if !ok {
+		log.Printf("[WARN] slack moderation by unknown user %s (%s) rejected", user.ID, user.Name)
+		return fmt.Sprintf("rejected, slack user %s is not mapped to admin", user.ID), false
+	}
+	log.Printf("[INFO] slack moderation %s for %s by %s (%s)", act.Action, act.CommentID, user.ID, userID)
+	res, err := t.Moderator.Moderate(userID, act)
+	if err != nil {
+		log.Printf("[WARN] slack moderation %s for %s rejected, %v", act.Action, act.CommentID, err)
+		return "rejected, " + err.Error(), false
+	}
+	return fmt.Sprintf("<@%s> %s", user.ID, res), true
+}
+
 // SendVerification is not implemented for Slack
 func (t *Slack) SendVerification(_ context.Context, _ VerificationRequest) error {
 	return nil
 }
 
+// Name of the destination, doesn't depend on settings
+func (t *Slack) Name() string { return "slack" }
+
 func (t *Slack) String() string {
 	return "slack: " + t.channelName + " (" + t.channelID + ")"
 }
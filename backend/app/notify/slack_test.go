@@ -2,9 +2,13 @@ package notify
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -57,6 +61,9 @@ func TestSlack_Send(t *testing.T) {
 	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp})
 	assert.NoError(t, err)
 
+	err = tb.Send(context.TODO(), Request{Comment: c, Reports: []store.Report{{Reason: store.ReportSpam}}})
+	assert.NoError(t, err)
+
 	tb, err = ts.newClient("general")
 	assert.NoError(t, err)
 	ts.isServerDown = true
@@ -64,6 +71,8 @@ func TestSlack_Send(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "slack server error", "send on broken client")
 
+	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp, Approved: true})
+	assert.NoError(t, err, "approved comment not sent, admins notified about it on creation")
 }
 
 func TestSlack_Name(t *testing.T) {
@@ -88,9 +97,90 @@ func TestSlack_SendVerification(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSlack_SendButtons(t *testing.T) {
+	ts := newMockSlackServer()
+	defer ts.Close()
+
+	tb, err := ts.newClient("general")
+	require.NoError(t, err)
+	c := store.Comment{Text: "some text", Orig: "some text", ID: "999", Pending: true,
+		Locator: store.Locator{SiteID: "remark", URL: "http://example.com/blah"}}
+	c.User.Name = "from"
+
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c}))
+	assert.Equal(t, "", ts.lastMessage().Get("blocks"), "no buttons by default")
+
+	tb.Buttons = true
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c}))
+	msg := ts.lastMessage()
+	assert.Equal(t, "New comment from from, awaiting approval", msg.Get("text"))
+	blocks := msg.Get("blocks")
+	assert.Contains(t, blocks, `"action_id":"ok","value":"remark|999|http://example.com/blah"`)
+	assert.Contains(t, blocks, `"action_id":"del","value":"remark|999|http://example.com/blah"`)
+	assert.Contains(t, blocks, `"action_id":"bf","value":"remark|999|http://example.com/blah"`)
+	assert.Contains(t, blocks, `"text":"Delete comment from from?"`)
+
+	c.Pending = false
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c}))
+	assert.Equal(t, "New comment from from", ts.lastMessage().Get("text"))
+	assert.NotContains(t, ts.lastMessage().Get("blocks"), `"action_id":"ok"`, "no approve button for published comment")
+}
+
+func TestSlack_ProcessInteraction(t *testing.T) {
+	ts := newMockSlackServer()
+	defer ts.Close()
+
+	tb, err := ts.newClient("general")
+	require.NoError(t, err)
+	tb.Admins = map[string]string{"U1": "a1", "U3": "dev"}
+
+	cb := func(user, actionID, value string) slack.InteractionCallback {
+		return slack.InteractionCallback{Type: slack.InteractionTypeBlockActions, User: slack.User{ID: user},
+			ResponseURL: ts.URL + "/respond", Channel: slack.Channel{GroupConversation: slack.GroupConversation{
+				Conversation: slack.Conversation{ID: "C12345678"}}},
+			ActionCallback: slack.ActionCallbacks{BlockActions: []*slack.BlockAction{{ActionID: actionID, Value: value}}}}
+	}
+
+	tb.ProcessInteraction(context.TODO(), cb("U1", ModerationDelete, "remark|999|http://example.com/blah"))
+	assert.Contains(t, ts.lastResponse(), `"text":"moderation disabled","response_type":"ephemeral"`)
+
+	mod := &mockModerator{}
+	tb.Moderator = mod
+	tb.ProcessInteraction(context.TODO(), cb("U1", ModerationDelete, "remark|999|http://example.com/blah"))
+	assert.Contains(t, ts.lastResponse(), `"text":"\u003c@U1\u003e comment deleted","response_type":"in_channel"`)
+	assert.Equal(t, "a1", mod.userID)
+	assert.Equal(t, ModerationAction{Action: ModerationDelete, Locator: store.Locator{SiteID: "remark", URL: "http://example.com/blah"},
+		CommentID: "999", Via: "slack"}, mod.act)
+
+	tb.ProcessInteraction(context.TODO(), cb("U2", ModerationDelete, "remark|999|http://example.com/blah"))
+	assert.Contains(t, ts.lastResponse(), `"text":"rejected, slack user U2 is not mapped to admin","response_type":"ephemeral"`)
+
+	tb.ProcessInteraction(context.TODO(), cb("U3", ModerationDelete, "remark|999|http://example.com/blah"))
+	assert.Contains(t, ts.lastResponse(), `"text":"rejected, not allowed","response_type":"ephemeral"`)
+
+	tb.ProcessInteraction(context.TODO(), cb("U1", ModerationDelete, "bad value"))
+	assert.Contains(t, ts.lastResponse(), `"text":"unknown action","response_type":"ephemeral"`)
+}
+
 type mockSlackServer struct {
 	*httptest.Server
 	isServerDown bool
+
+	lock      sync.Mutex
+	messages  []url.Values
+	responses []string
+}
+
+func (ts *mockSlackServer) lastMessage() url.Values {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.messages[len(ts.messages)-1]
+}
+
+func (ts *mockSlackServer) lastResponse() string {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.responses[len(ts.responses)-1]
 }
 
 func (ts *mockSlackServer) newClient(channelName string) (*Slack, error) {
@@ -144,6 +234,10 @@ func newMockSlackServer() *mockSlackServer {
 			w.WriteHeader(500)
 
 		} else {
+			_ = r.ParseForm()
+			mockServer.lock.Lock()
+			mockServer.messages = append(mockServer.messages, r.PostForm)
+			mockServer.lock.Unlock()
 			s := `{
 			    "ok": true,
 			    "channel": "C12345678",
@@ -161,6 +255,14 @@ func newMockSlackServer() *mockSlackServer {
 		}
 	})
 
+	router.Post("/respond", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mockServer.lock.Lock()
+		mockServer.responses = append(mockServer.responses, strings.TrimSpace(string(body)))
+		mockServer.lock.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	})
+
 	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("..... 404 for %s .....\n", r.URL)
 	})
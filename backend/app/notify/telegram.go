@@ -26,18 +26,25 @@ type TelegramParams struct {
 	BotUsername       string        // filled with bot username after Telegram creation, used in frontend
 	UserNotifications bool          // flag which enables user notifications
 
+	SiteAdminChannel func(siteID string) string // optional, per-site admin channel, replaces AdminChannelID if not empty
+
+	AdminButtons bool             // attach moderation buttons to admin channel messages
+	Admins       map[int64]string // telegram user id to remark42 user id, allowed to use moderation buttons
+
 	apiPrefix string // changed only in tests
 }
 
 // Telegram implements notify.Destination for telegram
 type Telegram struct {
 	TelegramParams
+	Moderator Moderator // applies actions of admin channel buttons, buttons rejected if nil
 }
 
 // telegramMsg is used to send message trough Telegram bot API
 type telegramMsg struct {
-	Text      string `json:"text"`
-	ParseMode string `json:"parse_mode,omitempty"`
+	Text        string            `json:"text"`
+	ParseMode   string            `json:"parse_mode,omitempty"`
+	ReplyMarkup *telegramKeyboard `json:"reply_markup,omitempty"`
 }
 
 const telegramTimeOut = 5000 * time.Millisecond
@@ -115,10 +122,17 @@ func (t *Telegram) Send(ctx context.Context, req Request) error {
 		return errors.Wrapf(err, "failed to make telegram message body for comment ID %s", req.Comment.ID)
 	}
 
-	if t.AdminChannelID != "" {
-		err := t.sendMessage(ctx, msg, t.AdminChannelID)
+	// admins notified about pending comment on creation, approved one sent to users only
+	if adminChannel := t.adminChannel(req.Comment.Locator.SiteID); adminChannel != "" && !req.Approved {
+		adminMsg := msg
+		if t.AdminButtons {
+			if adminMsg, err = buildTelegramAdminMessage(req); err != nil {
+				return errors.Wrapf(err, "failed to make telegram admin message body for comment ID %s", req.Comment.ID)
+			}
+		}
+		err := t.sendMessage(ctx, adminMsg, adminChannel)
 		result = multierror.Append(errors.Wrapf(err,
-			"problem sending admin telegram notification about comment ID %s to %s", req.Comment.ID, t.AdminChannelID),
+			"problem sending admin telegram notification about comment ID %s to %s", req.Comment.ID, adminChannel),
 		)
 	}
 
@@ -133,6 +147,16 @@ func (t *Telegram) Send(ctx context.Context, req Request) error {
 	return result.ErrorOrNil()
 }
 
+// adminChannel returns admin channel of the site, AdminChannelID used for sites without own channel
+func (t *Telegram) adminChannel(siteID string) string {
+	if t.SiteAdminChannel != nil {
+		if channel := t.SiteAdminChannel(siteID); channel != "" {
+			return channel
+		}
+	}
+	return t.AdminChannelID
+}
+
 func (t *Telegram) sendMessage(ctx context.Context, b []byte, chatID string) error {
 	if _, err := strconv.ParseInt(chatID, 10, 64); err != nil {
 		chatID = "@" + chatID // if chatID not a number enforce @ prefix
@@ -179,9 +203,26 @@ func (t *Telegram) sendMessage(ctx context.Context, b []byte, chatID string) err
 }
 
 func buildTelegramMessage(req Request) ([]byte, error) {
+	return json.Marshal(telegramMessage(req))
+}
+
+// buildTelegramAdminMessage makes message with moderation buttons
+func buildTelegramAdminMessage(req Request) ([]byte, error) {
+	msg := telegramMessage(req)
+	msg.ReplyMarkup = moderationKeyboard(req.Comment)
+	return json.Marshal(msg)
+}
+
+func telegramMessage(req Request) telegramMsg {
 	commentURLPrefix := req.Comment.Locator.URL + uiNav
 
 	msg := fmt.Sprintf("[%s](%s)", escapeText(req.Comment.User.Name), commentURLPrefix+req.Comment.ID)
+	if len(req.Reports) > 0 {
+		msg = fmt.Sprintf("Reported, %s\n\n", escapeText(reportReasons(req.Reports))) + msg
+	}
+	if req.Comment.Pending {
+		msg = "Awaiting approval\n\n" + msg
+	}
 
 	if req.Comment.ParentID != "" {
 		msg += fmt.Sprintf(" -> [%s](%s)", escapeText(req.parent.User.Name), commentURLPrefix+req.parent.ID)
@@ -198,12 +239,7 @@ func buildTelegramMessage(req Request) ([]byte, error) {
 	}
 
 	msg = html.UnescapeString(msg)
-	body := telegramMsg{Text: msg, ParseMode: "MarkdownV2"}
-	b, err := json.Marshal(body)
-	if err != nil {
-		return nil, err
-	}
-	return b, nil
+	return telegramMsg{Text: msg, ParseMode: "MarkdownV2"}
 }
 
 func escapeText(title string) string {
@@ -259,6 +295,9 @@ func (t *Telegram) buildVerificationMessage(user, token, site string) ([]byte, e
 	return b, nil
 }
 
+// Name of the destination, doesn't depend on settings
+func (t *Telegram) Name() string { return "telegram" }
+
 func (t *Telegram) String() string {
 	result := "telegram"
 	if t.AdminChannelID != "" {
@@ -0,0 +1,241 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// TelegramUpdate is update received from telegram bot API, only fields used for moderation
+type TelegramUpdate struct {
+	UpdateID      int64                  `json:"update_id"`
+	Message       *TelegramMessage       `json:"message,omitempty"`
+	CallbackQuery *TelegramCallbackQuery `json:"callback_query,omitempty"`
+}
+
+// TelegramMessage is message sent to the bot or to admin channel
+type TelegramMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *TelegramUser `json:"from"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text     string `json:"text"`
+	Entities []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"entities"`
+}
+
+// TelegramUser is sender of message or author of button press
+type TelegramUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// TelegramCallbackQuery is button press on admin channel message
+type TelegramCallbackQuery struct {
+	ID      string           `json:"id"`
+	From    TelegramUser     `json:"from"`
+	Message *TelegramMessage `json:"message"`
+	Data    string           `json:"data"`
+}
+
+type telegramKeyboard struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+const (
+	telegramPollTimeout     = 30 * time.Second
+	telegramPollRetry       = 5 * time.Second
+	telegramMaxCallbackData = 64 // telegram limit for callback data, in bytes
+)
+
+// moderationKeyboard makes buttons for comment actions. Callback data keeps action, site and comment id,
+// post url is not fit to telegram limit and taken from the comment link of the message.
+// Returns nil if comment can't be identified within the limit.
+func moderationKeyboard(c store.Comment) *telegramKeyboard {
+	data := func(action string) string { return strings.Join([]string{action, c.Locator.SiteID, c.ID}, "|") }
+	if len(data(ModerationBlockDay)) > telegramMaxCallbackData {
+		log.Printf("[DEBUG] no moderation buttons for comment %s, callback data too long", c.ID)
+		return nil
+	}
+	res := &telegramKeyboard{InlineKeyboard: [][]telegramButton{
+		{{Text: "Delete", CallbackData: data(ModerationDelete)}, {Text: "Pin", CallbackData: data(ModerationPin)},
+			{Text: "Read-only post", CallbackData: data(ModerationReadOnly)}},
+		{{Text: "Block for a day", CallbackData: data(ModerationBlockDay)},
+			{Text: "Block forever", CallbackData: data(ModerationBlockForever)}},
+	}}
+	if c.Pending {
+		res.InlineKeyboard = append([][]telegramButton{{{Text: "Approve", CallbackData: data(ModerationApprove)}}}, res.InlineKeyboard...)
+	}
+	return res
+}
+
+// parseCallback makes action from callback data and comment link of the message
+func parseCallback(cb TelegramCallbackQuery) (ModerationAction, error) {
+	elems := strings.Split(cb.Data, "|")
+	if len(elems) != 3 {
+		return ModerationAction{}, errors.Errorf("invalid callback data %q", cb.Data)
+	}
+	act := ModerationAction{Action: elems[0], Locator: store.Locator{SiteID: elems[1]}, CommentID: elems[2], Via: "telegram"}
+	if cb.Message == nil {
+		return ModerationAction{}, errors.New("no message in callback")
+	}
+	for _, e := range cb.Message.Entities {
+		if e.Type == "text_link" && strings.HasSuffix(e.URL, uiNav+act.CommentID) {
+			act.Locator.URL = strings.TrimSuffix(e.URL, uiNav+act.CommentID)
+			return act, nil
+		}
+	}
+	return ModerationAction{}, errors.Errorf("no link to comment %s in message", act.CommentID)
+}
+
+// ProcessUpdate handles update from telegram, received with long polling or webhook.
+// Applies moderation action of pressed button and answers to /id command with telegram id of the user,
+// used to map telegram users to admins.
+func (t *Telegram) ProcessUpdate(ctx context.Context, upd TelegramUpdate) {
+	if upd.Message != nil && upd.Message.From != nil && strings.HasPrefix(upd.Message.Text, "/id") {
+		text := fmt.Sprintf("your telegram id is %d", upd.Message.From.ID)
+		if err := t.apiRequest(ctx, "sendMessage", map[string]interface{}{"chat_id": upd.Message.Chat.ID, "text": text}, nil); err != nil {
+			log.Printf("[WARN] can't answer telegram command, %v", err)
+		}
+		return
+	}
+	if upd.CallbackQuery == nil {
+		return
+	}
+
+	answer, ok := t.moderate(*upd.CallbackQuery)
+	// failures shown as alert to be noticed
+	req := map[string]interface{}{"callback_query_id": upd.CallbackQuery.ID, "text": answer, "show_alert": !ok}
+	if err := t.apiRequest(ctx, "answerCallbackQuery", req, nil); err != nil {
+		log.Printf("[WARN] can't answer telegram callback, %v", err)
+	}
+}
+
+// moderate applies action of the button, returns answer to the user and success flag
+func (t *Telegram) moderate(cb TelegramCallbackQuery) (answer string, ok bool) {
+	if t.Moderator == nil {
+		return "moderation disabled", false
+	}
+	act, err := parseCallback(cb)
+	if err != nil {
+		log.Printf("[WARN] bad telegram callback from %d, %v", cb.From.ID, err)
+		return "unknown action", false
+	}
+	userID, ok := t.Admins[cb.From.ID]
+	if !ok {
+		log.Printf("[WARN] telegram moderation by unknown user %d (%s) rejected", cb.From.ID, cb.From.Username)
+		return fmt.Sprintf("rejected, telegram user %d is not mapped to admin", cb.From.ID), false
+	}
+	log.Printf("[INFO] telegram moderation %s for %s by %d (%s)", act.Action, act.CommentID, cb.From.ID, userID)
+	res, err := t.Moderator.Moderate(userID, act)
+	if err != nil {
+		log.Printf("[WARN] telegram moderation %s for %s rejected, %v", act.Action, act.CommentID, err)
+		return "rejected, " + err.Error(), false
+	}
+	return res, true
+}
+
+// RunUpdates receives updates with long polling, blocks till context canceled.
+// Should not be used together with webhook, removes webhook set before.
+func (t *Telegram) RunUpdates(ctx context.Context) {
+	log.Printf("[INFO] start telegram updates polling")
+	if err := t.apiRequest(ctx, "deleteWebhook", nil, nil); err != nil {
+		log.Printf("[WARN] can't delete telegram webhook, %v", err)
+	}
+	var offset int64
+	for {
+		var updates []TelegramUpdate
+		req := map[string]interface{}{"offset": offset, "timeout": int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message", "callback_query"}}
+		err := t.apiRequest(ctx, "getUpdates", req, &updates)
+		if ctx.Err() != nil {
+			log.Printf("[INFO] telegram updates polling terminated, %v", ctx.Err())
+			return
+		}
+		if err != nil {
+			log.Printf("[WARN] can't get telegram updates, %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(telegramPollRetry):
+			}
+			continue
+		}
+		for _, upd := range updates {
+			t.ProcessUpdate(ctx, upd)
+			offset = upd.UpdateID + 1
+		}
+	}
+}
+
+// SetWebhook asks telegram to send updates to url, with secret passed in X-Telegram-Bot-Api-Secret-Token header
+func (t *Telegram) SetWebhook(ctx context.Context, url, secret string) error {
+	req := map[string]interface{}{"url": url, "secret_token": secret, "allowed_updates": []string{"message", "callback_query"}}
+	if err := t.apiRequest(ctx, "setWebhook", req, nil); err != nil {
+		return errors.Wrapf(err, "can't set telegram webhook to %s", url)
+	}
+	log.Printf("[INFO] telegram webhook set to %s", url)
+	return nil
+}
+
+// apiRequest calls telegram bot API method with json request, decodes result if res not nil
+func (t *Telegram) apiRequest(ctx context.Context, method string, req, res interface{}) error {
+	if req == nil {
+		req = struct{}{}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s request", method)
+	}
+	r, err := http.NewRequest("POST", t.apiPrefix+t.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "failed to make telegram %s request", method)
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	client := http.Client{Timeout: t.Timeout + telegramPollTimeout} // long polling keeps request for poll timeout
+	resp, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get telegram %s response", method)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+
+	tgResp := struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&tgResp); err != nil {
+		return errors.Wrapf(err, "can't decode telegram %s response, status %d", method, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || !tgResp.OK {
+		return errors.Errorf("unexpected telegram API status code %d, error: %q", resp.StatusCode, tgResp.Description)
+	}
+	if res != nil {
+		if err = json.Unmarshal(tgResp.Result, res); err != nil {
+			return errors.Wrapf(err, "can't decode telegram %s result", method)
+		}
+	}
+	return nil
+}
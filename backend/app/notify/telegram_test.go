@@ -2,9 +2,14 @@ package notify
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -138,6 +143,28 @@ func TestTelegram_Send(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestTelegram_adminChannel(t *testing.T) {
+	tb := Telegram{TelegramParams: TelegramParams{AdminChannelID: "remark_test"}}
+	assert.Equal(t, "remark_test", tb.adminChannel("blog"))
+
+	tb.SiteAdminChannel = func(siteID string) string {
+		if siteID == "blog" {
+			return "blog_channel"
+		}
+		return ""
+	}
+	assert.Equal(t, "blog_channel", tb.adminChannel("blog"))
+	assert.Equal(t, "remark_test", tb.adminChannel("remark"), "site without own channel")
+}
+
+func Test_buildTelegramMessageWithReports(t *testing.T) {
+	c := store.Comment{Text: "some text", Orig: "some text", ID: "999", Locator: store.Locator{URL: "http://example.com/blah"}}
+	c.User.Name = "from"
+	b, err := buildTelegramMessage(Request{Comment: c, Reports: []store.Report{{Reason: store.ReportOffTopic}}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"text":"Reported, 1 report: off\\-topic\n\n[from](http://example.com/blah#remark42__comment-999)\n\nsome text","parse_mode":"MarkdownV2"}`, string(b))
+}
+
 func TestTelegram_SendVerification(t *testing.T) {
 	ts := mockTelegramServer()
 	defer ts.Close()
@@ -237,3 +264,131 @@ func Test_escapeTitle(t *testing.T) {
 	}
 
 }
+
+func Test_buildTelegramAdminMessage(t *testing.T) {
+	c := store.Comment{Text: "some text", Orig: "some text", ID: "999", Locator: store.Locator{SiteID: "remark", URL: "http://example.com/blah"}}
+	c.User.Name = "from"
+	b, err := buildTelegramAdminMessage(Request{Comment: c})
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"reply_markup":{"inline_keyboard":[[{"text":"Delete","callback_data":"del|remark|999"}`)
+	assert.Contains(t, string(b), `{"text":"Block forever","callback_data":"bf|remark|999"}]]}`)
+	assert.NotContains(t, string(b), "Awaiting approval")
+
+	c.Pending = true
+	b, err = buildTelegramAdminMessage(Request{Comment: c})
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"text":"Awaiting approval\n\n[from](http://example.com/blah#remark42__comment-999)`)
+	assert.Contains(t, string(b), `"reply_markup":{"inline_keyboard":[[{"text":"Approve","callback_data":"ok|remark|999"}],[{"text":"Delete"`)
+
+	c.Locator.SiteID = strings.Repeat("x", 60)
+	b, err = buildTelegramAdminMessage(Request{Comment: c})
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "reply_markup", "no buttons if callback data too long")
+}
+
+func TestTelegram_ProcessUpdate(t *testing.T) {
+	var answers []string
+	var lock sync.Mutex
+	router := chi.NewRouter()
+	router.Post("/good-token/{method}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		answers = append(answers, chi.URLParam(r, "method")+" "+string(body))
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "result": true}`))
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tb := Telegram{TelegramParams: TelegramParams{Token: "good-token", apiPrefix: ts.URL + "/",
+		Admins: map[int64]string{123: "a1", 789: "dev"}}}
+	msg := &TelegramMessage{}
+	msg.Entities = append(msg.Entities, struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}{Type: "text_link", URL: "https://example.com/post1#remark42__comment-c1"})
+	upd := TelegramUpdate{CallbackQuery: &TelegramCallbackQuery{ID: "cb1", From: TelegramUser{ID: 123}, Message: msg, Data: "del|remark|c1"}}
+
+	tb.ProcessUpdate(context.Background(), upd)
+	assert.Equal(t, []string{`answerCallbackQuery {"callback_query_id":"cb1","show_alert":true,"text":"moderation disabled"}`}, answers)
+
+	mod := &mockModerator{}
+	tb.Moderator = mod
+	answers = nil
+	tb.ProcessUpdate(context.Background(), upd)
+	assert.Equal(t, []string{`answerCallbackQuery {"callback_query_id":"cb1","show_alert":false,"text":"comment deleted"}`}, answers)
+	assert.Equal(t, ModerationAction{Action: ModerationDelete, CommentID: "c1", Via: "telegram",
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post1"}}, mod.act)
+	assert.Equal(t, "a1", mod.userID)
+
+	answers = nil
+	upd.CallbackQuery.From.ID = 456
+	tb.ProcessUpdate(context.Background(), upd)
+	assert.Equal(t, []string{`answerCallbackQuery {"callback_query_id":"cb1","show_alert":true,` +
+		`"text":"rejected, telegram user 456 is not mapped to admin"}`}, answers)
+
+	answers = nil
+	upd.CallbackQuery.From.ID = 789
+	tb.ProcessUpdate(context.Background(), upd)
+	assert.Equal(t, []string{`answerCallbackQuery {"callback_query_id":"cb1","show_alert":true,"text":"rejected, not allowed"}`}, answers)
+
+	answers = nil
+	upd.CallbackQuery.Data = "del|remark|c2"
+	tb.ProcessUpdate(context.Background(), upd)
+	assert.Equal(t, []string{`answerCallbackQuery {"callback_query_id":"cb1","show_alert":true,"text":"unknown action"}`}, answers,
+		"no link to the comment in the message")
+
+	answers = nil
+	idMsg := &TelegramMessage{From: &TelegramUser{ID: 123}, Text: "/id"}
+	idMsg.Chat.ID = 123
+	tb.ProcessUpdate(context.Background(), TelegramUpdate{Message: idMsg})
+	assert.Equal(t, []string{`sendMessage {"chat_id":123,"text":"your telegram id is 123"}`}, answers)
+}
+
+func TestTelegram_RunUpdates(t *testing.T) {
+	var offsets []string
+	var lock sync.Mutex
+	router := chi.NewRouter()
+	router.Post("/good-token/deleteWebhook", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true, "result": true}`))
+	})
+	router.Post("/good-token/getUpdates", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Offset int64 `json:"offset"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		lock.Lock()
+		offsets = append(offsets, strconv.FormatInt(req.Offset, 10))
+		lock.Unlock()
+		if req.Offset == 0 {
+			_, _ = w.Write([]byte(`{"ok": true, "result": [{"update_id": 10, "message": {"text": "hi"}}]}`))
+			return
+		}
+		time.Sleep(50 * time.Millisecond) // long polling
+		_, _ = w.Write([]byte(`{"ok": true, "result": []}`))
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tb := Telegram{TelegramParams: TelegramParams{Token: "good-token", apiPrefix: ts.URL + "/"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	tb.RunUpdates(ctx)
+	lock.Lock()
+	defer lock.Unlock()
+	require.True(t, len(offsets) >= 2)
+	assert.Equal(t, []string{"0", "11"}, offsets[:2], "offset moved after received update")
+}
+
+type mockModerator struct {
+	userID string
+	act    ModerationAction
+}
+
+func (m *mockModerator) Moderate(userID string, act ModerationAction) (string, error) {
+	if userID != "a1" {
+		return "", errors.New("not allowed")
+	}
+	m.userID, m.act = userID, act
+	return "comment deleted", nil
+}
@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/repeater"
+	"github.com/go-pkgz/repeater/strategy"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// WebhookParams contain settings for webhook notifications
+type WebhookParams struct {
+	URLs       []string      // endpoints to post to
+	Secret     string        // key to sign body with hmac-sha256, signature not sent if empty
+	Template   string        // text/template of the body, WebhookMessage passed to it. json of WebhookMessage if empty
+	Headers    []string      // additional headers, as "name:value"
+	Timeout    time.Duration // http client timeout
+	Retries    int           // number of attempts, 1 means no retries
+	RetryDelay time.Duration // delay before the first retry, doubled for each next one
+
+	SiteURLs func(siteID string) []string // optional, per-site endpoints, replace URLs if not empty
+}
+
+// Webhook implements notify.Destination for generic http endpoints
+type Webhook struct {
+	WebhookParams
+	client http.Client
+	tmpl   *template.Template
+}
+
+// WebhookMessage is a data of the webhook, type is "comment", "report" or "verification"
+type WebhookMessage struct {
+	Type         string               `json:"type"`
+	Comment      *store.Comment       `json:"comment,omitempty"`
+	Parent       *store.Comment       `json:"parent,omitempty"`  // set for replies
+	Reports      []store.Report       `json:"reports,omitempty"` // set for reported comment
+	Verification *VerificationRequest `json:"verification,omitempty"`
+}
+
+// WebhookSignatureHeader keeps hex-encoded hmac-sha256 signature of the body, prefixed by "sha256="
+const WebhookSignatureHeader = "X-Remark42-Signature"
+
+const (
+	webhookTimeOut    = 5 * time.Second
+	webhookRetries    = 5
+	webhookRetryDelay = time.Second
+)
+
+// NewWebhook makes webhook notifier and parses the template. Template has "json" function
+// to escape values, i.e. {"text": {{json .Comment.Orig}}}
+func NewWebhook(params WebhookParams) (*Webhook, error) {
+	if len(params.URLs) == 0 {
+		return nil, errors.New("no webhook urls")
+	}
+	for _, h := range params.Headers {
+		if !strings.Contains(h, ":") {
+			return nil, errors.Errorf("invalid webhook header %q, should be name:value", h)
+		}
+	}
+	if params.Timeout == 0 {
+		params.Timeout = webhookTimeOut
+	}
+	if params.Retries <= 0 {
+		params.Retries = webhookRetries
+	}
+	if params.RetryDelay == 0 {
+		params.RetryDelay = webhookRetryDelay
+	}
+
+	res := Webhook{WebhookParams: params, client: http.Client{Timeout: params.Timeout}}
+	if params.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": webhookJSON}).Parse(params.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse webhook template")
+		}
+		res.tmpl = tmpl
+	}
+	log.Printf("[DEBUG] create new webhook notifier for %d urls, timeout=%s, retries=%d", len(params.URLs), res.Timeout, res.Retries)
+	return &res, nil
+}
+
+// Send comment to all webhook urls
+func (w *Webhook) Send(ctx context.Context, req Request) error {
+	log.Printf("[DEBUG] send webhook notification, comment id %s", req.Comment.ID)
+	return w.send(ctx, req.Comment.Locator.SiteID, commentMessage(req))
+}
+
+// SendVerification to all webhook urls
+func (w *Webhook) SendVerification(ctx context.Context, req VerificationRequest) error {
+	log.Printf("[DEBUG] send webhook verification for %s", req.User)
+	return w.send(ctx, req.SiteID, WebhookMessage{Type: "verification", Verification: &req})
+}
+
+// Targets returns webhook urls of the site, outbox delivers to each one separately
+func (w *Webhook) Targets(siteID string) []string {
+	return w.urls(siteID)
+}
+
+// SendTo posts comment to the single url, without retries as outbox retries failed urls by itself
+func (w *Webhook) SendTo(ctx context.Context, u string, req Request) error {
+	log.Printf("[DEBUG] send webhook notification to %s, comment id %s", u, req.Comment.ID)
+	return w.sendTo(ctx, u, commentMessage(req))
+}
+
+// SendVerificationTo posts verification to the single url, without retries
+func (w *Webhook) SendVerificationTo(ctx context.Context, u string, req VerificationRequest) error {
+	log.Printf("[DEBUG] send webhook verification to %s for %s", u, req.User)
+	return w.sendTo(ctx, u, WebhookMessage{Type: "verification", Verification: &req})
+}
+
+// Name of the destination, doesn't depend on settings
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) String() string {
+	return fmt.Sprintf("webhook: %s", strings.Join(w.URLs, ", "))
+}
+
+// commentMessage makes webhook message of the comment or the report
+func commentMessage(req Request) WebhookMessage {
+	msg := WebhookMessage{Type: "comment", Comment: &req.Comment, Reports: req.Reports}
+	if len(req.Reports) > 0 {
+		msg.Type = "report"
+	}
+	if req.parent.ID != "" {
+		msg.Parent = &req.parent
+	}
+	return msg
+}
+
+// urls returns endpoints of the site, URLs used for sites without own endpoints
+func (w *Webhook) urls(siteID string) []string {
+	if w.SiteURLs != nil {
+		if urls := w.SiteURLs(siteID); len(urls) > 0 {
+			return urls
+		}
+	}
+	return w.URLs
+}
+
+func (w *Webhook) send(ctx context.Context, siteID string, msg WebhookMessage) error {
+	body, err := w.body(msg)
+	if err != nil {
+		return errors.Wrapf(err, "can't make webhook body for %s", msg.Type)
+	}
+
+	errs := new(multierror.Error)
+	for _, u := range w.urls(siteID) {
+		rpt := repeater.New(&strategy.Backoff{Duration: w.RetryDelay, Repeats: w.Retries, Factor: 2, Jitter: true})
+		if e := rpt.Do(ctx, func() error { return w.post(ctx, u, body) }); e != nil {
+			errs = multierror.Append(errs, errors.Wrapf(e, "webhook %s failed", u))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func (w *Webhook) sendTo(ctx context.Context, u string, msg WebhookMessage) error {
+	body, err := w.body(msg)
+	if err != nil {
+		return errors.Wrapf(err, "can't make webhook body for %s", msg.Type)
+	}
+	return errors.Wrapf(w.post(ctx, u, body), "webhook %s failed", u)
+}
+
+func (w *Webhook) post(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "can't make webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range w.Headers {
+		kv := strings.SplitN(h, ":", 2)
+		req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(w.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close webhook response body, %s", err)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// body renders template or marshals message if no template set
+func (w *Webhook) body(msg WebhookMessage) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(msg)
+	}
+	buf := bytes.Buffer{}
+	if err := w.tmpl.Execute(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WebhookSignature returns hex-encoded hmac-sha256 of the body, receiver can check it against signature header
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookJSON used in templates to put escaped value
+func webhookJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestWebhook_New(t *testing.T) {
+	_, err := NewWebhook(WebhookParams{})
+	assert.EqualError(t, err, "no webhook urls")
+
+	_, err = NewWebhook(WebhookParams{URLs: []string{"http://example.com"}, Headers: []string{"bad"}})
+	assert.EqualError(t, err, `invalid webhook header "bad", should be name:value`)
+
+	_, err = NewWebhook(WebhookParams{URLs: []string{"http://example.com"}, Template: "{{.Bad"})
+	assert.Error(t, err)
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{"http://example.com", "http://example.org"}})
+	require.NoError(t, err)
+	assert.Equal(t, "webhook: http://example.com, http://example.org", wh.String())
+	assert.Equal(t, webhookTimeOut, wh.Timeout)
+	assert.Equal(t, webhookRetries, wh.Retries)
+}
+
+func TestWebhook_Send(t *testing.T) {
+	var body []byte
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{ts.URL}, Secret: "secret", Headers: []string{"X-Token: 123"}})
+	require.NoError(t, err)
+
+	c := store.Comment{ID: "c2", ParentID: "c1", Orig: "some text", User: store.User{Name: "user2"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://example.com/post"}}
+	req := Request{Comment: c, parent: store.Comment{ID: "c1", User: store.User{Name: "user1"}}}
+	require.NoError(t, wh.Send(context.Background(), req))
+
+	msg := WebhookMessage{}
+	require.NoError(t, json.Unmarshal(body, &msg))
+	assert.Equal(t, "comment", msg.Type)
+	assert.Equal(t, "some text", msg.Comment.Orig)
+	assert.Equal(t, "user1", msg.Parent.User.Name)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "123", header.Get("X-Token"))
+	assert.Equal(t, "sha256="+WebhookSignature("secret", body), header.Get(WebhookSignatureHeader))
+
+	req = Request{Comment: c, Reports: []store.Report{{Reason: store.ReportSpam}}}
+	require.NoError(t, wh.Send(context.Background(), req))
+	msg = WebhookMessage{}
+	require.NoError(t, json.Unmarshal(body, &msg))
+	assert.Equal(t, "report", msg.Type)
+	assert.Nil(t, msg.Parent)
+	assert.Equal(t, store.ReportSpam, msg.Reports[0].Reason)
+
+	require.NoError(t, wh.SendVerification(context.Background(), VerificationRequest{SiteID: "remark42", User: "user1", Token: "tkn"}))
+	msg = WebhookMessage{}
+	require.NoError(t, json.Unmarshal(body, &msg))
+	assert.Equal(t, "verification", msg.Type)
+	assert.Nil(t, msg.Comment)
+	assert.Equal(t, VerificationRequest{SiteID: "remark42", User: "user1", Token: "tkn"}, *msg.Verification)
+}
+
+func TestWebhook_SendSiteURLs(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{ts.URL + "/all"}})
+	require.NoError(t, err)
+	wh.SiteURLs = func(siteID string) []string {
+		if siteID == "blog" {
+			return []string{ts.URL + "/blog1", ts.URL + "/blog2"}
+		}
+		return nil
+	}
+
+	c := store.Comment{ID: "c1", Orig: "some text", Locator: store.Locator{SiteID: "blog", URL: "https://example.com/post"}}
+	require.NoError(t, wh.Send(context.Background(), Request{Comment: c}))
+	assert.Equal(t, []string{"/blog1", "/blog2"}, calls)
+
+	c.Locator.SiteID = "remark42"
+	require.NoError(t, wh.Send(context.Background(), Request{Comment: c}))
+	assert.Equal(t, []string{"/blog1", "/blog2", "/all"}, calls, "site without own urls")
+
+	require.NoError(t, wh.SendVerification(context.Background(), VerificationRequest{SiteID: "blog", User: "user1"}))
+	assert.Equal(t, []string{"/blog1", "/blog2", "/all", "/blog1", "/blog2"}, calls)
+}
+
+func TestWebhook_SendTemplate(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "", r.Header.Get(WebhookSignatureHeader), "not signed without secret")
+	}))
+	defer ts.Close()
+
+	tmpl := `{"text": {{json .Comment.Orig}}, "user": "{{.Comment.User.Name}}"}`
+	wh, err := NewWebhook(WebhookParams{URLs: []string{ts.URL}, Template: tmpl})
+	require.NoError(t, err)
+
+	c := store.Comment{Orig: `text with "quotes"`, User: store.User{Name: "user1"}}
+	require.NoError(t, wh.Send(context.Background(), Request{Comment: c}))
+	assert.Equal(t, `{"text": "text with \"quotes\"", "user": "user1"}`, string(body))
+
+	err = wh.SendVerification(context.Background(), VerificationRequest{User: "user1"})
+	assert.Error(t, err, "template fails on nil comment")
+}
+
+func TestWebhook_SendRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookParams{URLs: []string{ts.URL}, Retries: 3, RetryDelay: time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, wh.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}}))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, -10)
+	err = wh.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected status code 502")
+	assert.Equal(t, int32(-7), atomic.LoadInt32(&calls), "3 attempts")
+}
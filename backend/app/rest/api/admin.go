@@ -2,8 +2,10 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,33 +15,72 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 
+	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
 )
 
 // admin provides router for all requests available for admin users only
 type admin struct {
-	dataService   adminStore
-	cache         LoadingCache
-	authenticator *auth.Service
-	readOnlyAge   int
-	migrator      *Migrator
+	dataService    adminStore
+	cache          LoadingCache
+	authenticator  *auth.Service
+	readOnlyAge    int
+	moderatorBlock time.Duration // max block duration for moderators, they can't block permanently
+	migrator       *Migrator
+	notifyService  *notify.Service
+	broker         *stream.Broker
 }
 
 type adminStore interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
+	Restore(locator store.Locator, commentID string) (store.Comment, error)
+	ReportSpam(locator store.Locator, commentID string, spam bool) (store.Comment, error)
 	DeleteUser(siteID string, userID string, mode store.DeleteMode) error
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+	Search(req engine.SearchRequest, user store.User) ([]store.Comment, error)
 	IsBlocked(siteID string, userID string) bool
 	SetBlock(siteID string, userID string, status bool, ttl time.Duration) error
 	BlockedUsers(siteID string) ([]store.BlockedUser, error)
+	SetShadowBan(siteID, userID string, status bool) error
+	ShadowBannedUsers(siteID string) ([]store.User, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
 	SetTitle(locator store.Locator, commentID string) (comment store.Comment, err error)
 	SetVerified(siteID string, userID string, status bool) error
+	SetPostAuthor(locator store.Locator, userID string, status bool) error
+	PostAuthors(locator store.Locator) ([]string, error)
+	SetModerator(siteID, userID string, status bool) error
+	Moderators(siteID string) ([]string, error)
 	SetReadOnly(locator store.Locator, status bool) error
 	SetPin(locator store.Locator, commentID string, status bool) error
+	SetModerated(locator store.Locator, status bool) error
+	Pending(locator store.Locator, limit, skip int, user store.User) ([]store.Comment, error)
+	Approve(locator store.Locator, commentID string) (store.Comment, error)
+	Reject(locator store.Locator, commentID string) error
+	ReportedComments(siteID string, user store.User) ([]service.ReportedComment, error)
+	ResolveReports(locator store.Locator, commentID string) error
+	DismissReports(locator store.Locator, commentID string) error
+	AddAudit(rec store.AuditRecord) error
+	AuditLog(req engine.AuditRequest) ([]store.AuditRecord, error)
+	CreateAPIToken(siteID, userID, name string, scopes []store.TokenScope, ttl time.Duration) (string, store.APIToken, error)
+	APITokens(siteID string) ([]store.APIToken, error)
+	RevokeAPIToken(siteID, id string) error
+}
+
+// AuditStore defines sub-interface for consumers recording admin actions
+type AuditStore interface {
+	AddAudit(rec store.AuditRecord) error
+}
+
+// pendingRef identifies pending comment in bulk approve and reject requests
+type pendingRef struct {
+	URL string `json:"url"`
+	ID  string `json:"id"`
 }
 
 // DELETE /comment/{id}?site=siteID&url=post-url - removes comment
@@ -54,11 +95,54 @@ func (a *admin) deleteCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete comment", rest.ErrInternal)
 		return
 	}
+	audit(a.dataService, r, locator.SiteID, store.AuditDeleteComment, id, map[string]string{"url": locator.URL})
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	publishEvent(a.broker, a.dataService, stream.EvDeleted, locator, id)
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
 
+// PUT /comment/{id}/restore?site=siteID&url=post-url - restores soft-deleted comment with its text, score and votes
+func (a *admin) restoreCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] restore comment %s", id)
+
+	comment, err := a.dataService.Restore(locator, id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't restore comment", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditRestoreComment, id, map[string]string{"url": locator.URL})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+	if !comment.Pending { // restored pending comment published on approval
+		publishEvent(a.broker, a.dataService, stream.EvCreated, locator, id)
+	}
+	render.JSON(w, r, R.JSON{"id": id, "locator": locator, "restored": true})
+}
+
+// PUT /comment/{id}/spam?site=siteID&url=post-url&spam=1 - reports missed spam (spam=1) or false positive (spam=0)
+// to spam checker and sets spam mark of the comment
+func (a *admin) reportSpamCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	spam := r.URL.Query().Get("spam") == "1"
+	log.Printf("[INFO] report comment %s, spam=%v", id, spam)
+
+	comment, err := a.dataService.ReportSpam(locator, id, spam)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't report spam", rest.ErrActionRejected)
+		return
+	}
+	if spam {
+		audit(a.dataService, r, locator.SiteID, store.AuditReportSpam, id, map[string]string{"url": locator.URL})
+	} else {
+		audit(a.dataService, r, locator.SiteID, store.AuditReportHam, id, map[string]string{"url": locator.URL})
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+	render.JSON(w, r, R.JSON{"id": id, "locator": locator, "spam": spam})
+}
+
 // DELETE /user/{userid}?site=side-id - delete all user comments for requested userid
 func (a *admin) deleteUserCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -70,11 +154,47 @@ func (a *admin) deleteUserCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete user", rest.ErrInternal)
 		return
 	}
+	audit(a.dataService, r, siteID, store.AuditDeleteUser, userID, nil)
 	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID})
 }
 
+// GET /search?site=siteID&q=query&user=userID&url=post-url&from=unix_ts_msec&to=unix_ts_msec&limit=20&skip=10
+// full-text search of comments with optional filters by user, post and time range
+func (a *admin) searchCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := engine.SearchRequest{
+		Locator: store.Locator{SiteID: query.Get("site"), URL: query.Get("url")},
+		Query:   query.Get("q"),
+		UserID:  query.Get("user"),
+	}
+	log.Printf("[INFO] search comments for %s, query %q, user %q, url %q", req.Locator.SiteID, req.Query, req.UserID, req.Locator.URL)
+
+	var err error
+	if req.From, err = parseTimestamp(query.Get("from")); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't translate from parameter", rest.ErrDecode)
+		return
+	}
+	if req.To, err = parseTimestamp(query.Get("to")); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't translate to parameter", rest.ErrDecode)
+		return
+	}
+	if req.Limit, err = strconv.Atoi(query.Get("limit")); err != nil {
+		req.Limit = 0
+	}
+	if req.Skip, err = strconv.Atoi(query.Get("skip")); err != nil {
+		req.Skip = 0
+	}
+
+	comments, err := a.dataService.Search(req, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't search comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
 // GET /user/{userid}?site=side-id - get user info for requested userid
 func (a *admin) getUserInfoCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -130,6 +250,7 @@ func (a *admin) deleteMeRequestCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	audit(a.dataService, r, claims.Audience, store.AuditDeleteMe, claims.User.ID, nil)
 	a.cache.Flush(cache.Flusher(claims.Audience).Scopes(claims.Audience, claims.User.ID, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"user_id": claims.User.ID, "site_id": claims.Audience})
@@ -148,6 +269,28 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if user := rest.MustGetUserInfo(r); blockStatus && !user.Admin && (ttl == 0 || ttl > a.moderatorBlock) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("block is too long for moderator"),
+			fmt.Sprintf("moderator can block for %s max", a.moderatorBlock), rest.ErrActionRejected)
+		return
+	}
+
+	// moderator can lift only blocks they could set, permanent and longer blocks lifted by owners
+	if user := rest.MustGetUserInfo(r); !blockStatus && !user.Admin {
+		blocked, err := a.dataService.BlockedUsers(siteID)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get blocked users", rest.ErrActionRejected)
+			return
+		}
+		for _, b := range blocked {
+			if b.ID == userID && time.Until(b.Until) > a.moderatorBlock {
+				rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("block is too long for moderator"),
+					fmt.Sprintf("moderator can lift blocks for %s max", a.moderatorBlock), rest.ErrActionRejected)
+				return
+			}
+		}
+	}
+
 	if err := a.dataService.SetBlock(siteID, userID, blockStatus, ttl); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set blocking status", rest.ErrActionRejected)
 		return
@@ -159,6 +302,11 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", userID, siteID, err)
 		}
 	}
+	if blockStatus {
+		audit(a.dataService, r, siteID, store.AuditBlock, userID, map[string]string{"ttl": ttl.String()})
+	} else {
+		audit(a.dataService, r, siteID, store.AuditUnblock, userID, nil)
+	}
 	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "block": blockStatus})
 }
@@ -174,6 +322,37 @@ func (a *admin) blockedUsersCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, users)
 }
 
+// PUT /shadowban/{userid}?site=side-id&shadowban=1 - shadow-ban or un-ban user.
+// New comments of shadow-banned user visible to the user and admins only, un-ban publishes them
+func (a *admin) setShadowBanCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+	banStatus := r.URL.Query().Get("shadowban") == "1"
+
+	if err := a.dataService.SetShadowBan(siteID, userID, banStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set shadow-ban status", rest.ErrActionRejected)
+		return
+	}
+	if banStatus {
+		audit(a.dataService, r, siteID, store.AuditShadowBan, userID, nil)
+	} else {
+		audit(a.dataService, r, siteID, store.AuditShadowUnban, userID, nil)
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
+	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "shadowban": banStatus})
+}
+
+// GET /shadowbanned?site=siteID - list shadow-banned users
+func (a *admin) shadowBannedUsersCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	users, err := a.dataService.ShadowBannedUsers(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get shadow-banned users", rest.ErrSiteNotFound)
+		return
+	}
+	render.JSON(w, r, users)
+}
+
 // PUT /readonly?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -197,6 +376,11 @@ func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set readonly status", rest.ErrPostNotFound)
 		return
 	}
+	if roStatus {
+		audit(a.dataService, r, locator.SiteID, store.AuditReadOnly, locator.URL, nil)
+	} else {
+		audit(a.dataService, r, locator.SiteID, store.AuditReadWrite, locator.URL, nil)
+	}
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, locator.SiteID))
 	render.JSON(w, r, R.JSON{"locator": locator, "read-only": roStatus})
 }
@@ -212,6 +396,7 @@ func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("[INFO] set comment's title %s to %q", id, c.PostTitle)
+	audit(a.dataService, r, locator.SiteID, store.AuditSetTitle, id, map[string]string{"url": locator.URL, "title": c.PostTitle})
 
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope))
 	render.Status(r, http.StatusOK)
@@ -228,10 +413,231 @@ func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set verify status", rest.ErrActionRejected)
 		return
 	}
+	if verifyStatus {
+		audit(a.dataService, r, siteID, store.AuditVerify, userID, nil)
+	} else {
+		audit(a.dataService, r, siteID, store.AuditUnverify, userID, nil)
+	}
 	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID, userID))
 	render.JSON(w, r, R.JSON{"user": userID, "verified": verifyStatus})
 }
 
+// PUT /author/{userid}?site=siteID&url=post-url&author=1 - set or remove user as author of the post
+func (a *admin) setPostAuthorCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	authorStatus := r.URL.Query().Get("author") == "1"
+
+	if err := a.dataService.SetPostAuthor(locator, userID, authorStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set author status", rest.ErrActionRejected)
+		return
+	}
+	if authorStatus {
+		audit(a.dataService, r, locator.SiteID, store.AuditAddAuthor, userID, map[string]string{"url": locator.URL})
+	} else {
+		audit(a.dataService, r, locator.SiteID, store.AuditRemoveAuthor, userID, map[string]string{"url": locator.URL})
+	}
+	render.JSON(w, r, R.JSON{"user": userID, "locator": locator, "author": authorStatus})
+}
+
+// GET /authors?site=siteID&url=post-url - list ids of authors of the post
+func (a *admin) postAuthorsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	authors, err := a.dataService.PostAuthors(locator)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get authors of the post", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, authors)
+}
+
+// PUT /moderator/{userid}?site=siteID&moderator=1 - set or remove user as moderator of the site
+func (a *admin) setModeratorCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+	modStatus := r.URL.Query().Get("moderator") == "1"
+
+	if err := a.dataService.SetModerator(siteID, userID, modStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set moderator status", rest.ErrActionRejected)
+		return
+	}
+	if modStatus {
+		audit(a.dataService, r, siteID, store.AuditAddModerator, userID, nil)
+	} else {
+		audit(a.dataService, r, siteID, store.AuditRemoveModerator, userID, nil)
+	}
+	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "moderator": modStatus})
+}
+
+// GET /moderators?site=siteID - list ids of moderators of the site, set by server's options and at runtime
+func (a *admin) moderatorsCtrl(w http.ResponseWriter, r *http.Request) {
+	mods, err := a.dataService.Moderators(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get moderators", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, mods)
+}
+
+// PUT /moderation?site=siteID&url=post-url&moderation=1 - set or reset pre-moderation status for the post
+func (a *admin) setModeratedCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	modStatus := r.URL.Query().Get("moderation") == "1"
+
+	if err := a.dataService.SetModerated(locator, modStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set moderation status", rest.ErrPostNotFound)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditModeration, locator.URL, map[string]string{"moderation": strconv.FormatBool(modStatus)})
+	render.JSON(w, r, R.JSON{"locator": locator, "moderation": modStatus})
+}
+
+// GET /pending?site=siteID&url=post-url&limit=20&skip=10 - list comments waiting for moderation, oldest first.
+// url is optional, all posts of the site listed without it
+func (a *admin) pendingCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 0
+	}
+	skip, err := strconv.Atoi(r.URL.Query().Get("skip"))
+	if err != nil {
+		skip = 0
+	}
+
+	user := rest.GetUserOrEmpty(r)
+	user.Admin = true // moderator route, pending comments shown to moderators the same way as to admins
+	comments, err := a.dataService.Pending(locator, limit, skip, user)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get pending comments", rest.ErrSiteNotFound)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// PUT /pending/{id}?site=siteID&url=post-url - approve pending comment
+func (a *admin) approveCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] approve comment %s", commentID)
+
+	if err := a.approve(locator, commentID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't approve comment", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditApprove, commentID, map[string]string{"url": locator.URL})
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "approved": true})
+}
+
+// DELETE /pending/{id}?site=siteID&url=post-url - reject pending comment
+func (a *admin) rejectCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] reject comment %s", commentID)
+
+	if err := a.dataService.Reject(locator, commentID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't reject comment", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditReject, commentID, map[string]string{"url": locator.URL})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "rejected": true})
+}
+
+// POST /pending/approve?site=siteID - approve pending comments in bulk, body is a list of {"url": "post-url", "id": "comment-id"}
+func (a *admin) approveCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	a.bulkPending(w, r, store.AuditApprove, a.approve)
+}
+
+// POST /pending/reject?site=siteID - reject pending comments in bulk, body is a list of {"url": "post-url", "id": "comment-id"}
+func (a *admin) rejectCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	a.bulkPending(w, r, store.AuditReject, func(locator store.Locator, commentID string) error {
+		if err := a.dataService.Reject(locator, commentID); err != nil {
+			return err
+		}
+		a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+		return nil
+	})
+}
+
+// bulkPending applies fn to all pending comments from the request's body and responds with lists of done and failed ids
+func (a *admin) bulkPending(w http.ResponseWriter, r *http.Request, action store.AuditAction, fn func(store.Locator, string) error) {
+	siteID := r.URL.Query().Get("site")
+	refs := []pendingRef{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &refs); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind pending comments", rest.ErrDecode)
+		return
+	}
+
+	done, failed := []string{}, []string{}
+	for _, ref := range refs {
+		if err := fn(store.Locator{SiteID: siteID, URL: ref.URL}, ref.ID); err != nil {
+			log.Printf("[WARN] can't %s comment %s, %v", action, ref.ID, err)
+			failed = append(failed, ref.ID)
+			continue
+		}
+		audit(a.dataService, r, siteID, action, ref.ID, map[string]string{"url": ref.URL})
+		done = append(done, ref.ID)
+	}
+	log.Printf("[INFO] %s %d pending comments for %s, failed %d", action, len(done), siteID, len(failed))
+	render.JSON(w, r, R.JSON{"site": siteID, "done": done, "failed": failed})
+}
+
+// approve publishes pending comment and sends deferred notifications
+func (a *admin) approve(locator store.Locator, commentID string) error {
+	comment, err := a.dataService.Approve(locator, commentID)
+	if err != nil {
+		return err
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+	if a.notifyService != nil && !comment.Shadow {
+		a.notifyService.Submit(notify.Request{Comment: comment, Approved: true})
+	}
+	publishEvent(a.broker, a.dataService, stream.EvCreated, locator, commentID)
+	return nil
+}
+
+// GET /reports?site=siteID - list of reported comments with reports, in order of the first report
+func (a *admin) reportedCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.ReportedComments(siteID, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get reported comments", rest.ErrSiteNotFound)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// PUT /reports/{id}/resolve?site=siteID&url=post-url - delete reported comment and clear its reports
+func (a *admin) resolveReportsCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] resolve reports for comment %s", commentID)
+
+	if err := a.dataService.ResolveReports(locator, commentID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't resolve reports", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditResolveReports, commentID, map[string]string{"url": locator.URL})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	publishEvent(a.broker, a.dataService, stream.EvDeleted, locator, commentID)
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "resolved": true})
+}
+
+// PUT /reports/{id}/dismiss?site=siteID&url=post-url - clear reports, keeping the comment
+func (a *admin) dismissReportsCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] dismiss reports for comment %s", commentID)
+
+	if err := a.dataService.DismissReports(locator, commentID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't dismiss reports", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, locator.SiteID, store.AuditDismissReports, commentID, map[string]string{"url": locator.URL})
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "dismissed": true})
+}
+
 // PUT /pin/{id}?site=siteID&url=post-url&pin=1
 // mark/unmark comment as a special
 func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
@@ -243,6 +649,157 @@ func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set pin status", rest.ErrActionRejected)
 		return
 	}
+	if pinStatus {
+		audit(a.dataService, r, locator.SiteID, store.AuditPin, commentID, map[string]string{"url": locator.URL})
+	} else {
+		audit(a.dataService, r, locator.SiteID, store.AuditUnpin, commentID, map[string]string{"url": locator.URL})
+	}
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+	publishEvent(a.broker, a.dataService, stream.EvPinned, locator, commentID)
 	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus})
 }
+
+// GET /audit?site=siteID&actor=userID&action=block&target=id&from=unix_ts_msec&to=unix_ts_msec&limit=100&skip=10
+// lists recorded admin actions, newest first
+func (a *admin) auditLogCtrl(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := engine.AuditRequest{
+		SiteID: query.Get("site"),
+		Actor:  query.Get("actor"),
+		Action: store.AuditAction(query.Get("action")),
+		Target: query.Get("target"),
+	}
+
+	var err error
+	if req.From, err = parseTimestamp(query.Get("from")); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't translate from parameter", rest.ErrDecode)
+		return
+	}
+	if req.To, err = parseTimestamp(query.Get("to")); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't translate to parameter", rest.ErrDecode)
+		return
+	}
+	if req.Limit, err = strconv.Atoi(query.Get("limit")); err != nil || req.Limit <= 0 {
+		req.Limit = 100
+	}
+	if req.Limit > 1000 {
+		req.Limit = 1000
+	}
+	if req.Skip, err = strconv.Atoi(query.Get("skip")); err != nil {
+		req.Skip = 0
+	}
+
+	records, err := a.dataService.AuditLog(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get audit log", rest.ErrInternal)
+		return
+	}
+	if records == nil {
+		records = []store.AuditRecord{}
+	}
+	render.JSON(w, r, records)
+}
+
+// GET /notifications/failed?site=siteID - notifications not delivered to some destinations after all retries
+func (a *admin) failedNotificationsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	recs, err := a.notifyService.Failed(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get failed notifications", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, recs)
+}
+
+// PUT /notifications/resend?site=siteID&id=notification-id - resend failed notification, all failed notifications of the site if id not set
+func (a *admin) resendNotificationsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, id := r.URL.Query().Get("site"), r.URL.Query().Get("id")
+	log.Printf("[INFO] resend failed notifications for %s, id=%q", siteID, id)
+	count, err := a.notifyService.Resend(siteID, id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't resend notifications", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, siteID, store.AuditResendNotifications, id, map[string]string{"count": strconv.Itoa(count)})
+	render.JSON(w, r, R.JSON{"site": siteID, "id": id, "count": count})
+}
+
+// POST /tokens?site=siteID - make API token, body is {"name": "ci", "scopes": ["read"], "ttl": "720h"}
+// scopes and ttl are optional, token value returned once and can't be restored later
+func (a *admin) createAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Name   string             `json:"name"`
+		Scopes []store.TokenScope `json:"scopes"`
+		TTL    string             `json:"ttl"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind token request", rest.ErrDecode)
+		return
+	}
+	ttl := time.Duration(0) // never expires by default
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse token ttl", rest.ErrDecode)
+			return
+		}
+		ttl = d
+	}
+
+	siteID := r.URL.Query().Get("site")
+	value, tkn, err := a.dataService.CreateAPIToken(siteID, rest.MustGetUserInfo(r).ID, req.Name, req.Scopes, ttl)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't make token", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, siteID, store.AuditCreateToken, tkn.ID, map[string]string{"name": tkn.Name})
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, R.JSON{"token": value, "info": tkn})
+}
+
+// GET /tokens?site=siteID - list API tokens of the site, without values
+func (a *admin) apiTokensCtrl(w http.ResponseWriter, r *http.Request) {
+	tokens, err := a.dataService.APITokens(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get tokens", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, tokens)
+}
+
+// DELETE /tokens/{id}?site=siteID - revoke API token
+func (a *admin) revokeAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, id := r.URL.Query().Get("site"), chi.URLParam(r, "id")
+	if err := a.dataService.RevokeAPIToken(siteID, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't revoke token", rest.ErrActionRejected)
+		return
+	}
+	audit(a.dataService, r, siteID, store.AuditRevokeToken, id, nil)
+	render.JSON(w, r, R.JSON{"id": id, "revoked": true})
+}
+
+// newAuditRecord makes audit record for the action made by the user from request
+func newAuditRecord(r *http.Request, siteID string, action store.AuditAction, target string, params map[string]string) store.AuditRecord {
+	return store.AuditRecord{
+		SiteID: siteID,
+		Actor:  rest.GetUserOrEmpty(r).ID,
+		Action: action,
+		Target: target,
+		Params: params,
+	}
+}
+
+// saveAudit stores audit record, failure logged and not reported to the caller as the action itself already done
+func saveAudit(as AuditStore, rec store.AuditRecord) {
+	if as == nil {
+		return
+	}
+	if err := as.AddAudit(rec); err != nil {
+		log.Printf("[WARN] can't save audit record %s for %s on site %s, %v", rec.Action, rec.Target, rec.SiteID, err)
+	}
+}
+
+// audit records action made by the user from request
+func audit(as AuditStore, r *http.Request, siteID string, action store.AuditAction, target string, params map[string]string) {
+	saveAudit(as, newAuditRecord(r, siteID, action, target, params))
+}
@@ -3,13 +3,16 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -19,8 +22,12 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
+	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -205,6 +212,287 @@ func TestAdmin_DeleteUser(t *testing.T) {
 	assert.True(t, cmntWithInfo.Comments[2].Deleted)
 }
 
+func TestAdmin_Moderator(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.AdminStore.(*adminstore.StaticStore).SetModerators([]string{"dev"})
+
+	id1 := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	for _, u := range []string{"/api/v1/admin/user/user2?site=remark42&block=1", "/api/v1/admin/user/user3?site=remark42&block=1&ttl=48h"} {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+u, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, "blocked by owner")
+	}
+
+	tbl := []struct {
+		method, url string
+		code        int
+	}{
+		{"PUT", "/api/v1/admin/pin/" + id1 + "?site=remark42&url=https://radio-t.com/blah&pin=1", http.StatusOK},
+		{"PUT", "/api/v1/admin/user/user1?site=remark42&block=1&ttl=24h", http.StatusOK},
+		{"PUT", "/api/v1/admin/user/user1?site=remark42&block=1&ttl=25h", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/user/user1?site=remark42&block=1", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/user/user1?site=remark42&block=0", http.StatusOK},
+		{"PUT", "/api/v1/admin/user/user2?site=remark42&block=0", http.StatusForbidden}, // permanent block
+		{"PUT", "/api/v1/admin/user/user3?site=remark42&block=0", http.StatusForbidden}, // longer than moderator's block
+		{"PUT", "/api/v1/admin/user/user4?site=remark42&block=0", http.StatusOK},        // not blocked
+		{"PUT", "/api/v1/admin/moderator/user1?site=remark42&moderator=1", http.StatusForbidden},
+		{"GET", "/api/v1/admin/moderators?site=remark42", http.StatusForbidden},
+		{"GET", "/api/v1/admin/blocked?site=remark42", http.StatusOK},
+		{"DELETE", "/api/v1/admin/comment/" + id1 + "?site=remark42&url=https://radio-t.com/blah", http.StatusOK},
+		{"GET", "/api/v1/admin/export?site=remark42&mode=file", http.StatusForbidden},
+		{"POST", "/api/v1/admin/remap?site=remark42", http.StatusForbidden},
+		{"GET", "/api/v1/admin/deleteme?site=remark42", http.StatusForbidden},
+		{"DELETE", "/api/v1/admin/user/user1?site=remark42", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/pin/" + id1 + "?site=remark42_other&url=https://radio-t.com/blah&pin=1", http.StatusForbidden},
+	}
+	for i, tt := range tbl {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.url, nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "case #%d, %s %s", i, tt.method, tt.url)
+	}
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"role":"moderator"`)
+
+	body, code = get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"moderators":["dev"],"moderator_block":86400`)
+}
+
+func TestAdmin_SetModerator(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.AdminStore.(*adminstore.StaticStore).SetModerators([]string{"static_mod"})
+
+	setModerator := func(status string) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderator/dev?site=remark42&moderator="+status, nil)
+		require.NoError(t, err)
+		requireAdminOnly(t, req)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	blockAsDev := func() int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/user/user1?site=remark42&block=1&ttl=1h", nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusForbidden, blockAsDev(), "not moderator yet")
+	setModerator("1")
+	assert.Equal(t, http.StatusOK, blockAsDev(), "moderator set at runtime")
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/moderators?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `["static_mod","dev"]`+"\n", body)
+	body, code = get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"moderators":["static_mod","dev"]`)
+
+	setModerator("0")
+	assert.Equal(t, http.StatusForbidden, blockAsDev(), "moderator removed")
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/moderators?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `["static_mod"]`+"\n", body)
+}
+
+func TestAdmin_PostAuthor(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	id1 := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}, ts)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/author/dev?site=remark42&url=https://radio-t.com/blah&author=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	req.SetBasicAuth("admin", "password")
+	resp, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/authors?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `["dev"]`+"\n", body)
+
+	tbl := []struct {
+		method, url string
+		code        int
+	}{
+		{"PUT", "/api/v1/admin/pin/" + id1 + "?site=remark42&url=https://radio-t.com/blah&pin=1", http.StatusOK},
+		{"PUT", "/api/v1/admin/pin/" + id2 + "?site=remark42&url=https://radio-t.com/blah2&pin=1", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/pin/" + id2 + "?site=remark42&pin=1", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/readonly?site=remark42&url=https://radio-t.com/blah&ro=1", http.StatusOK},
+		{"PUT", "/api/v1/admin/readonly?site=remark42&url=https://radio-t.com/blah2&ro=1", http.StatusForbidden},
+		{"DELETE", "/api/v1/admin/comment/" + id2 + "?site=remark42&url=https://radio-t.com/blah", http.StatusInternalServerError}, // not in the post
+		{"DELETE", "/api/v1/admin/comment/" + id1 + "?site=remark42&url=https://radio-t.com/blah", http.StatusOK},
+		{"PUT", "/api/v1/admin/user/user1?site=remark42&block=1&ttl=1h", http.StatusForbidden},
+		{"GET", "/api/v1/admin/pending?site=remark42&url=https://radio-t.com/blah", http.StatusForbidden},
+		{"PUT", "/api/v1/admin/author/dev?site=remark42&url=https://radio-t.com/blah2&author=1", http.StatusForbidden},
+	}
+	for i, tt := range tbl {
+		req, err = http.NewRequest(tt.method, ts.URL+tt.url, nil)
+		require.NoError(t, err)
+		resp, err = sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "case #%d, %s %s", i, tt.method, tt.url)
+	}
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"role":"author"`)
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, `"role"`)
+
+	// author set by token made by host site
+	tkn, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"url": "https://radio-t.com/blah2", "author": "dev",
+		"aud": "remark42", "exp": time.Now().Add(time.Minute).Unix()}).SignedString([]byte("123456"))
+	require.NoError(t, err)
+	resp, err = post(t, ts.URL+"/api/v1/author?site=remark42", `{"token": "`+tkn+`"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"role":"author"`)
+
+	resp, err = post(t, ts.URL+"/api/v1/author?site=remark42", `{"token": "bad.token"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestAdmin_APIToken(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	id1 := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/tokens?site=remark42", strings.NewReader(`{"name":"ci"}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	makeToken := func(body string) (value, id string) {
+		resp, e := post(t, ts.URL+"/api/v1/admin/tokens?site=remark42", body)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		res := struct {
+			Token string         `json:"token"`
+			Info  store.APIToken `json:"info"`
+		}{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Equal(t, "", res.Info.Hash)
+		return res.Token, res.Info.ID
+	}
+	fullTkn, fullID := makeToken(`{"name":"full"}`)
+	readTkn, _ := makeToken(`{"name":"read", "scopes":["read"]}`)
+	migTkn, _ := makeToken(`{"name":"migration", "scopes":["migration"], "ttl":"1h"}`)
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/tokens?site=remark42", `{"name":"bad", "scopes":["all"]}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = post(t, ts.URL+"/api/v1/admin/tokens?site=remark42", `{"name":"bad", "ttl":"1 year"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/tokens?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	tokens := []store.APIToken{}
+	require.NoError(t, json.Unmarshal([]byte(body), &tokens))
+	require.Equal(t, 3, len(tokens))
+	assert.Equal(t, "full", tokens[0].Name)
+	assert.Equal(t, "admin", tokens[0].UserID)
+	assert.NotContains(t, body, `"hash"`)
+	modTkn, _ := makeToken(`{"name":"moderation", "scopes":["moderation"]}`)
+
+	tbl := []struct {
+		tkn, method, url string
+		code             int
+	}{
+		{fullTkn, "GET", "/api/v1/admin/blocked?site=remark42", http.StatusOK},
+		{fullTkn, "GET", "/api/v1/admin/audit?site=remark42", http.StatusOK},
+		{fullTkn, "PUT", "/api/v1/admin/pin/" + id1 + "?site=remark42&url=https://radio-t.com/blah&pin=1", http.StatusOK},
+		{fullTkn, "GET", "/api/v1/admin/blocked?site=other", http.StatusForbidden},
+		{fullTkn, "GET", "/api/v1/admin/tokens?site=remark42", http.StatusForbidden},
+		{fullTkn, "POST", "/api/v1/comment?site=remark42", http.StatusUnauthorized},
+		{readTkn, "GET", "/api/v1/admin/blocked?site=remark42", http.StatusOK},
+		{readTkn, "GET", "/api/v1/admin/audit?site=remark42", http.StatusOK},
+		{readTkn, "PUT", "/api/v1/admin/pin/" + id1 + "?site=remark42&url=https://radio-t.com/blah&pin=0", http.StatusForbidden},
+		{readTkn, "GET", "/api/v1/admin/export?site=remark42", http.StatusForbidden},
+		{readTkn, "GET", "/api/v1/admin/deleteme?site=remark42", http.StatusForbidden},
+		{migTkn, "GET", "/api/v1/admin/blocked?site=remark42", http.StatusForbidden},
+		{migTkn, "GET", "/api/v1/admin/export?site=remark42&mode=file", http.StatusOK},
+		{modTkn, "GET", "/api/v1/admin/pending?site=remark42", http.StatusOK},
+		{modTkn, "PUT", "/api/v1/admin/user/user1?site=remark42&block=1&ttl=24h", http.StatusOK},
+		{modTkn, "PUT", "/api/v1/admin/user/user1?site=remark42&block=1&ttl=25h", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/user/user1?site=remark42&block=1", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/user/user1?site=remark42&block=0", http.StatusOK},
+		{modTkn, "PUT", "/api/v1/admin/verify/user1?site=remark42&verified=1", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/shadowban/user1?site=remark42&shadowban=1", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/moderation?site=remark42&url=https://radio-t.com/blah&moderation=1", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/author/user1?site=remark42&url=https://radio-t.com/blah&author=1", http.StatusForbidden},
+		{modTkn, "GET", "/api/v1/admin/audit?site=remark42", http.StatusForbidden},
+		{modTkn, "PUT", "/api/v1/admin/notifications/resend?site=remark42", http.StatusForbidden},
+		{modTkn, "GET", "/api/v1/admin/export?site=remark42&mode=file", http.StatusForbidden},
+		{modTkn, "DELETE", "/api/v1/admin/user/user1?site=remark42", http.StatusForbidden},
+		{"bad", "GET", "/api/v1/admin/blocked?site=remark42", http.StatusUnauthorized},
+	}
+	for i, tt := range tbl {
+		req, err = http.NewRequest(tt.method, ts.URL+tt.url, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("remark42", tt.tkn)
+		resp, err = sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "case #%d, %s %s", i, tt.method, tt.url)
+	}
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&action=pin")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"actor":"token_`+fullID+`"`)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/tokens/"+fullID+"?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = sendReq(t, req, "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/blocked?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("remark42", fullTkn)
+	resp, err = sendReq(t, req, "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "revoked")
+}
+
 func TestAdmin_Pin(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -820,6 +1108,253 @@ func TestAdmin_DeleteMeRequestFailed(t *testing.T) {
 	assert.True(t, strings.Contains(string(b), "can't use provided token"))
 }
 
+func TestAdmin_Search(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "searchable comment #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
+	c2 := store.Comment{Text: "searchable comment #2", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah2"}, User: store.User{Name: "user2", ID: "user2"}}
+
+	id1, err := srv.DataService.Create(c1)
+	assert.NoError(t, err)
+	_, err = srv.DataService.Create(c2)
+	assert.NoError(t, err)
+
+	err = srv.DataService.Delete(c1.Locator, id1, store.SoftDelete)
+	assert.NoError(t, err)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable")
+	assert.Equal(t, http.StatusOK, code)
+	comments := []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 1, len(comments), "deleted comments excluded from the index")
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable&user=user2&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusOK, code)
+	comments = []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "user2", comments[0].User.ID)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable&user=user1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+
+	from := time.Now().Add(time.Hour).UnixNano() / 1000000
+	body, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/search?site=remark42&q=searchable&from=%d", ts.URL, from))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable&from=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable&to=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, code = get(t, ts.URL+"/api/v1/admin/search?site=remark42&q=searchable")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestAdmin_Pending(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.PreModeration = true
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+	id3 := addComment(t, store.Comment{Text: "test test #3", Locator: locator}, ts)
+	id4 := addComment(t, store.Comment{Text: "test test #4", Locator: locator}, ts)
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 0, len(comments.Comments), "pending comments hidden from anonymous")
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 4, len(comments.Comments), "pending comments visible to author")
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/pending?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	pending := []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &pending))
+	require.Equal(t, 4, len(pending))
+	assert.Equal(t, id1, pending[0].ID, "oldest first")
+	assert.True(t, pending[0].Pending)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/pending/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/pending/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id2), nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// second approve of the same comment rejected
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/pending/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// bulk approve
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/pending/approve?site=remark42",
+		strings.NewReader(fmt.Sprintf(`[{"url":"https://radio-t.com/blah","id":%q},{"url":"https://radio-t.com/blah","id":"bad"}]`, id3)))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	bulk := struct {
+		Done   []string `json:"done"`
+		Failed []string `json:"failed"`
+	}{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&bulk))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, []string{id3}, bulk.Done)
+	assert.Equal(t, []string{"bad"}, bulk.Failed)
+
+	// bulk reject
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/pending/reject?site=remark42",
+		strings.NewReader(fmt.Sprintf(`[{"url":"https://radio-t.com/blah","id":%q}]`, id4)))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/pending?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 2, len(comments.Comments), "approved comments visible, rejected hidden")
+	assert.Equal(t, id1, comments.Comments[0].ID)
+	assert.Equal(t, id3, comments.Comments[1].ID)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/pending/approve?site=remark42", strings.NewReader("bad"))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestAdmin_SetModerated(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderation?site=remark42&url=https://radio-t.com/blah&moderation=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, srv.DataService.IsModerated(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}))
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+	c, err := srv.DataService.Get(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}, id, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.True(t, c.Pending)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderation?site=remark42&url=https://radio-t.com/blah&moderation=0", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, srv.DataService.IsModerated(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}))
+}
+
+func TestAdmin_Reports(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "test test #3", Locator: locator}, ts)
+
+	_, err := srv.DataService.Report(locator, id2, store.User{ID: "user1"}, store.ReportSpam, "")
+	require.NoError(t, err)
+	_, err = srv.DataService.Report(locator, id1, store.User{ID: "user1"}, store.ReportAbusive, "rude")
+	require.NoError(t, err)
+	_, err = srv.DataService.Report(locator, id2, store.User{ID: "user2"}, store.ReportOffTopic, "")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/reports?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/reports?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	reported := []service.ReportedComment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &reported))
+	require.Equal(t, 2, len(reported))
+	assert.Equal(t, id2, reported[0].Comment.ID)
+	assert.Equal(t, 2, len(reported[0].Reports))
+	assert.Equal(t, id1, reported[1].Comment.ID)
+	assert.Equal(t, "rude", reported[1].Reports[0].Text)
+
+	// resolve deletes the comment
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/reports/%s/resolve?site=remark42&url=https://radio-t.com/blah", ts.URL, id2), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	c, err := srv.DataService.Get(locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+
+	// dismiss keeps the comment
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/reports/%s/dismiss?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	c, err = srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/reports?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body, "no reports left")
+
+	req, err = http.NewRequest(http.MethodPut,
+		ts.URL+"/api/v1/admin/reports/bad-id/resolve?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
 func TestAdmin_GetUserInfo(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -849,3 +1384,331 @@ func TestAdmin_GetUserInfo(t *testing.T) {
 	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/user/userX?site=remark42&url=https://radio-t.com/blah", ts.URL))
 	assert.Equal(t, 400, code, "no info about user")
 }
+
+func TestAdmin_Audit(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/pin/%s?site=remark42&url=https://radio-t.com/blah&pin=1", ts.URL, id1), nil)
+	require.NoError(t, err)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/user/dev?site=remark42&block=1&ttl=10m", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/audit?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	records := []store.AuditRecord{}
+	require.NoError(t, json.Unmarshal([]byte(body), &records))
+	require.Equal(t, 3, len(records), "newest first")
+	assert.Equal(t, store.AuditDeleteComment, records[0].Action)
+	assert.Equal(t, id1, records[0].Target)
+	assert.Equal(t, store.AuditBlock, records[1].Action)
+	assert.Equal(t, "dev", records[1].Target)
+	assert.Equal(t, "10m0s", records[1].Params["ttl"])
+	assert.Equal(t, store.AuditPin, records[2].Action)
+	for _, rec := range records {
+		assert.Equal(t, "github_ef0f706a7", rec.Actor)
+		assert.Equal(t, "remark42", rec.SiteID)
+	}
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&action=block&limit=10")
+	require.Equal(t, http.StatusOK, code, body)
+	records = []store.AuditRecord{}
+	require.NoError(t, json.Unmarshal([]byte(body), &records))
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, "dev", records[0].Target)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&actor=user2")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+
+	// remap is audited once completed
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/remap?site=remark42",
+		strings.NewReader("https://radio-t.com/blah https://radio-t.com/blah2"))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/wait?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+
+	recs, err := srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42", Action: store.AuditRemap})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, "github_ef0f706a7", recs[0].Actor)
+	recs, err = srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(recs), "audit kept after remap")
+}
+
+func TestAdmin_ShadowBan(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator,
+		User: store.User{ID: "user1", Name: "user one"}})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/shadowban/dev?site=remark42&shadowban=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/shadowbanned?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	users := []store.User{}
+	require.NoError(t, json.Unmarshal([]byte(body), &users))
+	require.Equal(t, 1, len(users))
+	assert.Equal(t, "dev", users[0].ID)
+
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+
+	id2 := addComment(t, store.Comment{Text: "test test #2", ParentID: id1, Locator: locator}, ts)
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, 0, len(mockDestination.Get()), "no notifications for shadowed comment")
+
+	findURL := ts.URL + "/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain"
+	comments := commentsWithInfo{}
+	body, code = get(t, findURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 1, len(comments.Comments), "shadowed comment hidden from anonymous")
+	assert.Equal(t, id1, comments.Comments[0].ID)
+	assert.Equal(t, 1, comments.Info.Count)
+
+	comments = commentsWithInfo{}
+	body, code = getWithDevAuth(t, findURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 2, len(comments.Comments), "shadowed comment visible to the author")
+	assert.Equal(t, id2, comments.Comments[1].ID)
+	assert.False(t, comments.Comments[1].Shadow)
+
+	for _, getFn := range []func(*testing.T, string) (string, int){get, getWithDevAuth} {
+		body, code = getFn(t, ts.URL+"/api/v1/last/10?site=remark42")
+		require.Equal(t, http.StatusOK, code, body)
+		last := []store.Comment{}
+		require.NoError(t, json.Unmarshal([]byte(body), &last))
+		assert.Equal(t, 1, len(last), "shadowed comment excluded from shared last comments")
+	}
+
+	body, code = get(t, ts.URL+"/api/v1/rss/site?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.NotContains(t, body, "test test #2")
+
+	// un-ban publishes comment
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/shadowban/dev?site=remark42&shadowban=0", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	comments = commentsWithInfo{}
+	body, code = get(t, findURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 2, len(comments.Comments))
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/shadowbanned?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+}
+
+func TestAdmin_RestoreComment(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.DeletedRetention = time.Hour
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code := get(t, ts.URL+"/api/v1/last/10?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	last := []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &last))
+	assert.Equal(t, 1, len(last), "deleted comment excluded")
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s/restore?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	require.Equal(t, http.StatusOK, code, body)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.False(t, c.Deleted)
+	assert.Equal(t, "<p>test test #1</p>\n", c.Text)
+
+	body, code = get(t, ts.URL+"/api/v1/last/10?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	last = []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &last))
+	assert.Equal(t, 2, len(last), "restored comment back in last comments, cache flushed")
+
+	// not deleted comment can't be restored
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	recs, err := srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42", Action: store.AuditRestoreComment})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(recs))
+}
+
+func TestAdmin_ReportSpam(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s/spam?site=remark42&url=https://radio-t.com/blah&spam=1", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code := getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	require.Equal(t, http.StatusOK, code, body)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.True(t, c.Spam, "spam mark visible to admin")
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	require.Equal(t, http.StatusOK, code, body)
+	c = store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.False(t, c.Spam, "spam mark hidden from others")
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/comment/bad-id/spam?site=remark42&url=https://radio-t.com/blah&spam=1", ts.URL), nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	recs, err := srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42", Action: store.AuditReportSpam})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(recs))
+}
+
+func TestAdmin_FailedNotifications(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/notifications/failed?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, "outbox disabled")
+	assert.Contains(t, body, "notification outbox disabled")
+
+	outboxFile := fmt.Sprintf("%s/notify-%d.db", os.TempDir(), time.Now().UnixNano())
+	defer os.Remove(outboxFile)
+	outbox, err := notify.NewBoltOutbox(outboxFile, bolt.Options{})
+	require.NoError(t, err)
+	dest := &failingDestination{}
+	srv.adminRest.notifyService = notify.NewOutboxService(srv.DataService, outbox,
+		notify.OutboxParams{MaxAttempts: 1, PollInterval: 10 * time.Millisecond}, dest)
+	defer srv.adminRest.notifyService.Close()
+
+	srv.adminRest.notifyService.SubmitVerification(notify.VerificationRequest{SiteID: "remark42", User: "user1", Token: "tkn"})
+	var recs []notify.OutboxRecord
+	require.Eventually(t, func() bool {
+		body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/notifications/failed?site=remark42")
+		recs = []notify.OutboxRecord{}
+		return code == http.StatusOK && json.Unmarshal([]byte(body), &recs) == nil && len(recs) == 1
+	}, time.Second, 20*time.Millisecond)
+	assert.Equal(t, "user1", recs[0].Verification.User)
+	assert.Equal(t, notify.DeliveryDead, recs[0].Deliveries[dest.String()].Status)
+	assert.Equal(t, "not available", recs[0].Deliveries[dest.String()].LastError)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notifications/resend?site=remark42&id="+recs[0].ID, nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	resp := R.JSON{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1.0, resp["count"])
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&dest.calls) == 2 }, time.Second, 10*time.Millisecond)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notifications/resend?site=remark42&id=bad", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	audits, err := srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42", Action: store.AuditResendNotifications})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(audits))
+	assert.Equal(t, recs[0].ID, audits[0].Target)
+}
+
+// failingDestination fails all sends
+type failingDestination struct {
+	calls int32
+}
+
+func (f *failingDestination) Send(context.Context, notify.Request) error {
+	atomic.AddInt32(&f.calls, 1)
+	return errors.New("not available")
+}
+
+func (f *failingDestination) SendVerification(context.Context, notify.VerificationRequest) error {
+	atomic.AddInt32(&f.calls, 1)
+	return errors.New("not available")
+}
+
+func (f *failingDestination) String() string { return "failing" }
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 
 	"github.com/umputun/remark42/backend/app/migrator"
 	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
 )
 
 // Migrator rest with import and export controllers
@@ -30,6 +32,7 @@ type Migrator struct {
 	NativeExporter    migrator.Exporter
 	URLMapperMaker    migrator.MapperMaker
 	KeyStore          KeyStore
+	AuditStore        AuditStore
 
 	busy map[string]bool
 	lock sync.Mutex
@@ -58,7 +61,9 @@ func (m *Migrator) importCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go m.runImport(siteID, r.URL.Query().Get("provider"), tmpfile) // import runs in background and sets busy flag for site
+	provider := r.URL.Query().Get("provider")
+	rec := newAuditRecord(r, siteID, store.AuditImport, "", map[string]string{"provider": provider})
+	go m.runImport(siteID, provider, tmpfile, rec) // import runs in background and sets busy flag for site
 
 	render.Status(r, http.StatusAccepted)
 	render.JSON(w, r, R.JSON{"status": "import request accepted"})
@@ -93,7 +98,9 @@ func (m *Migrator) importFormCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go m.runImport(siteID, r.URL.Query().Get("provider"), tmpfile) // import runs in background and sets busy flag for site
+	provider := r.URL.Query().Get("provider")
+	rec := newAuditRecord(r, siteID, store.AuditImport, "", map[string]string{"provider": provider})
+	go m.runImport(siteID, provider, tmpfile, rec) // import runs in background and sets busy flag for site
 
 	render.Status(r, http.StatusAccepted)
 	render.JSON(w, r, R.JSON{"status": "import request accepted"})
@@ -167,6 +174,7 @@ func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	rec := newAuditRecord(r, siteID, store.AuditRemap, "", nil)
 
 	// start remap procedure with mapper
 	go func() {
@@ -204,6 +212,8 @@ func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 
 		m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+		rec.Params = map[string]string{"comments": strconv.Itoa(size)}
+		saveAudit(m.AuditStore, rec)
 		log.Printf("[DEBUG] convert request completed. site=%s, comments=%d", siteID, size)
 	}()
 
@@ -211,8 +221,8 @@ func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"status": "convert request accepted"})
 }
 
-// runImport reads from tmpfile and import for given siteID and provider
-func (m *Migrator) runImport(siteID, provider, tmpfile string) {
+// runImport reads from tmpfile and import for given siteID and provider, audit record saved on success
+func (m *Migrator) runImport(siteID, provider, tmpfile string, rec store.AuditRecord) {
 	m.setBusy(siteID, true)
 
 	defer func() {
@@ -245,6 +255,8 @@ func (m *Migrator) runImport(siteID, provider, tmpfile string) {
 		return
 	}
 	m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	rec.Params["comments"] = strconv.Itoa(size)
+	saveAudit(m.AuditStore, rec)
 	log.Printf("[DEBUG] import request completed. site=%s, provider=%s, comments=%d", siteID, provider, size)
 }
 
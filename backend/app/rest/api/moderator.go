@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
+)
+
+// Moderator applies moderation actions of admin notification buttons, in telegram and slack.
+// Users of messengers mapped to remark42 users by notify destinations, and allowed to act
+// only if the user is admin of the comment's site.
+type Moderator struct {
+	DataService   *service.DataStore
+	Cache         LoadingCache
+	NotifyService *notify.Service // optional
+	StreamBroker  *stream.Broker  // optional
+}
+
+const moderationBlockDuration = 24 * time.Hour
+
+// Moderate applies the action on behalf of admin, implements notify.Moderator
+func (m *Moderator) Moderate(userID string, act notify.ModerationAction) (string, error) {
+	if !m.DataService.IsAdmin(act.Locator.SiteID, userID) {
+		return "", errors.Errorf("user %s is not admin of %s", userID, act.Locator.SiteID)
+	}
+	locator := act.Locator
+	comment, err := m.DataService.Get(locator, act.CommentID, store.User{ID: userID, Admin: true})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get comment %s", act.CommentID)
+	}
+
+	rec := store.AuditRecord{SiteID: locator.SiteID, Actor: userID, Params: map[string]string{"via": act.Via}}
+	var res string
+	switch act.Action {
+	case notify.ModerationDelete:
+		if err = m.DataService.Delete(locator, comment.ID, store.SoftDelete); err != nil {
+			return "", errors.Wrap(err, "can't delete comment")
+		}
+		rec.Action, rec.Target, rec.Params["url"] = store.AuditDeleteComment, comment.ID, locator.URL
+		m.Cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+		publishEvent(m.StreamBroker, m.DataService, stream.EvDeleted, locator, comment.ID)
+		res = "comment deleted"
+	case notify.ModerationBlockDay, notify.ModerationBlockForever:
+		ttl := time.Duration(0) // unlimited
+		if act.Action == notify.ModerationBlockDay {
+			ttl = moderationBlockDuration
+		}
+		if err = m.DataService.SetBlock(locator.SiteID, comment.User.ID, true, ttl); err != nil {
+			return "", errors.Wrap(err, "can't block user")
+		}
+		if ttl == 0 { // delete comments for permanently blocked user, same as with admin api
+			if e := m.DataService.DeleteUser(locator.SiteID, comment.User.ID, store.SoftDelete); e != nil {
+				log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", comment.User.ID, locator.SiteID, e)
+			}
+		}
+		rec.Action, rec.Target, rec.Params["ttl"] = store.AuditBlock, comment.User.ID, ttl.String()
+		m.Cache.Flush(cache.Flusher(locator.SiteID).Scopes(comment.User.ID, locator.SiteID, lastCommentsScope))
+		res = fmt.Sprintf("user %s blocked", comment.User.Name)
+	case notify.ModerationPin:
+		if err = m.DataService.SetPin(locator, comment.ID, true); err != nil {
+			return "", errors.Wrap(err, "can't pin comment")
+		}
+		rec.Action, rec.Target, rec.Params["url"] = store.AuditPin, comment.ID, locator.URL
+		m.Cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+		publishEvent(m.StreamBroker, m.DataService, stream.EvPinned, locator, comment.ID)
+		res = "comment pinned"
+	case notify.ModerationReadOnly:
+		if err = m.DataService.SetReadOnly(locator, true); err != nil {
+			return "", errors.Wrap(err, "can't set read-only")
+		}
+		rec.Action, rec.Target = store.AuditReadOnly, locator.URL
+		m.Cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, locator.SiteID))
+		res = "post is read-only"
+	case notify.ModerationApprove:
+		approved, e := m.DataService.Approve(locator, comment.ID)
+		if e != nil {
+			return "", errors.Wrap(e, "can't approve comment")
+		}
+		rec.Action, rec.Target, rec.Params["url"] = store.AuditApprove, comment.ID, locator.URL
+		m.Cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, approved.User.ID))
+		if m.NotifyService != nil && !approved.Shadow {
+			m.NotifyService.Submit(notify.Request{Comment: approved, Approved: true})
+		}
+		publishEvent(m.StreamBroker, m.DataService, stream.EvCreated, locator, comment.ID)
+		res = "comment approved"
+	default:
+		return "", errors.Errorf("unknown action %q", act.Action)
+	}
+	saveAudit(m.DataService, rec)
+	return res, nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestModerator_Moderate(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	m := Moderator{DataService: srv.DataService, Cache: srv.Cache}
+
+	_, err := m.Moderate("dev", notify.ModerationAction{Action: notify.ModerationPin, Locator: locator, CommentID: id1})
+	assert.EqualError(t, err, "user dev is not admin of remark42")
+	_, err = m.Moderate("a1", notify.ModerationAction{Action: "bad", Locator: locator, CommentID: id1})
+	assert.EqualError(t, err, `unknown action "bad"`)
+
+	res, err := m.Moderate("a1", notify.ModerationAction{Via: "telegram", Action: notify.ModerationPin, Locator: locator, CommentID: id1})
+	require.NoError(t, err)
+	assert.Equal(t, "comment pinned", res)
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Pin)
+
+	res, err = m.Moderate("a1", notify.ModerationAction{Via: "telegram", Action: notify.ModerationDelete, Locator: locator, CommentID: id2})
+	require.NoError(t, err)
+	assert.Equal(t, "comment deleted", res)
+	c, err = srv.DataService.Get(locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+
+	require.NoError(t, srv.DataService.SetModerated(locator, true))
+	id3 := addComment(t, store.Comment{Text: "test test #3", Locator: locator}, ts)
+	c, err = srv.DataService.Get(locator, id3, store.User{Admin: true})
+	require.NoError(t, err)
+	require.True(t, c.Pending)
+	res, err = m.Moderate("a1", notify.ModerationAction{Via: "slack", Action: notify.ModerationApprove, Locator: locator, CommentID: id3})
+	require.NoError(t, err)
+	assert.Equal(t, "comment approved", res)
+	c, err = srv.DataService.Get(locator, id3, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+
+	res, err = m.Moderate("a1", notify.ModerationAction{Via: "telegram", Action: notify.ModerationReadOnly, Locator: locator, CommentID: id1})
+	require.NoError(t, err)
+	assert.Equal(t, "post is read-only", res)
+	assert.True(t, srv.DataService.IsReadOnly(locator))
+
+	res, err = m.Moderate("a1", notify.ModerationAction{Via: "telegram", Action: notify.ModerationBlockDay, Locator: locator, CommentID: id1})
+	require.NoError(t, err)
+	assert.Equal(t, "user developer one blocked", res)
+	assert.True(t, srv.DataService.IsBlocked("remark42", "dev"))
+
+	recs, err := srv.DataService.AuditLog(engine.AuditRequest{SiteID: "remark42", Actor: "a1"})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+	assert.Equal(t, store.AuditBlock, recs[0].Action)
+	assert.Equal(t, map[string]string{"via": "telegram", "ttl": "24h0m0s"}, recs[0].Params)
+	assert.Equal(t, store.AuditApprove, recs[2].Action)
+	assert.Equal(t, "slack", recs[2].Params["via"])
+}
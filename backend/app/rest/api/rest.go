@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
 	"github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
@@ -27,9 +29,12 @@ import (
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
+	"github.com/umputun/remark42/backend/app/rest/sso"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -45,17 +50,26 @@ type Rest struct {
 	Migrator         *Migrator
 	NotifyService    *notify.Service
 	ImageService     *image.Service
+	StreamBroker     *stream.Broker     // optional, real-time updates disabled if nil
+	EmailDigest      notify.DigestStore // optional, email digest modes disabled if nil
+	TelegramBot      TelegramBot        // optional, telegram webhook disabled if nil
+	SlackBot         SlackBot           // optional, slack interactivity disabled if nil
+	SiteProvisioner  SiteProvisioner    // optional, runtime sites disabled if nil
 
 	AnonVote        bool
 	WebRoot         string
 	RemarkURL       string
 	ReadOnlyAge     int
 	SharedSecret    string
+	ModeratorBlock  time.Duration // max block duration allowed for moderators
+	TelegramSecret  string        // secret of telegram webhook
+	SlackSecret     string        // signing secret of slack app, verifies interactivity requests
 	ScoreThresholds struct {
 		Low      int
 		Critical int
 	}
 	UpdateLimiter       float64
+	ReportThreshold     int // number of reports to notify admins about reported comment
 	EmailNotifications  bool
 	TelegramBotUsername string
 	EmojiEnabled        bool
@@ -248,14 +262,19 @@ func (s *Rest) routes() chi.Router {
 			ropen.Get("/config", s.configCtrl)
 			ropen.Get("/find", s.pubRest.findCommentsCtrl)
 			ropen.Get("/id/{id}", s.pubRest.commentByIDCtrl)
+			ropen.Get("/id/{id}/history", s.pubRest.commentHistoryCtrl)
 			ropen.Get("/comments", s.pubRest.findUserCommentsCtrl)
 			ropen.Get("/last/{limit}", s.pubRest.lastCommentsCtrl)
+			ropen.Get("/search", s.pubRest.searchCommentsCtrl)
 			ropen.Get("/count", s.pubRest.countCtrl)
 			ropen.Post("/counts", s.pubRest.countMultiCtrl)
 			ropen.Get("/list", s.pubRest.listCtrl)
 			ropen.Post("/preview", s.pubRest.previewCommentCtrl)
 			ropen.Get("/info", s.pubRest.infoCtrl)
 			ropen.Get("/img", s.ImageProxy.Handler)
+			ropen.Post("/telegram/webhook", s.telegramWebhookCtrl)
+			ropen.Post("/slack/interactive", s.slackInteractiveCtrl)
+			ropen.Post("/author", s.postAuthorClaimCtrl)
 
 			ropen.Route("/rss", func(rrss chi.Router) {
 				rrss.Get("/post", s.rssRest.postCommentsCtrl)
@@ -265,6 +284,13 @@ func (s *Rest) routes() chi.Router {
 
 		})
 
+		// streaming routes, long-living connections without timeout
+		rapi.Group(func(rstream chi.Router) {
+			rstream.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			rstream.Use(authMiddleware.Trace, middleware.NoCache)
+			rstream.Get("/stream", s.pubRest.streamCtrl)
+		})
+
 		// open routes, cached
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
@@ -282,30 +308,91 @@ func (s *Rest) routes() chi.Router {
 			rauth.Get("/userdata", s.privRest.userAllDataCtrl)
 		})
 
-		// admin routes, require auth and admin users only
+		// admin routes, require auth and admin, moderator or post author users only
 		rapi.Route("/admin", func(radmin chi.Router) {
 			radmin.Use(middleware.Timeout(30 * time.Second))
 			radmin.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
+			radmin.Use(s.apiTokenAuth(authMiddleware.Auth), matchSiteID)
 			radmin.Use(middleware.NoCache, logInfoWithBody)
 
-			radmin.Delete("/comment/{id}", s.adminRest.deleteCommentCtrl)
-			radmin.Put("/user/{userid}", s.adminRest.setBlockCtrl)
-			radmin.Delete("/user/{userid}", s.adminRest.deleteUserCtrl)
-			radmin.Get("/user/{userid}", s.adminRest.getUserInfoCtrl)
-			radmin.Get("/deleteme", s.adminRest.deleteMeRequestCtrl)
-			radmin.Put("/verify/{userid}", s.adminRest.setVerifyCtrl)
-			radmin.Put("/pin/{id}", s.adminRest.setPinCtrl)
-			radmin.Get("/blocked", s.adminRest.blockedUsersCtrl)
-			radmin.Put("/readonly", s.adminRest.setReadOnlyCtrl)
-			radmin.Put("/title/{id}", s.adminRest.setTitleCtrl)
-
-			// migrator
-			radmin.Get("/export", s.adminRest.migrator.exportCtrl)
-			radmin.Post("/import", s.adminRest.migrator.importCtrl)
-			radmin.Post("/import/form", s.adminRest.migrator.importFormCtrl)
-			radmin.Post("/remap", s.adminRest.migrator.remapCtrl)
-			radmin.Get("/wait", s.adminRest.migrator.waitCtrl)
+			// post routes, allowed for authors of the post from url param
+			radmin.Group(func(rpost chi.Router) {
+				rpost.Use(s.postAuthorOnly, tokenScope(store.TokenScopeModeration, store.TokenScopeRead))
+				rpost.Delete("/comment/{id}", s.adminRest.deleteCommentCtrl)
+				rpost.Put("/pin/{id}", s.adminRest.setPinCtrl)
+				rpost.Put("/readonly", s.adminRest.setReadOnlyCtrl)
+			})
+
+			// moderator routes
+			radmin.Group(func(rmod chi.Router) {
+				rmod.Use(s.moderatorOnly, tokenScope(store.TokenScopeModeration, store.TokenScopeRead))
+				rmod.Put("/comment/{id}/restore", s.adminRest.restoreCommentCtrl)
+				rmod.Put("/comment/{id}/spam", s.adminRest.reportSpamCtrl)
+				rmod.Put("/user/{userid}", s.adminRest.setBlockCtrl)
+				rmod.Get("/user/{userid}", s.adminRest.getUserInfoCtrl)
+				rmod.Get("/blocked", s.adminRest.blockedUsersCtrl)
+				rmod.Get("/search", s.adminRest.searchCommentsCtrl)
+				rmod.Get("/pending", s.adminRest.pendingCommentsCtrl)
+				rmod.Put("/pending/{id}", s.adminRest.approveCommentCtrl)
+				rmod.Delete("/pending/{id}", s.adminRest.rejectCommentCtrl)
+				rmod.Post("/pending/approve", s.adminRest.approveCommentsCtrl)
+				rmod.Post("/pending/reject", s.adminRest.rejectCommentsCtrl)
+				rmod.Get("/reports", s.adminRest.reportedCommentsCtrl)
+				rmod.Put("/reports/{id}/resolve", s.adminRest.resolveReportsCtrl)
+				rmod.Put("/reports/{id}/dismiss", s.adminRest.dismissReportsCtrl)
+				rmod.Put("/title/{id}", s.adminRest.setTitleCtrl)
+			})
+
+			// owner routes, not allowed for moderators and API tokens with moderation scope
+			radmin.Group(func(rowner chi.Router) {
+				rowner.Group(func(rmod chi.Router) {
+					rmod.Use(adminOnly(store.TokenScopeRead))
+					rmod.Put("/verify/{userid}", s.adminRest.setVerifyCtrl)
+					rmod.Put("/shadowban/{userid}", s.adminRest.setShadowBanCtrl)
+					rmod.Get("/shadowbanned", s.adminRest.shadowBannedUsersCtrl)
+					rmod.Put("/moderation", s.adminRest.setModeratedCtrl)
+					rmod.Put("/author/{userid}", s.adminRest.setPostAuthorCtrl)
+					rmod.Get("/authors", s.adminRest.postAuthorsCtrl)
+					rmod.Get("/audit", s.adminRest.auditLogCtrl)
+					rmod.Get("/notifications/failed", s.adminRest.failedNotificationsCtrl)
+					rmod.Put("/notifications/resend", s.adminRest.resendNotificationsCtrl)
+				})
+
+				// users removal allowed for unscoped tokens only
+				rowner.Group(func(rdel chi.Router) {
+					rdel.Use(adminOnly())
+					rdel.Delete("/user/{userid}", s.adminRest.deleteUserCtrl)
+					rdel.Get("/deleteme", s.adminRest.deleteMeRequestCtrl)
+				})
+
+				// API tokens can't manage tokens and moderators
+				rowner.Group(func(rtkn chi.Router) {
+					rtkn.Use(adminOnly(), rejectAPIToken)
+					rtkn.Post("/tokens", s.adminRest.createAPITokenCtrl)
+					rtkn.Get("/tokens", s.adminRest.apiTokensCtrl)
+					rtkn.Delete("/tokens/{id}", s.adminRest.revokeAPITokenCtrl)
+					rtkn.Put("/moderator/{userid}", s.adminRest.setModeratorCtrl)
+					rtkn.Get("/moderators", s.adminRest.moderatorsCtrl)
+				})
+
+				// migrator
+				rowner.Group(func(rmig chi.Router) {
+					rmig.Use(adminOnly(store.TokenScopeMigration))
+					rmig.Get("/export", s.adminRest.migrator.exportCtrl)
+					rmig.Post("/import", s.adminRest.migrator.importCtrl)
+					rmig.Post("/import/form", s.adminRest.migrator.importFormCtrl)
+					rmig.Post("/remap", s.adminRest.migrator.remapCtrl)
+					rmig.Get("/wait", s.adminRest.migrator.waitCtrl)
+				})
+			})
+
+			// runtime sites, allowed for admin with basic auth only
+			radmin.Group(func(rsites chi.Router) {
+				rsites.Use(basicAdminOnly)
+				rsites.Get("/sites", s.sitesCtrl)
+				rsites.Post("/sites/{id}", s.addSiteCtrl)
+				rsites.Delete("/sites/{id}", s.removeSiteCtrl)
+			})
 		})
 
 		// protected routes, throttled to 10/s by default, controlled by external UpdateLimiter param
@@ -318,14 +405,19 @@ func (s *Rest) routes() chi.Router {
 			rauth.Put("/comment/{id}", s.privRest.updateCommentCtrl)
 			rauth.Post("/comment", s.privRest.createCommentCtrl)
 			rauth.Put("/vote/{id}", s.privRest.voteCtrl)
+			rauth.With(rejectAnonUser).Post("/report/{id}", s.privRest.reportCtrl)
 			rauth.With(rejectAnonUser).Post("/deleteme", s.privRest.deleteMeCtrl)
 			rauth.With(rejectAnonUser).Get("/email", s.privRest.getEmailCtrl)
 			rauth.With(rejectAnonUser).Post("/email/subscribe", s.privRest.sendEmailConfirmationCtrl)
 			rauth.With(rejectAnonUser).Post("/email/confirm", s.privRest.setConfirmedEmailCtrl)
 			rauth.With(rejectAnonUser).Delete("/email", s.privRest.deleteEmailCtrl)
+			rauth.With(rejectAnonUser).Put("/email/digest", s.privRest.setEmailDigestCtrl)
 			rauth.With(rejectAnonUser).Post("/telegram/subscribe", s.privRest.sendTelegramConfirmationCtrl)
 			rauth.With(rejectAnonUser).Post("/telegram/confirm", s.privRest.setConfirmedTelegramCtrl)
 			rauth.With(rejectAnonUser).Delete("/telegram", s.privRest.deleteTelegramCtrl)
+			rauth.With(rejectAnonUser).Get("/subscriptions", s.privRest.getSubscriptionsCtrl)
+			rauth.With(rejectAnonUser).Put("/subscription", s.privRest.setSubscriptionCtrl)
+			rauth.With(rejectAnonUser).Delete("/subscription", s.privRest.deleteSubscriptionCtrl)
 		})
 
 		// protected routes, anonymous rejected
@@ -363,6 +455,7 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		commentFormatter: s.CommentFormatter,
 		readOnlyAge:      s.ReadOnlyAge,
 		webRoot:          s.WebRoot,
+		broker:           s.StreamBroker,
 	}
 
 	privGrp := private{
@@ -375,15 +468,21 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		notifyService:    s.NotifyService,
 		remarkURL:        s.RemarkURL,
 		anonVote:         s.AnonVote,
+		reportThreshold:  s.ReportThreshold,
 		templates:        templates.NewFS(),
+		broker:           s.StreamBroker,
+		emailDigest:      s.EmailDigest,
 	}
 
 	admGrp := admin{
-		dataService:   s.DataService,
-		migrator:      s.Migrator,
-		cache:         s.Cache,
-		authenticator: s.Authenticator,
-		readOnlyAge:   s.ReadOnlyAge,
+		dataService:    s.DataService,
+		migrator:       s.Migrator,
+		cache:          s.Cache,
+		authenticator:  s.Authenticator,
+		readOnlyAge:    s.ReadOnlyAge,
+		moderatorBlock: s.ModeratorBlock,
+		notifyService:  s.NotifyService,
+		broker:         s.StreamBroker,
 	}
 
 	rssGrp := rss{
@@ -403,12 +502,41 @@ func (s *Rest) updateLimiter() float64 {
 	return lmt
 }
 
+// POST /author?site=siteID - sets author of the post from token made by host site, expects {"token": "..."} in body.
+// Token signed with the secret of the site and has url and author claims
+func (s *Rest) postAuthorClaimCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	req := struct {
+		Token string `json:"token"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't decode author token", rest.ErrDecode)
+		return
+	}
+
+	claims, err := sso.ParseAuthor(s.DataService.AdminStore, siteID, req.Token)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "invalid author token", rest.ErrActionRejected)
+		return
+	}
+	locator := store.Locator{SiteID: siteID, URL: claims.URL}
+	if err = s.DataService.SetPostAuthor(locator, claims.Author, true); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set author of the post", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user": claims.Author, "locator": locator, "author": true})
+}
+
 // GET /config?site=siteID - returns configuration
 func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 
 	admins, _ := s.DataService.AdminStore.Admins(siteID)
 	emails, _ := s.DataService.AdminStore.Email(siteID)
+	moderators := []string{}
+	if mods, err := s.DataService.Moderators(siteID); err == nil {
+		moderators = mods
+	}
 
 	cnf := struct {
 		Version             string   `json:"version"`
@@ -416,6 +544,8 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		AdminEdit           bool     `json:"admin_edit"`
 		MaxCommentSize      int      `json:"max_comment_size"`
 		Admins              []string `json:"admins"`
+		Moderators          []string `json:"moderators"`
+		ModeratorBlock      int      `json:"moderator_block"`
 		AdminEmail          string   `json:"admin_email"`
 		Auth                []string `json:"auth_providers"`
 		AnonVote            bool     `json:"anon_vote"`
@@ -425,16 +555,20 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		ReadOnlyAge         int      `json:"readonly_age"`
 		MaxImageSize        int      `json:"max_image_size"`
 		EmailNotifications  bool     `json:"email_notifications"`
+		EmailDigest         bool     `json:"email_digest"`
 		TelegramBotUsername string   `json:"telegram_bot_username"`
 		EmojiEnabled        bool     `json:"emoji_enabled"`
 		SimpleView          bool     `json:"simple_view"`
 		SendJWTHeader       bool     `json:"send_jwt_header"`
+		PreModeration       bool     `json:"pre_moderation"`
 	}{
 		Version:             s.Version,
 		EditDuration:        int(s.DataService.EditDuration.Seconds()),
 		AdminEdit:           s.DataService.AdminEdits,
 		MaxCommentSize:      s.DataService.MaxCommentSize,
 		Admins:              admins,
+		Moderators:          moderators,
+		ModeratorBlock:      int(s.ModeratorBlock.Seconds()),
 		AdminEmail:          emails,
 		LowScore:            s.ScoreThresholds.Low,
 		CriticalScore:       s.ScoreThresholds.Critical,
@@ -442,15 +576,20 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		ReadOnlyAge:         s.ReadOnlyAge,
 		MaxImageSize:        s.ImageService.MaxSize,
 		EmailNotifications:  s.EmailNotifications,
+		EmailDigest:         s.EmailNotifications && s.EmailDigest != nil,
 		TelegramBotUsername: s.TelegramBotUsername,
 		EmojiEnabled:        s.EmojiEnabled,
 		AnonVote:            s.AnonVote,
 		SimpleView:          s.SimpleView,
 		SendJWTHeader:       s.SendJWTHeader,
+		PreModeration:       s.DataService.PreModeration,
 	}
 
 	cnf.Auth = []string{}
 	for _, ap := range s.Authenticator.Providers() {
+		if ap.Name() == sso.ProviderName {
+			continue // sso login initiated by host site, not an option for the user
+		}
 		cnf.Auth = append(cnf.Auth, ap.Name())
 	}
 
@@ -517,6 +656,18 @@ func filterComments(comments []store.Comment, fn func(c store.Comment) bool) []s
 	return filtered
 }
 
+// parseTimestamp converts unix timestamp in msec to time.Time, empty string results in zero time
+func parseTimestamp(ts string) (time.Time, error) {
+	if ts == "" {
+		return time.Time{}, nil
+	}
+	unixTS, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unixTS/1000, 1000000*(unixTS%1000)), nil
+}
+
 // URLKey gets url from request to use it as cache key
 // admins will have different keys in order to prevent leak of admin-only data to regular users
 func URLKey(r *http.Request) string {
@@ -528,6 +679,21 @@ func URLKey(r *http.Request) string {
 	return key
 }
 
+// isAdmin checks if request made by admin user
+func isAdmin(r *http.Request) bool {
+	user, err := rest.GetUserInfo(r)
+	return err == nil && user.Admin
+}
+
+// sharedUser returns user for results cached and shared between all non-admin users.
+// Identity of non-admin user dropped, otherwise user's own hidden comments (shadowed) would sneak into the shared results
+func sharedUser(r *http.Request) store.User {
+	if isAdmin(r) {
+		return rest.GetUserOrEmpty(r)
+	}
+	return store.User{}
+}
+
 // URLKeyWithUser gets url from request to use it as cache key and attaching user ID
 // admins will have different keys in order to prevent leak of admin-only data to regular users
 func URLKeyWithUser(r *http.Request) string {
@@ -561,6 +727,179 @@ func rejectAnonUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// rejectAPIToken is a middleware rejecting users authenticated with API token
+func rejectAPIToken(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := token.GetUserInfo(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if user.BoolAttr("api_token") {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// tokenScope is a middleware limiting users authenticated with API token to tokens with any of given scopes.
+// Read scope allows GET requests only. Tokens without scopes and regular users always allowed
+func tokenScope(scopes ...store.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, err := token.GetUserInfo(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !user.BoolAttr("api_token") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(user.SliceAttr("scopes")) == 0 || hasTokenScope(user, r, scopes) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "Access denied", http.StatusForbidden)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// hasTokenScope checks if user authenticated with API token has any of given scopes. Read scope allows GET requests only
+func hasTokenScope(user token.User, r *http.Request, scopes []store.TokenScope) bool {
+	if !user.BoolAttr("api_token") {
+		return false
+	}
+	tkn := store.APIToken{}
+	for _, scope := range user.SliceAttr("scopes") {
+		tkn.Scopes = append(tkn.Scopes, store.TokenScope(scope))
+	}
+	for _, scope := range scopes {
+		if scope == store.TokenScopeRead && r.Method != http.MethodGet {
+			continue
+		}
+		if tkn.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenAuth is a middleware accepting admin's API token passed with basic auth, site id as user name and token
+// as password. Requests without valid token passed to auth middleware as is
+func (s *Rest) apiTokenAuth(authenticate func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authHandler := authenticate(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			siteID, value, ok := r.BasicAuth()
+			if !ok || siteID == "admin" { // admin's basic auth checked by auth middleware
+				authHandler.ServeHTTP(w, r)
+				return
+			}
+
+			tkn, err := s.DataService.CheckAPIToken(siteID, value)
+			if err != nil {
+				log.Printf("[DEBUG] api token rejected for site %s, %v", siteID, err)
+				authHandler.ServeHTTP(w, r)
+				return
+			}
+
+			// unscoped token has all admin's rights, scoped one limited by its scopes and moderator's rights
+			user := token.User{ID: "token_" + tkn.ID, Name: tkn.Name, Audience: tkn.SiteID}
+			if len(tkn.Scopes) == 0 {
+				user.SetAdmin(true)
+			} else {
+				user.SetRole(string(adminstore.RoleModerator))
+			}
+			user.SetBoolAttr("api_token", true)
+			scopes := make([]string, 0, len(tkn.Scopes))
+			for _, scope := range tkn.Scopes {
+				scopes = append(scopes, string(scope))
+			}
+			user.SetSliceAttr("scopes", scopes)
+			next.ServeHTTP(w, token.SetUserInfo(r, user))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// adminOnly is a middleware allowing admins only, as well as users authenticated with API token with any of given
+// scopes. Unlike AdminOnly of auth middleware it doesn't authenticate request again, so users authenticated with
+// unscoped API token allowed as admins
+func adminOnly(scopes ...store.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, err := token.GetUserInfo(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !user.IsAdmin() && !hasTokenScope(user, r, scopes) {
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// moderatorOnly is a middleware allowing admins and moderators of the user's site only
+func (s *Rest) moderatorOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := rest.GetUserInfo(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !s.canModerate(r, user, "") {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// postAuthorOnly is a middleware allowing admins and moderators of the user's site, as well as authors of the post
+// from url param
+func (s *Rest) postAuthorOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := rest.GetUserInfo(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !s.canModerate(r, user, r.URL.Query().Get("url")) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// canModerate checks if user is admin or moderator of the site, or author of the post if url is set.
+// Users authenticated with scoped API token are moderators
+func (s *Rest) canModerate(r *http.Request, user store.User, url string) bool {
+	if user.Admin || s.DataService.Role(user.SiteID, user.ID) == adminstore.RoleModerator {
+		return true
+	}
+	if tu, err := token.GetUserInfo(r); err == nil && tu.BoolAttr("api_token") && tu.GetRole() == string(adminstore.RoleModerator) {
+		return true
+	}
+	return url != "" && s.DataService.IsPostAuthor(store.Locator{SiteID: user.SiteID, URL: url}, user.ID)
+}
+
 // matchSiteID is a middleware rejecting users with mismatch between site param and and User.SiteID
 func matchSiteID(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
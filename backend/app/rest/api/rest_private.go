@@ -25,9 +25,11 @@ import (
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -41,7 +43,15 @@ type private struct {
 	authenticator    *auth.Service
 	remarkURL        string
 	anonVote         bool
+	reportThreshold  int
 	templates        templates.FileReader
+	broker           *stream.Broker
+	emailDigest      digestModes
+}
+
+type digestModes interface {
+	Mode(siteID, userID string) (notify.DigestMode, error)
+	SetMode(siteID, userID string, mode notify.DigestMode) error
 }
 
 type privStore interface {
@@ -55,11 +65,20 @@ type privStore interface {
 	GetUserTelegram(siteID string, userID string) (string, error)
 	SetUserTelegram(siteID string, userID string, value string) (string, error)
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
+	Subscribe(locator store.Locator, userID string, mode store.SubscriptionMode) error
+	Unsubscribe(locator store.Locator, userID string) error
+	MuteThread(locator store.Locator, userID, commentID string) (threadID string, err error)
+	UnmuteThread(locator store.Locator, userID, threadID string) error
+	UserSubscriptions(siteID, userID string) ([]store.Subscription, error)
 	ValidateComment(c *store.Comment) error
 	IsVerified(siteID string, userID string) bool
+	Role(siteID, userID string) adminstore.Role
+	IsPostAuthor(locator store.Locator, userID string) bool
 	IsReadOnly(locator store.Locator) bool
 	IsBlocked(siteID string, userID string) bool
+	IsShadowBanned(siteID, userID string) bool
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
+	Report(locator store.Locator, commentID string, user store.User, reason store.ReportReason, text string) ([]store.Report, error)
 }
 
 // POST /comment - adds comment, resets all immutable fields
@@ -115,6 +134,10 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentRestrictWords)
 		return
 	}
+	if err == service.ErrSpamDetected {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentSpam)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save comment", rest.ErrInternal)
 		return
@@ -129,9 +152,13 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
 		Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
 
-	if s.notifyService != nil {
+	// pending comment notified to admins only, users notified after approval.
+	// Comments of shadow-banned users never notified
+	shadowed := !comment.User.Admin && s.dataService.IsShadowBanned(comment.Locator.SiteID, comment.User.ID)
+	if s.notifyService != nil && !shadowed {
 		s.notifyService.Submit(notify.Request{Comment: finalComment})
 	}
+	publishEvent(s.broker, s.dataService, stream.EvCreated, comment.Locator, id)
 
 	log.Printf("[DEBUG] created commend %+v", finalComment)
 
@@ -173,11 +200,13 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	editReq := service.EditRequest{
-		Text:    s.commentFormatter.FormatText(edit.Text),
-		Orig:    edit.Text,
-		Summary: edit.Summary,
-		Delete:  edit.Delete,
-		Admin:   user.Admin,
+		Text:     s.commentFormatter.FormatText(edit.Text),
+		Orig:     edit.Text,
+		Summary:  edit.Summary,
+		Delete:   edit.Delete,
+		Admin:    user.Admin,
+		EditorID: user.ID,
+		UserIP:   strings.Split(r.RemoteAddr, ":")[0],
 	}
 
 	res, err := s.dataService.EditComment(locator, id, editReq)
@@ -185,6 +214,10 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
 		return
 	}
+	if err == service.ErrSpamDetected {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentSpam)
+		return
+	}
 
 	if err != nil {
 		code := parseError(err, rest.ErrCommentRejected)
@@ -193,14 +226,27 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID))
+	if edit.Delete {
+		publishEvent(s.broker, s.dataService, stream.EvDeleted, locator, id)
+	} else {
+		publishEvent(s.broker, s.dataService, stream.EvEdited, locator, id)
+	}
 	render.JSON(w, r, res)
 }
 
-// GET /user?site=siteID - returns user info
+// GET /user?site=siteID&url=post-url - returns user info, with author role for authors of the post if url set
 func (s *private) userInfoCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
 	if siteID := r.URL.Query().Get("site"); siteID != "" {
 		user.Verified = s.dataService.IsVerified(siteID, user.ID)
+		user.Role = string(s.dataService.Role(siteID, user.ID))
+		if user.Admin {
+			user.Role = string(adminstore.RoleOwner) // admin by token, i.e. set by host site
+		}
+		locator := store.Locator{SiteID: siteID, URL: r.URL.Query().Get("url")}
+		if user.Role == string(adminstore.RoleNone) && s.dataService.IsPostAuthor(locator, user.ID) {
+			user.Role = string(adminstore.RoleAuthor)
+		}
 
 		email, err := s.dataService.GetUserEmail(siteID, user.ID)
 		if err != nil {
@@ -252,9 +298,52 @@ func (s *private) voteCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	publishEvent(s.broker, s.dataService, stream.EvVoted, locator, comment.ID)
 	render.JSON(w, r, R.JSON{"id": comment.ID, "score": comment.Score})
 }
 
+// POST /report/{id}?site=siteID&url=post-url - report comment as abusive, spam or off-topic
+// body is {"reason": "spam", "text": "optional details"}. Admins notified once number of reports reaches threshold
+func (s *private) reportCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	log.Printf("[DEBUG] report comment %s", id)
+
+	req := struct {
+		Reason store.ReportReason `json:"reason"`
+		Text   string             `json:"text"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind report", rest.ErrDecode)
+		return
+	}
+
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
+
+	reports, err := s.dataService.Report(locator, id, user, req.Reason, req.Text)
+	if err != nil {
+		if errors.Is(err, service.ErrAlreadyReported) {
+			rest.SendErrorJSON(w, r, http.StatusConflict, err, "comment already reported", rest.ErrReportDbl)
+			return
+		}
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't report comment", rest.ErrReportRejected)
+		return
+	}
+
+	if s.notifyService != nil && s.reportThreshold > 0 && len(reports) == s.reportThreshold {
+		if comment, e := s.dataService.Get(locator, id, store.User{Admin: true}); e == nil {
+			s.notifyService.Submit(notify.Request{Comment: comment, Reports: reports})
+		}
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, R.JSON{"id": id, "reports": len(reports)})
+}
+
 // getEmailCtrl gets email address for authenticated user.
 // GET /email?site=siteID
 func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
@@ -265,7 +354,36 @@ func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
 	}
 
-	render.JSON(w, r, R.JSON{"user": user, "address": address})
+	res := R.JSON{"user": user, "address": address}
+	if s.emailDigest != nil {
+		if res["digest"], err = s.emailDigest.Mode(siteID, user.ID); err != nil {
+			log.Printf("[WARN] can't read email digest mode for %s, %v", user.ID, err)
+		}
+	}
+	render.JSON(w, r, res)
+}
+
+// PUT /email/digest?site=siteID&mode=immediate|hourly|daily - sets how user gets email notifications
+func (s *private) setEmailDigestCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	mode := notify.DigestMode(r.URL.Query().Get("mode"))
+	if s.emailDigest == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("digest disabled"), "email digest is not enabled", rest.ErrActionRejected)
+		return
+	}
+	if !mode.Valid() {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			fmt.Errorf("unknown mode %q", mode), "mode should be immediate, hourly or daily", rest.ErrInternal)
+		return
+	}
+	log.Printf("[DEBUG] set email digest mode %s for user %s", mode, user.ID)
+
+	if err := s.emailDigest.SetMode(siteID, user.ID, mode); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set email digest mode", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"updated": true, "digest": mode})
 }
 
 // sendEmailConfirmationCtrl gets address and siteID from query, makes confirmation token and sends it to user.
@@ -462,7 +580,8 @@ func (s *private) setConfirmedTelegramCtrl(w http.ResponseWriter, r *http.Reques
 	render.JSON(w, r, R.JSON{"updated": true, "address": val})
 }
 
-// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt - unsubscribe the user in token from email notifications
+// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt[&url=post-url] - unsubscribe the user in token from email notifications,
+// or from the single post if url set
 func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 	tkn := r.URL.Query().Get("tkn")
 	if tkn == "" {
@@ -512,6 +631,17 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if postURL := r.URL.Query().Get("url"); postURL != "" {
+		// unsubscribe from the single post only, email stays in place
+		log.Printf("[DEBUG] mute post %s for user %s", postURL, userID)
+		if err = s.dataService.Subscribe(store.Locator{SiteID: siteID, URL: postURL}, userID, store.SubscriptionMute); err != nil {
+			rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't unsubscribe user from post", rest.ErrInternal, s.templates)
+			return
+		}
+		s.renderUnsubscribe(w, r)
+		return
+	}
+
 	log.Printf("[DEBUG] unsubscribe user %s", userID)
 
 	if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
@@ -531,6 +661,11 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	s.renderUnsubscribe(w, r)
+}
+
+// renderUnsubscribe shows unsubscribe confirmation page
+func (s *private) renderUnsubscribe(w http.ResponseWriter, r *http.Request) {
 
 	// MustExecute behaves like template.Execute, but panics if an error occurs.
 	MustExecute := func(tmpl *template.Template, wr io.Writer, data interface{}) {
@@ -593,6 +728,86 @@ func (s *private) deleteTelegramCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"deleted": true})
 }
 
+// GET /subscriptions?site=siteID - returns post subscriptions, muted posts and muted threads of the user
+func (s *private) getSubscriptionsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	subs, err := s.dataService.UserSubscriptions(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get subscriptions", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, subs)
+}
+
+// PUT /subscription?site=siteID&url=post-url&mode=post|mute[&id=comment-id] - subscribes user to all comments
+// of the post or mutes it. With id set mutes the thread of the comment only, response has root comment as thread_id
+func (s *private) setSubscriptionCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	mode := store.SubscriptionMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = store.SubscriptionPost
+	}
+	if locator.URL == "" || !mode.Valid() {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			errors.New("bad parameters"), "url and mode post or mute are required", rest.ErrInternal)
+		return
+	}
+
+	if commentID := r.URL.Query().Get("id"); commentID != "" {
+		if mode != store.SubscriptionMute {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest,
+				errors.New("bad parameters"), "thread can be muted only", rest.ErrInternal)
+			return
+		}
+		log.Printf("[DEBUG] mute thread of %s for user %s to %+v", commentID, user.ID, locator)
+		threadID, err := s.dataService.MuteThread(locator, user.ID, commentID)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't mute thread", rest.ErrCommentNotFound)
+			return
+		}
+		render.JSON(w, r, R.JSON{"url": locator.URL, "mode": mode, "thread_id": threadID})
+		return
+	}
+	log.Printf("[DEBUG] set subscription %s for user %s to %+v", mode, user.ID, locator)
+
+	if err := s.dataService.Subscribe(locator, user.ID, mode); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set subscription", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"url": locator.URL, "mode": mode})
+}
+
+// DELETE /subscription?site=siteID&url=post-url[&thread=thread-id] - removes user's subscription or mute of the post,
+// or mute of the thread if set
+func (s *private) deleteSubscriptionCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "url parameter is required", rest.ErrInternal)
+		return
+	}
+
+	if threadID := r.URL.Query().Get("thread"); threadID != "" {
+		log.Printf("[DEBUG] unmute thread %s for user %s to %+v", threadID, user.ID, locator)
+		if err := s.dataService.UnmuteThread(locator, user.ID, threadID); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't unmute thread", rest.ErrInternal)
+			return
+		}
+		render.JSON(w, r, R.JSON{"deleted": true})
+		return
+	}
+	log.Printf("[DEBUG] remove subscription for user %s to %+v", user.ID, locator)
+
+	if err := s.dataService.Unsubscribe(locator, user.ID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete subscription", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"deleted": true})
+}
+
 // GET /userdata?site=siteID - exports all data about the user as a json with user info and list of all comments
 func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
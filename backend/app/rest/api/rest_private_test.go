@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -23,10 +24,13 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/service"
 )
 
 // gopher png for test, from https://golang.org/src/image/png/example_test.go
@@ -142,6 +146,44 @@ func TestRest_CreateWithRestrictedWord(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_CreateSpam(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.SpamChecker = spamCheckerFunc(func(req service.SpamRequest) (bool, error) {
+		return strings.Contains(req.Comment.Text, "viagra"), nil
+	})
+	srv.DataService.SpamAction = service.SpamReject
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "buy viagra", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	c := R.JSON{}
+	require.NoError(t, json.Unmarshal(b, &c))
+	assert.Equal(t, "comment detected as spam", c["error"])
+	assert.Equal(t, float64(rest.ErrCommentSpam), c["code"])
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "good comment", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+// spamCheckerFunc makes service.SpamChecker from check function, feedback ignored
+type spamCheckerFunc func(req service.SpamRequest) (bool, error)
+
+func (f spamCheckerFunc) Check(req service.SpamRequest) (bool, error) { return f(req) }
+
+func (f spamCheckerFunc) Feedback(service.SpamRequest, bool) error { return nil }
+
 func TestRest_CreateRejected(t *testing.T) {
 
 	ts, _, teardown := startupT(t)
@@ -573,6 +615,51 @@ func (fs *MockFS) ReadFile(path string) ([]byte, error) {
 	return []byte(fmt.Sprintf("template %s", path)), nil
 }
 
+func TestRest_Report(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator,
+		User: store.User{ID: "user1", Name: "user one"}})
+	require.NoError(t, err)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+	srv.privRest.reportThreshold = 1
+
+	report := func(id, body, tkn string) int {
+		req, e := http.NewRequest(http.MethodPost,
+			fmt.Sprintf("%s/api/v1/report/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), strings.NewReader(body))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusCreated, report(id1, `{"reason":"spam","text":"buy now"}`, devToken))
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 1, len(mockDestination.Get()), "admins notified on threshold")
+	assert.Equal(t, id1, mockDestination.Get()[0].Comment.ID)
+	require.Equal(t, 1, len(mockDestination.Get()[0].Reports))
+	assert.Equal(t, store.ReportSpam, mockDestination.Get()[0].Reports[0].Reason)
+
+	assert.Equal(t, http.StatusConflict, report(id1, `{"reason":"abusive"}`, devToken), "second report rejected")
+	assert.Equal(t, http.StatusCreated, report(id1, `{"reason":"off-topic"}`, adminUmputunToken))
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, 1, len(mockDestination.Get()), "no more notifications above threshold")
+
+	assert.Equal(t, http.StatusBadRequest, report(id2, `{"reason":"spam"}`, devToken), "own comment")
+	assert.Equal(t, http.StatusBadRequest, report(id1, `{"reason":"boring"}`, devToken), "bad reason")
+	assert.Equal(t, http.StatusBadRequest, report(id1, `{"reason":`, devToken), "bad body")
+	assert.Equal(t, http.StatusBadRequest, report("bad-id", `{"reason":"spam"}`, adminUmputunToken))
+	assert.Equal(t, http.StatusForbidden, report(id1, `{"reason":"spam"}`, anonToken), "anonymous can't report")
+	assert.Equal(t, http.StatusUnauthorized, report(id1, `{"reason":"spam"}`, ""))
+}
+
 func TestRest_EmailAndTelegram(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -656,6 +743,196 @@ func TestRest_EmailAndTelegram(t *testing.T) {
 	}
 }
 
+func TestRest_Subscriptions(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/subscriptions?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+
+	var testData = []struct {
+		description  string
+		url          string
+		method       string
+		responseCode int
+	}{
+		{description: "subscribe without url", url: "/api/v1/subscription?site=remark42", method: http.MethodPut, responseCode: http.StatusBadRequest},
+		{description: "subscribe with bad mode", url: "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1&mode=bad", method: http.MethodPut, responseCode: http.StatusBadRequest},
+		{description: "subscribe to post", url: "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "mute post", url: "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah2&mode=mute", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "delete without url", url: "/api/v1/subscription?site=remark42", method: http.MethodDelete, responseCode: http.StatusBadRequest},
+		{description: "delete subscription", url: "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah3", method: http.MethodDelete, responseCode: http.StatusOK},
+	}
+	for _, x := range testData {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			req, err := http.NewRequest(x.method, ts.URL+x.url, nil)
+			require.NoError(t, err)
+			resp, err := sendReq(t, req, devToken)
+			require.NoError(t, err)
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.NoError(t, resp.Body.Close())
+			assert.Equal(t, x.responseCode, resp.StatusCode, string(b))
+		})
+	}
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/subscriptions?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	subs := []store.Subscription{}
+	require.NoError(t, json.Unmarshal([]byte(body), &subs))
+	require.Equal(t, 2, len(subs))
+	assert.Equal(t, "https://radio-t.com/blah1", subs[0].Locator.URL)
+	assert.Equal(t, store.SubscriptionPost, subs[0].Mode)
+	assert.Equal(t, "https://radio-t.com/blah2", subs[1].Locator.URL)
+	assert.Equal(t, store.SubscriptionMute, subs[1].Mode)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/subscriptions?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	subs = []store.Subscription{}
+	require.NoError(t, json.Unmarshal([]byte(body), &subs))
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, "https://radio-t.com/blah2", subs[0].Locator.URL)
+}
+
+func TestRest_MuteThread(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	rootID := addComment(t, store.Comment{Text: "root", Locator: loc}, ts)
+	replyID := addComment(t, store.Comment{Text: "reply", ParentID: rootID, Locator: loc}, ts)
+
+	send := func(method, u string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+u, nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+
+	body, code := send(http.MethodPut, "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1&id="+replyID)
+	assert.Equal(t, http.StatusBadRequest, code, "thread can't be subscribed")
+	body, code = send(http.MethodPut, "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1&mode=mute&id=bad")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	body, code = send(http.MethodPut, "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1&mode=mute&id="+replyID)
+	require.Equal(t, http.StatusOK, code, body)
+	res := struct {
+		ThreadID string `json:"thread_id"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, rootID, res.ThreadID, "muted by root comment")
+
+	subs, err := srv.DataService.UserSubscriptions("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, rootID, subs[0].ThreadID)
+	assert.Equal(t, store.SubscriptionMute, subs[0].Mode)
+
+	// post-level delete doesn't touch thread mute
+	body, code = send(http.MethodDelete, "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	subs, err = srv.DataService.UserSubscriptions("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+
+	body, code = send(http.MethodDelete, "/api/v1/subscription?site=remark42&url=https://radio-t.com/blah1&thread="+rootID)
+	require.Equal(t, http.StatusOK, code, body)
+	subs, err = srv.DataService.UserSubscriptions("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(subs))
+}
+
+func TestRest_EmailUnsubscribePost(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.templates = &MockFS{}
+
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "good@example.com")
+	require.NoError(t, err)
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: "dev::good@example.com"},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "remark42",
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+
+	body, code := get(t, fmt.Sprintf("%s/email/unsubscribe.html?site=remark42&tkn=%s&url=%s", ts.URL, tkn,
+		url.QueryEscape("https://radio-t.com/blah1")))
+	require.Equal(t, http.StatusOK, code, body)
+
+	email, err := srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "good@example.com", email, "email kept on post unsubscribe")
+
+	subs, err := srv.DataService.UserSubscriptions("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, "https://radio-t.com/blah1", subs[0].Locator.URL)
+	assert.Equal(t, store.SubscriptionMute, subs[0].Mode)
+}
+
+func TestRest_EmailDigest(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/digest?site=remark42&mode=daily", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "digest disabled")
+
+	digestFile := fmt.Sprintf("/tmp/digest-%d.db", time.Now().UnixNano())
+	digest, err := notify.NewBoltDigest(digestFile, bolt.Options{})
+	require.NoError(t, err)
+	defer os.Remove(digestFile)
+	defer digest.Close()
+	srv.privRest.emailDigest = digest
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/digest?site=remark42&mode=weekly", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown mode")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/digest?site=remark42&mode=hourly", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	mode, err := digest.Mode("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, notify.DigestHourly, mode)
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/email?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	res := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, "hourly", res["digest"])
+}
+
 func TestRest_EmailNotification(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -21,8 +21,10 @@ import (
 
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/stream"
 )
 
 type public struct {
@@ -32,6 +34,7 @@ type public struct {
 	commentFormatter *store.CommentFormatter
 	imageService     *image.Service
 	webRoot          string
+	broker           *stream.Broker
 }
 
 type pubStore interface {
@@ -41,6 +44,8 @@ type pubStore interface {
 	Last(siteID string, limit int, since time.Time, user store.User) ([]store.Comment, error)
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
 	UserCount(siteID, userID string) (int, error)
+	Search(req engine.SearchRequest, user store.User) ([]store.Comment, error)
+	History(locator store.Locator, commentID string, user store.User) ([]service.CommentVersion, error)
 	Count(locator store.Locator) (int, error)
 	List(siteID string, limit int, skip int) ([]store.PostInfo, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
@@ -187,12 +192,13 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(lastCommentsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Last(siteID, limit, sinceTime, rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Last(siteID, limit, sinceTime, sharedUser(r))
 		if e != nil {
 			return nil, e
 		}
 		// filter deleted from last comments view. Blocked marked as deleted and will sneak in without
-		filterDeleted := filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
+		// pending comments filtered for non-admins as the result shared between all users
+		filterDeleted := filterComments(comments, func(c store.Comment) bool { return !c.Deleted && (!c.Pending || isAdmin(r)) })
 		return encodeJSONWithHTML(filterDeleted)
 	})
 
@@ -206,6 +212,49 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /search?site=siteID&q=query&limit=20&skip=10 - full-text search of comments for the siteID, across all posts,
+// sorted by time, recent first. Deleted comments and comments of blocked users excluded.
+func (s *public) searchCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	query := r.URL.Query().Get("q")
+	log.Printf("[DEBUG] search comments for %s, query %q", siteID, query)
+
+	if len(engine.SearchTerms(query)) == 0 {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no search terms"), "can't search comments", rest.ErrDecode)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 0
+	}
+	skip, err := strconv.Atoi(r.URL.Query().Get("skip"))
+	if err != nil {
+		skip = 0
+	}
+
+	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, lastCommentsScope)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		req := engine.SearchRequest{Locator: store.Locator{SiteID: siteID}, Query: query, Limit: limit, Skip: skip}
+		comments, e := s.dataService.Search(req, sharedUser(r))
+		if e != nil {
+			return nil, e
+		}
+		// blocked marked as deleted by alterComments, filter them out together with deleted.
+		// pending comments filtered for non-admins as the result shared between all users
+		return encodeJSONWithHTML(filterComments(comments, func(c store.Comment) bool { return !c.Deleted && (!c.Pending || isAdmin(r)) }))
+	})
+
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't search comments", rest.ErrInternal)
+		return
+	}
+
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render search results for site %s", siteID)
+	}
+}
+
 // GET /id/{id}?site=siteID&url=post-url - gets a comment by id
 func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -227,6 +276,24 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /id/{id}/history?site=siteID&url=post-url - returns all versions of the comment with diffs between them.
+// Admins can see revisions of deleted comments
+func (s *public) commentHistoryCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[DEBUG] get history of comment %s, %+v", id, locator)
+
+	versions, err := s.dataService.History(locator, id, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment history", rest.ErrCommentNotFound)
+		return
+	}
+
+	if err = R.RenderJSONWithHTML(w, r, R.JSON{"id": id, "revisions": versions}); err != nil {
+		log.Printf("[WARN] can't render history for comment %s, %v", id, err)
+	}
+}
+
 // GET /comments?site=siteID&user=id - returns comments for given userID
 func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -414,13 +481,9 @@ func (s *public) applyView(comments []store.Comment, view string) []store.Commen
 }
 
 func (s *public) parseSince(r *http.Request) (time.Time, error) {
-	sinceTS := time.Time{}
-	if since := r.URL.Query().Get("since"); since != "" {
-		unixTS, e := strconv.ParseInt(since, 10, 64)
-		if e != nil {
-			return time.Time{}, errors.Wrap(e, "can't translate since parameter")
-		}
-		sinceTS = time.Unix(unixTS/1000, 1000000*(unixTS%1000)) // since param in msec timestamp
+	sinceTS, err := parseTimestamp(r.URL.Query().Get("since")) // since param in msec timestamp
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "can't translate since parameter")
 	}
 	return sinceTS, nil
 }
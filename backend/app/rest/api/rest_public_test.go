@@ -391,6 +391,69 @@ func TestRest_Last(t *testing.T) {
 	assert.Equal(t, 500, code)
 }
 
+func TestRest_Search(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	res, code := get(t, ts.URL+"/api/v1/search?site=remark42&q=test")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "[]\n", res, "empty search should return empty list")
+
+	c1 := store.Comment{Text: "first searchable comment", ParentID: "p1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	c2 := store.Comment{Text: "second searchable comment", ParentID: "p1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+	c3 := store.Comment{Text: "something else", ParentID: "p1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+
+	id1 := addComment(t, c1, ts)
+	time.Sleep(10 * time.Millisecond)
+	id2 := addComment(t, c2, ts)
+	addComment(t, c3, ts)
+
+	res, code = get(t, ts.URL+"/api/v1/search?site=remark42&q=Searchable")
+	assert.Equal(t, 200, code)
+	comments := []store.Comment{}
+	err := json.Unmarshal([]byte(res), &comments)
+	assert.NoError(t, err)
+	require.Equal(t, 2, len(comments), "should have 2 comments")
+	assert.Equal(t, id2, comments[0].ID)
+	assert.Equal(t, id1, comments[1].ID)
+
+	res, code = get(t, ts.URL+"/api/v1/search?site=remark42&q=searchable+first")
+	assert.Equal(t, 200, code)
+	comments = []store.Comment{}
+	err = json.Unmarshal([]byte(res), &comments)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(comments), "should have 1 comment")
+	assert.Equal(t, id1, comments[0].ID)
+
+	res, code = get(t, ts.URL+"/api/v1/search?site=remark42&q=searchable&limit=1&skip=1")
+	assert.Equal(t, 200, code)
+	comments = []store.Comment{}
+	err = json.Unmarshal([]byte(res), &comments)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(comments), "should have 1 comment")
+	assert.Equal(t, id1, comments[0].ID)
+
+	err = srv.DataService.Delete(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, id1, store.SoftDelete)
+	assert.NoError(t, err)
+	srv.Cache.Flush(cache.FlusherRequest{})
+	res, code = get(t, ts.URL+"/api/v1/search?site=remark42&q=searchable")
+	assert.Equal(t, 200, code)
+	comments = []store.Comment{}
+	err = json.Unmarshal([]byte(res), &comments)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(comments), "deleted comment excluded")
+	assert.Equal(t, id2, comments[0].ID)
+
+	_, code = get(t, ts.URL+"/api/v1/search?site=remark42&q=+")
+	assert.Equal(t, 400, code)
+
+	_, code = get(t, ts.URL+"/api/v1/search?site=remark42-BLAH&q=searchable")
+	assert.Equal(t, 500, code)
+}
+
 func TestRest_FindUserComments(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -431,6 +494,67 @@ func TestRest_FindUserComments(t *testing.T) {
 	assert.True(t, resp.Comments[1].Timestamp.After(resp.Comments[2].Timestamp))
 }
 
+func TestRest_CommentHistory(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id := addComment(t, c1, ts)
+
+	update := func(body string) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+			strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	update(`{"text":"updated text", "summary":"my edit"}`)
+
+	history := struct {
+		ID        string                   `json:"id"`
+		Revisions []service.CommentVersion `json:"revisions"`
+	}{}
+	body, code := get(t, ts.URL+"/api/v1/id/"+id+"/history?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	assert.Equal(t, id, history.ID)
+	require.Equal(t, 2, len(history.Revisions))
+	assert.Equal(t, "test test #1", history.Revisions[0].Orig)
+	assert.Equal(t, "dev", history.Revisions[0].EditorID)
+	assert.Equal(t, "updated text", history.Revisions[1].Orig)
+	assert.Equal(t, "my edit", history.Revisions[1].Summary)
+	assert.Equal(t, "--- revision 0\n+++ revision 1\n@@ -1 +1 @@\n-test test #1\n+updated text\n", history.Revisions[1].Diff)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, code = get(t, ts.URL+"/api/v1/id/"+id+"/history?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusBadRequest, code, "deleted comment history hidden from users")
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/id/"+id+"/history?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Equal(t, 2, len(history.Revisions), "admin sees revisions of deleted comment")
+	assert.Equal(t, "updated text", history.Revisions[1].Orig)
+
+	// comment deleted by the author keeps no previous versions
+	id = addComment(t, c1, ts)
+	update(`{"text":"updated text", "summary":"my edit"}`)
+	update(`{"delete": true}`)
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/id/"+id+"/history?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	assert.Equal(t, 0, len(history.Revisions), "content deleted by the author not kept")
+
+	_, code = get(t, ts.URL+"/api/v1/id/bad-id/history?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestRest_UserInfo(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
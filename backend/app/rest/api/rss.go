@@ -39,7 +39,7 @@ func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Find(locator, "-time", rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Find(locator, "-time", sharedUser(r))
 		if e != nil {
 			return nil, e
 		}
@@ -70,7 +70,7 @@ func (s *rss) siteCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, lastCommentsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Last(siteID, maxRssItems, time.Time{}, rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Last(siteID, maxRssItems, time.Time{}, sharedUser(r))
 		if e != nil {
 			return nil, e
 		}
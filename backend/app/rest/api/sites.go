@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
+)
+
+// SiteProvisioner adds, disables and removes sites at runtime, without restart
+type SiteProvisioner interface {
+	Sites() []adminstore.Site
+	AddSite(siteID string) (adminstore.Site, error) // add new site or enable disabled one
+	DisableSite(siteID string) error                // close the site, keep its definition and data
+	RemoveSite(siteID string, purge bool) error     // close the site and remove its definition, data removed with purge
+}
+
+// GET /admin/sites - list all sites, static and added at runtime
+func (s *Rest) sitesCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.SiteProvisioner == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not supported"), "runtime sites disabled", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, s.SiteProvisioner.Sites())
+}
+
+// POST /admin/sites/{id} - add new site or enable disabled one
+func (s *Rest) addSiteCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.SiteProvisioner == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not supported"), "runtime sites disabled", rest.ErrActionRejected)
+		return
+	}
+	siteID := chi.URLParam(r, "id")
+	site, err := s.SiteProvisioner.AddSite(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't add site", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] site %s added", siteID)
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, site)
+}
+
+// DELETE /admin/sites/{id}?disable=1&purge=1 - disable site, or remove it. Data of removed site kept unless purge set
+func (s *Rest) removeSiteCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.SiteProvisioner == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not supported"), "runtime sites disabled", rest.ErrActionRejected)
+		return
+	}
+	siteID := chi.URLParam(r, "id")
+	disable, purge := r.URL.Query().Get("disable") == "1", r.URL.Query().Get("purge") == "1"
+
+	if disable {
+		if err := s.SiteProvisioner.DisableSite(siteID); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't disable site", rest.ErrActionRejected)
+			return
+		}
+		log.Printf("[INFO] site %s disabled", siteID)
+		s.Cache.Flush(lcw.Flusher(siteID))
+		render.JSON(w, r, R.JSON{"site": siteID, "disabled": true})
+		return
+	}
+
+	if err := s.SiteProvisioner.RemoveSite(siteID, purge); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't remove site", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] site %s removed, purge=%v", siteID, purge)
+	s.Cache.Flush(lcw.Flusher(siteID))
+	render.JSON(w, r, R.JSON{"site": siteID, "removed": true, "purge": purge})
+}
+
+// basicAdminOnly is a middleware allowing admin authenticated with basic auth only, sites are not owned by
+// admins of any particular site
+func basicAdminOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := rest.GetUserInfo(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !user.Admin || user.Name != "admin" || user.ID != "admin" {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestRest_Sites(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/sites", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	resp, err := sendReq(t, req, adminUmputunToken) // site admin can't manage sites
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/sites")
+	assert.Equal(t, http.StatusBadRequest, code, "runtime sites disabled")
+
+	prov := &mockSiteProvisioner{sites: map[string]adminstore.Site{"remark42": {ID: "remark42", Enabled: true, Static: true}}}
+	srv.SiteProvisioner = prov
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/sites")
+	require.Equal(t, http.StatusOK, code, body)
+	sites := []adminstore.Site{}
+	require.NoError(t, json.Unmarshal([]byte(body), &sites))
+	assert.Equal(t, []adminstore.Site{{ID: "remark42", Enabled: true, Static: true}}, sites)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/sites/blog", "")
+	require.NoError(t, err)
+	site := adminstore.Site{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&site))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, adminstore.Site{ID: "blog", Enabled: true}, site)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/sites/blog", "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "already exists")
+
+	del := func(url string) (string, int) {
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		res := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		require.NoError(t, resp.Body.Close())
+		b, err := json.Marshal(res)
+		require.NoError(t, err)
+		return string(b), resp.StatusCode
+	}
+
+	body, code = del(ts.URL + "/api/v1/admin/sites/blog?disable=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"disabled":true,"site":"blog"}`, body)
+	assert.False(t, prov.sites["blog"].Enabled)
+
+	body, code = del(ts.URL + "/api/v1/admin/sites/blog?purge=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"purge":true,"removed":true,"site":"blog"}`, body)
+	assert.Equal(t, []string{"blog"}, prov.purged)
+	assert.Equal(t, 1, len(prov.Sites()))
+
+	_, code = del(ts.URL + "/api/v1/admin/sites/blog")
+	assert.Equal(t, http.StatusBadRequest, code, "not found")
+	_, code = del(ts.URL + "/api/v1/admin/sites/blog?disable=1")
+	assert.Equal(t, http.StatusBadRequest, code, "not found")
+}
+
+type mockSiteProvisioner struct {
+	lock   sync.Mutex
+	sites  map[string]adminstore.Site
+	purged []string
+}
+
+func (m *mockSiteProvisioner) Sites() []adminstore.Site {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := []adminstore.Site{}
+	for _, s := range m.sites {
+		res = append(res, s)
+	}
+	return res
+}
+
+func (m *mockSiteProvisioner) AddSite(siteID string) (adminstore.Site, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.sites[siteID]; ok {
+		return adminstore.Site{}, errors.Errorf("site %q already exists", siteID)
+	}
+	m.sites[siteID] = adminstore.Site{ID: siteID, Enabled: true}
+	return m.sites[siteID], nil
+}
+
+func (m *mockSiteProvisioner) DisableSite(siteID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	site, ok := m.sites[siteID]
+	if !ok {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	site.Enabled = false
+	m.sites[siteID] = site
+	return nil
+}
+
+func (m *mockSiteProvisioner) RemoveSite(siteID string, purge bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.sites[siteID]; !ok {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	delete(m.sites, siteID)
+	if purge {
+		m.purged = append(m.purged, siteID)
+	}
+	return nil
+}
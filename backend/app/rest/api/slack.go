@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/slack-go/slack"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// SlackBot handles interactions with slack messages, i.e. press of moderation button
+type SlackBot interface {
+	ProcessInteraction(ctx context.Context, cb slack.InteractionCallback)
+}
+
+// POST /slack/interactive - interactivity requests of slack app, authorized by signature of the request
+func (s *Rest) slackInteractiveCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.SlackBot == nil || s.SlackSecret == "" {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "slack interactivity disabled", rest.ErrActionRejected)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hardBodyLimit))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't read slack request", rest.ErrDecode)
+		return
+	}
+
+	sv, err := slack.NewSecretsVerifier(r.Header, s.SlackSecret)
+	if err == nil {
+		if _, err = sv.Write(body); err == nil {
+			err = sv.Ensure()
+		}
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "invalid slack signature", rest.ErrActionRejected)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse slack request", rest.ErrDecode)
+		return
+	}
+	cb := slack.InteractionCallback{}
+	if err = json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't decode slack payload", rest.ErrDecode)
+		return
+	}
+	s.SlackBot.ProcessInteraction(r.Context(), cb)
+	w.WriteHeader(http.StatusOK)
+}
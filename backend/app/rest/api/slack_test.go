@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRest_SlackInteractive(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(secret, body string) int {
+		req, err := http.NewRequest("POST", ts.URL+"/api/v1/slack/interactive", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			_, err = mac.Write([]byte("v0:" + timestamp + ":" + body))
+			require.NoError(t, err)
+			req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+			req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	payload := func(p string) string { return "payload=" + url.QueryEscape(p) }
+	action := payload(`{"type": "block_actions", "user": {"id": "U1"}, "response_url": "https://hooks.slack.com/actions/1",
+		"actions": [{"block_id": "moderation", "action_id": "del", "value": "remark42|c1|https://radio-t.com/blah"}]}`)
+
+	assert.Equal(t, http.StatusForbidden, send("secret", action), "interactivity disabled")
+
+	bot := &mockSlackBot{}
+	srv.SlackBot, srv.SlackSecret = bot, "secret"
+	assert.Equal(t, http.StatusForbidden, send("", action))
+	assert.Equal(t, http.StatusForbidden, send("bad", action))
+	assert.Equal(t, http.StatusBadRequest, send("secret", payload(`{"type": "block_actions"`)))
+	assert.Equal(t, 0, len(bot.callbacks))
+
+	assert.Equal(t, http.StatusOK, send("secret", action))
+	require.Equal(t, 1, len(bot.callbacks))
+	assert.Equal(t, slack.InteractionTypeBlockActions, bot.callbacks[0].Type)
+	assert.Equal(t, "U1", bot.callbacks[0].User.ID)
+	require.Equal(t, 1, len(bot.callbacks[0].ActionCallback.BlockActions))
+	assert.Equal(t, "del", bot.callbacks[0].ActionCallback.BlockActions[0].ActionID)
+	assert.Equal(t, "remark42|c1|https://radio-t.com/blah", bot.callbacks[0].ActionCallback.BlockActions[0].Value)
+}
+
+type mockSlackBot struct {
+	callbacks []slack.InteractionCallback
+}
+
+func (m *mockSlackBot) ProcessInteraction(_ context.Context, cb slack.InteractionCallback) {
+	m.callbacks = append(m.callbacks, cb)
+}
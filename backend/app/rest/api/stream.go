@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/stream"
+)
+
+const (
+	streamKeepAlive    = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+// comments embedded to other sites, same as cors allows any origin
+var wsUpgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+// commentGetter is a subset of store interfaces used to publish stream events
+type commentGetter interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+}
+
+// GET /stream?site=siteID&url=post-url - real-time updates of the post.
+// WebSocket for upgrade requests, server-sent events otherwise
+func (s *public) streamCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if s.broker == nil {
+		rest.SendErrorJSON(w, r, http.StatusServiceUnavailable, errors.New("streaming disabled"), "can't stream", rest.ErrInternal)
+		return
+	}
+	if locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing url"), "can't stream", rest.ErrPostNotFound)
+		return
+	}
+
+	events, cancel := s.broker.Subscribe(locator)
+	defer cancel()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamWebSocket(w, r, events)
+		return
+	}
+	s.streamSSE(w, r, events)
+}
+
+// streamSSE sends events as server-sent events till client disconnected
+func (s *public) streamSSE(w http.ResponseWriter, r *http.Request, events <-chan stream.Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, errors.New("streaming unsupported"), "can't stream", rest.ErrInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering by nginx
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("[WARN] can't marshal stream event, %v", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// streamWebSocket sends events as json messages till client disconnected. Incoming messages ignored
+func (s *public) streamWebSocket(w http.ResponseWriter, r *http.Request, events <-chan stream.Event) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[WARN] can't upgrade to websocket, %v", err)
+		return
+	}
+	defer conn.Close() //nolint
+
+	done := make(chan struct{})
+	go func() { // read till close or error, required to handle control messages
+		defer close(done)
+		for {
+			if _, _, e := conn.ReadMessage(); e != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case ev := <-events:
+			if err = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+			if err = conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publishEvent sends stream event with public view of the comment.
+// Comments hidden from public (pending, shadow-banned) not published
+func publishEvent(broker *stream.Broker, ds commentGetter, evType stream.EventType, locator store.Locator, commentID string) {
+	if broker == nil {
+		return
+	}
+	comment, err := ds.Get(locator, commentID, store.User{})
+	if err != nil {
+		log.Printf("[DEBUG] stream event %s for %s skipped, %v", evType, commentID, err)
+		return
+	}
+	broker.Publish(stream.Event{Type: evType, Locator: locator, CommentID: commentID, Comment: &comment})
+}
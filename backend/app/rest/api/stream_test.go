@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/stream"
+)
+
+func TestStream_SSE(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/stream?site=remark42&url=https://radio-t.com/blah1", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, 1, srv.StreamBroker.Subscribers())
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1 := addComment(t, store.Comment{Text: "test 123", Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "other post", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}, ts)
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/pin/%s?site=remark42&url=https://radio-t.com/blah1&pin=1", ts.URL, id1), nil)
+	require.NoError(t, err)
+	pinResp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, pinResp.Body.Close())
+	require.Equal(t, http.StatusOK, pinResp.StatusCode)
+
+	scanner := bufio.NewScanner(resp.Body)
+	events := []stream.Event{}
+	for len(events) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		ev := stream.Event{}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+		events = append(events, ev)
+	}
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, stream.EvCreated, events[0].Type)
+	assert.Equal(t, id1, events[0].CommentID)
+	assert.Equal(t, "<p>test 123</p>\n", events[0].Comment.Text)
+	assert.Equal(t, "", events[0].Comment.User.IP, "public view of the comment")
+	assert.Equal(t, stream.EvPinned, events[1].Type)
+	assert.True(t, events[1].Comment.Pin)
+}
+
+func TestStream_WebSocket(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/stream?site=remark42&url=https://radio-t.com/blah1"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close() //nolint
+	require.NoError(t, resp.Body.Close())
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1 := addComment(t, store.Comment{Text: "test 123", Locator: locator}, ts)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/vote/%s?site=remark42&url=https://radio-t.com/blah1&vote=1", ts.URL, id1), nil)
+	require.NoError(t, err)
+	voteResp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, voteResp.Body.Close())
+	require.Equal(t, http.StatusOK, voteResp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah1", ts.URL, id1), nil)
+	require.NoError(t, err)
+	delResp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, delResp.Body.Close())
+	require.Equal(t, http.StatusOK, delResp.StatusCode)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for _, expected := range []stream.EventType{stream.EvCreated, stream.EvVoted, stream.EvDeleted} {
+		ev := stream.Event{}
+		require.NoError(t, conn.ReadJSON(&ev))
+		assert.Equal(t, expected, ev.Type)
+		assert.Equal(t, id1, ev.CommentID)
+		if expected == stream.EvVoted {
+			assert.Equal(t, 1, ev.Comment.Score)
+		}
+		if expected == stream.EvDeleted {
+			assert.True(t, ev.Comment.Deleted)
+		}
+	}
+}
+
+func TestStream_Hidden(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	require.NoError(t, srv.DataService.SetShadowBan("remark42", "dev", true))
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	events, cancel := srv.StreamBroker.Subscribe(locator)
+	defer cancel()
+
+	addComment(t, store.Comment{Text: "test 123", Locator: locator}, ts)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStream_NoURL(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	body, code := get(t, ts.URL+"/api/v1/stream?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+}
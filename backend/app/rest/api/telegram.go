@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// TelegramBot handles updates of telegram bot, received with webhook
+type TelegramBot interface {
+	ProcessUpdate(ctx context.Context, upd notify.TelegramUpdate)
+}
+
+// POST /telegram/webhook - updates of telegram bot, authorized by X-Telegram-Bot-Api-Secret-Token header
+func (s *Rest) telegramWebhookCtrl(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if s.TelegramBot == nil || s.TelegramSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(s.TelegramSecret)) != 1 {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "invalid telegram secret", rest.ErrActionRejected)
+		return
+	}
+	upd := notify.TelegramUpdate{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &upd); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't decode telegram update", rest.ErrDecode)
+		return
+	}
+	s.TelegramBot.ProcessUpdate(r.Context(), upd)
+	render.JSON(w, r, R.JSON{"ok": true})
+}
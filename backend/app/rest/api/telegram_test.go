@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+)
+
+func TestRest_TelegramWebhook(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(secret, body string) int {
+		req, err := http.NewRequest("POST", ts.URL+"/api/v1/telegram/webhook", strings.NewReader(body))
+		require.NoError(t, err)
+		if secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusForbidden, send("secret", `{"update_id": 1}`), "webhook disabled")
+
+	bot := &mockTelegramBot{}
+	srv.TelegramBot, srv.TelegramSecret = bot, "secret"
+	assert.Equal(t, http.StatusForbidden, send("", `{"update_id": 1}`))
+	assert.Equal(t, http.StatusForbidden, send("bad", `{"update_id": 1}`))
+	assert.Equal(t, http.StatusBadRequest, send("secret", `{"update_id": 1`))
+	assert.Equal(t, 0, len(bot.updates))
+
+	assert.Equal(t, http.StatusOK, send("secret", `{"update_id": 1, "callback_query": {"id": "cb1", "data": "del|remark42|c1"}}`))
+	require.Equal(t, 1, len(bot.updates))
+	assert.Equal(t, int64(1), bot.updates[0].UpdateID)
+	assert.Equal(t, "del|remark42|c1", bot.updates[0].CallbackQuery.Data)
+}
+
+type mockTelegramBot struct {
+	updates []notify.TelegramUpdate
+}
+
+func (m *mockTelegramBot) ProcessUpdate(_ context.Context, upd notify.TelegramUpdate) {
+	m.updates = append(m.updates, upd)
+}
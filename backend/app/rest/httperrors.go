@@ -40,6 +40,9 @@ const (
 	ErrAssetNotFound        = 18 // requested file not found
 	ErrCommentRestrictWords = 19 // restricted words in a comment
 	ErrImgNotFound          = 20 // posted image not found in the storage
+	ErrReportRejected       = 21 // general error on rejected report
+	ErrReportDbl            = 22 // already reported the comment
+	ErrCommentSpam          = 23 // comment rejected as spam
 )
 
 // errTmplData store data for error message
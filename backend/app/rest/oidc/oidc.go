@@ -0,0 +1,170 @@
+// Package oidc implements generic OpenID Connect and custom OAuth2 auth providers, defined in config file
+package oidc
+
+import (
+	"crypto/sha1" // nolint
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v3"
+)
+
+// Provider defines generic OpenID Connect or OAuth2 provider. Endpoints discovered with issuer url,
+// endpoints set explicitly override discovered ones and used for OAuth2 providers without discovery.
+type Provider struct {
+	Name     string   `yaml:"name"`      // used in auth routes and as prefix of user id
+	Issuer   string   `yaml:"issuer"`    // issuer url with /.well-known/openid-configuration
+	AuthURL  string   `yaml:"auth_url"`  // authorization endpoint
+	TokenURL string   `yaml:"token_url"` // token endpoint
+	InfoURL  string   `yaml:"info_url"`  // user info endpoint
+	CID      string   `yaml:"cid"`
+	CSecret  string   `yaml:"csecret"`
+	Scopes   []string `yaml:"scopes"`
+	Claims   Claims   `yaml:"claims"`
+}
+
+// Claims defines fields of user info used as user id, name and picture
+type Claims struct {
+	ID      string `yaml:"id"`
+	Name    string `yaml:"name"`
+	Picture string `yaml:"picture"`
+}
+
+// names of providers added by remark42 itself
+var reservedNames = map[string]bool{"google": true, "github": true, "facebook": true, "microsoft": true,
+	"yandex": true, "twitter": true, "battlenet": true, "dev": true, "email": true, "anonymous": true, "sso": true}
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+const discoveryTimeout = 10 * time.Second
+
+// LoadProviders reads providers from yaml or json file, checks them and sets defaults
+func LoadProviders(fileName string) ([]Provider, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read providers file %s", fileName)
+	}
+	res := struct {
+		Providers []Provider `yaml:"providers"`
+	}{}
+	if err = yaml.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrapf(err, "can't parse providers file %s", fileName)
+	}
+
+	names := map[string]bool{}
+	for i := range res.Providers {
+		p := &res.Providers[i]
+		if !validName.MatchString(p.Name) || reservedNames[p.Name] || names[p.Name] {
+			return nil, errors.Errorf("invalid provider name %q, should be unique, lowercase and not one of built-in providers", p.Name)
+		}
+		names[p.Name] = true
+		if p.CID == "" || p.CSecret == "" {
+			return nil, errors.Errorf("client id and secret required for provider %s", p.Name)
+		}
+		if p.Issuer == "" && (p.AuthURL == "" || p.TokenURL == "" || p.InfoURL == "") {
+			return nil, errors.Errorf("issuer or auth, token and info urls required for provider %s", p.Name)
+		}
+		if len(p.Scopes) == 0 && p.Issuer != "" {
+			p.Scopes = []string{"openid", "profile", "email"}
+		}
+		if p.Claims.ID == "" {
+			p.Claims.ID = "sub"
+		}
+		if p.Claims.Name == "" {
+			p.Claims.Name = "name"
+		}
+		if p.Claims.Picture == "" {
+			p.Claims.Picture = "picture"
+		}
+	}
+	return res.Providers, nil
+}
+
+// HandlerOpt makes options of custom auth provider, discovers endpoints not set explicitly
+func (p Provider) HandlerOpt(client *http.Client) (provider.CustomHandlerOpt, error) {
+	if p.Issuer != "" && (p.AuthURL == "" || p.TokenURL == "" || p.InfoURL == "") {
+		if err := p.discover(client); err != nil {
+			return provider.CustomHandlerOpt{}, errors.Wrapf(err, "discovery failed for provider %s", p.Name)
+		}
+	}
+	log.Printf("[DEBUG] provider %s, auth %s, token %s, info %s", p.Name, p.AuthURL, p.TokenURL, p.InfoURL)
+	return provider.CustomHandlerOpt{
+		Endpoint:  oauth2.Endpoint{AuthURL: p.AuthURL, TokenURL: p.TokenURL},
+		InfoURL:   p.InfoURL,
+		Scopes:    p.Scopes,
+		MapUserFn: p.mapUser,
+	}, nil
+}
+
+// discover sets empty endpoints from openid configuration of the issuer
+func (p *Provider) discover(client *http.Client) error {
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	if client == nil {
+		client = &http.Client{Timeout: discoveryTimeout}
+	}
+	resp, err := client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return errors.Wrap(err, "can't get openid configuration")
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected openid configuration status %d", resp.StatusCode)
+	}
+
+	conf := struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		InfoURL  string `json:"userinfo_endpoint"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return errors.Wrap(err, "can't decode openid configuration")
+	}
+	if strings.TrimSuffix(conf.Issuer, "/") != issuer {
+		return errors.Errorf("issuer %q of openid configuration doesn't match %q", conf.Issuer, p.Issuer)
+	}
+
+	set := func(val *string, discovered string) {
+		if *val == "" {
+			*val = discovered
+		}
+	}
+	set(&p.AuthURL, conf.AuthURL)
+	set(&p.TokenURL, conf.TokenURL)
+	set(&p.InfoURL, conf.InfoURL)
+	if p.AuthURL == "" || p.TokenURL == "" || p.InfoURL == "" {
+		return errors.New("no auth, token or info endpoint in openid configuration")
+	}
+	return nil
+}
+
+// mapUser makes user from user info. User without id claim gets empty id and rejected by token validator.
+func (p Provider) mapUser(data provider.UserData, _ []byte) token.User {
+	id := data.Value(p.Claims.ID)
+	if id == "" {
+		log.Printf("[WARN] no %s claim in user info of provider %s", p.Claims.ID, p.Name)
+		return token.User{}
+	}
+	u := token.User{
+		// encode id with provider name to avoid collision if same id returned by other provider
+		ID:      p.Name + "_" + token.HashID(sha1.New(), id),
+		Name:    data.Value(p.Claims.Name),
+		Picture: data.Value(p.Claims.Picture),
+	}
+	if u.Name == "" {
+		u.Name = data.Value("preferred_username")
+	}
+	if u.Name == "" {
+		u.Name = "noname_" + token.HashID(sha1.New(), id)[:4]
+	}
+	return u
+}
@@ -0,0 +1,221 @@
+package oidc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oidc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(data string) string {
+		fileName := path.Join(dir, "providers.yml")
+		require.NoError(t, ioutil.WriteFile(fileName, []byte(data), 0600))
+		return fileName
+	}
+
+	res, err := LoadProviders(write(`
+providers:
+  - name: keycloak
+    issuer: https://sso.example.com/realms/blog
+    cid: cid1
+    csecret: secret1
+  - name: custom
+    auth_url: https://oauth.example.com/authorize
+    token_url: https://oauth.example.com/token
+    info_url: https://oauth.example.com/user
+    cid: cid2
+    csecret: secret2
+    scopes: [profile]
+    claims: {id: login, name: display_name, picture: avatar_url}
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, Provider{Name: "keycloak", Issuer: "https://sso.example.com/realms/blog", CID: "cid1", CSecret: "secret1",
+		Scopes: []string{"openid", "profile", "email"}, Claims: Claims{ID: "sub", Name: "name", Picture: "picture"}}, res[0])
+	assert.Equal(t, []string{"profile"}, res[1].Scopes)
+	assert.Equal(t, Claims{ID: "login", Name: "display_name", Picture: "avatar_url"}, res[1].Claims)
+
+	res, err = LoadProviders(write(`{"providers": [{"name": "authentik", "issuer": "https://auth.example.com", "cid": "c", "csecret": "s"}]}`))
+	require.NoError(t, err, "json is fine too")
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "authentik", res[0].Name)
+
+	tbl := []struct {
+		data, err string
+	}{
+		{`{"providers": [{"name": "github", "issuer": "https://a.example.com", "cid": "c", "csecret": "s"}]}`, `invalid provider name "github"`},
+		{`{"providers": [{"name": "sso", "issuer": "https://a.example.com", "cid": "c", "csecret": "s"}]}`, `invalid provider name "sso"`},
+		{`{"providers": [{"name": "Bad Name", "issuer": "https://a.example.com", "cid": "c", "csecret": "s"}]}`, `invalid provider name "Bad Name"`},
+		{`{"providers": [{"name": "p1", "issuer": "https://a.example.com", "cid": "c", "csecret": "s"},
+			{"name": "p1", "issuer": "https://b.example.com", "cid": "c", "csecret": "s"}]}`, `invalid provider name "p1"`},
+		{`{"providers": [{"name": "p1", "issuer": "https://a.example.com"}]}`, "client id and secret required for provider p1"},
+		{`{"providers": [{"name": "p1", "auth_url": "https://a.example.com", "cid": "c", "csecret": "s"}]}`,
+			"issuer or auth, token and info urls required for provider p1"},
+		{`{"providers": [`, "can't parse providers file"},
+	}
+	for i, tt := range tbl {
+		_, err = LoadProviders(write(tt.data))
+		require.Error(t, err, "case #%d", i)
+		assert.Contains(t, err.Error(), tt.err, "case #%d", i)
+	}
+
+	_, err = LoadProviders(path.Join(dir, "no-such-file.yml"))
+	assert.Error(t, err)
+}
+
+func TestProvider_HandlerOpt(t *testing.T) {
+	stub := newStubServer(t)
+	defer stub.Close()
+
+	p := Provider{Name: "keycloak", Issuer: stub.URL + "/", CID: "cid", CSecret: "secret", Scopes: []string{"openid"},
+		Claims: Claims{ID: "sub", Name: "name", Picture: "picture"}}
+	opts, err := p.HandlerOpt(nil)
+	require.NoError(t, err)
+	assert.Equal(t, stub.URL+"/authorize", opts.Endpoint.AuthURL)
+	assert.Equal(t, stub.URL+"/token", opts.Endpoint.TokenURL)
+	assert.Equal(t, stub.URL+"/userinfo", opts.InfoURL)
+	assert.Equal(t, []string{"openid"}, opts.Scopes)
+
+	p.InfoURL = "https://other.example.com/me"
+	opts, err = p.HandlerOpt(nil)
+	require.NoError(t, err)
+	assert.Equal(t, stub.URL+"/authorize", opts.Endpoint.AuthURL)
+	assert.Equal(t, "https://other.example.com/me", opts.InfoURL, "explicit endpoint not overridden")
+
+	p.InfoURL, p.Issuer = "", "https://wrong.example.com"
+	_, err = p.HandlerOpt(&http.Client{Transport: redirectTransport(stub.URL)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `doesn't match "https://wrong.example.com"`)
+
+	p.Issuer = stub.URL + "/no-such-realm"
+	_, err = p.HandlerOpt(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "discovery failed for provider keycloak: unexpected openid configuration status 404")
+}
+
+func TestProvider_mapUser(t *testing.T) {
+	p := Provider{Name: "keycloak", Claims: Claims{ID: "sub", Name: "name", Picture: "picture"}}
+	u := p.mapUser(provider.UserData{"sub": "user1", "name": "John Doe", "picture": "https://example.com/pic.png"}, nil)
+	assert.Equal(t, token.User{ID: "keycloak_b3daa77b4c04a9551b8781d03191fe098f325e67", Name: "John Doe",
+		Picture: "https://example.com/pic.png"}, u)
+
+	u = p.mapUser(provider.UserData{"sub": "user1", "preferred_username": "jdoe"}, nil)
+	assert.Equal(t, "jdoe", u.Name)
+	u = p.mapUser(provider.UserData{"sub": "user1"}, nil)
+	assert.Equal(t, "noname_b3da", u.Name)
+
+	u = p.mapUser(provider.UserData{"name": "John Doe"}, nil)
+	assert.Equal(t, token.User{}, u, "no id claim")
+}
+
+func TestProvider_Login(t *testing.T) {
+	stub := newStubServer(t)
+	defer stub.Close()
+
+	var authHandler http.Handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { authHandler.ServeHTTP(w, r) }))
+	defer ts.Close()
+
+	authenticator := auth.NewService(auth.Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		URL:          ts.URL,
+		AvatarStore:  avatar.NewNoOp(),
+		DisableXSRF:  true,
+	})
+	p := Provider{Name: "keycloak", Issuer: stub.URL, CID: "cid", CSecret: "csecret", Scopes: []string{"openid", "profile"},
+		Claims: Claims{ID: "sub", Name: "name", Picture: "picture"}}
+	opts, err := p.HandlerOpt(nil)
+	require.NoError(t, err)
+	authenticator.AddCustomProvider(p.Name, auth.Client{Cid: p.CID, Csecret: p.CSecret}, opts)
+	authHandler, _ = authenticator.Handlers()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(ts.URL + "/auth/keycloak/login?site=remark")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	u := token.User{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&u))
+	assert.Equal(t, "keycloak_b3daa77b4c04a9551b8781d03191fe098f325e67", u.ID)
+	assert.Equal(t, "John Doe", u.Name)
+	assert.Equal(t, "openid profile", stub.scope)
+
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	cookies := map[string]string{}
+	for _, c := range jar.Cookies(tsURL) {
+		cookies[c.Name] = c.Value
+	}
+	assert.NotEmpty(t, cookies["JWT"], "session token set")
+}
+
+type stubServer struct {
+	*httptest.Server
+	scope string
+}
+
+// newStubServer makes minimal OpenID Connect provider with discovery, authorization code flow and user info
+func newStubServer(t *testing.T) *stubServer {
+	res := &stubServer{}
+	router := chi.NewRouter()
+	router.Get("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer": "` + res.URL + `", "authorization_endpoint": "` + res.URL + `/authorize",
+			"token_endpoint": "` + res.URL + `/token", "userinfo_endpoint": "` + res.URL + `/userinfo"}`))
+	})
+	router.Get("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "cid", r.URL.Query().Get("client_id"))
+		res.scope = r.URL.Query().Get("scope")
+		redir := r.URL.Query().Get("redirect_uri") + "?code=code1&state=" + url.QueryEscape(r.URL.Query().Get("state"))
+		http.Redirect(w, r, redir, http.StatusFound)
+	})
+	router.Post("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("code") != "code1" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "token1", "token_type": "Bearer", "expires_in": 3600}`))
+	})
+	router.Get("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"sub": "user1", "name": "John Doe", "preferred_username": "jdoe"}`))
+	})
+	res.Server = httptest.NewServer(router)
+	return res
+}
+
+// redirectTransport sends all requests to the stub server
+type redirectTransport string
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	u, err := url.Parse(string(rt))
+	if err != nil {
+		return nil, err
+	}
+	r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
@@ -0,0 +1,267 @@
+// Package sso implements login of users already authenticated by the host site.
+// Host site passes token signed with the secret of the site and gets regular remark42 session for it.
+// Authors of posts are set by tokens signed the same way.
+package sso
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+)
+
+// ProviderName used in auth routes, i.e. /auth/sso/login, and as prefix of user id
+const ProviderName = "sso"
+
+// AdminAttr is bool attribute of the user set for users marked as admins by the host site
+const AdminAttr = "sso_admin"
+
+// MaxTokenTTL limits expiration of host site tokens, long-living token can be replayed
+const MaxTokenTTL = 5 * time.Minute
+
+// KeyStore returns secret of the site
+type KeyStore interface {
+	Key(siteID string) (key string, err error)
+}
+
+// Claims of the token made by host site
+type Claims struct {
+	jwt.StandardClaims
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Picture string `json:"avatar,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Admin   bool   `json:"admin,omitempty"`
+}
+
+// Handler implements provider.Provider for host site tokens.
+// GET or POST /login?site=site-id&token=host-token&from=redirect-back-url&session=1
+type Handler struct {
+	KeyStore     KeyStore
+	TokenService provider.TokenService
+	AvatarSaver  provider.AvatarSaver // optional, avatar url used as is if nil
+	Issuer       string
+	AllowedHosts []string // hosts allowed in redirect-back url, i.e. example.com or *.example.com, relative urls always allowed
+}
+
+var validID = regexp.MustCompile(`^[\w.@-]{1,64}$`)
+
+// UserID makes namespaced id of the host site user, can't collide with ids of other providers and sites
+func UserID(siteID, id string) string {
+	return ProviderName + "_" + siteID + "_" + id
+}
+
+// IsAdmin checks if user marked as admin by the host site of given site
+func IsAdmin(siteID string, u token.User) bool {
+	return strings.HasPrefix(u.ID, ProviderName+"_"+siteID+"_") && u.BoolAttr(AdminAttr)
+}
+
+// Name of the provider
+func (h Handler) Name() string { return ProviderName }
+
+// LoginHandler checks host site token and sets session for the user
+func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	from := r.URL.Query().Get("from")
+	if from != "" && !h.redirectAllowed(from) {
+		log.Printf("[WARN] sso login rejected for site %s, redirect to %q not allowed", siteID, from)
+		R.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, errors.Errorf("redirect to %q not allowed", from),
+			"invalid redirect url")
+		return
+	}
+	claims, err := h.parse(siteID, r.FormValue("token"))
+	if err != nil {
+		log.Printf("[WARN] sso login rejected for site %s, %v", siteID, err)
+		R.SendErrorJSON(w, r, log.Default(), http.StatusForbidden, err, "invalid sso token")
+		return
+	}
+
+	u := token.User{ID: UserID(siteID, claims.ID), Name: claims.Name, Picture: claims.Picture, Email: claims.Email}
+	if u.Name == "" {
+		u.Name = claims.ID
+	}
+	u.SetBoolAttr(AdminAttr, claims.Admin)
+	if h.AvatarSaver != nil && u.Picture != "" {
+		if u.Picture, err = h.AvatarSaver.Put(u, &http.Client{Timeout: 5 * time.Second}); err != nil {
+			R.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "failed to save avatar to proxy")
+			return
+		}
+	}
+
+	cid, err := randToken()
+	if err != nil {
+		R.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "can't make token id")
+		return
+	}
+	session := token.Claims{
+		User: &u,
+		StandardClaims: jwt.StandardClaims{
+			Id:       cid,
+			Issuer:   h.Issuer,
+			Audience: siteID,
+		},
+		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+	}
+	if _, err = h.TokenService.Set(w, session); err != nil {
+		R.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+	log.Printf("[INFO] sso login of %s for site %s", u.ID, siteID)
+
+	if from != "" {
+		http.Redirect(w, r, from, http.StatusTemporaryRedirect)
+		return
+	}
+	R.RenderJSON(w, &u)
+}
+
+// redirectAllowed checks if url is relative or points to one of allowed hosts, so login can't be used as open redirect
+func (h Handler) redirectAllowed(from string) bool {
+	// browsers treat backslash as slash, i.e. /\example.com is the same as //example.com
+	if strings.Contains(from, "\\") {
+		return false
+	}
+	u, err := url.Parse(from)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" && !strings.HasPrefix(from, "//") {
+		return true
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range h.AllowedHosts {
+		if hostMatch(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatch checks if host matches allowed host, which can be set with scheme and port, and with *. for subdomains
+func hostMatch(allowed, host string) bool {
+	allowed = strings.ToLower(allowed)
+	if i := strings.Index(allowed, "://"); i >= 0 {
+		allowed = allowed[i+3:]
+	}
+	if i := strings.IndexByte(allowed, '/'); i >= 0 {
+		allowed = allowed[:i]
+	}
+	if h, _, err := net.SplitHostPort(allowed); err == nil {
+		allowed = h
+	}
+	if strings.HasPrefix(allowed, "*.") {
+		return strings.HasSuffix(host, allowed[1:])
+	}
+	return allowed != "" && allowed == host
+}
+
+// AuthHandler is not used, host site token exchanged in one step
+func (h Handler) AuthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// LogoutHandler removes session
+func (h Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := h.TokenService.Get(r); err != nil {
+		R.SendErrorJSON(w, r, log.Default(), http.StatusForbidden, err, "logout not allowed")
+		return
+	}
+	h.TokenService.Reset(w)
+}
+
+// parse checks signature and expiration of the token and returns its claims.
+// Token should be signed with HMAC and the secret of the site, expiration and audience are mandatory.
+func (h Handler) parse(siteID, tkn string) (Claims, error) {
+	if siteID == "" || tkn == "" {
+		return Claims{}, errors.New("site and token required")
+	}
+	claims := Claims{}
+	if _, err := jwt.ParseWithClaims(tkn, &claims, keyFunc(h.KeyStore, siteID)); err != nil {
+		return Claims{}, errors.Wrap(err, "can't parse token")
+	}
+	if err := checkStandard(siteID, claims.StandardClaims); err != nil {
+		return Claims{}, err
+	}
+	if !validID.MatchString(claims.ID) {
+		return Claims{}, errors.Errorf("invalid user id %q", claims.ID)
+	}
+	return claims, nil
+}
+
+// AuthorClaims of the token made by host site for the post page, marks the user as author of the post
+type AuthorClaims struct {
+	jwt.StandardClaims
+	URL    string `json:"url"`
+	Author string `json:"author"` // remark42 user id, i.e. sso_site_id for users of the host site
+}
+
+// ParseAuthor checks token of the post author made by host site and returns its claims.
+// Token should be signed with HMAC and the secret of the site, expiration and audience are mandatory.
+func ParseAuthor(ks KeyStore, siteID, tkn string) (AuthorClaims, error) {
+	if siteID == "" || tkn == "" {
+		return AuthorClaims{}, errors.New("site and token required")
+	}
+	claims := AuthorClaims{}
+	if _, err := jwt.ParseWithClaims(tkn, &claims, keyFunc(ks, siteID)); err != nil {
+		return AuthorClaims{}, errors.Wrap(err, "can't parse token")
+	}
+	if err := checkStandard(siteID, claims.StandardClaims); err != nil {
+		return AuthorClaims{}, err
+	}
+	if claims.URL == "" || claims.Author == "" {
+		return AuthorClaims{}, errors.New("no url or author in token")
+	}
+	return claims, nil
+}
+
+// keyFunc returns secret of the site for tokens signed with HMAC only
+func keyFunc(ks KeyStore, siteID string) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		secret, err := ks.Key(siteID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get secret for site %s", siteID)
+		}
+		return []byte(secret), nil
+	}
+}
+
+// checkStandard rejects tokens without expiration, tokens expiring later than MaxTokenTTL from now
+// and tokens made for other sites
+func checkStandard(siteID string, c jwt.StandardClaims) error {
+	if c.ExpiresAt == 0 {
+		return errors.New("no expiration in token")
+	}
+	if time.Unix(c.ExpiresAt, 0).After(time.Now().Add(MaxTokenTTL)) {
+		return errors.Errorf("token expiration exceeds %v", MaxTokenTTL)
+	}
+	if c.Audience != siteID {
+		return errors.Errorf("token made for site %q", c.Audience)
+	}
+	return nil
+}
+
+func randToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't read random bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
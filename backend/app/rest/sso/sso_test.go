@@ -0,0 +1,216 @@
+package sso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestHandler_Login(t *testing.T) {
+	h, ts := prepHandler()
+	defer ts.Close()
+
+	tkn := makeToken(t, "secret", Claims{ID: "42", Name: "John Doe", Email: "john@example.com", Admin: true,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix(), Audience: "remark"}})
+	resp, err := http.Get(ts.URL + "/auth/sso/login?site=remark&token=" + tkn)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	u := token.User{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&u))
+	assert.Equal(t, "sso_remark_42", u.ID)
+	assert.Equal(t, "John Doe", u.Name)
+	assert.Equal(t, "john@example.com", u.Email)
+	assert.True(t, IsAdmin("remark", u))
+	assert.False(t, IsAdmin("other", u), "admin of another site")
+
+	var jwtCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c
+		}
+	}
+	require.NotNil(t, jwtCookie, "session set")
+	claims, err := h.TokenService.Parse(jwtCookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "remark", claims.Audience)
+	assert.Equal(t, "sso_remark_42", claims.User.ID)
+	assert.Equal(t, "remark42", claims.Issuer)
+
+	// post form with redirect back to the page
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	tkn = makeToken(t, "secret", Claims{ID: "user.43@example.com",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix(), Audience: "remark"}})
+	resp, err = client.PostForm(ts.URL+"/auth/sso/login?site=remark&from="+url.QueryEscape("https://example.com/post"),
+		url.Values{"token": {tkn}})
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "https://example.com/post", resp.Header.Get("Location"))
+}
+
+func TestHandler_LoginRedirect(t *testing.T) {
+	_, ts := prepHandler()
+	defer ts.Close()
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	tbl := []struct {
+		from string
+		code int
+	}{
+		{"/post?id=1", http.StatusTemporaryRedirect},
+		{"https://example.com/post", http.StatusTemporaryRedirect},
+		{"http://EXAMPLE.com:8080/post", http.StatusTemporaryRedirect},
+		{"https://blog.example.org/post", http.StatusTemporaryRedirect},
+		{"https://example.org/post", http.StatusBadRequest},
+		{"https://evil.com/post", http.StatusBadRequest},
+		{"https://example.com.evil.com/post", http.StatusBadRequest},
+		{"//evil.com/post", http.StatusBadRequest},
+		{"/\\evil.com/post", http.StatusBadRequest},
+		{"javascript:alert(1)", http.StatusBadRequest},
+	}
+	for i, tt := range tbl {
+		tkn := makeToken(t, "secret", Claims{ID: "42",
+			StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix(), Audience: "remark"}})
+		resp, err := client.PostForm(ts.URL+"/auth/sso/login?site=remark&from="+url.QueryEscape(tt.from), url.Values{"token": {tkn}})
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "case #%d, %s", i, tt.from)
+		if tt.code == http.StatusTemporaryRedirect {
+			assert.Equal(t, tt.from, resp.Header.Get("Location"), "case #%d", i)
+		}
+	}
+}
+
+func TestHandler_LoginRejected(t *testing.T) {
+	_, ts := prepHandler()
+	defer ts.Close()
+
+	exp := time.Now().Add(time.Minute).Unix()
+	tbl := []struct {
+		site, tkn string
+	}{
+		{"remark", ""},
+		{"", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: exp}})},
+		{"remark", makeToken(t, "bad secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{Audience: "remark"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+			Audience: "remark"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Audience: "remark"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "other"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "42", StandardClaims: jwt.StandardClaims{ExpiresAt: exp}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "", StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}})},
+		{"remark", makeToken(t, "secret", Claims{ID: "bad/id", StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}})},
+		{"remark", "bad.token"},
+	}
+	for i, tt := range tbl {
+		resp, err := http.Get(ts.URL + "/auth/sso/login?site=" + tt.site + "&token=" + tt.tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "case #%d", i)
+		for _, c := range resp.Cookies() {
+			assert.NotEqual(t, "JWT", c.Name, "case #%d, no session", i)
+		}
+	}
+
+	// token without signature is not accepted
+	noneTkn, err := jwt.NewWithClaims(jwt.SigningMethodNone, Claims{ID: "42",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	resp, err := http.Get(ts.URL + "/auth/sso/login?site=remark&token=" + noneTkn)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestParseAuthor(t *testing.T) {
+	ks := admin.NewStaticKeyStore("secret")
+	exp := time.Now().Add(time.Minute).Unix()
+	sign := func(secret string, c AuthorClaims) string {
+		res, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return res
+	}
+
+	claims, err := ParseAuthor(ks, "remark", sign("secret", AuthorClaims{URL: "https://example.com/post", Author: "sso_remark_42",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/post", claims.URL)
+	assert.Equal(t, "sso_remark_42", claims.Author)
+
+	tbl := []struct {
+		site, tkn, err string
+	}{
+		{"remark", sign("bad", AuthorClaims{URL: "u", Author: "a", StandardClaims: jwt.StandardClaims{ExpiresAt: exp}}), "can't parse token"},
+		{"remark", sign("secret", AuthorClaims{URL: "u", Author: "a"}), "no expiration in token"},
+		{"remark", sign("secret", AuthorClaims{URL: "u", Author: "a", StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(), Audience: "remark"}}), "token expiration exceeds 5m0s"},
+		{"remark", sign("secret", AuthorClaims{URL: "u", Author: "a",
+			StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "other"}}), `token made for site "other"`},
+		{"remark", sign("secret", AuthorClaims{URL: "u", Author: "a", StandardClaims: jwt.StandardClaims{ExpiresAt: exp}}),
+			`token made for site ""`},
+		{"remark", sign("secret", AuthorClaims{URL: "u", StandardClaims: jwt.StandardClaims{ExpiresAt: exp, Audience: "remark"}}),
+			"no url or author in token"},
+		{"", "token", "site and token required"},
+	}
+	for i, tt := range tbl {
+		_, err = ParseAuthor(ks, tt.site, tt.tkn)
+		require.Error(t, err, "case #%d", i)
+		assert.Contains(t, err.Error(), tt.err, "case #%d", i)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	u := token.User{ID: "sso_remark_42"}
+	assert.False(t, IsAdmin("remark", u))
+	u.SetBoolAttr(AdminAttr, true)
+	assert.True(t, IsAdmin("remark", u))
+	assert.False(t, IsAdmin("rem", u))
+
+	u = token.User{ID: "github_42"}
+	u.SetBoolAttr(AdminAttr, true)
+	assert.False(t, IsAdmin("remark", u), "not sso user")
+}
+
+func prepHandler() (Handler, *httptest.Server) {
+	h := Handler{
+		KeyStore: admin.NewStaticKeyStore("secret"),
+		TokenService: token.NewService(token.Opts{
+			SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+			TokenDuration:  time.Minute,
+			CookieDuration: time.Hour,
+		}),
+		Issuer:       "remark42",
+		AllowedHosts: []string{"example.com", "https://*.example.org:8443"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/login"):
+			h.LoginHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/logout"):
+			h.LogoutHandler(w, r)
+		default:
+			h.AuthHandler(w, r)
+		}
+	}))
+	return h, ts
+}
+
+func makeToken(t *testing.T, secret string, claims Claims) string {
+	res, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return res
+}
@@ -3,6 +3,7 @@ package admin
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	log "github.com/go-pkgz/lgr"
@@ -17,6 +18,36 @@ type Store interface {
 	OnEvent(siteID string, et EventType) error
 }
 
+// RoleStore is an optional extension of Store with users having limited admin rights.
+// Admins of Store are owners of the site with all rights.
+type RoleStore interface {
+	Moderators(siteID string) (ids []string, err error)
+}
+
+// NotifyStore is an optional extension of Store with per-site destinations of admin notifications.
+// Destinations not set for the site are taken from server's options.
+type NotifyStore interface {
+	Notify(siteID string) (dest Notify, err error)
+}
+
+// Notify defines destinations of admin notifications for the site, empty fields are not set
+type Notify struct {
+	Emails   []string `json:"emails,omitempty" yaml:"emails"`     // admin emails
+	Telegram string   `json:"telegram,omitempty" yaml:"telegram"` // telegram channel
+	Webhooks []string `json:"webhooks,omitempty" yaml:"webhooks"` // webhook urls
+}
+
+// Role of the user on the site
+type Role string
+
+// enum of all roles
+const (
+	RoleNone      Role = ""
+	RoleOwner     Role = "owner"     // admin with all rights
+	RoleModerator Role = "moderator" // can delete, pin and block for limited time, no import, export, remap and deleteme
+	RoleAuthor    Role = "author"    // author of the post, can delete and pin comments and set read-only for the post only
+)
+
 // EventType indicates type of the event
 type EventType int
 
@@ -28,12 +59,29 @@ const (
 	EvVote
 )
 
+// String returns name of the event type
+func (e EventType) String() string {
+	switch e {
+	case EvCreate:
+		return "create"
+	case EvDelete:
+		return "delete"
+	case EvUpdate:
+		return "update"
+	case EvVote:
+		return "vote"
+	}
+	return fmt.Sprintf("unknown(%d)", int(e))
+}
+
 // StaticStore implements keys.Store with a single set of admins and email for all sites
 type StaticStore struct {
-	admins []string
-	email  string
-	key    string
-	sites  []string
+	admins     []string
+	moderators []string
+	email      string
+	key        string
+	sites      []string
+	runtime    *Sites // sites with runtime changes, replaces static sites if set
 }
 
 // NewStaticStore makes StaticStore instance with given key
@@ -60,13 +108,32 @@ func (s *StaticStore) Admins(string) (ids []string, err error) {
 	return s.admins, nil
 }
 
+// SetModerators sets static list of moderator ids, the same for all sites
+func (s *StaticStore) SetModerators(ids []string) {
+	log.Printf("[DEBUG] moderator users %+v", ids)
+	s.moderators = ids
+}
+
+// Moderators returns static list of moderator ids, the same for all sites
+func (s *StaticStore) Moderators(string) (ids []string, err error) {
+	return s.moderators, nil
+}
+
 // Email gets static email address
 func (s *StaticStore) Email(string) (email string, err error) {
 	return s.email, nil
 }
 
+// SetSites sets sites added, disabled and removed at runtime, used by Enabled instead of static sites
+func (s *StaticStore) SetSites(sites *Sites) {
+	s.runtime = sites
+}
+
 // Enabled if always true for StaticStore
 func (s *StaticStore) Enabled(site string) (ok bool, err error) {
+	if s.runtime != nil {
+		return s.runtime.Enabled(site), nil
+	}
 	if len(s.sites) == 0 {
 		return true, nil
 	}
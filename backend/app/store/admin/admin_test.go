@@ -29,4 +29,12 @@ func TestStaticStore_Get(t *testing.T) {
 	enabled, err = ks.Enabled("serr")
 	assert.NoError(t, err)
 	assert.Equal(t, false, enabled)
+
+	mods, err := ks.(RoleStore).Moderators("s1")
+	assert.NoError(t, err)
+	assert.Empty(t, mods)
+	ks.(*StaticStore).SetModerators([]string{"m1"})
+	mods, err = ks.(RoleStore).Moderators("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m1"}, mods)
 }
@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSite defines admin data of the single site in FileStore
+type FileSite struct {
+	Key        string   `json:"key" yaml:"key"`               // signing key, default key of the store used if empty
+	Disabled   bool     `json:"disabled" yaml:"disabled"`     // disabled site rejected by Enabled
+	Admins     []string `json:"admins" yaml:"admins"`         // ids of admins
+	Moderators []string `json:"moderators" yaml:"moderators"` // ids of moderators
+	Emails     []string `json:"emails" yaml:"emails"`         // admin emails, the first one returned by Email
+	Notify     Notify   `json:"notify" yaml:"notify"`         // optional destinations of admin notifications
+}
+
+// fileConfig is the content of FileStore's file
+type fileConfig struct {
+	Sites map[string]FileSite `json:"sites" yaml:"sites"`
+}
+
+// FileStore implements Store, RoleStore and NotifyStore with per-site admins, emails and keys defined in yaml or
+// json file. The file checked for changes on access, at most once per check interval, and reloaded if changed.
+// Broken file logged and ignored, the last good content kept in this case. Thread safe.
+type FileStore struct {
+	fileName      string
+	key           string // default key for sites without own key
+	eventLog      string // file to write events to, disabled if empty
+	checkInterval time.Duration
+
+	lock    sync.RWMutex
+	sites   map[string]FileSite
+	modTime time.Time
+	size    int64
+	checked time.Time
+
+	eventLock sync.Mutex
+}
+
+const fileCheckInterval = 5 * time.Second
+
+// NewFileStore makes FileStore with sites loaded from the file. Key used for sites without own key,
+// events written to eventLog file if it is not empty
+func NewFileStore(fileName, key, eventLog string) (*FileStore, error) {
+	res := FileStore{fileName: fileName, key: key, eventLog: eventLog, checkInterval: fileCheckInterval}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] admin store file %s, %d sites", fileName, len(res.sites))
+	return &res, nil
+}
+
+// Key returns signing key of the site, default key for sites without own key. Sites not defined in the file
+// rejected, empty siteID used for the key shared across sites and gets the default key
+func (f *FileStore) Key(siteID string) (key string, err error) {
+	if siteID != "" {
+		site, ok := f.site(siteID)
+		if !ok {
+			return "", errors.Errorf("site %q not found", siteID)
+		}
+		if site.Key != "" {
+			return site.Key, nil
+		}
+	}
+	if f.key == "" {
+		return "", errors.Errorf("empty key for site %q", siteID)
+	}
+	return f.key, nil
+}
+
+// Admins returns ids of admins of the site
+func (f *FileStore) Admins(siteID string) (ids []string, err error) {
+	site, ok := f.site(siteID)
+	if !ok {
+		return []string{}, errors.Errorf("site %q not found", siteID)
+	}
+	return site.Admins, nil
+}
+
+// Moderators returns ids of moderators of the site
+func (f *FileStore) Moderators(siteID string) (ids []string, err error) {
+	site, ok := f.site(siteID)
+	if !ok {
+		return []string{}, errors.Errorf("site %q not found", siteID)
+	}
+	return site.Moderators, nil
+}
+
+// Email returns the first admin email of the site, empty if no emails defined
+func (f *FileStore) Email(siteID string) (email string, err error) {
+	site, ok := f.site(siteID)
+	if !ok {
+		return "", errors.Errorf("site %q not found", siteID)
+	}
+	if len(site.Emails) == 0 {
+		return "", nil
+	}
+	return site.Emails[0], nil
+}
+
+// Enabled checks if site defined and not disabled
+func (f *FileStore) Enabled(siteID string) (ok bool, err error) {
+	site, ok := f.site(siteID)
+	return ok && !site.Disabled, nil
+}
+
+// Notify returns destinations of admin notifications for the site. Admin emails of the site used
+// if notification emails not set
+func (f *FileStore) Notify(siteID string) (dest Notify, err error) {
+	site, ok := f.site(siteID)
+	if !ok {
+		return Notify{}, errors.Errorf("site %q not found", siteID)
+	}
+	dest = site.Notify
+	if len(dest.Emails) == 0 {
+		dest.Emails = site.Emails
+	}
+	return dest, nil
+}
+
+// OnEvent writes the event to the event log, does nothing if event log not set
+func (f *FileStore) OnEvent(siteID string, et EventType) error {
+	if f.eventLog == "" {
+		return nil
+	}
+	rec := struct {
+		Time  time.Time `json:"time"`
+		Site  string    `json:"site"`
+		Event string    `json:"event"`
+	}{Time: time.Now(), Site: siteID, Event: et.String()}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal event")
+	}
+
+	f.eventLock.Lock()
+	defer f.eventLock.Unlock()
+	fh, err := os.OpenFile(f.eventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // nolint
+	if err != nil {
+		return errors.Wrapf(err, "can't open event log %s", f.eventLog)
+	}
+	if _, err = fmt.Fprintln(fh, string(data)); err != nil {
+		_ = fh.Close()
+		return errors.Wrapf(err, "can't write event to %s", f.eventLog)
+	}
+	return errors.Wrapf(fh.Close(), "can't close event log %s", f.eventLog)
+}
+
+// site returns site by id, exact match only. Reloads the file if changed
+func (f *FileStore) site(siteID string) (site FileSite, ok bool) {
+	f.reload()
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	site, ok = f.sites[siteID]
+	return site, ok
+}
+
+// reload loads the file if it was changed since the last load. Checks the file once per check interval only
+func (f *FileStore) reload() {
+	f.lock.RLock()
+	checked := time.Since(f.checked) < f.checkInterval
+	f.lock.RUnlock()
+	if checked {
+		return
+	}
+
+	fi, err := os.Stat(f.fileName)
+	if err != nil {
+		log.Printf("[WARN] can't check admin store file %s, %v", f.fileName, err)
+		f.lock.Lock()
+		f.checked = time.Now()
+		f.lock.Unlock()
+		return
+	}
+	f.lock.RLock()
+	changed := !fi.ModTime().Equal(f.modTime) || fi.Size() != f.size
+	f.lock.RUnlock()
+	if !changed {
+		f.lock.Lock()
+		f.checked = time.Now()
+		f.lock.Unlock()
+		return
+	}
+
+	if err = f.load(); err != nil {
+		log.Printf("[WARN] can't reload admin store, previous content kept, %v", err)
+		f.lock.Lock()
+		f.checked = time.Now()
+		f.lock.Unlock()
+		return
+	}
+	log.Printf("[INFO] admin store reloaded from %s", f.fileName)
+}
+
+// load reads sites from the file, yaml or json by file extension
+func (f *FileStore) load() error {
+	fi, err := os.Stat(f.fileName)
+	if err != nil {
+		return errors.Wrapf(err, "can't check admin store file %s", f.fileName)
+	}
+	data, err := ioutil.ReadFile(f.fileName) // nolint
+	if err != nil {
+		return errors.Wrapf(err, "can't read admin store file %s", f.fileName)
+	}
+
+	conf := fileConfig{}
+	if strings.EqualFold(filepath.Ext(f.fileName), ".json") {
+		err = json.Unmarshal(data, &conf)
+	} else {
+		err = yaml.Unmarshal(data, &conf)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't parse admin store file %s", f.fileName)
+	}
+	if conf.Sites == nil {
+		conf.Sites = map[string]FileSite{}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sites, f.modTime, f.size, f.checked = conf.Sites, fi.ModTime(), fi.Size(), time.Now()
+	return nil
+}
@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	var ks Store
+	ks, err := NewFileStore("testdata/admin.yml", "default-key", "")
+	require.NoError(t, err)
+
+	k, err := ks.Key("remark")
+	require.NoError(t, err)
+	assert.Equal(t, "remark-secret", k)
+	k, err = ks.Key("blog")
+	require.NoError(t, err)
+	assert.Equal(t, "default-key", k, "site without own key")
+	k, err = ks.Key("")
+	require.NoError(t, err)
+	assert.Equal(t, "default-key", k, "shared key")
+	_, err = ks.Key("unknown")
+	assert.EqualError(t, err, `site "unknown" not found`)
+	_, err = ks.Key("Remark")
+	assert.EqualError(t, err, `site "Remark" not found`, "case sensitive")
+
+	a, err := ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github_ef0f706a7", "google_123"}, a)
+	a, err = ks.Admins("blog")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github_xyz"}, a)
+	_, err = ks.Admins("Blog")
+	assert.EqualError(t, err, `site "Blog" not found`, "case sensitive")
+	_, err = ks.Admins("unknown")
+	assert.EqualError(t, err, `site "unknown" not found`)
+
+	mods, err := ks.(RoleStore).Moderators("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github_mod1"}, mods)
+	mods, err = ks.(RoleStore).Moderators("blog")
+	require.NoError(t, err)
+	assert.Empty(t, mods)
+
+	email, err := ks.Email("remark")
+	require.NoError(t, err)
+	assert.Equal(t, "admin@example.com", email)
+	email, err = ks.Email("blog")
+	require.NoError(t, err)
+	assert.Equal(t, "", email)
+
+	enabled, err := ks.Enabled("remark")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	enabled, err = ks.Enabled("blog")
+	require.NoError(t, err)
+	assert.False(t, enabled, "disabled site")
+	enabled, err = ks.Enabled("unknown")
+	require.NoError(t, err)
+	assert.False(t, enabled, "unknown site")
+
+	dest, err := ks.(NotifyStore).Notify("remark")
+	require.NoError(t, err)
+	assert.Equal(t, Notify{Emails: []string{"admin@example.com", "admin2@example.com"}, Telegram: "remark_channel",
+		Webhooks: []string{"https://example.com/hook"}}, dest, "admin emails used for notifications")
+	dest, err = ks.(NotifyStore).Notify("blog")
+	require.NoError(t, err)
+	assert.Equal(t, Notify{Emails: []string{"blog-notify@example.com"}}, dest)
+
+	assert.NoError(t, ks.OnEvent("remark", EvCreate), "event log disabled")
+
+	_, err = NewFileStore("testdata/no-such-file.yml", "default-key", "")
+	assert.Error(t, err)
+}
+
+func TestFileStore_JSON(t *testing.T) {
+	ks, err := NewFileStore("testdata/admin.json", "", "")
+	require.NoError(t, err)
+
+	a, err := ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github_ef0f706a7"}, a)
+	k, err := ks.Key("remark")
+	require.NoError(t, err)
+	assert.Equal(t, "remark-secret", k)
+	_, err = ks.Key("unknown")
+	assert.EqualError(t, err, `site "unknown" not found`)
+	_, err = ks.Key("")
+	assert.EqualError(t, err, `empty key for site ""`)
+}
+
+func TestFileStore_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_admin_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "admin.yml")
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("sites:\n  remark:\n    admins: [user1]\n"), 0600))
+	ks, err := NewFileStore(fileName, "secret", "")
+	require.NoError(t, err)
+	ks.checkInterval = time.Millisecond
+
+	a, err := ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1"}, a)
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("sites:\n  remark:\n    admins: [user1, user2]\n  blog: {}\n"), 0600))
+	time.Sleep(5 * time.Millisecond)
+	a, err = ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2"}, a, "reloaded on change")
+	enabled, err := ks.Enabled("blog")
+	require.NoError(t, err)
+	assert.True(t, enabled, "new site added")
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("sites: [broken"), 0600))
+	time.Sleep(5 * time.Millisecond)
+	a, err = ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2"}, a, "previous content kept for broken file")
+
+	require.NoError(t, os.Remove(fileName))
+	time.Sleep(5 * time.Millisecond)
+	a, err = ks.Admins("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2"}, a, "previous content kept for removed file")
+}
+
+func TestFileStore_OnEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_admin_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	eventLog := filepath.Join(dir, "events.log")
+
+	ks, err := NewFileStore("testdata/admin.yml", "secret", eventLog)
+	require.NoError(t, err)
+	require.NoError(t, ks.OnEvent("remark", EvCreate))
+	require.NoError(t, ks.OnEvent("remark", EvVote))
+	require.NoError(t, ks.OnEvent("blog", EvDelete))
+
+	data, err := ioutil.ReadFile(eventLog) // nolint
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Equal(t, 3, len(lines))
+
+	events := []string{}
+	for _, l := range lines {
+		rec := struct {
+			Time  time.Time `json:"time"`
+			Site  string    `json:"site"`
+			Event string    `json:"event"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(l), &rec))
+		assert.False(t, rec.Time.IsZero())
+		events = append(events, rec.Site+":"+rec.Event)
+	}
+	assert.Equal(t, []string{"remark:create", "remark:vote", "blog:delete"}, events)
+
+	ks, err = NewFileStore("testdata/admin.yml", "secret", filepath.Join(dir, "no-such-dir", "events.log"))
+	require.NoError(t, err)
+	assert.Error(t, ks.OnEvent("remark", EvCreate))
+}
+
+func TestEventType_String(t *testing.T) {
+	assert.Equal(t, "create", EvCreate.String())
+	assert.Equal(t, "delete", EvDelete.String())
+	assert.Equal(t, "update", EvUpdate.String())
+	assert.Equal(t, "vote", EvVote.String())
+	assert.Equal(t, "unknown(10)", EventType(10).String())
+}
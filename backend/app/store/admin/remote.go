@@ -41,6 +41,19 @@ func (r *RPC) Admins(siteID string) (ids []string, err error) {
 	return ids, nil
 }
 
+// Moderators returns list of moderator's ids for given site
+func (r *RPC) Moderators(siteID string) (ids []string, err error) {
+	resp, err := r.Call("admin.moderators", siteID)
+	if err != nil {
+		return []string{}, err
+	}
+
+	if err := json.Unmarshal(*resp.Result, &ids); err != nil {
+		return []string{}, err
+	}
+	return ids, nil
+}
+
 // Email gets email address for given site
 func (r *RPC) Email(siteID string) (email string, err error) {
 	resp, err := r.Call("admin.email", siteID)
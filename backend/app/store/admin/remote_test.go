@@ -48,6 +48,20 @@ func TestRemote_Admins(t *testing.T) {
 	t.Logf("%v %T", res, res)
 }
 
+func TestRemote_Moderators(t *testing.T) {
+	ts := testServer(t, `{"method":"admin.moderators","params":"site-1","id":1}`,
+		`{"result":["id3"],"id":1}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	var a RoleStore = &c
+	_ = a
+
+	res, err := c.Moderators("site-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id3"}, res)
+}
+
 func TestRemote_Email(t *testing.T) {
 	ts := testServer(t, `{"method":"admin.email","params":"site-1","id":1}`,
 		`{"result":"bbb@example.com","id":1}`)
@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Site defines single site served by remark42
+type Site struct {
+	ID      string    `json:"id"`
+	Enabled bool      `json:"enabled"`
+	Static  bool      `json:"static,omitempty"` // defined by server's options, can't be changed at runtime
+	Created time.Time `json:"created,omitempty"`
+}
+
+// Sites keeps all sites, static ones defined by server's options and sites added at runtime.
+// Runtime sites persisted to json file and restored on the next start. Thread safe.
+type Sites struct {
+	fileName string
+	lock     sync.RWMutex
+	sites    map[string]Site
+}
+
+var reSiteID = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// NewSites makes Sites with static sites and runtime sites loaded from the file. Missing file is not an error,
+// it will be created on the first change
+func NewSites(fileName string, static ...string) (*Sites, error) {
+	res := Sites{fileName: fileName, sites: map[string]Site{}}
+
+	data, err := ioutil.ReadFile(fileName) // nolint
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "can't read sites from %s", fileName)
+	}
+	if err == nil {
+		sites := []Site{}
+		if err = json.Unmarshal(data, &sites); err != nil {
+			return nil, errors.Wrapf(err, "can't parse sites from %s", fileName)
+		}
+		for _, site := range sites {
+			site.Static = false
+			res.sites[site.ID] = site
+		}
+	}
+
+	for _, siteID := range static {
+		res.sites[siteID] = Site{ID: siteID, Enabled: true, Static: true}
+	}
+	log.Printf("[DEBUG] sites %+v", res.List())
+	return &res, nil
+}
+
+// List returns all sites sorted by id
+func (s *Sites) List() []Site {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	res := make([]Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		res = append(res, site)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// EnabledIDs returns ids of all enabled sites sorted by id
+func (s *Sites) EnabledIDs() []string {
+	res := []string{}
+	for _, site := range s.List() {
+		if site.Enabled {
+			res = append(res, site.ID)
+		}
+	}
+	return res
+}
+
+// Get returns site by id
+func (s *Sites) Get(siteID string) (site Site, ok bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	site, ok = s.sites[siteID]
+	return site, ok
+}
+
+// Enabled checks if site known and enabled, case insensitive like StaticStore
+func (s *Sites) Enabled(siteID string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, site := range s.sites {
+		if strings.EqualFold(site.ID, siteID) {
+			return site.Enabled
+		}
+	}
+	return false
+}
+
+// Add makes new enabled site and persists it
+func (s *Sites) Add(siteID string) (Site, error) {
+	if !reSiteID.MatchString(siteID) {
+		return Site{}, errors.Errorf("invalid site id %q", siteID)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id := range s.sites {
+		if strings.EqualFold(id, siteID) {
+			return Site{}, errors.Errorf("site %q already exists", id)
+		}
+	}
+	site := Site{ID: siteID, Enabled: true, Created: time.Now()}
+	s.sites[siteID] = site
+	if err := s.save(); err != nil {
+		delete(s.sites, siteID)
+		return Site{}, err
+	}
+	return site, nil
+}
+
+// SetEnabled enables or disables runtime site and persists the change
+func (s *Sites) SetEnabled(siteID string, enabled bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	site, err := s.runtimeSite(siteID)
+	if err != nil {
+		return err
+	}
+	prev := site
+	site.Enabled = enabled
+	s.sites[siteID] = site
+	if err = s.save(); err != nil {
+		s.sites[siteID] = prev
+		return err
+	}
+	return nil
+}
+
+// Remove deletes runtime site and persists the change
+func (s *Sites) Remove(siteID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	site, err := s.runtimeSite(siteID)
+	if err != nil {
+		return err
+	}
+	delete(s.sites, siteID)
+	if err = s.save(); err != nil {
+		s.sites[siteID] = site
+		return err
+	}
+	return nil
+}
+
+// runtimeSite returns site by id, static sites rejected. Should be called under lock
+func (s *Sites) runtimeSite(siteID string) (Site, error) {
+	site, ok := s.sites[siteID]
+	if !ok {
+		return Site{}, errors.Errorf("site %q not found", siteID)
+	}
+	if site.Static {
+		return Site{}, errors.Errorf("site %q defined by server's options, can't be changed", siteID)
+	}
+	return site, nil
+}
+
+// save writes all runtime sites to the file, static sites not saved. Should be called under lock
+func (s *Sites) save() error {
+	sites := []Site{}
+	for _, site := range s.sites {
+		if !site.Static {
+			sites = append(sites, site)
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].ID < sites[j].ID })
+	data, err := json.MarshalIndent(sites, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can't marshal sites")
+	}
+
+	if err = os.MkdirAll(filepath.Dir(s.fileName), 0700); err != nil {
+		return errors.Wrapf(err, "can't make directory for %s", s.fileName)
+	}
+	// write to temp file and rename, so the file never left half-written
+	tmpFile := s.fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return errors.Wrapf(err, "can't write sites to %s", tmpFile)
+	}
+	return errors.Wrapf(os.Rename(tmpFile, s.fileName), "can't save sites to %s", s.fileName)
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_sites")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "var", "sites.json")
+
+	sites, err := NewSites(fileName, "remark")
+	require.NoError(t, err)
+	assert.Equal(t, []Site{{ID: "remark", Enabled: true, Static: true}}, sites.List())
+
+	s1, err := sites.Add("site1")
+	require.NoError(t, err)
+	assert.Equal(t, "site1", s1.ID)
+	assert.True(t, s1.Enabled)
+	assert.False(t, s1.Created.IsZero())
+	_, err = sites.Add("site2")
+	require.NoError(t, err)
+
+	_, err = sites.Add("Site1")
+	assert.EqualError(t, err, `site "site1" already exists`)
+	_, err = sites.Add("remark")
+	assert.EqualError(t, err, `site "remark" already exists`)
+	_, err = sites.Add("../etc")
+	assert.EqualError(t, err, `invalid site id "../etc"`)
+	_, err = sites.Add("")
+	assert.EqualError(t, err, `invalid site id ""`)
+
+	require.NoError(t, sites.SetEnabled("site2", false))
+	assert.True(t, sites.Enabled("site1"))
+	assert.True(t, sites.Enabled("SITE1"))
+	assert.False(t, sites.Enabled("site2"))
+	assert.False(t, sites.Enabled("bad"))
+	assert.Equal(t, []string{"remark", "site1"}, sites.EnabledIDs())
+
+	assert.EqualError(t, sites.SetEnabled("remark", false), `site "remark" defined by server's options, can't be changed`)
+	assert.EqualError(t, sites.Remove("remark"), `site "remark" defined by server's options, can't be changed`)
+	assert.EqualError(t, sites.Remove("bad"), `site "bad" not found`)
+
+	// restored from the file, static sites from options
+	sites, err = NewSites(fileName, "remark", "other")
+	require.NoError(t, err)
+	list := sites.List()
+	require.Equal(t, 4, len(list))
+	assert.Equal(t, "other", list[0].ID)
+	assert.Equal(t, "site1", list[2].ID)
+	assert.True(t, list[2].Enabled)
+	assert.Equal(t, "site2", list[3].ID)
+	assert.False(t, list[3].Enabled)
+
+	require.NoError(t, sites.Remove("site1"))
+	_, ok := sites.Get("site1")
+	assert.False(t, ok)
+	site, ok := sites.Get("site2")
+	assert.True(t, ok)
+	assert.False(t, site.Enabled)
+
+	data, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "site1")
+	assert.NotContains(t, string(data), "remark")
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("bad json"), 0600))
+	_, err = NewSites(fileName)
+	assert.Error(t, err)
+}
+
+func TestStaticStore_SetSites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_sites")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ks := NewStaticStore("key123", []string{"s1"}, []string{"123"}, "aa@example.com")
+	sites, err := NewSites(filepath.Join(dir, "sites.json"), "s1")
+	require.NoError(t, err)
+	ks.SetSites(sites)
+
+	ok, err := ks.Enabled("s2")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = sites.Add("s2")
+	require.NoError(t, err)
+	ok, err = ks.Enabled("s2")
+	require.NoError(t, err)
+	assert.True(t, ok, "added at runtime")
+
+	require.NoError(t, sites.SetEnabled("s2", false))
+	ok, err = ks.Enabled("s2")
+	require.NoError(t, err)
+	assert.False(t, ok, "disabled at runtime")
+}
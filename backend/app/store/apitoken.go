@@ -0,0 +1,55 @@
+package store
+
+import (
+	"time"
+)
+
+// TokenScope defines group of admin calls allowed for API token
+type TokenScope string
+
+// TokenScope enum
+const (
+	TokenScopeRead       TokenScope = "read"       // read-only admin calls, like search, audit and list of blocked users
+	TokenScopeModeration TokenScope = "moderation" // moderation calls, like delete, pin, block and approve
+	TokenScopeMigration  TokenScope = "migration"  // import, export, remap and wait for migration
+)
+
+// APIToken is admin's personal token used by scripts instead of the session. Token value is not stored,
+// only its hash. Token without scopes allows all admin calls of the site
+type APIToken struct {
+	ID        string       `json:"id" bson:"_id"`
+	SiteID    string       `json:"site" bson:"site"`
+	Name      string       `json:"name" bson:"name"`
+	UserID    string       `json:"user_id" bson:"user_id"` // admin made the token
+	Hash      string       `json:"hash,omitempty" bson:"hash"`
+	Scopes    []TokenScope `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	Expires   time.Time    `json:"expires,omitempty" bson:"expires,omitempty"` // never expires if zero
+	Timestamp time.Time    `json:"time" bson:"time"`
+}
+
+// Valid checks if scope is one of supported
+func (s TokenScope) Valid() bool {
+	switch s {
+	case TokenScopeRead, TokenScopeModeration, TokenScopeMigration:
+		return true
+	}
+	return false
+}
+
+// Expired checks if token expired at given time
+func (t APIToken) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// HasScope checks if token allows calls of given scope, token without scopes allows all calls
+func (t APIToken) HasScope(scope TokenScope) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIToken_HasScope(t *testing.T) {
+	tkn := APIToken{ID: "t1"}
+	assert.True(t, tkn.HasScope(TokenScopeRead), "token without scopes allows all")
+	assert.True(t, tkn.HasScope(TokenScopeMigration), "token without scopes allows all")
+
+	tkn.Scopes = []TokenScope{TokenScopeRead, TokenScopeModeration}
+	assert.True(t, tkn.HasScope(TokenScopeRead))
+	assert.True(t, tkn.HasScope(TokenScopeModeration))
+	assert.False(t, tkn.HasScope(TokenScopeMigration))
+}
+
+func TestAPIToken_Expired(t *testing.T) {
+	now := time.Date(2020, 10, 2, 15, 18, 0, 0, time.UTC)
+	assert.False(t, APIToken{}.Expired(now), "never expires")
+	assert.False(t, APIToken{Expires: now.Add(time.Minute)}.Expired(now))
+	assert.True(t, APIToken{Expires: now.Add(-time.Minute)}.Expired(now))
+}
+
+func TestTokenScope_Valid(t *testing.T) {
+	assert.True(t, TokenScopeRead.Valid())
+	assert.True(t, TokenScopeMigration.Valid())
+	assert.False(t, TokenScope("all").Valid())
+}
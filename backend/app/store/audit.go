@@ -0,0 +1,54 @@
+package store
+
+import (
+	"time"
+)
+
+// AuditAction defines admin action recorded in audit log
+type AuditAction string
+
+// AuditAction enum
+const (
+	AuditDeleteComment       AuditAction = "delete_comment"
+	AuditRestoreComment      AuditAction = "restore_comment"
+	AuditReportSpam          AuditAction = "report_spam"
+	AuditReportHam           AuditAction = "report_ham"
+	AuditDeleteUser          AuditAction = "delete_user"
+	AuditDeleteMe            AuditAction = "deleteme"
+	AuditBlock               AuditAction = "block"
+	AuditUnblock             AuditAction = "unblock"
+	AuditShadowBan           AuditAction = "shadowban"
+	AuditShadowUnban         AuditAction = "shadowunban"
+	AuditVerify              AuditAction = "verify"
+	AuditUnverify            AuditAction = "unverify"
+	AuditPin                 AuditAction = "pin"
+	AuditUnpin               AuditAction = "unpin"
+	AuditReadOnly            AuditAction = "readonly"
+	AuditReadWrite           AuditAction = "readwrite"
+	AuditModeration          AuditAction = "moderation"
+	AuditApprove             AuditAction = "approve"
+	AuditReject              AuditAction = "reject"
+	AuditResolveReports      AuditAction = "resolve_reports"
+	AuditDismissReports      AuditAction = "dismiss_reports"
+	AuditSetTitle            AuditAction = "set_title"
+	AuditImport              AuditAction = "import"
+	AuditRemap               AuditAction = "remap"
+	AuditResendNotifications AuditAction = "resend_notifications"
+	AuditAddAuthor           AuditAction = "add_author"
+	AuditRemoveAuthor        AuditAction = "remove_author"
+	AuditAddModerator        AuditAction = "add_moderator"
+	AuditRemoveModerator     AuditAction = "remove_moderator"
+	AuditCreateToken         AuditAction = "create_token"
+	AuditRevokeToken         AuditAction = "revoke_token"
+)
+
+// AuditRecord keeps a single admin action, who did what and when
+type AuditRecord struct {
+	ID        string            `json:"id" bson:"_id"`
+	SiteID    string            `json:"site" bson:"site"`
+	Actor     string            `json:"actor" bson:"actor"` // id of admin made the action
+	Action    AuditAction       `json:"action" bson:"action"`
+	Target    string            `json:"target,omitempty" bson:"target"` // comment id, user id or post url, depends on action
+	Params    map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+	Timestamp time.Time         `json:"time" bson:"time"`
+}
@@ -8,11 +8,12 @@ import (
 	"time"
 
 	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
 )
 
 // Comment represents a single comment with optional reference to its parent
 type Comment struct {
-	ID          string                 `json:"id" bson:"_id"`
+	ID          string                 `json:"id" bson:"id"`
 	ParentID    string                 `json:"pid"`
 	Text        string                 `json:"text"`
 	Orig        string                 `json:"orig,omitempty"`
@@ -24,13 +25,29 @@ type Comment struct {
 	Vote        int                    `json:"vote"`                // vote for the current user, -1/1/0.
 	Controversy float64                `json:"controversy,omitempty"`
 	Timestamp   time.Time              `json:"time" bson:"time"`
-	Edit        *Edit                  `json:"edit,omitempty" bson:"edit,omitempty"` // pointer to have empty default in json response
+	Edit        *Edit                  `json:"edit,omitempty" bson:"edit,omitempty"`           // pointer to have empty default in json response
+	Revisions   []Revision             `json:"revisions,omitempty" bson:"revisions,omitempty"` // previous versions, hidden from api
+	Retained    *Retained              `json:"retained,omitempty" bson:"retained,omitempty"`   // content of soft-deleted comment, hidden from api
 	Pin         bool                   `json:"pin,omitempty" bson:"pin,omitempty"`
 	Deleted     bool                   `json:"delete,omitempty" bson:"delete"`
+	Pending     bool                   `json:"pending,omitempty" bson:"pending"`         // waiting for approval by admin
+	Shadow      bool                   `json:"shadow,omitempty" bson:"shadow,omitempty"` // made by shadow-banned user
+	Spam        bool                   `json:"spam,omitempty" bson:"spam,omitempty"`     // detected by spam checker or marked by admin
 	Imported    bool                   `json:"imported,omitempty" bson:"imported"`
 	PostTitle   string                 `json:"title,omitempty" bson:"title"`
 }
 
+// Retained keeps content of soft-deleted comment, cleared by SetDeleted, to restore the comment
+type Retained struct {
+	Text      string          `json:"text"`
+	Orig      string          `json:"orig,omitempty"`
+	Score     int             `json:"score"`
+	Votes     map[string]bool `json:"votes,omitempty"`
+	Edit      *Edit           `json:"edit,omitempty" bson:"edit,omitempty"`
+	Pin       bool            `json:"pin,omitempty"`
+	DeletedAt time.Time       `json:"deleted_at" bson:"deleted_at"`
+}
+
 // Locator keeps site and url of the post
 type Locator struct {
 	SiteID string `json:"site,omitempty" bson:"site"`
@@ -43,6 +60,15 @@ type Edit struct {
 	Summary   string    `json:"summary"`
 }
 
+// Revision keeps comment text replaced by the edit, with editor, time and summary of the edit
+type Revision struct {
+	Text      string    `json:"text"`
+	Orig      string    `json:"orig,omitempty"`
+	EditorID  string    `json:"editor_id" bson:"editor_id"` // user or admin made the edit
+	Timestamp time.Time `json:"time" bson:"time"`           // time of the edit
+	Summary   string    `json:"summary,omitempty"`
+}
+
 // PostInfo holds summary for given post url
 type PostInfo struct {
 	URL      string    `json:"url"`
@@ -72,6 +98,7 @@ type DeleteMode int
 const (
 	SoftDelete DeleteMode = 0
 	HardDelete DeleteMode = 1
+	UserDelete DeleteMode = 2 // soft delete by the author, content not retained
 )
 
 // Maximum length for URL text shortening.
@@ -87,12 +114,25 @@ func (c *Comment) PrepareUntrusted() {
 	c.VotedIPs = make(map[string]VotedIPInfo)
 	c.Score = 0
 	c.Edit = nil
+	c.Revisions = nil
+	c.Retained = nil
 	c.Pin = false
 	c.Deleted = false
+	c.Spam = false
 }
 
-// SetDeleted clears comment info, reset to deleted state. hard flag will clear all user info as well
+// SetDeleted clears comment info, reset to deleted state. hard flag will clear all user info as well.
+// Content of soft-deleted comment retained and can be brought back by Restore, voters' IPs are not retained.
+// Nothing retained for comment deleted by the author
 func (c *Comment) SetDeleted(mode DeleteMode) {
+	if len(c.Revisions) > 0 && !c.Deleted && mode == SoftDelete {
+		// keep the last version of edited comment for admins, deletion is the last edit
+		c.Revisions = append(c.Revisions, Revision{Text: c.Text, Orig: c.Orig, Timestamp: time.Now(), Summary: "deleted"})
+	}
+	if mode == SoftDelete && !c.Deleted {
+		c.Retained = &Retained{Text: c.Text, Orig: c.Orig, Score: c.Score, Votes: c.Votes, Edit: c.Edit, Pin: c.Pin,
+			DeletedAt: time.Now()}
+	}
 	c.Text = ""
 	c.Orig = ""
 	c.Score = 0
@@ -102,7 +142,14 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	c.Deleted = true
 	c.Pin = false
 
+	if mode == UserDelete {
+		c.Revisions = nil // previous versions removed with the content
+		c.Retained = nil
+	}
+
 	if mode == HardDelete {
+		c.Revisions = nil // soft-deleted comment keeps previous versions for admins
+		c.Retained = nil
 		c.User.Name = "deleted"
 		c.User.ID = "deleted"
 		c.User.Picture = ""
@@ -110,6 +157,25 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	}
 }
 
+// Restore brings back content of soft-deleted comment retained by SetDeleted
+func (c *Comment) Restore() error {
+	if !c.Deleted || c.Retained == nil {
+		return errors.Errorf("comment %s can't be restored", c.ID)
+	}
+	r := c.Retained
+	c.Text, c.Orig, c.Score, c.Edit, c.Pin = r.Text, r.Orig, r.Score, r.Edit, r.Pin
+	c.Votes, c.VotedIPs = r.Votes, make(map[string]VotedIPInfo)
+	if c.Votes == nil {
+		c.Votes = map[string]bool{}
+	}
+	if n := len(c.Revisions); n > 0 && c.Revisions[n-1].Summary == "deleted" {
+		c.Revisions = c.Revisions[:n-1] // restored text is the current version again
+	}
+	c.Retained = nil
+	c.Deleted = false
+	return nil
+}
+
 // Sanitize clean dangerous html/js from the comment
 func (c *Comment) Sanitize() {
 	p := bluemonday.UGCPolicy()
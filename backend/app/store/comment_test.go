@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestComment_Sanitize(t *testing.T) {
@@ -93,6 +94,7 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 		Deleted:   true,
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
+		Revisions: []Revision{{Text: "old", EditorID: "username"}},
 	}
 
 	comment.PrepareUntrusted()
@@ -106,6 +108,7 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 	assert.Equal(t, make(map[string]bool), comment.Votes)
 	assert.Equal(t, make(map[string]VotedIPInfo), comment.VotedIPs)
 	assert.Equal(t, User{ID: "username"}, comment.User)
+	assert.Nil(t, comment.Revisions)
 
 }
 
@@ -121,6 +124,7 @@ func TestComment_SetDeleted(t *testing.T) {
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
 		Pin:       true,
+		Revisions: []Revision{{Text: "old", EditorID: "userid"}},
 	}
 
 	comment.SetDeleted(SoftDelete)
@@ -132,6 +136,10 @@ func TestComment_SetDeleted(t *testing.T) {
 	assert.Equal(t, 0, comment.Score)
 	assert.True(t, comment.Deleted)
 	assert.Nil(t, comment.Edit)
+	require.Equal(t, 2, len(comment.Revisions), "kept for admins")
+	assert.Equal(t, Revision{Text: "old", EditorID: "userid"}, comment.Revisions[0])
+	assert.Equal(t, "blah", comment.Revisions[1].Text, "last version kept")
+	assert.Equal(t, "deleted", comment.Revisions[1].Summary)
 	assert.False(t, comment.Pin)
 	assert.Equal(t, User{Name: "username", ID: "userid", Picture: "pic", Admin: false, Blocked: false, IP: "123"}, comment.User)
 }
@@ -148,6 +156,7 @@ func TestComment_SetDeletedHard(t *testing.T) {
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
 		Pin:       true,
+		Revisions: []Revision{{Text: "old", EditorID: "userid"}},
 	}
 
 	comment.SetDeleted(HardDelete)
@@ -159,8 +168,67 @@ func TestComment_SetDeletedHard(t *testing.T) {
 	assert.Equal(t, 0, comment.Score)
 	assert.True(t, comment.Deleted)
 	assert.Nil(t, comment.Edit)
+	assert.Nil(t, comment.Revisions)
 	assert.False(t, comment.Pin)
 	assert.Equal(t, User{Name: "deleted", ID: "deleted", Picture: "", Admin: false, Blocked: false, IP: ""}, comment.User)
+	assert.Nil(t, comment.Retained, "hard-deleted comment can't be restored")
+}
+
+func TestComment_SetDeletedUser(t *testing.T) {
+	comment := Comment{
+		Text:      `blah`,
+		User:      User{ID: "userid", Name: "username", IP: "123", Picture: "pic"},
+		ID:        "123",
+		Locator:   Locator{SiteID: "site", URL: "url"},
+		Score:     10,
+		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
+		Votes:     map[string]bool{"uu": true},
+		Revisions: []Revision{{Text: "old", EditorID: "userid"}},
+	}
+
+	comment.SetDeleted(UserDelete)
+
+	assert.Equal(t, "", comment.Text)
+	assert.Equal(t, map[string]bool{}, comment.Votes)
+	assert.True(t, comment.Deleted)
+	assert.Nil(t, comment.Revisions, "previous versions removed")
+	assert.Nil(t, comment.Retained, "content deleted by the author not retained")
+	assert.Equal(t, User{Name: "username", ID: "userid", Picture: "pic", IP: "123"}, comment.User)
+	assert.EqualError(t, comment.Restore(), "comment 123 can't be restored")
+}
+
+func TestComment_Restore(t *testing.T) {
+	comment := Comment{
+		Text:      `blah`,
+		Orig:      "blah orig",
+		User:      User{ID: "userid", Name: "username", IP: "123", Picture: "pic"},
+		ID:        "123",
+		Locator:   Locator{SiteID: "site", URL: "url"},
+		Score:     10,
+		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
+		Votes:     map[string]bool{"uu": true},
+		VotedIPs:  map[string]VotedIPInfo{"uu-hash": {Value: true}},
+		Edit:      &Edit{Summary: "fix"},
+		Pin:       true,
+		Revisions: []Revision{{Text: "old", EditorID: "userid"}},
+	}
+	orig := comment
+	assert.EqualError(t, comment.Restore(), "comment 123 can't be restored", "not deleted")
+
+	comment.SetDeleted(SoftDelete)
+	require.NotNil(t, comment.Retained)
+	assert.Equal(t, "blah", comment.Retained.Text)
+	deletedAt := comment.Retained.DeletedAt
+	assert.WithinDuration(t, time.Now(), deletedAt, time.Second)
+
+	comment.SetDeleted(SoftDelete)
+	assert.Equal(t, deletedAt, comment.Retained.DeletedAt, "second delete keeps retained content")
+	assert.Equal(t, "blah", comment.Retained.Text)
+
+	require.NoError(t, comment.Restore())
+	orig.VotedIPs = map[string]VotedIPInfo{} // voters' ips not retained
+	assert.Equal(t, orig, comment)
+	assert.EqualError(t, comment.Restore(), "comment 123 can't be restored", "already restored")
 }
 
 func TestComment_Snippet(t *testing.T) {
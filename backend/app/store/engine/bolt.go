@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
@@ -17,33 +19,63 @@ import (
 
 // BoltDB implements store.Interface, represents multiple sites with multiplexing to different bolt dbs. Thread safe.
 // there are 6 types of top-level buckets:
-//  - comments for post in "posts" top-level bucket. Each url (post) makes its own bucket and each k:v pair is commentID:comment
-//  - history of all comments. They all in a single "last" bucket (per site) and key is defined by ref struct as ts+commentID
-//    value is not full comment but a reference combined from post-url+commentID
-//  - user to comment references in "users" bucket. It used to get comments for user. Key is userID and value
-//    is a nested bucket named userID with kv as ts:reference
-//  - users details in "user_details" bucket. Key is userID, value - UserDetailEntry
-//  - blocking info sits in "block" bucket. Key is userID, value - ts
-//  - counts per post to keep number of comments. Key is post url, value - count
-//  - readonly per post to keep status of manually set RO posts. Key is post url, value - ts
+//   - comments for post in "posts" top-level bucket. Each url (post) makes its own bucket and each k:v pair is commentID:comment
+//   - history of all comments. They all in a single "last" bucket (per site) and key is defined by ref struct as ts+commentID
+//     value is not full comment but a reference combined from post-url+commentID
+//   - user to comment references in "users" bucket. It used to get comments for user. Key is userID and value
+//     is a nested bucket named userID with kv as ts:reference
+//   - users details in "user_details" bucket. Key is userID, value - UserDetailEntry
+//   - blocking info sits in "block" bucket. Key is userID, value - ts
+//   - counts per post to keep number of comments. Key is post url, value - count
+//   - readonly per post to keep status of manually set RO posts. Key is post url, value - ts
+//   - full-text search index in "search" bucket. Key is search term and value is a nested bucket with kv as
+//     reference:searchEntry
+//   - comments waiting for moderation in "pending" bucket, key is ts and value is reference. Posts with
+//     pre-moderation enabled sit in "moderated" bucket, key is post url, value - ts
+//   - users' reports in "reports" bucket. Key is comment's reference and value is a nested bucket with kv as
+//     userID:report
+//   - admin actions in "audit" bucket. Key is ts+recordID, value - audit record. Kept on site's data removal
+//   - users' post subscriptions in "subscriptions" bucket. Key is post url and value is a nested bucket with kv as
+//     userID:subscription for the post and userID!!threadID:subscription for its threads
+//   - authors of posts in "post_authors" bucket. Key is post url + "!!" + userID, value - ts
+//   - admins' API tokens in "api_tokens" bucket. Key is token id, value - token with hash of its value.
+//     Kept on site's data removal
 type BoltDB struct {
-	dbs map[string]*bolt.DB
+	dbs     map[string]*bolt.DB
+	options bolt.Options
+	lock    sync.RWMutex // protects dbs, sites can be added and removed at runtime
 }
 
 const (
 	// top level buckets
-	postsBucketName       = "posts"
-	lastBucketName        = "last"
-	userBucketName        = "users"
-	userDetailsBucketName = "user_details"
-	blocksBucketName      = "block"
-	infoBucketName        = "info"
-	readonlyBucketName    = "readonly"
-	verifiedBucketName    = "verified"
+	postsBucketName         = "posts"
+	lastBucketName          = "last"
+	userBucketName          = "users"
+	userDetailsBucketName   = "user_details"
+	blocksBucketName        = "block"
+	infoBucketName          = "info"
+	readonlyBucketName      = "readonly"
+	verifiedBucketName      = "verified"
+	searchBucketName        = "search"
+	pendingBucketName       = "pending"
+	moderatedBucketName     = "moderated"
+	reportsBucketName       = "reports"
+	auditBucketName         = "audit"
+	shadowBannedBucketName  = "shadow_banned"
+	subscriptionsBucketName = "subscriptions"
+	postAuthorsBucketName   = "post_authors"
+	apiTokensBucketName     = "api_tokens"
+	moderatorsBucketName    = "moderators"
 
 	tsNano = "2006-01-02T15:04:05.000000000Z07:00"
 )
 
+// searchEntry is a value in search index, keeps fields used to filter search results
+type searchEntry struct {
+	UserID    string    `json:"user_id"`
+	Timestamp time.Time `json:"time"`
+}
+
 // BoltSite defines single site param
 type BoltSite struct {
 	FileName string // full path to boltdb
@@ -53,33 +85,86 @@ type BoltSite struct {
 // NewBoltDB makes persistent boltdb-based store. For each site new boltdb file created
 func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 	log.Printf("[INFO] bolt store for sites %+v, options %+v", sites, options)
-	result := BoltDB{dbs: make(map[string]*bolt.DB)}
+	result := BoltDB{dbs: make(map[string]*bolt.DB), options: options}
 	for _, site := range sites {
-		db, err := bolt.Open(site.FileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+		db, err := result.open(site)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to make boltdb for %s", site.FileName)
+			return nil, err
 		}
+		result.dbs[site.SiteID] = db
+		log.Printf("[DEBUG] bolt store created for %s", site.SiteID)
+	}
+	return &result, nil
+}
 
-		// make top-level buckets
-		topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
-			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName}
-		err = db.Update(func(tx *bolt.Tx) error {
-			for _, bktName := range topBuckets {
-				if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
-					return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
-				}
-			}
-			return nil
-		})
+// AddSite opens boltdb file for the site at runtime, the file created if not exists
+func (b *BoltDB) AddSite(site BoltSite) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.dbs[site.SiteID]; ok {
+		return errors.Errorf("site %q already opened", site.SiteID)
+	}
+	db, err := b.open(site)
+	if err != nil {
+		return err
+	}
+	b.dbs[site.SiteID] = db
+	log.Printf("[INFO] bolt store added for %s, %s", site.SiteID, site.FileName)
+	return nil
+}
 
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create top level bucket)")
+// RemoveSite closes boltdb file of the site at runtime and returns the file name. Data of the site kept in the file
+func (b *BoltDB) RemoveSite(siteID string) (fileName string, err error) {
+	b.lock.Lock()
+	db, ok := b.dbs[siteID]
+	delete(b.dbs, siteID)
+	b.lock.Unlock()
+	if !ok {
+		return "", errors.Errorf("site %q not found", siteID)
+	}
+	// close waits for active transactions, new requests rejected as the site already removed
+	fileName = db.Path()
+	if err = db.Close(); err != nil {
+		return "", errors.Wrapf(err, "can't close site %s", siteID)
+	}
+	log.Printf("[INFO] bolt store removed for %s, %s", siteID, fileName)
+	return fileName, nil
+}
+
+// open makes boltdb for the site with all top-level buckets
+func (b *BoltDB) open(site BoltSite) (*bolt.DB, error) {
+	options := b.options
+	db, err := bolt.Open(site.FileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", site.FileName)
+	}
+
+	// make top-level buckets
+	topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
+		blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName, pendingBucketName,
+		moderatedBucketName, reportsBucketName, auditBucketName, shadowBannedBucketName, subscriptionsBucketName,
+		postAuthorsBucketName, apiTokensBucketName, moderatorsBucketName}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range topBuckets {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		// search index added to existing db, build it from all stored comments
+		if tx.Bucket([]byte(searchBucketName)) == nil {
+			if _, e := tx.CreateBucket([]byte(searchBucketName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", searchBucketName)
+			}
+			return b.indexAll(tx, site.SiteID)
 		}
+		return nil
+	})
 
-		result.dbs[site.SiteID] = db
-		log.Printf("[DEBUG] bolt store created for %s", site.SiteID)
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create top level bucket)")
 	}
-	return &result, nil
+	return db, nil
 }
 
 // Create saves new comment to store. Adds to posts bucket, reference to last and user bucket and increments count bucket
@@ -109,6 +194,10 @@ func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 			return errors.Wrapf(err, "failed to put key %s to bucket %s", comment.ID, comment.Locator.URL)
 		}
 
+		if err = b.index(tx, comment); err != nil {
+			return errors.Wrapf(err, "failed to index comment %s", comment.ID)
+		}
+
 		ref := b.makeRef(comment) // reference combines url and comment id
 
 		// add reference to comment to "last" bucket
@@ -118,6 +207,13 @@ func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 			return errors.Wrapf(err, "can't put reference %s to %s", ref, lastBucketName)
 		}
 
+		// add reference to comment waiting for moderation to "pending" bucket
+		if comment.Pending {
+			if err = tx.Bucket([]byte(pendingBucketName)).Put(commentTS, ref); err != nil {
+				return errors.Wrapf(err, "can't put reference %s to %s", ref, pendingBucketName)
+			}
+		}
+
 		// add reference to commentID to "users" bucket
 		if userBkt, err = b.getUserBucket(tx, comment.User.ID); err != nil {
 			return errors.Wrapf(err, "can't get bucket %s", comment.User.ID)
@@ -165,6 +261,8 @@ func (b *BoltDB) Find(req FindRequest) (comments []store.Comment, err error) {
 	}
 
 	switch {
+	case req.Locator.SiteID != "" && req.Pending: // find comments waiting for moderation, for site or post
+		comments, err = b.pendingComments(req.Locator, req.Limit, req.Skip)
 	case req.Locator.SiteID != "" && req.Locator.URL != "": // find post comments, i.e. for site and url
 		err = bdb.View(func(tx *bolt.Tx) error {
 
@@ -237,7 +335,8 @@ func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 func (b *BoltDB) Update(comment store.Comment) error {
 
 	getReq := GetRequest{Locator: comment.Locator, CommentID: comment.ID}
-	if curComment, err := b.Get(getReq); err == nil {
+	curComment, curErr := b.Get(getReq)
+	if curErr == nil {
 		// preserve immutable fields
 		comment.ParentID = curComment.ParentID
 		comment.Locator = curComment.Locator
@@ -255,6 +354,38 @@ func (b *BoltDB) Update(comment store.Comment) error {
 		if e != nil {
 			return e
 		}
+		// reindex updated comment
+		if curErr == nil {
+			if e = b.unindex(tx, curComment); e != nil {
+				return errors.Wrapf(e, "failed to unindex comment %s", comment.ID)
+			}
+		}
+		if e = b.index(tx, comment); e != nil {
+			return errors.Wrapf(e, "failed to index comment %s", comment.ID)
+		}
+		// restored or approved comment counted again
+		if curErr == nil && counted(curComment) != counted(comment) {
+			delta := 1
+			if !counted(comment) {
+				delta = -1
+			}
+			if _, e = b.count(tx, comment.Locator.URL, delta); e != nil {
+				return errors.Wrapf(e, "failed to update count for %s", comment.Locator)
+			}
+		}
+		// sync moderation queue with pending status, deleted comments are not in the queue
+		if queued := comment.Pending && !comment.Deleted; curErr == nil && queued != (curComment.Pending && !curComment.Deleted) {
+			pendingBkt := tx.Bucket([]byte(pendingBucketName))
+			commentTS := []byte(comment.Timestamp.Format(tsNano))
+			if queued {
+				e = pendingBkt.Put(commentTS, b.makeRef(comment))
+			} else {
+				e = pendingBkt.Delete(commentTS)
+			}
+			if e != nil {
+				return errors.Wrapf(e, "failed to update %s for comment %s", pendingBucketName, comment.ID)
+			}
+		}
 		return b.save(bucket, comment.ID, comment)
 	})
 }
@@ -362,9 +493,12 @@ func (b *BoltDB) ListFlags(req FlagRequest) (res []interface{}, err error) {
 
 	res = []interface{}{}
 	switch req.Flag {
-	case Verified:
+	case Verified, ShadowBanned, Moderator:
 		err = bdb.View(func(tx *bolt.Tx) error {
-			usersBkt := tx.Bucket([]byte(verifiedBucketName))
+			usersBkt, e := b.flagBucket(tx, req.Flag)
+			if e != nil {
+				return e
+			}
 			_ = usersBkt.ForEach(func(k, _ []byte) error {
 				res = append(res, string(k))
 				return nil
@@ -372,6 +506,21 @@ func (b *BoltDB) ListFlags(req FlagRequest) (res []interface{}, err error) {
 			return nil
 		})
 		return res, err
+	case PostAuthor:
+		if req.Locator.URL == "" {
+			return nil, errors.New("post url required to list post authors")
+		}
+		err = bdb.View(func(tx *bolt.Tx) error {
+			prefix := []byte(req.Locator.URL + "!!")
+			c := tx.Bucket([]byte(postAuthorsBucketName)).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				if userID, ok := postAuthor(req.Locator.URL, string(k)); ok {
+					res = append(res, userID)
+				}
+			}
+			return nil
+		})
+		return res, err
 	case Blocked:
 		err = bdb.View(func(tx *bolt.Tx) error {
 			bucket := tx.Bucket([]byte(blocksBucketName))
@@ -398,6 +547,358 @@ func (b *BoltDB) ListFlags(req FlagRequest) (res []interface{}, err error) {
 	return nil, errors.Errorf("flag %s not listable", req.Flag)
 }
 
+// Search returns comments with all terms from the query, sorted from newest to oldest.
+// Uses "search" bucket and doesn't load comments which are not going to be returned.
+func (b *BoltDB) Search(req SearchRequest) (comments []store.Comment, err error) {
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	comments = []store.Comment{}
+	terms := SearchTerms(req.Query)
+	if len(terms) == 0 {
+		return comments, nil
+	}
+
+	// candidates are partially filled comments, with fields from reference and searchEntry only
+	candidates := []store.Comment{}
+	err = bdb.View(func(tx *bolt.Tx) error {
+		searchBkt := tx.Bucket([]byte(searchBucketName))
+		termBkts := make([]*bolt.Bucket, 0, len(terms))
+		for _, term := range terms {
+			termBkt := searchBkt.Bucket([]byte(term))
+			if termBkt == nil {
+				return nil // missing term, nothing to find
+			}
+			termBkts = append(termBkts, termBkt)
+		}
+
+		return termBkts[0].ForEach(func(k, v []byte) error {
+			for _, termBkt := range termBkts[1:] {
+				if termBkt.Get(k) == nil {
+					return nil
+				}
+			}
+			url, commentID, e := b.parseRef(k)
+			if e != nil {
+				return e
+			}
+			entry := searchEntry{}
+			if e = json.Unmarshal(v, &entry); e != nil {
+				return errors.Wrap(e, "failed to unmarshal")
+			}
+			c := store.Comment{ID: commentID, Locator: store.Locator{SiteID: req.Locator.SiteID, URL: url},
+				User: store.User{ID: entry.UserID}, Timestamp: entry.Timestamp}
+			if req.Matches(c) {
+				candidates = append(candidates, c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates = SortComments(candidates, "-time")
+	if req.Skip > 0 {
+		if req.Skip >= len(candidates) {
+			return comments, nil
+		}
+		candidates = candidates[req.Skip:]
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > searchLimit {
+		limit = searchLimit
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	for _, c := range candidates {
+		comment, e := b.Get(GetRequest{Locator: c.Locator, CommentID: c.ID})
+		if e != nil {
+			log.Printf("[WARN] can't load found comment %s from %s, %v", c.ID, c.Locator.URL, e)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+// Report adds user's report for the comment, lists reports for the comment or the whole site, or clears comment's reports.
+// Reports stored in nested bucket per comment, with reporter's user id as a key
+func (b *BoltDB) Report(req ReportRequest) (reports []store.Report, err error) {
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	reports = []store.Report{}
+	ref := b.makeRef(store.Comment{ID: req.CommentID, Locator: req.Locator})
+
+	switch {
+	case req.Add != nil:
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			postBkt, e := b.getPostBucket(tx, req.Locator.URL)
+			if e != nil {
+				return e
+			}
+			if postBkt.Get([]byte(req.CommentID)) == nil {
+				return errors.Errorf("no comment %s in store", req.CommentID)
+			}
+			reportBkt, e := tx.Bucket([]byte(reportsBucketName)).CreateBucketIfNotExists(ref)
+			if e != nil {
+				return errors.Wrapf(e, "can't make reports bucket for %s", ref)
+			}
+			if reportBkt.Get([]byte(req.Add.UserID)) != nil {
+				return errors.Errorf("user %s already reported comment %s", req.Add.UserID, req.CommentID)
+			}
+			return b.save(reportBkt, req.Add.UserID, req.Add)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return b.Report(ReportRequest{Locator: req.Locator, CommentID: req.CommentID})
+
+	case req.Clear:
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			reportsBkt := tx.Bucket([]byte(reportsBucketName))
+			if reportsBkt.Bucket(ref) == nil {
+				return nil
+			}
+			return errors.Wrapf(reportsBkt.DeleteBucket(ref), "can't delete reports of %s", ref)
+		})
+		return reports, err
+	}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		reportsBkt := tx.Bucket([]byte(reportsBucketName))
+		loadReports := func(bkt *bolt.Bucket) error {
+			return bkt.ForEach(func(k, v []byte) error {
+				report := store.Report{}
+				if e := json.Unmarshal(v, &report); e != nil {
+					return errors.Wrap(e, "failed to unmarshal report")
+				}
+				reports = append(reports, report)
+				return nil
+			})
+		}
+
+		if req.CommentID != "" { // reports for the comment
+			if reportBkt := reportsBkt.Bucket(ref); reportBkt != nil {
+				return loadReports(reportBkt)
+			}
+			return nil
+		}
+
+		return reportsBkt.ForEach(func(k, v []byte) error { // all reports for the site
+			if v != nil {
+				return nil
+			}
+			return loadReports(reportsBkt.Bucket(k))
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Timestamp.Before(reports[j].Timestamp) })
+	return reports, nil
+}
+
+// Audit adds admin action record or lists site's records, newest first
+func (b *BoltDB) Audit(req AuditRequest) (records []store.AuditRecord, err error) {
+	bdb, err := b.db(req.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Add != nil {
+		key := fmt.Sprintf("%s!%s", req.Add.Timestamp.Format(tsNano), req.Add.ID)
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			return b.save(tx.Bucket([]byte(auditBucketName)), key, req.Add)
+		})
+		return nil, errors.Wrapf(err, "can't add audit record %s", req.Add.ID)
+	}
+
+	records = []store.AuditRecord{}
+	err = bdb.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(auditBucketName)).Cursor()
+		skip := req.Skip
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rec := store.AuditRecord{}
+			if e := json.Unmarshal(v, &rec); e != nil {
+				return errors.Wrap(e, "failed to unmarshal audit record")
+			}
+			if !req.Matches(rec) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			records = append(records, rec)
+			if req.Limit > 0 && len(records) >= req.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Subscription sets or removes user's subscription to the post or the thread, lists subscriptions of the post or the user.
+// Subscriptions stored in nested bucket per post, with subscriber's user id and thread id as a key
+func (b *BoltDB) Subscription(req SubscriptionRequest) (subs []store.Subscription, err error) {
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Set != nil:
+		sub := *req.Set
+		sub.Locator = req.Locator
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			subsBkt, e := tx.Bucket([]byte(subscriptionsBucketName)).CreateBucketIfNotExists([]byte(req.Locator.URL))
+			if e != nil {
+				return errors.Wrapf(e, "can't make subscriptions bucket for %s", req.Locator.URL)
+			}
+			return b.save(subsBkt, subscriptionKey(sub.UserID, sub.ThreadID), sub)
+		})
+		return nil, err
+
+	case req.Remove:
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			if subsBkt := tx.Bucket([]byte(subscriptionsBucketName)).Bucket([]byte(req.Locator.URL)); subsBkt != nil {
+				key := subscriptionKey(req.UserID, req.ThreadID)
+				return errors.Wrapf(subsBkt.Delete([]byte(key)), "can't delete subscription of %s", req.UserID)
+			}
+			return nil
+		})
+		return nil, err
+	}
+
+	subs = []store.Subscription{}
+	err = bdb.View(func(tx *bolt.Tx) error {
+		subscriptionsBkt := tx.Bucket([]byte(subscriptionsBucketName))
+		loadSubs := func(bkt *bolt.Bucket) error {
+			return bkt.ForEach(func(_, v []byte) error {
+				sub := store.Subscription{}
+				if e := json.Unmarshal(v, &sub); e != nil {
+					return errors.Wrap(e, "failed to unmarshal subscription")
+				}
+				if req.UserID != "" && sub.UserID != req.UserID {
+					return nil
+				}
+				subs = append(subs, sub)
+				return nil
+			})
+		}
+
+		if req.Locator.URL != "" { // subscriptions of the post
+			if subsBkt := subscriptionsBkt.Bucket([]byte(req.Locator.URL)); subsBkt != nil {
+				return loadSubs(subsBkt)
+			}
+			return nil
+		}
+
+		return subscriptionsBkt.ForEach(func(k, v []byte) error { // all subscriptions of the site
+			if v != nil {
+				return nil
+			}
+			return loadSubs(subscriptionsBkt.Bucket(k))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Timestamp.Before(subs[j].Timestamp) })
+	return subs, nil
+}
+
+// APIToken adds or revokes admin's API token, lists tokens of the site or the token with given hash
+func (b *BoltDB) APIToken(req APITokenRequest) (tokens []store.APIToken, err error) {
+	bdb, err := b.db(req.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Add != nil:
+		tkn := *req.Add
+		tkn.SiteID = req.SiteID
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			return b.save(tx.Bucket([]byte(apiTokensBucketName)), tkn.ID, tkn)
+		})
+		return nil, err
+
+	case req.Revoke != "":
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			return errors.Wrapf(tx.Bucket([]byte(apiTokensBucketName)).Delete([]byte(req.Revoke)), "can't revoke token %s", req.Revoke)
+		})
+		return nil, err
+	}
+
+	tokens = []store.APIToken{}
+	err = bdb.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(apiTokensBucketName)).ForEach(func(_, v []byte) error {
+			tkn := store.APIToken{}
+			if e := json.Unmarshal(v, &tkn); e != nil {
+				return errors.Wrap(e, "failed to unmarshal api token")
+			}
+			if req.Hash == "" || tkn.Hash == req.Hash {
+				tokens = append(tokens, tkn)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Timestamp.Before(tokens[j].Timestamp) })
+	return tokens, nil
+}
+
+// deleteSubscriptions removes all user's subscriptions, to posts and to threads
+func (b *BoltDB) deleteSubscriptions(bdb *bolt.DB, userID string) error {
+	return bdb.Update(func(tx *bolt.Tx) error {
+		subscriptionsBkt := tx.Bucket([]byte(subscriptionsBucketName))
+		return subscriptionsBkt.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			subsBkt := subscriptionsBkt.Bucket(k)
+			keys := [][]byte{}
+			threadPrefix := []byte(userID + "!!")
+			err := subsBkt.ForEach(func(sk, _ []byte) error {
+				if string(sk) == userID || bytes.HasPrefix(sk, threadPrefix) {
+					keys = append(keys, sk)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, sk := range keys {
+				if err = subsBkt.Delete(sk); err != nil {
+					return errors.Wrapf(err, "can't delete subscription of %s to %s", userID, string(k))
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// subscriptionKey makes key of user's subscription to the post, or to the thread if threadID set
+func subscriptionKey(userID, threadID string) string {
+	if threadID == "" {
+		return userID
+	}
+	return userID + "!!" + threadID
+}
+
 // Delete post(s), user, comment, user details, or everything
 func (b *BoltDB) Delete(req DeleteRequest) error {
 
@@ -422,6 +923,8 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 
 // Close boltdb store
 func (b *BoltDB) Close() error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 	errs := new(multierror.Error)
 	for site, db := range b.dbs {
 		err := errors.Wrapf(db.Close(), "can't close site %s", site)
@@ -485,6 +988,50 @@ func (b *BoltDB) lastComments(siteID string, max int, since time.Time) (comments
 	return comments, err
 }
 
+// pendingComments returns comments waiting for moderation, oldest first. Empty locator's URL means all site's posts
+func (b *BoltDB) pendingComments(locator store.Locator, limit, skip int) (comments []store.Comment, err error) {
+
+	comments = []store.Comment{}
+
+	bdb, err := b.db(locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		pendingBkt := tx.Bucket([]byte(pendingBucketName))
+		return pendingBkt.ForEach(func(k, v []byte) error {
+			url, commentID, e := b.parseRef(v)
+			if e != nil {
+				return e
+			}
+			if locator.URL != "" && url != locator.URL {
+				return nil
+			}
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			if limit > 0 && len(comments) >= limit {
+				return nil
+			}
+			postBkt, e := b.getPostBucket(tx, url)
+			if e != nil {
+				return e
+			}
+			comment := store.Comment{}
+			if e = b.load(postBkt, commentID, &comment); e != nil {
+				log.Printf("[WARN] can't load comment for %s from store %s", commentID, url)
+				return nil
+			}
+			comments = append(comments, comment)
+			return nil
+		})
+	})
+
+	return comments, err
+}
+
 // userComments extracts all comments for given site and given userID
 // "users" bucket has sub-bucket for each userID, and keeps it as ts:ref
 func (b *BoltDB) userComments(siteID, userID string, limit, skip int) (comments []store.Comment, err error) {
@@ -550,10 +1097,7 @@ func (b *BoltDB) checkFlag(req FlagRequest) (val bool) {
 		return false
 	}
 
-	key := req.Locator.URL
-	if req.UserID != "" {
-		key = req.UserID
-	}
+	key := flagKey(req)
 
 	if req.Flag == Blocked {
 		var blocked bool
@@ -593,10 +1137,7 @@ func (b *BoltDB) setFlag(req FlagRequest) (res bool, err error) {
 		return false, e
 	}
 
-	key := req.Locator.URL
-	if req.UserID != "" {
-		key = req.UserID
-	}
+	key := flagKey(req)
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
 		var bucket *bolt.Bucket
@@ -642,6 +1183,14 @@ func (b *BoltDB) flagBucket(tx *bolt.Tx, flag Flag) (bkt *bolt.Bucket, err error
 		bkt = tx.Bucket([]byte(blocksBucketName))
 	case Verified:
 		bkt = tx.Bucket([]byte(verifiedBucketName))
+	case Moderated:
+		bkt = tx.Bucket([]byte(moderatedBucketName))
+	case ShadowBanned:
+		bkt = tx.Bucket([]byte(shadowBannedBucketName))
+	case PostAuthor:
+		bkt = tx.Bucket([]byte(postAuthorsBucketName))
+	case Moderator:
+		bkt = tx.Bucket([]byte(moderatorsBucketName))
 	default:
 		return nil, errors.Errorf("unsupported flag %v", flag)
 	}
@@ -804,13 +1353,17 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 			return errors.Wrapf(e, "can't load key %s from bucket %s", commentID, locator.URL)
 		}
 
-		if !comment.Deleted {
+		if counted(comment) {
 			// decrement comments count for post url
 			if _, e = b.count(tx, comment.Locator.URL, -1); e != nil {
 				return errors.Wrapf(e, "failed to decrement count for %s", comment.Locator)
 			}
 		}
 
+		if e = b.unindex(tx, comment); e != nil {
+			return errors.Wrapf(e, "failed to unindex comment %s", commentID)
+		}
+
 		// set deleted status and clear fields
 		comment.SetDeleted(mode)
 
@@ -824,6 +1377,14 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 			return errors.Wrapf(e, "can't delete key %s from bucket %s", commentID, lastBucketName)
 		}
 
+		// delete from "pending" bucket, deleted comment can't be approved
+		if comment.Pending {
+			pendingBkt := tx.Bucket([]byte(pendingBucketName))
+			if e = pendingBkt.Delete([]byte(comment.Timestamp.Format(tsNano))); e != nil {
+				return errors.Wrapf(e, "can't delete comment %s from bucket %s", commentID, pendingBucketName)
+			}
+		}
+
 		return nil
 	})
 }
@@ -832,7 +1393,8 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 func (b *BoltDB) deleteAll(bdb *bolt.DB, siteID string) error {
 
 	// delete all buckets except blocked users
-	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName}
+	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName,
+		searchBucketName, pendingBucketName, reportsBucketName, subscriptionsBucketName}
 
 	// delete top-level buckets
 	err := bdb.Update(func(tx *bolt.Tx) error {
@@ -920,9 +1482,74 @@ func (b *BoltDB) deleteUser(bdb *bolt.DB, siteID, userID string, mode store.Dele
 		return errors.Errorf("unknown user %s", userID)
 	}
 
+	if err = b.deleteSubscriptions(bdb, userID); err != nil {
+		return errors.Wrapf(err, "can't delete subscriptions of %s", userID)
+	}
 	return b.deleteUserDetail(bdb, userID, AllUserDetails)
 }
 
+// index adds comment's reference to search bucket for each term of comment's text. Should run in update tx
+func (b *BoltDB) index(tx *bolt.Tx, comment store.Comment) error {
+	if comment.Deleted {
+		return nil
+	}
+	searchBkt := tx.Bucket([]byte(searchBucketName))
+	ref := string(b.makeRef(comment))
+	entry := searchEntry{UserID: comment.User.ID, Timestamp: comment.Timestamp}
+	for _, term := range SearchTerms(comment.Text) {
+		termBkt, err := searchBkt.CreateBucketIfNotExists([]byte(term))
+		if err != nil {
+			return errors.Wrapf(err, "can't get search bucket %s", term)
+		}
+		if err = b.save(termBkt, ref, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindex removes comment's reference from search bucket, drops empty term buckets. Should run in update tx
+func (b *BoltDB) unindex(tx *bolt.Tx, comment store.Comment) error {
+	searchBkt := tx.Bucket([]byte(searchBucketName))
+	ref := b.makeRef(comment)
+	for _, term := range SearchTerms(comment.Text) {
+		termBkt := searchBkt.Bucket([]byte(term))
+		if termBkt == nil {
+			continue
+		}
+		if err := termBkt.Delete(ref); err != nil {
+			return errors.Wrapf(err, "can't delete %s from search bucket %s", ref, term)
+		}
+		if k, _ := termBkt.Cursor().First(); k == nil {
+			if err := searchBkt.DeleteBucket([]byte(term)); err != nil {
+				return errors.Wrapf(err, "can't delete empty search bucket %s", term)
+			}
+		}
+	}
+	return nil
+}
+
+// indexAll builds search index for all comments of the site. Should run in update tx
+func (b *BoltDB) indexAll(tx *bolt.Tx, siteID string) error {
+	count := 0
+	postsBkt := tx.Bucket([]byte(postsBucketName))
+	err := postsBkt.ForEach(func(postURL, _ []byte) error {
+		return postsBkt.Bucket(postURL).ForEach(func(_, v []byte) error {
+			comment := store.Comment{}
+			if e := json.Unmarshal(v, &comment); e != nil {
+				return errors.Wrap(e, "failed to unmarshal")
+			}
+			count++
+			return b.index(tx, comment)
+		})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to build search index for %s", siteID)
+	}
+	log.Printf("[INFO] search index built for %s, %d comments", siteID, count)
+	return nil
+}
+
 // getPostBucket return bucket with all comments for postURL
 func (b *BoltDB) getPostBucket(tx *bolt.Tx, postURL string) (*bolt.Bucket, error) {
 	postsBkt := tx.Bucket([]byte(postsBucketName))
@@ -1015,13 +1642,17 @@ func (b *BoltDB) setInfo(tx *bolt.Tx, comment store.Comment) (store.PostInfo, er
 			LastTS:  comment.Timestamp,
 		}
 	}
-	info.Count++
+	if counted(comment) {
+		info.Count++
+	}
 	info.LastTS = comment.Timestamp
 	err := b.save(infoBkt, comment.Locator.URL, &info)
 	return info, err
 }
 
 func (b *BoltDB) db(siteID string) (*bolt.DB, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 	if res, ok := b.dbs[siteID]; ok {
 		return res, nil
 	}
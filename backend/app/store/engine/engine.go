@@ -4,9 +4,12 @@ package engine
 // Includes default implementation with boltdb
 
 import (
+	"html"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/umputun/remark42/backend/app/store"
 )
@@ -16,15 +19,20 @@ import (
 
 // Interface defines methods provided by low-level storage engine
 type Interface interface {
-	Create(comment store.Comment) (commentID string, err error) // create new comment, avoid dups by id
-	Update(comment store.Comment) error                         // update comment, mutable parts only
-	Get(req GetRequest) (store.Comment, error)                  // get comment by id
-	Find(req FindRequest) ([]store.Comment, error)              // find comments for locator or site
-	Info(req InfoRequest) ([]store.PostInfo, error)             // get post(s) meta info
-	Count(req FindRequest) (int, error)                         // get count for post or user
-	Delete(req DeleteRequest) error                             // Delete post(s), user, comment, user details, or everything
-	Flag(req FlagRequest) (bool, error)                         // set and get flags
-	ListFlags(req FlagRequest) ([]interface{}, error)           // get list of flagged keys, like blocked & verified user
+	Create(comment store.Comment) (commentID string, err error)         // create new comment, avoid dups by id
+	Update(comment store.Comment) error                                 // update comment, mutable parts only
+	Get(req GetRequest) (store.Comment, error)                          // get comment by id
+	Find(req FindRequest) ([]store.Comment, error)                      // find comments for locator or site
+	Info(req InfoRequest) ([]store.PostInfo, error)                     // get post(s) meta info
+	Count(req FindRequest) (int, error)                                 // get count for post or user
+	Delete(req DeleteRequest) error                                     // Delete post(s), user, comment, user details, or everything
+	Flag(req FlagRequest) (bool, error)                                 // set and get flags
+	ListFlags(req FlagRequest) ([]interface{}, error)                   // get list of flagged keys, like blocked & verified user
+	Search(req SearchRequest) ([]store.Comment, error)                  // full-text search of comments for site
+	Report(req ReportRequest) ([]store.Report, error)                   // add, list or clear users' reports about comments
+	Audit(req AuditRequest) ([]store.AuditRecord, error)                // add or list records of admin actions
+	Subscription(req SubscriptionRequest) ([]store.Subscription, error) // set, remove or list users' post subscriptions
+	APIToken(req APITokenRequest) ([]store.APIToken, error)             // add, revoke or list admins' API tokens
 
 	// UserDetail sets or gets single detail value, or gets all details for requested site
 	// Returns list even for single entry request is a compromise in order to have both single detail getting and setting
@@ -48,6 +56,19 @@ type FindRequest struct {
 	Since   time.Time     `json:"since,omitempty"`   // time limit for found results
 	Limit   int           `json:"limit,omitempty"`
 	Skip    int           `json:"skip,omitempty"`
+	Pending bool          `json:"pending,omitempty"` // find comments waiting for moderation only, for site or post
+}
+
+// SearchRequest is the input for full-text search. All terms of the query should be present in found comments.
+// Results are sorted by time, from newest to oldest, deleted comments are never found.
+type SearchRequest struct {
+	Locator store.Locator `json:"locator"`           // lack of URL means site-wide search
+	Query   string        `json:"query"`             // text to search, split to terms
+	UserID  string        `json:"user_id,omitempty"` // limit search to comments of the user
+	From    time.Time     `json:"from,omitempty"`    // limit search to comments created after from
+	To      time.Time     `json:"to,omitempty"`      // limit search to comments created before to
+	Limit   int           `json:"limit,omitempty"`
+	Skip    int           `json:"skip,omitempty"`
 }
 
 // InfoRequest is the input of Info operation used to get meta data about posts
@@ -67,6 +88,51 @@ type DeleteRequest struct {
 	DeleteMode store.DeleteMode `json:"del_mode"`
 }
 
+// ReportRequest is the input for adding and listing of users' reports, as well as for clearing reports of the comment.
+// Adding and listing for the comment return all reports of the comment, listing for the site returns all site's reports.
+// Results are sorted by time, from oldest to newest
+type ReportRequest struct {
+	Locator   store.Locator `json:"locator"`              // lack of URL and CommentID means site's reports listing
+	CommentID string        `json:"comment_id,omitempty"` // comment to add, list or clear reports for
+	Add       *store.Report `json:"add,omitempty"`        // report to add, rejected if the user already reported the comment
+	Clear     bool          `json:"clear,omitempty"`      // remove all reports of the comment
+}
+
+// AuditRequest is the input for adding and listing of admin actions. Adding returns nothing and replaces record
+// with the same id, listing returns site's records filtered by actor, action, target and time range.
+// Results are sorted by time, from newest to oldest
+type AuditRequest struct {
+	SiteID string             `json:"site"`
+	Add    *store.AuditRecord `json:"add,omitempty"`    // record to add
+	Actor  string             `json:"actor,omitempty"`  // limit listing to actions of the admin
+	Action store.AuditAction  `json:"action,omitempty"` // limit listing to the action type
+	Target string             `json:"target,omitempty"` // limit listing to the target, like comment or user id
+	From   time.Time          `json:"from,omitempty"`   // limit listing to actions made after from
+	To     time.Time          `json:"to,omitempty"`     // limit listing to actions made before to
+	Limit  int                `json:"limit,omitempty"`  // no limit if 0
+	Skip   int                `json:"skip,omitempty"`
+}
+
+// SubscriptionRequest is the input for setting, removing and listing of users' post subscriptions.
+// Setting replaces user's subscription to the post or the thread, setting and removal return nothing.
+// Listing returns subscriptions of the post with its threads, or all user's subscriptions on the site if URL is not set
+type SubscriptionRequest struct {
+	Locator  store.Locator       `json:"locator"`
+	UserID   string              `json:"user_id,omitempty"`   // limit listing to the user, user to remove subscription of
+	ThreadID string              `json:"thread_id,omitempty"` // thread to remove subscription of, post if empty
+	Set      *store.Subscription `json:"set,omitempty"`       // subscription to set
+	Remove   bool                `json:"remove,omitempty"`    // remove user's subscription to the post or the thread
+}
+
+// APITokenRequest is the input for adding, revoking and listing of admins' API tokens. Adding and revoking
+// return nothing, listing returns all tokens of the site or the token with given hash, sorted by time
+type APITokenRequest struct {
+	SiteID string          `json:"site"`
+	Add    *store.APIToken `json:"add,omitempty"`    // token to add
+	Revoke string          `json:"revoke,omitempty"` // id of the token to remove
+	Hash   string          `json:"hash,omitempty"`   // limit listing to the token with the hash
+}
+
 // Flag defines type of binary attribute
 type Flag string
 
@@ -82,9 +148,17 @@ const (
 
 // Enum of all flags
 const (
-	ReadOnly = Flag("readonly")
-	Verified = Flag("verified")
-	Blocked  = Flag("blocked")
+	ReadOnly  = Flag("readonly")
+	Verified  = Flag("verified")
+	Blocked   = Flag("blocked")
+	Moderated = Flag("moderated")
+	// ShadowBanned user's new comments visible to the user and admins only
+	ShadowBanned = Flag("shadow_banned")
+	// PostAuthor user moderates comments of the post, set for both post url and user id.
+	// Listing returns user ids of authors of the post
+	PostAuthor = Flag("post_author")
+	// Moderator user has limited admin rights on the site, set at runtime in addition to server's options
+	Moderator = Flag("moderator")
 )
 
 // All possible user details
@@ -126,10 +200,100 @@ type UserDetailRequest struct {
 
 const (
 	// limits
-	lastLimit = 1000
-	userLimit = 500
+	lastLimit   = 1000
+	userLimit   = 500
+	searchLimit = 100
 )
 
+var reSearchTag = regexp.MustCompile(`<[^>]*>`)
+
+// flagKey returns key of the flag, user id for user's flags and post url for post's flags.
+// PostAuthor flag keyed by both post url and user id
+func flagKey(req FlagRequest) string {
+	switch {
+	case req.Flag == PostAuthor:
+		return req.Locator.URL + "!!" + req.UserID
+	case req.UserID != "":
+		return req.UserID
+	default:
+		return req.Locator.URL
+	}
+}
+
+// postAuthor returns user id from key of PostAuthor flag, ok is false if the key belongs to other post
+func postAuthor(url, key string) (userID string, ok bool) {
+	prefix := url + "!!"
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, prefix), true
+}
+
+// counted checks if comment included in post's count. Deleted and pending comments are not counted
+func counted(c store.Comment) bool {
+	return !c.Deleted && !c.Pending
+}
+
+// SearchTerms splits text to unique lowercase terms used by full-text search.
+// Html tags removed, terms shorter than 2 runes dropped.
+func SearchTerms(text string) []string {
+	text = html.UnescapeString(reSearchTag.ReplaceAllString(text, " "))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	res := []string{}
+	seen := map[string]bool{}
+	for _, w := range words {
+		if len([]rune(w)) < 2 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		res = append(res, w)
+	}
+	return res
+}
+
+// Matches checks if the comment matches all SearchRequest filters except of the query itself
+func (r SearchRequest) Matches(c store.Comment) bool {
+	if c.Deleted || c.Locator.SiteID != r.Locator.SiteID {
+		return false
+	}
+	if r.Locator.URL != "" && c.Locator.URL != r.Locator.URL {
+		return false
+	}
+	if r.UserID != "" && c.User.ID != r.UserID {
+		return false
+	}
+	if !r.From.IsZero() && c.Timestamp.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && c.Timestamp.After(r.To) {
+		return false
+	}
+	return true
+}
+
+// Matches checks if the record matches all AuditRequest filters
+func (r AuditRequest) Matches(rec store.AuditRecord) bool {
+	if r.Actor != "" && rec.Actor != r.Actor {
+		return false
+	}
+	if r.Action != "" && rec.Action != r.Action {
+		return false
+	}
+	if r.Target != "" && rec.Target != r.Target {
+		return false
+	}
+	if !r.From.IsZero() && rec.Timestamp.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && rec.Timestamp.After(r.To) {
+		return false
+	}
+	return true
+}
+
 // SortComments is for engines can't sort data internally
 func SortComments(comments []store.Comment, sortFld string) []store.Comment {
 	sort.Slice(comments, func(i, j int) bool {
@@ -12,6 +12,52 @@ type MockInterface struct {
 	mock.Mock
 }
 
+// APIToken provides a mock function with given fields: req
+func (_m *MockInterface) APIToken(req APITokenRequest) ([]store.APIToken, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.APIToken
+	if rf, ok := ret.Get(0).(func(APITokenRequest) []store.APIToken); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.APIToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(APITokenRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Audit provides a mock function with given fields: req
+func (_m *MockInterface) Audit(req AuditRequest) ([]store.AuditRecord, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.AuditRecord
+	if rf, ok := ret.Get(0).(func(AuditRequest) []store.AuditRecord); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(AuditRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *MockInterface) Close() error {
 	ret := _m.Called()
@@ -193,6 +239,75 @@ func (_m *MockInterface) ListFlags(req FlagRequest) ([]interface{}, error) {
 	return r0, r1
 }
 
+// Report provides a mock function with given fields: req
+func (_m *MockInterface) Report(req ReportRequest) ([]store.Report, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.Report
+	if rf, ok := ret.Get(0).(func(ReportRequest) []store.Report); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Report)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(ReportRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Search provides a mock function with given fields: req
+func (_m *MockInterface) Search(req SearchRequest) ([]store.Comment, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.Comment
+	if rf, ok := ret.Get(0).(func(SearchRequest) []store.Comment); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Comment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(SearchRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Subscription provides a mock function with given fields: req
+func (_m *MockInterface) Subscription(req SubscriptionRequest) ([]store.Subscription, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.Subscription
+	if rf, ok := ret.Get(0).(func(SubscriptionRequest) []store.Subscription); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Subscription)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(SubscriptionRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: comment
 func (_m *MockInterface) Update(comment store.Comment) error {
 	ret := _m.Called(comment)
@@ -53,3 +53,36 @@ func TestEngine_sortComments(t *testing.T) {
 	assert.Equal(t, "1", cc[2].ID)
 	assert.Equal(t, "4", cc[3].ID)
 }
+
+func TestEngine_SearchTerms(t *testing.T) {
+	tbl := []struct {
+		text string
+		res  []string
+	}{
+		{"", []string{}},
+		{"Some text, some TEXT!", []string{"some", "text"}},
+		{`<p>link to <a href="http://radio-t.com">Radio-T</a> &amp; a</p>`, []string{"link", "to", "radio"}},
+		{"Привет, мир 2021", []string{"привет", "мир", "2021"}},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, SearchTerms(tt.text), "case #%d", i)
+	}
+}
+
+func TestEngine_SearchRequestMatches(t *testing.T) {
+	c := store.Comment{ID: "1", Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"},
+		User: store.User{ID: "user1"}, Timestamp: time.Date(2018, 2, 5, 10, 1, 0, 0, time.Local)}
+
+	assert.True(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}}.Matches(c))
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "other"}}.Matches(c))
+	assert.True(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, UserID: "user1"}.Matches(c))
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}}.Matches(c))
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"}.Matches(c))
+	assert.True(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, From: c.Timestamp.Add(-time.Minute),
+		To: c.Timestamp.Add(time.Minute)}.Matches(c))
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, From: c.Timestamp.Add(time.Minute)}.Matches(c))
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, To: c.Timestamp.Add(-time.Minute)}.Matches(c))
+
+	c.Deleted = true
+	assert.False(t, SearchRequest{Locator: store.Locator{SiteID: "radio-t"}}.Matches(c))
+}
@@ -0,0 +1,1001 @@
+package engine
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Mongo implements store.Interface with mongodb. All sites share the same database,
+// so multiple remark42 instances can work with it at the same time. Thread safe.
+// There are 8 collections:
+//   - comments, each document is store.Comment, unique per site and comment id, as the same id can be used by
+//     different sites. Text index used for full-text search
+//   - posts, keeps post info (count, first and last ts) per site and url
+//   - flags, keeps readonly, moderated, verified, blocked, shadow_banned and post_author flags. Key is post url or user id,
+//     post url + "!!" + user id for post_author. Blocked flag has "until" field and ttl index removes expired blocks
+//   - user_details, keeps UserDetailEntry fields per site and user
+//   - reports, each document is store.Report, unique per comment and reporter
+//   - audit, each document is store.AuditRecord with _id set to record id. Kept on site's data removal
+//   - subscriptions, each document is store.Subscription, unique per post, thread and user
+//   - api_tokens, each document is store.APIToken with _id set to token id. Kept on site's data removal
+//
+// Note: mongo keeps timestamps with millisecond precision.
+type Mongo struct {
+	client  *mongo.Client
+	db      *mongo.Database
+	timeout time.Duration
+	sites   map[string]bool
+	lock    sync.RWMutex // protects sites, sites can be added and removed at runtime
+}
+
+const (
+	mongoComments      = "comments"
+	mongoPosts         = "posts"
+	mongoFlags         = "flags"
+	mongoUserDetails   = "user_details"
+	mongoReports       = "reports"
+	mongoAudit         = "audit"
+	mongoSubscriptions = "subscriptions"
+	mongoAPITokens     = "api_tokens"
+)
+
+// mongoPostInfo is a document of posts collection
+type mongoPostInfo struct {
+	SiteID         string `bson:"site"`
+	store.PostInfo `bson:",inline"`
+}
+
+// mongoFlag is a document of flags collection
+type mongoFlag struct {
+	SiteID string     `bson:"site"`
+	Flag   Flag       `bson:"flag"`
+	Key    string     `bson:"key"`
+	Until  *time.Time `bson:"until,omitempty"`
+}
+
+// mongoUserDetail is a document of user_details collection
+type mongoUserDetail struct {
+	SiteID   string `bson:"site"`
+	UserID   string `bson:"user_id"`
+	Email    string `bson:"email"`
+	Telegram string `bson:"telegram"`
+}
+
+// NewMongo makes mongo-based store for given url and database name, creates all required indexes
+func NewMongo(url, dbName string, timeout time.Duration, sites ...string) (*Mongo, error) {
+	log.Printf("[INFO] mongo store for db %s, sites %+v", dbName, sites)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to mongo")
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, errors.Wrap(err, "failed to ping mongo")
+	}
+
+	result := Mongo{client: client, db: client.Database(dbName), timeout: timeout, sites: make(map[string]bool)}
+	for _, site := range sites {
+		result.sites[site] = true
+	}
+
+	indexes := map[string][]mongo.IndexModel{
+		mongoComments: {
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "locator.url", Value: 1}, {Key: "time", Value: 1}}},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "user.id", Value: 1}, {Key: "time", Value: -1}}},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "time", Value: -1}}},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "pending", Value: 1}, {Key: "time", Value: 1}}},
+			{Keys: bson.D{{Key: "text", Value: "text"}}, Options: options.Index().SetDefaultLanguage("none")},
+		},
+		mongoPosts: {
+			{Keys: bson.D{{Key: "site", Value: 1}, {Key: "url", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		mongoFlags: {
+			{Keys: bson.D{{Key: "site", Value: 1}, {Key: "flag", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "until", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		mongoUserDetails: {
+			{Keys: bson.D{{Key: "site", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		mongoReports: {
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "locator.url", Value: 1}, {Key: "comment_id", Value: 1},
+				{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "time", Value: 1}}},
+		},
+		mongoAudit: {
+			{Keys: bson.D{{Key: "site", Value: 1}, {Key: "time", Value: -1}}},
+		},
+		mongoSubscriptions: {
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "locator.url", Value: 1}, {Key: "user_id", Value: 1},
+				{Key: "thread_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "locator.site", Value: 1}, {Key: "user_id", Value: 1}}},
+		},
+		mongoAPITokens: {
+			{Keys: bson.D{{Key: "site", Value: 1}, {Key: "hash", Value: 1}}},
+		},
+	}
+	for coll, models := range indexes {
+		if _, err = result.db.Collection(coll).Indexes().CreateMany(ctx, models); err != nil {
+			_ = client.Disconnect(ctx)
+			return nil, errors.Wrapf(err, "failed to create indexes for %s", coll)
+		}
+	}
+	return &result, nil
+}
+
+// Create saves new comment to store, rejects doubles and increments post count
+func (m *Mongo) Create(comment store.Comment) (commentID string, err error) {
+	if err = m.checkSite(comment.Locator.SiteID); err != nil {
+		return "", err
+	}
+
+	if m.checkFlag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly}) {
+		return "", errors.Errorf("post %s is read-only", comment.Locator.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	if _, err = m.db.Collection(mongoComments).InsertOne(ctx, comment); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", errors.Errorf("key %s already in store", comment.ID)
+		}
+		return "", errors.Wrapf(err, "failed to put key %s to %s", comment.ID, comment.Locator.URL)
+	}
+
+	// set info with the count for post url, pending comment counted after approval
+	inc := 0
+	if counted(comment) {
+		inc = 1
+	}
+	_, err = m.db.Collection(mongoPosts).UpdateOne(ctx,
+		bson.M{"site": comment.Locator.SiteID, "url": comment.Locator.URL},
+		bson.M{
+			"$inc":         bson.M{"count": inc},
+			"$set":         bson.M{"last_time": comment.Timestamp},
+			"$setOnInsert": bson.M{"first_time": comment.Timestamp},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to set info for %s", comment.Locator)
+	}
+	return comment.ID, nil
+}
+
+// Get returns comment for locator.URL and commentID string
+func (m *Mongo) Get(req GetRequest) (comment store.Comment, err error) {
+	if err = m.checkSite(req.Locator.SiteID); err != nil {
+		return comment, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	filter := bson.M{"id": req.CommentID, "locator.site": req.Locator.SiteID, "locator.url": req.Locator.URL}
+	if err = m.db.Collection(mongoComments).FindOne(ctx, filter).Decode(&comment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return comment, errors.Errorf("no value for %s", req.CommentID)
+		}
+		return comment, errors.Wrapf(err, "can't get comment %s", req.CommentID)
+	}
+	return comment, nil
+}
+
+// Find returns all comments for given request and sorts results
+func (m *Mongo) Find(req FindRequest) (comments []store.Comment, err error) {
+	if err = m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"locator.site": req.Locator.SiteID}
+	if !req.Since.IsZero() {
+		filter["time"] = bson.M{"$gt": req.Since}
+	}
+
+	switch {
+	case req.Locator.SiteID != "" && req.Pending: // find comments waiting for moderation, for site or post
+		if req.Locator.URL != "" {
+			filter["locator.url"] = req.Locator.URL
+		}
+		filter["pending"] = true
+		filter["delete"] = false
+		opts := options.Find().SetSort(bson.M{"time": 1}).SetLimit(int64(req.Limit)).SetSkip(int64(req.Skip))
+		comments, err = m.findComments(filter, opts)
+	case req.Locator.SiteID != "" && req.Locator.URL != "": // find post comments, i.e. for site and url
+		filter["locator.url"] = req.Locator.URL
+		comments, err = m.findComments(filter, options.Find().SetSort(bson.M{"time": 1}))
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.UserID == "": // find last comments for site
+		limit := req.Limit
+		if limit > lastLimit || limit == 0 {
+			limit = lastLimit
+		}
+		filter["delete"] = false
+		comments, err = m.findComments(filter, options.Find().SetSort(bson.M{"time": -1}).SetLimit(int64(limit)))
+	case req.Locator.SiteID != "" && req.UserID != "": // find comments for user
+		limit := req.Limit
+		if limit == 0 || limit > userLimit {
+			limit = userLimit
+		}
+		filter["user.id"] = req.UserID
+		opts := options.Find().SetSort(bson.M{"time": -1}).SetLimit(int64(limit)).SetSkip(int64(req.Skip))
+		comments, err = m.findComments(filter, opts)
+	default:
+		comments = []store.Comment{}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return SortComments(comments, req.Sort), nil
+}
+
+// Update for locator.URL with mutable part of comment
+func (m *Mongo) Update(comment store.Comment) error {
+	if err := m.checkSite(comment.Locator.SiteID); err != nil {
+		return err
+	}
+
+	curComment, err := m.Get(GetRequest{Locator: comment.Locator, CommentID: comment.ID})
+	if err != nil {
+		return errors.Wrapf(err, "can't update comment %s", comment.ID)
+	}
+	// preserve immutable fields
+	comment.ParentID = curComment.ParentID
+	comment.Locator = curComment.Locator
+	comment.Timestamp = curComment.Timestamp
+	comment.User = curComment.User
+
+	if counted(curComment) != counted(comment) {
+		// update comments count for post url, restored or approved comment counted again
+		inc := 1
+		if !counted(comment) {
+			inc = -1
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		_, err = m.db.Collection(mongoPosts).UpdateOne(ctx, bson.M{"site": comment.Locator.SiteID, "url": comment.Locator.URL},
+			bson.M{"$inc": bson.M{"count": inc}})
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "failed to update count for %s", comment.Locator)
+		}
+	}
+
+	return m.replaceComment(comment)
+}
+
+// Count returns number of comments for post or user
+func (m *Mongo) Count(req FindRequest) (count int, err error) {
+	if err = m.checkSite(req.Locator.SiteID); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	if req.Locator.URL != "" { // comment's count for post
+		info := mongoPostInfo{}
+		err = m.db.Collection(mongoPosts).FindOne(ctx, bson.M{"site": req.Locator.SiteID, "url": req.Locator.URL}).Decode(&info)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, errors.Wrapf(err, "can't get count for %s", req.Locator.URL)
+		}
+		return info.Count, nil
+	}
+
+	if req.UserID != "" { // comment's count for user
+		n, e := m.db.Collection(mongoComments).CountDocuments(ctx, bson.M{"locator.site": req.Locator.SiteID, "user.id": req.UserID})
+		if e != nil {
+			return 0, errors.Wrapf(e, "can't get count for user %s", req.UserID)
+		}
+		if n == 0 {
+			return 0, errors.Errorf("no comments for user %s in store for %s site", req.UserID, req.Locator.SiteID)
+		}
+		return int(n), nil
+	}
+
+	return 0, errors.Errorf("invalid count request %+v", req)
+}
+
+// Info get post(s) meta info
+func (m *Mongo) Info(req InfoRequest) ([]store.PostInfo, error) {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return []store.PostInfo{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	if req.Locator.URL != "" { // post info
+		rec := mongoPostInfo{}
+		err := m.db.Collection(mongoPosts).FindOne(ctx, bson.M{"site": req.Locator.SiteID, "url": req.Locator.URL}).Decode(&rec)
+		if err != nil {
+			return []store.PostInfo{}, errors.Wrapf(err, "can't load info for %s", req.Locator.URL)
+		}
+		info := rec.PostInfo
+
+		// set read-only from age and manual flag
+		readOnlyAge := req.ReadOnlyAge
+		info.ReadOnly = readOnlyAge > 0 && !info.FirstTS.IsZero() && info.FirstTS.AddDate(0, 0, readOnlyAge).Before(time.Now())
+		if m.checkFlag(FlagRequest{Locator: req.Locator, Flag: ReadOnly}) {
+			info.ReadOnly = true
+		}
+		return []store.PostInfo{info}, nil
+	}
+
+	if req.Locator.URL == "" && req.Locator.SiteID != "" { // site info (list)
+		opts := options.Find().SetSort(bson.M{"url": -1})
+		if req.Limit > 0 {
+			opts.SetLimit(int64(req.Limit))
+		}
+		if req.Skip > 0 {
+			opts.SetSkip(int64(req.Skip))
+		}
+		cursor, err := m.db.Collection(mongoPosts).Find(ctx, bson.M{"site": req.Locator.SiteID}, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't query info")
+		}
+		recs := []mongoPostInfo{}
+		if err = cursor.All(ctx, &recs); err != nil {
+			return nil, errors.Wrap(err, "can't decode info")
+		}
+		list := make([]store.PostInfo, 0, len(recs))
+		for _, r := range recs {
+			list = append(list, r.PostInfo)
+		}
+		return list, nil
+	}
+
+	return nil, errors.Errorf("invalid info request %+v", req)
+}
+
+// Flag sets and gets flag values
+func (m *Mongo) Flag(req FlagRequest) (val bool, err error) {
+	if err = m.checkSite(req.Locator.SiteID); err != nil {
+		return false, err
+	}
+
+	if req.Update == FlagNonSet { // read flag value, no update requested
+		return m.checkFlag(req), nil
+	}
+
+	// write flag value
+	return m.setFlag(req)
+}
+
+// ListFlags get list of flagged keys, like blocked & verified user
+func (m *Mongo) ListFlags(req FlagRequest) (res []interface{}, err error) {
+	if err = m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	res = []interface{}{}
+	switch req.Flag {
+	case Verified, Blocked, ShadowBanned, Moderator:
+		filter := bson.M{"site": req.Locator.SiteID, "flag": req.Flag}
+		if req.Flag == Blocked {
+			filter["until"] = bson.M{"$gt": time.Now()}
+		}
+		cursor, e := m.db.Collection(mongoFlags).Find(ctx, filter, options.Find().SetSort(bson.M{"key": 1}))
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't list %s", req.Flag)
+		}
+		flags := []mongoFlag{}
+		if e = cursor.All(ctx, &flags); e != nil {
+			return nil, errors.Wrapf(e, "can't decode %s", req.Flag)
+		}
+		for _, f := range flags {
+			if req.Flag != Blocked {
+				res = append(res, f.Key)
+				continue
+			}
+			// get user name from comment user section
+			blocked := store.BlockedUser{ID: f.Key, Until: *f.Until}
+			findReq := FindRequest{Locator: store.Locator{SiteID: req.Locator.SiteID}, UserID: f.Key, Limit: 1}
+			if userComments, errUser := m.Find(findReq); errUser == nil && len(userComments) > 0 {
+				blocked.Name = userComments[0].User.Name
+			}
+			res = append(res, blocked)
+		}
+		return res, nil
+	case PostAuthor:
+		if req.Locator.URL == "" {
+			return nil, errors.New("post url required to list post authors")
+		}
+		filter := bson.M{"site": req.Locator.SiteID, "flag": req.Flag,
+			"key": bson.M{"$regex": "^" + regexp.QuoteMeta(req.Locator.URL+"!!")}}
+		cursor, e := m.db.Collection(mongoFlags).Find(ctx, filter, options.Find().SetSort(bson.M{"key": 1}))
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't list %s", req.Flag)
+		}
+		flags := []mongoFlag{}
+		if e = cursor.All(ctx, &flags); e != nil {
+			return nil, errors.Wrapf(e, "can't decode %s", req.Flag)
+		}
+		for _, f := range flags {
+			if userID, ok := postAuthor(req.Locator.URL, f.Key); ok {
+				res = append(res, userID)
+			}
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("flag %s not listable", req.Flag)
+}
+
+// UserDetail sets or gets single detail value, or gets all details for requested site
+func (m *Mongo) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch req.Detail {
+	case UserEmail, UserTelegram:
+		if req.UserID == "" {
+			return nil, errors.New("userid cannot be empty in request for single detail")
+		}
+
+		if req.Update == "" { // read detail value, no update requested
+			return m.getUserDetail(req)
+		}
+
+		return m.setUserDetail(req)
+	case AllUserDetails:
+		// list of all details returned in case request is a read request
+		// (Update is not set) and does not have UserID
+		if req.Update == "" && req.UserID == "" { // read list of all details
+			return m.listDetails(req.Locator)
+		}
+		return nil, errors.New("unsupported request with userdetail all")
+	default:
+		return nil, errors.Errorf("unsupported detail %q", req.Detail)
+	}
+}
+
+// Search returns comments with all terms from the query, sorted from newest to oldest.
+// Each term passed to mongo's text search as a phrase, so all of them should be present.
+func (m *Mongo) Search(req SearchRequest) ([]store.Comment, error) {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	terms := SearchTerms(req.Query)
+	if len(terms) == 0 {
+		return []store.Comment{}, nil
+	}
+
+	filter := bson.M{
+		"$text":        bson.M{"$search": `"` + strings.Join(terms, `" "`) + `"`},
+		"locator.site": req.Locator.SiteID,
+		"delete":       false,
+	}
+	if req.Locator.URL != "" {
+		filter["locator.url"] = req.Locator.URL
+	}
+	if req.UserID != "" {
+		filter["user.id"] = req.UserID
+	}
+	timeFilter := bson.M{}
+	if !req.From.IsZero() {
+		timeFilter["$gte"] = req.From
+	}
+	if !req.To.IsZero() {
+		timeFilter["$lte"] = req.To
+	}
+	if len(timeFilter) > 0 {
+		filter["time"] = timeFilter
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > searchLimit {
+		limit = searchLimit
+	}
+	opts := options.Find().SetSort(bson.M{"time": -1}).SetLimit(int64(limit))
+	if req.Skip > 0 {
+		opts.SetSkip(int64(req.Skip))
+	}
+	return m.findComments(filter, opts)
+}
+
+// Report adds user's report for the comment, lists reports for the comment or the whole site, or clears comment's reports
+func (m *Mongo) Report(req ReportRequest) ([]store.Report, error) {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	commentFilter := bson.M{"locator.site": req.Locator.SiteID, "locator.url": req.Locator.URL, "comment_id": req.CommentID}
+
+	switch {
+	case req.Add != nil:
+		count, err := m.db.Collection(mongoComments).CountDocuments(ctx,
+			bson.M{"id": req.CommentID, "locator.site": req.Locator.SiteID, "locator.url": req.Locator.URL})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check comment %s", req.CommentID)
+		}
+		if count == 0 {
+			return nil, errors.Errorf("no comment %s in store", req.CommentID)
+		}
+		report := *req.Add
+		report.Locator, report.CommentID = req.Locator, req.CommentID
+		if _, err = m.db.Collection(mongoReports).InsertOne(ctx, report); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, errors.Errorf("user %s already reported comment %s", req.Add.UserID, req.CommentID)
+			}
+			return nil, errors.Wrapf(err, "failed to add report for %s", req.CommentID)
+		}
+		return m.findReports(ctx, commentFilter)
+	case req.Clear:
+		_, err := m.db.Collection(mongoReports).DeleteMany(ctx, commentFilter)
+		return []store.Report{}, errors.Wrapf(err, "can't delete reports of %s", req.CommentID)
+	case req.CommentID != "":
+		return m.findReports(ctx, commentFilter)
+	}
+	return m.findReports(ctx, bson.M{"locator.site": req.Locator.SiteID})
+}
+
+func (m *Mongo) findReports(ctx context.Context, filter bson.M) ([]store.Report, error) {
+	cursor, err := m.db.Collection(mongoReports).Find(ctx, filter, options.Find().SetSort(bson.M{"time": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query reports")
+	}
+	reports := []store.Report{}
+	if err = cursor.All(ctx, &reports); err != nil {
+		return nil, errors.Wrap(err, "can't decode reports")
+	}
+	return reports, nil
+}
+
+// Subscription sets or removes user's subscription to the post or the thread, lists subscriptions of the post or the user
+func (m *Mongo) Subscription(req SubscriptionRequest) ([]store.Subscription, error) {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	switch {
+	case req.Set != nil:
+		sub := *req.Set
+		sub.Locator = req.Locator
+		_, err := m.db.Collection(mongoSubscriptions).ReplaceOne(ctx,
+			bson.M{"locator.site": req.Locator.SiteID, "locator.url": req.Locator.URL, "user_id": sub.UserID, "thread_id": sub.ThreadID},
+			sub, options.Replace().SetUpsert(true))
+		return nil, errors.Wrapf(err, "failed to set subscription of %s", sub.UserID)
+	case req.Remove:
+		_, err := m.db.Collection(mongoSubscriptions).DeleteOne(ctx,
+			bson.M{"locator.site": req.Locator.SiteID, "locator.url": req.Locator.URL, "user_id": req.UserID, "thread_id": req.ThreadID})
+		return nil, errors.Wrapf(err, "can't delete subscription of %s", req.UserID)
+	}
+
+	filter := bson.M{"locator.site": req.Locator.SiteID}
+	if req.Locator.URL != "" {
+		filter["locator.url"] = req.Locator.URL
+	}
+	if req.UserID != "" {
+		filter["user_id"] = req.UserID
+	}
+	cursor, err := m.db.Collection(mongoSubscriptions).Find(ctx, filter, options.Find().SetSort(bson.M{"time": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query subscriptions")
+	}
+	subs := []store.Subscription{}
+	if err = cursor.All(ctx, &subs); err != nil {
+		return nil, errors.Wrap(err, "can't decode subscriptions")
+	}
+	return subs, nil
+}
+
+// APIToken adds or revokes admin's API token, lists tokens of the site or the token with given hash
+func (m *Mongo) APIToken(req APITokenRequest) ([]store.APIToken, error) {
+	if err := m.checkSite(req.SiteID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	switch {
+	case req.Add != nil:
+		tkn := *req.Add
+		tkn.SiteID = req.SiteID
+		_, err := m.db.Collection(mongoAPITokens).ReplaceOne(ctx, bson.M{"_id": tkn.ID}, tkn, options.Replace().SetUpsert(true))
+		return nil, errors.Wrapf(err, "failed to add token %s", tkn.ID)
+	case req.Revoke != "":
+		_, err := m.db.Collection(mongoAPITokens).DeleteOne(ctx, bson.M{"_id": req.Revoke, "site": req.SiteID})
+		return nil, errors.Wrapf(err, "can't revoke token %s", req.Revoke)
+	}
+
+	filter := bson.M{"site": req.SiteID}
+	if req.Hash != "" {
+		filter["hash"] = req.Hash
+	}
+	cursor, err := m.db.Collection(mongoAPITokens).Find(ctx, filter, options.Find().SetSort(bson.M{"time": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query api tokens")
+	}
+	tokens := []store.APIToken{}
+	if err = cursor.All(ctx, &tokens); err != nil {
+		return nil, errors.Wrap(err, "can't decode api tokens")
+	}
+	return tokens, nil
+}
+
+// Audit adds admin action record or lists site's records, newest first
+func (m *Mongo) Audit(req AuditRequest) ([]store.AuditRecord, error) {
+	if err := m.checkSite(req.SiteID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	if req.Add != nil {
+		rec := *req.Add
+		rec.SiteID = req.SiteID
+		_, err := m.db.Collection(mongoAudit).ReplaceOne(ctx, bson.M{"_id": rec.ID}, rec, options.Replace().SetUpsert(true))
+		return nil, errors.Wrapf(err, "can't add audit record %s", rec.ID)
+	}
+
+	filter := bson.M{"site": req.SiteID}
+	if req.Actor != "" {
+		filter["actor"] = req.Actor
+	}
+	if req.Action != "" {
+		filter["action"] = req.Action
+	}
+	if req.Target != "" {
+		filter["target"] = req.Target
+	}
+	if !req.From.IsZero() || !req.To.IsZero() {
+		ts := bson.M{}
+		if !req.From.IsZero() {
+			ts["$gte"] = req.From
+		}
+		if !req.To.IsZero() {
+			ts["$lte"] = req.To
+		}
+		filter["time"] = ts
+	}
+
+	opts := options.Find().SetSort(bson.M{"time": -1}).SetSkip(int64(req.Skip))
+	if req.Limit > 0 {
+		opts.SetLimit(int64(req.Limit))
+	}
+	cursor, err := m.db.Collection(mongoAudit).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query audit")
+	}
+	records := []store.AuditRecord{}
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, errors.Wrap(err, "can't decode audit records")
+	}
+	return records, nil
+}
+
+// Delete post(s), user, comment, user details, or everything
+func (m *Mongo) Delete(req DeleteRequest) error {
+	if err := m.checkSite(req.Locator.SiteID); err != nil {
+		return err
+	}
+
+	switch {
+	case req.UserDetail != "": // delete user detail
+		return m.deleteUserDetail(req.Locator.SiteID, req.UserID, req.UserDetail)
+	case req.Locator.URL != "" && req.CommentID != "" && req.UserDetail == "": // delete comment
+		return m.deleteComment(req.Locator, req.CommentID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.UserID != "" && req.CommentID == "" && req.UserDetail == "": // delete user
+		return m.deleteUser(req.Locator.SiteID, req.UserID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete site
+		return m.deleteAll(req.Locator.SiteID)
+	}
+
+	return errors.Errorf("invalid delete request %+v", req)
+}
+
+// Close mongo store
+func (m *Mongo) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	return errors.Wrap(m.client.Disconnect(ctx), "can't close mongo")
+}
+
+func (m *Mongo) findComments(filter bson.M, opts *options.FindOptions) ([]store.Comment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	cursor, err := m.db.Collection(mongoComments).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query comments")
+	}
+	comments := []store.Comment{}
+	if err = cursor.All(ctx, &comments); err != nil {
+		return nil, errors.Wrap(err, "can't decode comments")
+	}
+	return comments, nil
+}
+
+func (m *Mongo) replaceComment(comment store.Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	filter := bson.M{"id": comment.ID, "locator.site": comment.Locator.SiteID, "locator.url": comment.Locator.URL}
+	res, err := m.db.Collection(mongoComments).ReplaceOne(ctx, filter, comment)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save comment %s", comment.ID)
+	}
+	if res.MatchedCount == 0 {
+		return errors.Errorf("no value for %s", comment.ID)
+	}
+	return nil
+}
+
+func (m *Mongo) checkFlag(req FlagRequest) bool {
+	key := flagKey(req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	flag := mongoFlag{}
+	err := m.db.Collection(mongoFlags).FindOne(ctx, bson.M{"site": req.Locator.SiteID, "flag": req.Flag, "key": key}).Decode(&flag)
+	if err != nil {
+		return false
+	}
+	if req.Flag == Blocked {
+		// ttl index cleans expired blocks with a delay, so until should be checked
+		return flag.Until != nil && time.Now().Before(*flag.Until)
+	}
+	return true
+}
+
+func (m *Mongo) setFlag(req FlagRequest) (res bool, err error) {
+	switch req.Flag {
+	case ReadOnly, Verified, Blocked, Moderated, ShadowBanned, PostAuthor, Moderator:
+	default:
+		return false, errors.Errorf("unsupported flag %v", req.Flag)
+	}
+
+	key := flagKey(req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	filter := bson.M{"site": req.Locator.SiteID, "flag": req.Flag, "key": key}
+	switch req.Update {
+	case FlagTrue:
+		flag := mongoFlag{SiteID: req.Locator.SiteID, Flag: req.Flag, Key: key}
+		if req.Flag == Blocked {
+			until := time.Now().AddDate(100, 0, 0) // permanent is 100 year
+			if req.TTL > 0 {
+				until = time.Now().Add(req.TTL)
+			}
+			flag.Until = &until
+		}
+		if _, err = m.db.Collection(mongoFlags).ReplaceOne(ctx, filter, flag, options.Replace().SetUpsert(true)); err != nil {
+			return false, errors.Wrapf(err, "failed to set flag %s for %s", req.Flag, key)
+		}
+		return true, nil
+	case FlagFalse:
+		if _, err = m.db.Collection(mongoFlags).DeleteOne(ctx, filter); err != nil {
+			return false, errors.Wrapf(err, "failed to clean flag %s for %s", req.Flag, key)
+		}
+	}
+	return false, nil
+}
+
+// getUserDetail returns UserDetailEntry with requested userDetail (omitting other details)
+// as an only element of the slice.
+func (m *Mongo) getUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	rec := mongoUserDetail{}
+	err := m.db.Collection(mongoUserDetails).FindOne(ctx, bson.M{"site": req.Locator.SiteID, "user_id": req.UserID}).Decode(&rec)
+	if err != nil {
+		if err == mongo.ErrNoDocuments { // return no error in case of absent entry
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "can't get detail %s for %s", req.Detail, req.UserID)
+	}
+
+	switch req.Detail {
+	case UserEmail:
+		return []UserDetailEntry{{UserID: req.UserID, Email: rec.Email}}, nil
+	case UserTelegram:
+		return []UserDetailEntry{{UserID: req.UserID, Telegram: rec.Telegram}}, nil
+	}
+	return nil, nil
+}
+
+// setUserDetail sets requested userDetail, returning complete updated UserDetailEntry
+// as an only element of the slice in case of success
+func (m *Mongo) setUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	field, other := "email", "telegram"
+	if req.Detail == UserTelegram {
+		field, other = "telegram", "email"
+	}
+
+	rec := mongoUserDetail{}
+	err := m.db.Collection(mongoUserDetails).FindOneAndUpdate(ctx,
+		bson.M{"site": req.Locator.SiteID, "user_id": req.UserID},
+		bson.M{"$set": bson.M{field: req.Update}, "$setOnInsert": bson.M{other: ""}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&rec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update detail %s for %s in %s", req.Detail, req.UserID, req.Locator.SiteID)
+	}
+	return []UserDetailEntry{{UserID: rec.UserID, Email: rec.Email, Telegram: rec.Telegram}}, nil
+}
+
+// listDetails lists all available users details for given site
+func (m *Mongo) listDetails(loc store.Locator) ([]UserDetailEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	cursor, err := m.db.Collection(mongoUserDetails).Find(ctx, bson.M{"site": loc.SiteID}, options.Find().SetSort(bson.M{"user_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list user details")
+	}
+	recs := []mongoUserDetail{}
+	if err = cursor.All(ctx, &recs); err != nil {
+		return nil, errors.Wrap(err, "can't decode user details")
+	}
+
+	var result []UserDetailEntry
+	for _, r := range recs {
+		result = append(result, UserDetailEntry{UserID: r.UserID, Email: r.Email, Telegram: r.Telegram})
+	}
+	return result, nil
+}
+
+// deleteUserDetail deletes requested UserDetail or whole UserDetailEntry.
+// Entry without any non-empty details removed.
+func (m *Mongo) deleteUserDetail(siteID, userID string, userDetail UserDetail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	filter := bson.M{"site": siteID, "user_id": userID}
+	coll := m.db.Collection(mongoUserDetails)
+	var err error
+	switch userDetail {
+	case UserEmail:
+		_, err = coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"email": ""}})
+	case UserTelegram:
+		_, err = coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"telegram": ""}})
+	case AllUserDetails:
+		_, err = coll.DeleteOne(ctx, filter)
+	default:
+		return errors.Errorf("unsupported detail %q", userDetail)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+	}
+
+	_, err = coll.DeleteOne(ctx, bson.M{"site": siteID, "user_id": userID, "email": "", "telegram": ""})
+	return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+}
+
+func (m *Mongo) deleteComment(locator store.Locator, commentID string, mode store.DeleteMode) error {
+	comment, err := m.Get(GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return errors.Wrapf(err, "can't load key %s from %s", commentID, locator.URL)
+	}
+
+	if counted(comment) {
+		// decrement comments count for post url
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		_, err = m.db.Collection(mongoPosts).UpdateOne(ctx, bson.M{"site": locator.SiteID, "url": locator.URL},
+			bson.M{"$inc": bson.M{"count": -1}})
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrement count for %s", locator)
+		}
+	}
+
+	// set deleted status and clear fields
+	comment.SetDeleted(mode)
+	return errors.Wrapf(m.replaceComment(comment), "can't save deleted comment for key %s from %s", commentID, locator.URL)
+}
+
+// deleteUser marks all comments of given user as deleted and removes user details
+func (m *Mongo) deleteUser(siteID, userID string, mode store.DeleteMode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	cursor, err := m.db.Collection(mongoComments).Find(ctx, bson.M{"locator.site": siteID, "user.id": userID},
+		options.Find().SetProjection(bson.M{"id": 1, "locator": 1}))
+	if err != nil {
+		return errors.Wrapf(err, "can't get comments of %s", userID)
+	}
+	comments := []store.Comment{}
+	if err = cursor.All(ctx, &comments); err != nil {
+		return errors.Wrapf(err, "can't decode comments of %s", userID)
+	}
+
+	log.Printf("[DEBUG] comments for removal=%d", len(comments))
+
+	for _, c := range comments {
+		if e := m.deleteComment(c.Locator, c.ID, mode); e != nil {
+			return errors.Wrapf(e, "failed to delete comment %s", c.ID)
+		}
+	}
+
+	if len(comments) == 0 {
+		return errors.Errorf("unknown user %s", userID)
+	}
+
+	if _, err = m.db.Collection(mongoSubscriptions).DeleteMany(ctx, bson.M{"locator.site": siteID, "user_id": userID}); err != nil {
+		return errors.Wrapf(err, "can't delete subscriptions of %s", userID)
+	}
+	return m.deleteUserDetail(siteID, userID, AllUserDetails)
+}
+
+// deleteAll removes all comments, posts, reports, subscriptions and user details for given siteID, flags are kept
+func (m *Mongo) deleteAll(siteID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	filters := map[string]bson.M{
+		mongoComments:      {"locator.site": siteID},
+		mongoPosts:         {"site": siteID},
+		mongoUserDetails:   {"site": siteID},
+		mongoReports:       {"locator.site": siteID},
+		mongoSubscriptions: {"locator.site": siteID},
+	}
+	for coll, filter := range filters {
+		if _, err := m.db.Collection(coll).DeleteMany(ctx, filter); err != nil {
+			return errors.Wrapf(err, "failed to delete %s for site %s", coll, siteID)
+		}
+	}
+	return nil
+}
+
+// AddSite makes the site available at runtime, data of the site kept in the shared db
+func (m *Mongo) AddSite(siteID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.sites[siteID] {
+		return errors.Errorf("site %q already opened", siteID)
+	}
+	m.sites[siteID] = true
+	return nil
+}
+
+// RemoveSite makes the site unavailable at runtime, data of the site kept in the shared db
+func (m *Mongo) RemoveSite(siteID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.sites[siteID] {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	delete(m.sites, siteID)
+	return nil
+}
+
+func (m *Mongo) checkSite(siteID string) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if !m.sites[siteID] {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	return nil
+}
@@ -104,6 +104,56 @@ func (r *RPC) Count(req FindRequest) (count int, err error) {
 	return count, err
 }
 
+// Search comments with full-text query
+func (r *RPC) Search(req SearchRequest) (comments []store.Comment, err error) {
+	resp, err := r.Call("store.search", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &comments)
+	return comments, err
+}
+
+// Report adds, lists or clears users' reports
+func (r *RPC) Report(req ReportRequest) (reports []store.Report, err error) {
+	resp, err := r.Call("store.report", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &reports)
+	return reports, err
+}
+
+// Audit adds admin action record or lists site's records
+func (r *RPC) Audit(req AuditRequest) (records []store.AuditRecord, err error) {
+	resp, err := r.Call("store.audit", req)
+	if err != nil || resp.Result == nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &records)
+	return records, err
+}
+
+// Subscription sets, removes or lists users' post subscriptions
+func (r *RPC) Subscription(req SubscriptionRequest) (subs []store.Subscription, err error) {
+	resp, err := r.Call("store.subscription", req)
+	if err != nil || resp.Result == nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &subs)
+	return subs, err
+}
+
+// APIToken adds, revokes or lists admins' API tokens
+func (r *RPC) APIToken(req APITokenRequest) (tokens []store.APIToken, err error) {
+	resp, err := r.Call("store.api_token", req)
+	if err != nil || resp.Result == nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &tokens)
+	return tokens, err
+}
+
 // Delete post(s), user, comment, user details, or everything
 func (r *RPC) Delete(req DeleteRequest) error {
 	_, err := r.Call("store.delete", req)
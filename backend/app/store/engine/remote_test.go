@@ -108,6 +108,57 @@ func TestRemote_Find(t *testing.T) {
 	assert.Equal(t, []store.Comment{{Text: "1"}, {Text: "2"}}, res)
 }
 
+func TestRemote_Search(t *testing.T) {
+	ts := testServer(t, `{"method":"store.search","params":{"locator":{"site":"site","url":""},"query":"some text","user_id":"user1","from":"0001-01-01T00:00:00Z","to":"0001-01-01T00:00:00Z","limit":10},"id":1}`, `{"result":[{"text":"some text 1"},{"text":"some text 2"}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Search(SearchRequest{Locator: store.Locator{SiteID: "site"}, Query: "some text", UserID: "user1", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Comment{{Text: "some text 1"}, {Text: "some text 2"}}, res)
+}
+
+func TestRemote_Report(t *testing.T) {
+	ts := testServer(t, `{"method":"store.report","params":{"locator":{"site":"site","url":"u"},"comment_id":"c1","add":{"locator":{"url":""},"comment_id":"","user_id":"u1","reason":"spam","time":"0001-01-01T00:00:00Z"}},"id":1}`, `{"result":[{"comment_id":"c1","user_id":"u1","reason":"spam"}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Report(ReportRequest{Locator: store.Locator{SiteID: "site", URL: "u"}, CommentID: "c1",
+		Add: &store.Report{UserID: "u1", Reason: store.ReportSpam}})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Report{{CommentID: "c1", UserID: "u1", Reason: store.ReportSpam}}, res)
+}
+
+func TestRemote_Audit(t *testing.T) {
+	ts := testServer(t, `{"method":"store.audit","params":{"site":"site","actor":"a1","from":"0001-01-01T00:00:00Z","to":"0001-01-01T00:00:00Z","limit":10},"id":1}`, `{"result":[{"id":"r1","site":"site","actor":"a1","action":"pin","target":"c1","time":"0001-01-01T00:00:00Z"}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Audit(AuditRequest{SiteID: "site", Actor: "a1", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.AuditRecord{{ID: "r1", SiteID: "site", Actor: "a1", Action: store.AuditPin, Target: "c1"}}, res)
+}
+
+func TestRemote_Subscription(t *testing.T) {
+	ts := testServer(t, `{"method":"store.subscription","params":{"locator":{"site":"site","url":"u"}},"id":1}`, `{"result":[{"locator":{"site":"site","url":"u"},"user_id":"u1","mode":"post","time":"0001-01-01T00:00:00Z"}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Subscription(SubscriptionRequest{Locator: store.Locator{SiteID: "site", URL: "u"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Subscription{{Locator: store.Locator{SiteID: "site", URL: "u"}, UserID: "u1", Mode: store.SubscriptionPost}}, res)
+}
+
+func TestRemote_APIToken(t *testing.T) {
+	ts := testServer(t, `{"method":"store.api_token","params":{"site":"site","hash":"h1"},"id":1}`, `{"result":[{"id":"t1","site":"site","name":"ci","user_id":"a1","hash":"h1","time":"0001-01-01T00:00:00Z"}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.APIToken(APITokenRequest{SiteID: "site", Hash: "h1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.APIToken{{ID: "t1", SiteID: "site", Name: "ci", UserID: "a1", Hash: "h1"}}, res)
+}
+
 func TestRemote_Info(t *testing.T) {
 	ts := testServer(t, `{"method":"store.info","params":{"locator":{"url":"http://example.com/url"},"limit":10,"skip":5,"ro_age":10},"id":1}`, `{"result":[{"url":"u1","count":22},{"url":"u2","count":33}]}`)
 	defer ts.Close()
@@ -0,0 +1,1036 @@
+package engine
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	_ "github.com/mattn/go-sqlite3" // sqlite driver registration
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// SQLite implements store.Interface with a single sqlite file for all sites. Thread safe.
+// Each table keeps site column, so all sites share the same db and separated by site id.
+//   - comments table keeps serialized comment in data column, plus denormalized locator, user, ts, deleted and
+//     pending columns used for lookups. Indexed by locator (site+url), user (site+user_id+ts), ts (site+ts)
+//     and pending (site+pending+ts).
+//     Search column keeps space-separated search terms of the comment's text, used by full-text search
+//   - flags table keeps readonly, moderated, verified, blocked, shadow_banned and post_author flags. Key is post url or user id,
+//     post url + "!!" + user id for post_author, until is expiration ts
+//   - reports table keeps serialized users' reports in data column, one per comment and user
+//   - audit table keeps serialized admin actions in data column, plus actor, action, target and ts used by filters.
+//     Kept on site's data removal
+//   - subscriptions table keeps serialized users' post subscriptions in data column, one per post, thread and user
+//   - api_tokens table keeps serialized admins' API tokens in data column, plus hash used by lookup.
+//     Kept on site's data removal
+//   - user_details table keeps UserDetailEntry fields per site and user
+//
+// Post info (count, first and last ts) calculated from comments table and not stored separately.
+type SQLite struct {
+	db    *sql.DB
+	sites map[string]bool
+	lock  sync.RWMutex // protects sites, sites can be added and removed at runtime
+}
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS comments (
+		site TEXT NOT NULL,
+		url TEXT NOT NULL,
+		id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		deleted INTEGER NOT NULL DEFAULT 0,
+		pending INTEGER NOT NULL DEFAULT 0,
+		search TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL,
+		PRIMARY KEY (site, url, id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_locator ON comments (site, url)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_user ON comments (site, user_id, ts)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_ts ON comments (site, ts)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_pending ON comments (site, pending, ts)`,
+	`CREATE TABLE IF NOT EXISTS flags (
+		site TEXT NOT NULL,
+		flag TEXT NOT NULL,
+		key TEXT NOT NULL,
+		until INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (site, flag, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS reports (
+		site TEXT NOT NULL,
+		url TEXT NOT NULL,
+		comment_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (site, url, comment_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit (
+		site TEXT NOT NULL,
+		id TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (site, id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_ts ON audit (site, ts)`,
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		site TEXT NOT NULL,
+		url TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		thread_id TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (site, url, user_id, thread_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_subscriptions_user ON subscriptions (site, user_id)`,
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		site TEXT NOT NULL,
+		id TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (site, id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_api_tokens_hash ON api_tokens (site, hash)`,
+	`CREATE TABLE IF NOT EXISTS user_details (
+		site TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		telegram TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (site, user_id)
+	)`,
+}
+
+// NewSQLite makes persistent sqlite-based store. All sites share the same db file
+func NewSQLite(fileName string, sites ...string) (*SQLite, error) {
+	log.Printf("[INFO] sqlite store %s for sites %+v", fileName, sites)
+	db, err := sql.Open("sqlite3", fileName+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open sqlite %s", fileName)
+	}
+	db.SetMaxOpenConns(1) // sqlite doesn't support concurrent writes
+
+	for _, q := range sqliteSchema {
+		if _, err = db.Exec(q); err != nil {
+			_ = db.Close()
+			return nil, errors.Wrapf(err, "failed to make sqlite schema in %s", fileName)
+		}
+	}
+
+	result := SQLite{db: db, sites: make(map[string]bool)}
+	for _, site := range sites {
+		result.sites[site] = true
+	}
+	return &result, nil
+}
+
+// Create saves new comment to store, rejects doubles
+func (s *SQLite) Create(comment store.Comment) (commentID string, err error) {
+	if err = s.checkSite(comment.Locator.SiteID); err != nil {
+		return "", err
+	}
+
+	if s.checkFlag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly}) {
+		return "", errors.Errorf("post %s is read-only", comment.Locator.URL)
+	}
+
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return "", errors.Wrap(err, "can't marshal comment")
+	}
+
+	var count int
+	row := s.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE site=? AND url=? AND id=?`,
+		comment.Locator.SiteID, comment.Locator.URL, comment.ID)
+	if err = row.Scan(&count); err != nil {
+		return "", errors.Wrapf(err, "failed to check key %s", comment.ID)
+	}
+	if count > 0 {
+		return "", errors.Errorf("key %s already in store", comment.ID)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO comments (site, url, id, user_id, ts, deleted, pending, search, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		comment.Locator.SiteID, comment.Locator.URL, comment.ID, comment.User.ID, comment.Timestamp.UnixNano(),
+		comment.Deleted, comment.Pending, s.searchColumn(comment), string(data))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to put key %s to %s", comment.ID, comment.Locator.URL)
+	}
+	return comment.ID, nil
+}
+
+// Get returns comment for locator.URL and commentID string
+func (s *SQLite) Get(req GetRequest) (comment store.Comment, err error) {
+	if err = s.checkSite(req.Locator.SiteID); err != nil {
+		return comment, err
+	}
+
+	var data string
+	row := s.db.QueryRow(`SELECT data FROM comments WHERE site=? AND url=? AND id=?`,
+		req.Locator.SiteID, req.Locator.URL, req.CommentID)
+	if err = row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return comment, errors.Errorf("no value for %s", req.CommentID)
+		}
+		return comment, errors.Wrapf(err, "can't get comment %s", req.CommentID)
+	}
+
+	if err = json.Unmarshal([]byte(data), &comment); err != nil {
+		return comment, errors.Wrap(err, "failed to unmarshal")
+	}
+	return comment, nil
+}
+
+// Find returns all comments for given request and sorts results
+func (s *SQLite) Find(req FindRequest) (comments []store.Comment, err error) {
+	if err = s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	since := int64(0)
+	if !req.Since.IsZero() {
+		since = req.Since.UnixNano()
+	}
+
+	switch {
+	case req.Locator.SiteID != "" && req.Pending: // find comments waiting for moderation, for site or post
+		limit := req.Limit
+		if limit == 0 {
+			limit = -1 // no limit
+		}
+		comments, err = s.queryComments(`SELECT data FROM comments WHERE site=? AND pending=1 AND deleted=0
+			AND (?='' OR url=?) ORDER BY ts LIMIT ? OFFSET ?`,
+			req.Locator.SiteID, req.Locator.URL, req.Locator.URL, limit, req.Skip)
+	case req.Locator.SiteID != "" && req.Locator.URL != "": // find post comments, i.e. for site and url
+		comments, err = s.queryComments(`SELECT data FROM comments WHERE site=? AND url=? AND ts>? ORDER BY ts`,
+			req.Locator.SiteID, req.Locator.URL, since)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.UserID == "": // find last comments for site
+		limit := req.Limit
+		if limit > lastLimit || limit == 0 {
+			limit = lastLimit
+		}
+		comments, err = s.queryComments(`SELECT data FROM comments WHERE site=? AND deleted=0 AND ts>? ORDER BY ts DESC LIMIT ?`,
+			req.Locator.SiteID, since, limit)
+	case req.Locator.SiteID != "" && req.UserID != "": // find comments for user
+		limit := req.Limit
+		if limit == 0 || limit > userLimit {
+			limit = userLimit
+		}
+		comments, err = s.queryComments(`SELECT data FROM comments WHERE site=? AND user_id=? ORDER BY ts DESC LIMIT ? OFFSET ?`,
+			req.Locator.SiteID, req.UserID, limit, req.Skip)
+	default:
+		comments = []store.Comment{}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return SortComments(comments, req.Sort), nil
+}
+
+// Update for locator.URL with mutable part of comment
+func (s *SQLite) Update(comment store.Comment) error {
+	if err := s.checkSite(comment.Locator.SiteID); err != nil {
+		return err
+	}
+
+	curComment, err := s.Get(GetRequest{Locator: comment.Locator, CommentID: comment.ID})
+	if err != nil {
+		return errors.Wrapf(err, "can't update comment %s", comment.ID)
+	}
+	// preserve immutable fields
+	comment.ParentID = curComment.ParentID
+	comment.Locator = curComment.Locator
+	comment.Timestamp = curComment.Timestamp
+	comment.User = curComment.User
+
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal comment")
+	}
+	_, err = s.db.Exec(`UPDATE comments SET deleted=?, pending=?, search=?, data=? WHERE site=? AND url=? AND id=?`,
+		comment.Deleted, comment.Pending, s.searchColumn(comment), string(data),
+		comment.Locator.SiteID, comment.Locator.URL, comment.ID)
+	return errors.Wrapf(err, "failed to update comment %s", comment.ID)
+}
+
+// Count returns number of comments for post or user
+func (s *SQLite) Count(req FindRequest) (count int, err error) {
+	if err = s.checkSite(req.Locator.SiteID); err != nil {
+		return 0, err
+	}
+
+	if req.Locator.URL != "" { // comment's count for post
+		row := s.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE site=? AND url=? AND deleted=0 AND pending=0`,
+			req.Locator.SiteID, req.Locator.URL)
+		err = row.Scan(&count)
+		return count, errors.Wrapf(err, "can't get count for %s", req.Locator.URL)
+	}
+
+	if req.UserID != "" { // comment's count for user
+		row := s.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE site=? AND user_id=?`, req.Locator.SiteID, req.UserID)
+		if err = row.Scan(&count); err != nil {
+			return 0, errors.Wrapf(err, "can't get count for user %s", req.UserID)
+		}
+		if count == 0 {
+			return 0, errors.Errorf("no comments for user %s in store for %s site", req.UserID, req.Locator.SiteID)
+		}
+		return count, nil
+	}
+
+	return 0, errors.Errorf("invalid count request %+v", req)
+}
+
+// Info get post(s) meta info
+func (s *SQLite) Info(req InfoRequest) ([]store.PostInfo, error) {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return []store.PostInfo{}, err
+	}
+
+	if req.Locator.URL != "" { // post info
+		list, err := s.queryInfo(`SELECT url, SUM(CASE WHEN deleted=0 AND pending=0 THEN 1 ELSE 0 END), MIN(ts), MAX(ts) FROM comments
+			WHERE site=? AND url=? GROUP BY url`, req.Locator.SiteID, req.Locator.URL)
+		if err != nil {
+			return []store.PostInfo{}, err
+		}
+		if len(list) == 0 {
+			return []store.PostInfo{}, errors.Errorf("can't load info for %s", req.Locator.URL)
+		}
+		info := list[0]
+
+		// set read-only from age and manual flag
+		readOnlyAge := req.ReadOnlyAge
+		info.ReadOnly = readOnlyAge > 0 && !info.FirstTS.IsZero() && info.FirstTS.AddDate(0, 0, readOnlyAge).Before(time.Now())
+		if s.checkFlag(FlagRequest{Locator: req.Locator, Flag: ReadOnly}) {
+			info.ReadOnly = true
+		}
+		return []store.PostInfo{info}, nil
+	}
+
+	if req.Locator.URL == "" && req.Locator.SiteID != "" { // site info (list)
+		limit, skip := req.Limit, req.Skip
+		if limit <= 0 {
+			limit = -1 // no limit in sqlite
+		}
+		if skip < 0 {
+			skip = 0
+		}
+		return s.queryInfo(`SELECT url, SUM(CASE WHEN deleted=0 AND pending=0 THEN 1 ELSE 0 END), MIN(ts), MAX(ts) FROM comments
+			WHERE site=? GROUP BY url ORDER BY url DESC LIMIT ? OFFSET ?`, req.Locator.SiteID, limit, skip)
+	}
+
+	return nil, errors.Errorf("invalid info request %+v", req)
+}
+
+// Flag sets and gets flag values
+func (s *SQLite) Flag(req FlagRequest) (val bool, err error) {
+	if err = s.checkSite(req.Locator.SiteID); err != nil {
+		return false, err
+	}
+
+	if req.Update == FlagNonSet { // read flag value, no update requested
+		return s.checkFlag(req), nil
+	}
+
+	// write flag value
+	return s.setFlag(req)
+}
+
+// ListFlags get list of flagged keys, like blocked & verified user
+func (s *SQLite) ListFlags(req FlagRequest) (res []interface{}, err error) {
+	if err = s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	res = []interface{}{}
+	switch req.Flag {
+	case Verified, ShadowBanned, Moderator:
+		rows, e := s.db.Query(`SELECT key FROM flags WHERE site=? AND flag=? ORDER BY key`, req.Locator.SiteID, req.Flag)
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't list %s", req.Flag)
+		}
+		defer rows.Close() //nolint:gosec // read-only rows
+		for rows.Next() {
+			var key string
+			if e = rows.Scan(&key); e != nil {
+				return nil, errors.Wrapf(e, "can't scan %s", req.Flag)
+			}
+			res = append(res, key)
+		}
+		return res, rows.Err()
+	case PostAuthor:
+		if req.Locator.URL == "" {
+			return nil, errors.New("post url required to list post authors")
+		}
+		// keys of the post selected by range starting with url + "!!" prefix
+		prefix := req.Locator.URL + "!!"
+		rows, e := s.db.Query(`SELECT key FROM flags WHERE site=? AND flag=? AND key>=? AND key<? ORDER BY key`,
+			req.Locator.SiteID, req.Flag, prefix, prefix+"\uffff")
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't list %s", req.Flag)
+		}
+		defer rows.Close() //nolint:gosec // read-only rows
+		for rows.Next() {
+			var key string
+			if e = rows.Scan(&key); e != nil {
+				return nil, errors.Wrapf(e, "can't scan %s", req.Flag)
+			}
+			if userID, ok := postAuthor(req.Locator.URL, key); ok {
+				res = append(res, userID)
+			}
+		}
+		return res, rows.Err()
+	case Blocked:
+		rows, e := s.db.Query(`SELECT key, until FROM flags WHERE site=? AND flag=? AND until>? ORDER BY key`,
+			req.Locator.SiteID, Blocked, time.Now().UnixNano())
+		if e != nil {
+			return nil, errors.Wrap(e, "can't list blocked")
+		}
+		blocked := []store.BlockedUser{}
+		for rows.Next() {
+			var key string
+			var until int64
+			if e = rows.Scan(&key, &until); e != nil {
+				_ = rows.Close()
+				return nil, errors.Wrap(e, "can't scan blocked")
+			}
+			blocked = append(blocked, store.BlockedUser{ID: key, Until: time.Unix(0, until)})
+		}
+		_ = rows.Close()
+
+		// get user name from comment user section
+		for _, b := range blocked {
+			findReq := FindRequest{Locator: store.Locator{SiteID: req.Locator.SiteID}, UserID: b.ID, Limit: 1}
+			if userComments, errUser := s.Find(findReq); errUser == nil && len(userComments) > 0 {
+				b.Name = userComments[0].User.Name
+			}
+			res = append(res, b)
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("flag %s not listable", req.Flag)
+}
+
+// UserDetail sets or gets single detail value, or gets all details for requested site
+func (s *SQLite) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch req.Detail {
+	case UserEmail, UserTelegram:
+		if req.UserID == "" {
+			return nil, errors.New("userid cannot be empty in request for single detail")
+		}
+
+		if req.Update == "" { // read detail value, no update requested
+			return s.getUserDetail(req)
+		}
+
+		return s.setUserDetail(req)
+	case AllUserDetails:
+		// list of all details returned in case request is a read request
+		// (Update is not set) and does not have UserID
+		if req.Update == "" && req.UserID == "" { // read list of all details
+			return s.listDetails(req.Locator)
+		}
+		return nil, errors.New("unsupported request with userdetail all")
+	default:
+		return nil, errors.Errorf("unsupported detail %q", req.Detail)
+	}
+}
+
+// Search returns comments with all terms from the query, sorted from newest to oldest
+func (s *SQLite) Search(req SearchRequest) ([]store.Comment, error) {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	terms := SearchTerms(req.Query)
+	if len(terms) == 0 {
+		return []store.Comment{}, nil
+	}
+
+	query := `SELECT data FROM comments WHERE site=? AND deleted=0`
+	args := []interface{}{req.Locator.SiteID}
+	for _, term := range terms {
+		// terms made of letters and digits only, no need to escape like's wildcards
+		query += ` AND search LIKE ?`
+		args = append(args, "% "+term+" %")
+	}
+	if req.Locator.URL != "" {
+		query += ` AND url=?`
+		args = append(args, req.Locator.URL)
+	}
+	if req.UserID != "" {
+		query += ` AND user_id=?`
+		args = append(args, req.UserID)
+	}
+	if !req.From.IsZero() {
+		query += ` AND ts>=?`
+		args = append(args, req.From.UnixNano())
+	}
+	if !req.To.IsZero() {
+		query += ` AND ts<=?`
+		args = append(args, req.To.UnixNano())
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > searchLimit {
+		limit = searchLimit
+	}
+	skip := req.Skip
+	if skip < 0 {
+		skip = 0
+	}
+	query += ` ORDER BY ts DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, skip)
+
+	return s.queryComments(query, args...)
+}
+
+// Report adds user's report for the comment, lists reports for the comment or the whole site, or clears comment's reports
+func (s *SQLite) Report(req ReportRequest) ([]store.Report, error) {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Add != nil:
+		var count int
+		row := s.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE site=? AND url=? AND id=?`,
+			req.Locator.SiteID, req.Locator.URL, req.CommentID)
+		if err := row.Scan(&count); err != nil {
+			return nil, errors.Wrapf(err, "failed to check comment %s", req.CommentID)
+		}
+		if count == 0 {
+			return nil, errors.Errorf("no comment %s in store", req.CommentID)
+		}
+		data, err := json.Marshal(req.Add)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal report")
+		}
+		res, err := s.db.Exec(`INSERT OR IGNORE INTO reports (site, url, comment_id, user_id, ts, data) VALUES (?, ?, ?, ?, ?, ?)`,
+			req.Locator.SiteID, req.Locator.URL, req.CommentID, req.Add.UserID, req.Add.Timestamp.UnixNano(), string(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to add report for %s", req.CommentID)
+		}
+		if n, e := res.RowsAffected(); e == nil && n == 0 {
+			return nil, errors.Errorf("user %s already reported comment %s", req.Add.UserID, req.CommentID)
+		}
+		return s.Report(ReportRequest{Locator: req.Locator, CommentID: req.CommentID})
+	case req.Clear:
+		_, err := s.db.Exec(`DELETE FROM reports WHERE site=? AND url=? AND comment_id=?`,
+			req.Locator.SiteID, req.Locator.URL, req.CommentID)
+		return []store.Report{}, errors.Wrapf(err, "can't delete reports of %s", req.CommentID)
+	case req.CommentID != "":
+		return s.queryReports(`SELECT data FROM reports WHERE site=? AND url=? AND comment_id=? ORDER BY ts`,
+			req.Locator.SiteID, req.Locator.URL, req.CommentID)
+	}
+	return s.queryReports(`SELECT data FROM reports WHERE site=? ORDER BY ts`, req.Locator.SiteID)
+}
+
+// Subscription sets or removes user's subscription to the post or the thread, lists subscriptions of the post or the user
+func (s *SQLite) Subscription(req SubscriptionRequest) ([]store.Subscription, error) {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Set != nil:
+		sub := *req.Set
+		sub.Locator = req.Locator
+		data, err := json.Marshal(sub)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal subscription")
+		}
+		_, err = s.db.Exec(`INSERT OR REPLACE INTO subscriptions (site, url, user_id, thread_id, ts, data) VALUES (?, ?, ?, ?, ?, ?)`,
+			req.Locator.SiteID, req.Locator.URL, sub.UserID, sub.ThreadID, sub.Timestamp.UnixNano(), string(data))
+		return nil, errors.Wrapf(err, "failed to set subscription of %s", sub.UserID)
+	case req.Remove:
+		_, err := s.db.Exec(`DELETE FROM subscriptions WHERE site=? AND url=? AND user_id=? AND thread_id=?`,
+			req.Locator.SiteID, req.Locator.URL, req.UserID, req.ThreadID)
+		return nil, errors.Wrapf(err, "can't delete subscription of %s", req.UserID)
+	}
+
+	query := `SELECT data FROM subscriptions WHERE site=?`
+	args := []interface{}{req.Locator.SiteID}
+	if req.Locator.URL != "" {
+		query += ` AND url=?`
+		args = append(args, req.Locator.URL)
+	}
+	if req.UserID != "" {
+		query += ` AND user_id=?`
+		args = append(args, req.UserID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY ts`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query subscriptions")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	subs := []store.Subscription{}
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "can't scan subscription")
+		}
+		sub := store.Subscription{}
+		if err = json.Unmarshal([]byte(data), &sub); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal subscription")
+		}
+		subs = append(subs, sub)
+	}
+	return subs, errors.Wrap(rows.Err(), "failed to iterate subscriptions")
+}
+
+// APIToken adds or revokes admin's API token, lists tokens of the site or the token with given hash
+func (s *SQLite) APIToken(req APITokenRequest) ([]store.APIToken, error) {
+	if err := s.checkSite(req.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Add != nil:
+		tkn := *req.Add
+		tkn.SiteID = req.SiteID
+		data, err := json.Marshal(tkn)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal api token")
+		}
+		_, err = s.db.Exec(`INSERT OR REPLACE INTO api_tokens (site, id, hash, ts, data) VALUES (?, ?, ?, ?, ?)`,
+			req.SiteID, tkn.ID, tkn.Hash, tkn.Timestamp.UnixNano(), string(data))
+		return nil, errors.Wrapf(err, "failed to add token %s", tkn.ID)
+	case req.Revoke != "":
+		_, err := s.db.Exec(`DELETE FROM api_tokens WHERE site=? AND id=?`, req.SiteID, req.Revoke)
+		return nil, errors.Wrapf(err, "can't revoke token %s", req.Revoke)
+	}
+
+	query := `SELECT data FROM api_tokens WHERE site=?`
+	args := []interface{}{req.SiteID}
+	if req.Hash != "" {
+		query += ` AND hash=?`
+		args = append(args, req.Hash)
+	}
+	rows, err := s.db.Query(query+` ORDER BY ts`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query api tokens")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	tokens := []store.APIToken{}
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "can't scan api token")
+		}
+		tkn := store.APIToken{}
+		if err = json.Unmarshal([]byte(data), &tkn); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal api token")
+		}
+		tokens = append(tokens, tkn)
+	}
+	return tokens, errors.Wrap(rows.Err(), "failed to iterate api tokens")
+}
+
+// Audit adds admin action record or lists site's records, newest first
+func (s *SQLite) Audit(req AuditRequest) ([]store.AuditRecord, error) {
+	if err := s.checkSite(req.SiteID); err != nil {
+		return nil, err
+	}
+
+	if req.Add != nil {
+		data, err := json.Marshal(req.Add)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal audit record")
+		}
+		_, err = s.db.Exec(`INSERT OR REPLACE INTO audit (site, id, actor, action, target, ts, data) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			req.SiteID, req.Add.ID, req.Add.Actor, string(req.Add.Action), req.Add.Target, req.Add.Timestamp.UnixNano(), string(data))
+		return nil, errors.Wrapf(err, "can't add audit record %s", req.Add.ID)
+	}
+
+	query := `SELECT data FROM audit WHERE site=?`
+	args := []interface{}{req.SiteID}
+	if req.Actor != "" {
+		query += ` AND actor=?`
+		args = append(args, req.Actor)
+	}
+	if req.Action != "" {
+		query += ` AND action=?`
+		args = append(args, string(req.Action))
+	}
+	if req.Target != "" {
+		query += ` AND target=?`
+		args = append(args, req.Target)
+	}
+	if !req.From.IsZero() {
+		query += ` AND ts>=?`
+		args = append(args, req.From.UnixNano())
+	}
+	if !req.To.IsZero() {
+		query += ` AND ts<=?`
+		args = append(args, req.To.UnixNano())
+	}
+	query += ` ORDER BY ts DESC`
+	if req.Limit > 0 || req.Skip > 0 {
+		limit := req.Limit
+		if limit <= 0 {
+			limit = -1 // no limit in sqlite
+		}
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, req.Skip)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query audit")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	records := []store.AuditRecord{}
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "can't scan audit record")
+		}
+		rec := store.AuditRecord{}
+		if err = json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal audit record")
+		}
+		records = append(records, rec)
+	}
+	return records, errors.Wrap(rows.Err(), "failed to iterate audit records")
+}
+
+// Delete post(s), user, comment, user details, or everything
+func (s *SQLite) Delete(req DeleteRequest) error {
+	if err := s.checkSite(req.Locator.SiteID); err != nil {
+		return err
+	}
+
+	switch {
+	case req.UserDetail != "": // delete user detail
+		return s.deleteUserDetail(req.Locator.SiteID, req.UserID, req.UserDetail)
+	case req.Locator.URL != "" && req.CommentID != "" && req.UserDetail == "": // delete comment
+		return s.deleteComment(req.Locator, req.CommentID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.UserID != "" && req.CommentID == "" && req.UserDetail == "": // delete user
+		return s.deleteUser(req.Locator.SiteID, req.UserID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete site
+		return s.deleteAll(req.Locator.SiteID)
+	}
+
+	return errors.Errorf("invalid delete request %+v", req)
+}
+
+// Close sqlite store
+func (s *SQLite) Close() error {
+	return errors.Wrap(s.db.Close(), "can't close sqlite")
+}
+
+func (s *SQLite) queryComments(query string, args ...interface{}) ([]store.Comment, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query comments")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	comments := []store.Comment{}
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "can't scan comment")
+		}
+		comment := store.Comment{}
+		if err = json.Unmarshal([]byte(data), &comment); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal")
+		}
+		comments = append(comments, comment)
+	}
+	return comments, errors.Wrap(rows.Err(), "failed to iterate comments")
+}
+
+func (s *SQLite) queryReports(query string, args ...interface{}) ([]store.Report, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query reports")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	reports := []store.Report{}
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "can't scan report")
+		}
+		report := store.Report{}
+		if err = json.Unmarshal([]byte(data), &report); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal report")
+		}
+		reports = append(reports, report)
+	}
+	return reports, errors.Wrap(rows.Err(), "failed to iterate reports")
+}
+
+func (s *SQLite) queryInfo(query string, args ...interface{}) ([]store.PostInfo, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't query info")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	list := []store.PostInfo{}
+	for rows.Next() {
+		var info store.PostInfo
+		var firstTS, lastTS int64
+		if err = rows.Scan(&info.URL, &info.Count, &firstTS, &lastTS); err != nil {
+			return nil, errors.Wrap(err, "can't scan info")
+		}
+		info.FirstTS, info.LastTS = time.Unix(0, firstTS), time.Unix(0, lastTS)
+		list = append(list, info)
+	}
+	return list, errors.Wrap(rows.Err(), "failed to iterate info")
+}
+
+func (s *SQLite) checkFlag(req FlagRequest) bool {
+	key := flagKey(req)
+
+	var until int64
+	row := s.db.QueryRow(`SELECT until FROM flags WHERE site=? AND flag=? AND key=?`, req.Locator.SiteID, req.Flag, key)
+	if err := row.Scan(&until); err != nil {
+		return false
+	}
+	if req.Flag == Blocked {
+		return time.Now().Before(time.Unix(0, until))
+	}
+	return true
+}
+
+func (s *SQLite) setFlag(req FlagRequest) (res bool, err error) {
+	switch req.Flag {
+	case ReadOnly, Verified, Blocked, Moderated, ShadowBanned, PostAuthor, Moderator:
+	default:
+		return false, errors.Errorf("unsupported flag %v", req.Flag)
+	}
+
+	key := flagKey(req)
+
+	switch req.Update {
+	case FlagTrue:
+		until := time.Now()
+		if req.Flag == Blocked {
+			until = time.Now().AddDate(100, 0, 0) // permanent is 100 year
+			if req.TTL > 0 {
+				until = time.Now().Add(req.TTL)
+			}
+		}
+		_, err = s.db.Exec(`INSERT OR REPLACE INTO flags (site, flag, key, until) VALUES (?, ?, ?, ?)`,
+			req.Locator.SiteID, req.Flag, key, until.UnixNano())
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to set flag %s for %s", req.Flag, key)
+		}
+		return true, nil
+	case FlagFalse:
+		_, err = s.db.Exec(`DELETE FROM flags WHERE site=? AND flag=? AND key=?`, req.Locator.SiteID, req.Flag, key)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to clean flag %s for %s", req.Flag, key)
+		}
+	}
+	return false, nil
+}
+
+// getUserDetail returns UserDetailEntry with requested userDetail (omitting other details)
+// as an only element of the slice.
+func (s *SQLite) getUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	var entry UserDetailEntry
+	row := s.db.QueryRow(`SELECT user_id, email, telegram FROM user_details WHERE site=? AND user_id=?`,
+		req.Locator.SiteID, req.UserID)
+	if err := row.Scan(&entry.UserID, &entry.Email, &entry.Telegram); err != nil {
+		if err == sql.ErrNoRows { // return no error in case of absent entry
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "can't get detail %s for %s", req.Detail, req.UserID)
+	}
+
+	switch req.Detail {
+	case UserEmail:
+		return []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}, nil
+	case UserTelegram:
+		return []UserDetailEntry{{UserID: req.UserID, Telegram: entry.Telegram}}, nil
+	}
+	return nil, nil
+}
+
+// setUserDetail sets requested userDetail, returning complete updated UserDetailEntry
+// as an only element of the slice in case of success
+func (s *SQLite) setUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	column := "email"
+	if req.Detail == UserTelegram {
+		column = "telegram"
+	}
+	_, err := s.db.Exec(`INSERT INTO user_details (site, user_id, `+column+`) VALUES (?, ?, ?)
+		ON CONFLICT (site, user_id) DO UPDATE SET `+column+`=excluded.`+column,
+		req.Locator.SiteID, req.UserID, req.Update)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update detail %s for %s in %s", req.Detail, req.UserID, req.Locator.SiteID)
+	}
+
+	entry := UserDetailEntry{}
+	row := s.db.QueryRow(`SELECT user_id, email, telegram FROM user_details WHERE site=? AND user_id=?`,
+		req.Locator.SiteID, req.UserID)
+	if err = row.Scan(&entry.UserID, &entry.Email, &entry.Telegram); err != nil {
+		return nil, errors.Wrapf(err, "can't get detail %s for %s", req.Detail, req.UserID)
+	}
+	return []UserDetailEntry{entry}, nil
+}
+
+// listDetails lists all available users details for given site
+func (s *SQLite) listDetails(loc store.Locator) ([]UserDetailEntry, error) {
+	rows, err := s.db.Query(`SELECT user_id, email, telegram FROM user_details WHERE site=? ORDER BY user_id`, loc.SiteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list user details")
+	}
+	defer rows.Close() //nolint:gosec // read-only rows
+
+	var result []UserDetailEntry
+	for rows.Next() {
+		var entry UserDetailEntry
+		if err = rows.Scan(&entry.UserID, &entry.Email, &entry.Telegram); err != nil {
+			return nil, errors.Wrap(err, "can't scan user details")
+		}
+		result = append(result, entry)
+	}
+	return result, errors.Wrap(rows.Err(), "failed to iterate user details")
+}
+
+// deleteUserDetail deletes requested UserDetail or whole UserDetailEntry.
+// Entry without any non-empty details removed.
+func (s *SQLite) deleteUserDetail(siteID, userID string, userDetail UserDetail) error {
+	var query string
+	switch userDetail {
+	case UserEmail:
+		query = `UPDATE user_details SET email='' WHERE site=? AND user_id=?`
+	case UserTelegram:
+		query = `UPDATE user_details SET telegram='' WHERE site=? AND user_id=?`
+	case AllUserDetails:
+		query = `DELETE FROM user_details WHERE site=? AND user_id=?`
+	default:
+		return errors.Errorf("unsupported detail %q", userDetail)
+	}
+
+	if _, err := s.db.Exec(query, siteID, userID); err != nil {
+		return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+	}
+	_, err := s.db.Exec(`DELETE FROM user_details WHERE site=? AND user_id=? AND email='' AND telegram=''`, siteID, userID)
+	return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+}
+
+func (s *SQLite) deleteComment(locator store.Locator, commentID string, mode store.DeleteMode) error {
+	comment, err := s.Get(GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return errors.Wrapf(err, "can't load key %s from %s", commentID, locator.URL)
+	}
+
+	// set deleted status and clear fields
+	comment.SetDeleted(mode)
+
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal comment")
+	}
+	_, err = s.db.Exec(`UPDATE comments SET deleted=1, search='', user_id=?, data=? WHERE site=? AND url=? AND id=?`,
+		comment.User.ID, string(data), locator.SiteID, locator.URL, commentID)
+	return errors.Wrapf(err, "can't save deleted comment for key %s from %s", commentID, locator.URL)
+}
+
+// deleteUser marks all comments of given user as deleted and removes user details
+func (s *SQLite) deleteUser(siteID, userID string, mode store.DeleteMode) error {
+	rows, err := s.db.Query(`SELECT url, id FROM comments WHERE site=? AND user_id=?`, siteID, userID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get comments of %s", userID)
+	}
+	locators := []GetRequest{}
+	for rows.Next() {
+		req := GetRequest{Locator: store.Locator{SiteID: siteID}}
+		if err = rows.Scan(&req.Locator.URL, &req.CommentID); err != nil {
+			_ = rows.Close()
+			return errors.Wrapf(err, "can't scan comment of %s", userID)
+		}
+		locators = append(locators, req)
+	}
+	_ = rows.Close()
+
+	log.Printf("[DEBUG] comments for removal=%d", len(locators))
+
+	for _, req := range locators {
+		if e := s.deleteComment(req.Locator, req.CommentID, mode); e != nil {
+			return errors.Wrapf(e, "failed to delete comment %+v", req)
+		}
+	}
+
+	if len(locators) == 0 {
+		return errors.Errorf("unknown user %s", userID)
+	}
+
+	if _, err = s.db.Exec(`DELETE FROM subscriptions WHERE site=? AND user_id=?`, siteID, userID); err != nil {
+		return errors.Wrapf(err, "can't delete subscriptions of %s", userID)
+	}
+	return s.deleteUserDetail(siteID, userID, AllUserDetails)
+}
+
+// deleteAll removes all comments and user details for given siteID, flags are kept
+func (s *SQLite) deleteAll(siteID string) error {
+	for _, q := range []string{`DELETE FROM comments WHERE site=?`, `DELETE FROM user_details WHERE site=?`,
+		`DELETE FROM reports WHERE site=?`, `DELETE FROM subscriptions WHERE site=?`} {
+		if _, err := s.db.Exec(q, siteID); err != nil {
+			return errors.Wrapf(err, "failed to delete data for site %s", siteID)
+		}
+	}
+	return nil
+}
+
+// searchColumn makes value of search column, terms padded by spaces to match the whole term with like
+func (s *SQLite) searchColumn(comment store.Comment) string {
+	if comment.Deleted {
+		return ""
+	}
+	return " " + strings.Join(SearchTerms(comment.Text), " ") + " "
+}
+
+// AddSite makes the site available at runtime, data of the site kept in the shared db
+func (s *SQLite) AddSite(siteID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sites[siteID] {
+		return errors.Errorf("site %q already opened", siteID)
+	}
+	s.sites[siteID] = true
+	return nil
+}
+
+// RemoveSite makes the site unavailable at runtime, data of the site kept in the shared db
+func (s *SQLite) RemoveSite(siteID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.sites[siteID] {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	delete(s.sites, siteID)
+	return nil
+}
+
+func (s *SQLite) checkSite(siteID string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if !s.sites[siteID] {
+		return errors.Errorf("site %q not found", siteID)
+	}
+	return nil
+}
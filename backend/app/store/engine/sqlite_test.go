@@ -0,0 +1,857 @@
+package engine
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+var testSQLite = "/tmp/test-remark.sqlite"
+
+func TestSQLite_CreateAndFind(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	var _ Interface = s
+
+	req := FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Sort: "time"}
+	res, err := s.Find(req)
+	assert.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, res[0].Text)
+	assert.Equal(t, "user1", res[0].User.ID)
+
+	_, err = s.Create(store.Comment{ID: res[0].ID, Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	assert.EqualError(t, err, "key id-1 already in store")
+
+	req = FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t-bad"}, Sort: "time"}
+	_, err = s.Find(req)
+	assert.EqualError(t, err, `site "radio-t-bad" not found`)
+
+	req = FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		Since: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)}
+	res, err = s.Find(req)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+}
+
+func TestSQLite_CreateFailedReadOnly(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	comment := store.Comment{
+		ID:        "id-ro",
+		Text:      `some text, <a href="http://radio-t.com">link</a>`,
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com/ro", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+
+	v, err := s.Flag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly, Update: FlagTrue})
+	require.NoError(t, err)
+	assert.True(t, v)
+
+	_, err = s.Create(comment)
+	assert.EqualError(t, err, "post https://radio-t.com/ro is read-only")
+
+	v, err = s.Flag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly, Update: FlagFalse})
+	require.NoError(t, err)
+	assert.False(t, v)
+
+	_, err = s.Create(comment)
+	assert.NoError(t, err)
+}
+
+func TestSQLite_GetAndUpdate(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	comment, err := s.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, "some text2", comment.Text)
+	assert.True(t, time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local).Equal(comment.Timestamp))
+
+	_, err = s.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "1234567"))
+	assert.EqualError(t, err, "no value for 1234567")
+
+	comment.Text = "abc 123"
+	comment.Score = 100
+	comment.User.ID = "user-changed"
+	require.NoError(t, s.Update(comment))
+
+	comment, err = s.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc 123", comment.Text)
+	assert.Equal(t, 100, comment.Score)
+	assert.Equal(t, "user1", comment.User.ID, "user is immutable")
+
+	comment.Locator.URL = "https://radio-t.com-bad"
+	assert.Error(t, s.Update(comment))
+
+	comment.Locator.SiteID = "bad"
+	assert.EqualError(t, s.Update(comment), `site "bad" not found`)
+}
+
+func TestSQLite_FindLastAndUser(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	res, err := s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Sort: "-time", Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "some text2", res[0].Text)
+
+	res, err = s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"},
+		Since: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+
+	res, err = s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Sort: "-time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+
+	res, err = s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-1", res[0].ID)
+
+	res, err = s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "userZ"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+}
+
+func TestSQLite_CountAndInfo(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.Local) }
+
+	_, err := s.Create(store.Comment{ID: "12345", Text: "text", Timestamp: ts(24),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	count, err := s.Count(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = s.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = s.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "userZ"})
+	assert.EqualError(t, err, "no comments for user userZ in store for radio-t site")
+
+	r, err := s.Info(InfoRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com", Count: 2, FirstTS: ts(22), LastTS: ts(23)}}, r)
+
+	r, err = s.Info(InfoRequest{Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, ReadOnlyAge: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com/2", Count: 1, FirstTS: ts(24), LastTS: ts(24), ReadOnly: true}}, r)
+
+	_, err = s.Info(InfoRequest{Locator: store.Locator{URL: "https://radio-t.com/error", SiteID: "radio-t"}})
+	assert.EqualError(t, err, "can't load info for https://radio-t.com/error")
+
+	r, err = s.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com/2", Count: 1, FirstTS: ts(24), LastTS: ts(24)},
+		{URL: "https://radio-t.com", Count: 2, FirstTS: ts(22), LastTS: ts(23)}}, r)
+
+	r, err = s.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}, Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com", Count: 2, FirstTS: ts(22), LastTS: ts(23)}}, r)
+}
+
+func TestSQLite_Flags(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	val, err := s.Flag(FlagRequest{Flag: Blocked, Locator: loc, UserID: "user1"})
+	require.NoError(t, err)
+	assert.False(t, val, "nothing blocked yet")
+
+	_, err = s.Flag(FlagRequest{Flag: Blocked, Locator: loc, UserID: "user1", Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = s.Flag(FlagRequest{Flag: Blocked, Locator: loc, UserID: "user2", Update: FlagTrue, TTL: 50 * time.Millisecond})
+	require.NoError(t, err)
+	val, err = s.Flag(FlagRequest{Flag: Blocked, Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, val, "user2 blocked")
+
+	blocked, err := s.ListFlags(FlagRequest{Flag: Blocked, Locator: loc})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(blocked))
+	assert.Equal(t, "user1", blocked[0].(store.BlockedUser).ID)
+	assert.Equal(t, "user name", blocked[0].(store.BlockedUser).Name)
+
+	time.Sleep(50 * time.Millisecond)
+	val, err = s.Flag(FlagRequest{Flag: Blocked, Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	assert.False(t, val, "user2 block expired")
+
+	_, err = s.Flag(FlagRequest{Flag: Verified, Locator: loc, UserID: "user1", Update: FlagTrue})
+	require.NoError(t, err)
+	verified, err := s.ListFlags(FlagRequest{Flag: Verified, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"user1"}, verified)
+
+	_, err = s.Flag(FlagRequest{Flag: Verified, Locator: loc, UserID: "user1", Update: FlagFalse})
+	require.NoError(t, err)
+	verified, err = s.ListFlags(FlagRequest{Flag: Verified, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{}, verified)
+
+	_, err = s.Flag(FlagRequest{Flag: ShadowBanned, Locator: loc, UserID: "user2", Update: FlagTrue})
+	require.NoError(t, err)
+	val, err = s.Flag(FlagRequest{Flag: ShadowBanned, Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, val, "user2 shadow-banned")
+	banned, err := s.ListFlags(FlagRequest{Flag: ShadowBanned, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"user2"}, banned)
+
+	_, err = s.ListFlags(FlagRequest{Flag: ReadOnly, Locator: loc})
+	assert.EqualError(t, err, "flag readonly not listable")
+
+	_, err = s.Flag(FlagRequest{Flag: "bad", Locator: loc, UserID: "user1", Update: FlagTrue})
+	assert.EqualError(t, err, "unsupported flag bad")
+}
+
+func TestSQLite_FlagPostAuthor(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p1"}
+	val, err := s.Flag(FlagRequest{Flag: PostAuthor, Locator: loc, UserID: "u1"})
+	require.NoError(t, err)
+	assert.False(t, val, "no authors yet")
+
+	_, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: loc, UserID: "u1", Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: loc, UserID: "u2", Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p12"},
+		UserID: "u3", Update: FlagTrue})
+	require.NoError(t, err)
+	val, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: loc, UserID: "u1"})
+	require.NoError(t, err)
+	assert.True(t, val, "u1 is author")
+	val, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p12"}, UserID: "u1"})
+	require.NoError(t, err)
+	assert.False(t, val, "u1 is not author of other post")
+
+	ids, err := s.ListFlags(FlagRequest{Flag: PostAuthor, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"u1", "u2"}, ids)
+
+	_, err = s.Flag(FlagRequest{Flag: PostAuthor, Locator: loc, UserID: "u1", Update: FlagFalse})
+	require.NoError(t, err)
+	ids, err = s.ListFlags(FlagRequest{Flag: PostAuthor, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"u2"}, ids)
+
+	_, err = s.ListFlags(FlagRequest{Flag: PostAuthor, Locator: store.Locator{SiteID: "radio-t"}})
+	assert.EqualError(t, err, "post url required to list post authors")
+}
+
+func TestSQLite_UserDetail(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	result, err := s.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserEmail, Update: "test@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com"}}, result)
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserTelegram, Update: "tg"})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com", Telegram: "tg"}}, result)
+	_, err = s.UserDetail(UserDetailRequest{Locator: loc, UserID: "u2", Detail: UserEmail, Update: "other@example.com"})
+	require.NoError(t, err)
+
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserTelegram})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Telegram: "tg"}}, result)
+
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1xyz", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com", Telegram: "tg"},
+		{UserID: "u2", Email: "other@example.com"}}, result)
+
+	_, err = s.UserDetail(UserDetailRequest{Locator: loc, Detail: UserEmail})
+	assert.EqualError(t, err, "userid cannot be empty in request for single detail")
+	_, err = s.UserDetail(UserDetailRequest{Locator: loc, Detail: UserDetail("bad")})
+	assert.EqualError(t, err, `unsupported detail "bad"`)
+
+	require.NoError(t, s.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserEmail}))
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Telegram: "tg"}, {UserID: "u2", Email: "other@example.com"}}, result)
+
+	require.NoError(t, s.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserTelegram}))
+	require.NoError(t, s.Delete(DeleteRequest{Locator: loc, UserID: "u2", UserDetail: AllUserDetails}))
+	result, err = s.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Empty(t, result, "empty entries removed")
+}
+
+func TestSQLite_Delete(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	delReq := DeleteRequest{Locator: locator, CommentID: "id-1", DeleteMode: store.SoftDelete}
+	require.NoError(t, s.Delete(delReq))
+	require.NoError(t, s.Delete(delReq), "repeated deletion is fine")
+
+	res, err := s.Find(FindRequest{Locator: locator, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "", res[0].Text)
+	assert.True(t, res[0].Deleted, "marked deleted")
+	assert.Equal(t, store.User{Name: "user name", ID: "user1"}, res[0].User)
+
+	count, err := s.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	last, err := s.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(last), "deleted not in last")
+
+	delReq.CommentID = "123456"
+	assert.Error(t, s.Delete(delReq))
+
+	require.NoError(t, s.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", DeleteMode: store.HardDelete}))
+	res, err = s.Find(FindRequest{Locator: locator, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, store.User{Name: "deleted", ID: "deleted"}, res[1].User)
+	assert.True(t, res[1].Deleted)
+
+	err = s.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", DeleteMode: store.HardDelete})
+	assert.EqualError(t, err, "unknown user user1")
+
+	require.NoError(t, s.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}}))
+	res, err = s.Find(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	assert.EqualError(t, s.Delete(DeleteRequest{Locator: store.Locator{SiteID: "bad"}}), `site "bad" not found`)
+}
+
+func TestSQLite_Search(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.Local) }
+	_, err := e.Create(store.Comment{ID: "id-3", Text: "<p>Some other text</p>", Timestamp: ts(24),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	ids := func(cc []store.Comment) (res []string) {
+		for _, c := range cc {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+
+	res, err := e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "some TEXT"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-3", "id-1"}, ids(res), "id-2 has text2 term, not text")
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "some", Limit: 2, Skip: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2", "id-1"}, ids(res))
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Query: "some"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2", "id-1"}, ids(res))
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "some", UserID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-3"}, ids(res))
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "some", From: ts(23), To: ts(23)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2"}, ids(res))
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "some nothing"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "!"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	// index updated on comment update and delete
+	c, err := e.Get(getReq(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, "id-3"))
+	require.NoError(t, err)
+	c.Text = "updated message"
+	require.NoError(t, e.Update(c))
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "updated"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-3"}, ids(res))
+
+	require.NoError(t, e.Delete(DeleteRequest{Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		CommentID: "id-3", DeleteMode: store.SoftDelete}))
+	res, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "updated"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	_, err = e.Search(SearchRequest{Locator: store.Locator{SiteID: "bad"}, Query: "some"})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+// makes new sqlite db, put two records
+func TestSQLite_FindPending(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.Local) }
+	_, err := e.Create(store.Comment{ID: "p-1", Text: "pending 1", Timestamp: ts(30), Pending: true,
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	_, err = e.Create(store.Comment{ID: "p-2", Text: "pending 2", Timestamp: ts(31), Pending: true,
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = e.Create(store.Comment{ID: "p-3", Text: "pending 3", Timestamp: ts(32), Pending: true,
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	ids := func(cc []store.Comment) (res []string) {
+		for _, c := range cc {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+
+	res, err := e.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Pending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p-1", "p-2", "p-3"}, ids(res), "only pending comments, oldest first")
+
+	res, err = e.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}, Pending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p-2", "p-3"}, ids(res))
+
+	res, err = e.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Pending: true, Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p-2"}, ids(res))
+
+	// approve p-1
+	c, err := e.Get(GetRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, CommentID: "p-1"})
+	require.NoError(t, err)
+	c.Pending = false
+	require.NoError(t, e.Update(c))
+
+	// reject p-2
+	err = e.Delete(DeleteRequest{Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		CommentID: "p-2", DeleteMode: store.HardDelete})
+	require.NoError(t, err)
+
+	res, err = e.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Pending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p-3"}, ids(res))
+
+	res, err = e.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "p-1", res[2].ID)
+	assert.False(t, res[2].Pending, "approved")
+
+	_, err = e.Find(FindRequest{Locator: store.Locator{SiteID: "bad"}, Pending: true})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestSQLite_CountPending(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com/pending", SiteID: "radio-t"}
+	_, err := e.Create(store.Comment{ID: "c-1", Text: "published", Timestamp: time.Date(2017, 12, 20, 15, 18, 30, 0, time.Local),
+		Locator: loc, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	_, err = e.Create(store.Comment{ID: "p-1", Text: "pending", Timestamp: time.Date(2017, 12, 20, 15, 18, 31, 0, time.Local),
+		Pending: true, Locator: loc, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	check := func(expected int, msg string) {
+		count, e2 := e.Count(FindRequest{Locator: loc})
+		require.NoError(t, e2)
+		assert.Equal(t, expected, count, msg)
+		info, e2 := e.Info(InfoRequest{Locator: loc})
+		require.NoError(t, e2)
+		require.Equal(t, 1, len(info))
+		assert.Equal(t, expected, info[0].Count, msg)
+	}
+	check(1, "pending comment not counted")
+
+	c, err := e.Get(GetRequest{Locator: loc, CommentID: "p-1"})
+	require.NoError(t, err)
+	c.Pending = false
+	require.NoError(t, e.Update(c))
+	check(2, "approved comment counted")
+
+	_, err = e.Create(store.Comment{ID: "p-2", Text: "pending", Timestamp: time.Date(2017, 12, 20, 15, 18, 32, 0, time.Local),
+		Pending: true, Locator: loc, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	err = e.Delete(DeleteRequest{Locator: loc, CommentID: "p-2", DeleteMode: store.HardDelete})
+	require.NoError(t, err)
+	check(2, "rejected comment not subtracted")
+}
+
+func TestSQLite_FlagModeratedPost(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	req := FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-1"}, Flag: Moderated}
+	val, err := e.Flag(req)
+	assert.NoError(t, err)
+	assert.False(t, val, "nothing moderated")
+
+	req = FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-1"}, Flag: Moderated, Update: FlagTrue}
+	val, err = e.Flag(req)
+	assert.NoError(t, err)
+	assert.True(t, val)
+
+	req = FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-1"}, Flag: Moderated}
+	val, err = e.Flag(req)
+	assert.NoError(t, err)
+	assert.True(t, val, "url-1 moderated")
+
+	req = FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-2"}, Flag: Moderated}
+	val, err = e.Flag(req)
+	assert.NoError(t, err)
+	assert.False(t, val, "url-2 not moderated")
+
+	req = FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-1"}, Flag: Moderated, Update: FlagFalse}
+	_, err = e.Flag(req)
+	assert.NoError(t, err)
+	req = FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "url-1"}, Flag: Moderated}
+	val, err = e.Flag(req)
+	assert.NoError(t, err)
+	assert.False(t, val, "url-1 not moderated anymore")
+}
+
+func TestSQLite_Report(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.UTC) }
+
+	reports, err := e.Report(ReportRequest{Locator: loc, CommentID: "id-1",
+		Add: &store.Report{Locator: loc, CommentID: "id-1", UserID: "user2", Reason: store.ReportSpam, Timestamp: ts(30)}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reports))
+	assert.Equal(t, "user2", reports[0].UserID)
+	assert.Equal(t, store.ReportSpam, reports[0].Reason)
+
+	reports, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-1",
+		Add: &store.Report{Locator: loc, CommentID: "id-1", UserID: "user3", Reason: store.ReportAbusive, Text: "rude", Timestamp: ts(31)}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(reports))
+	assert.Equal(t, "user3", reports[1].UserID)
+	assert.Equal(t, "rude", reports[1].Text)
+
+	_, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-1",
+		Add: &store.Report{Locator: loc, CommentID: "id-1", UserID: "user3", Reason: store.ReportSpam, Timestamp: ts(32)}})
+	assert.EqualError(t, err, "user user3 already reported comment id-1")
+
+	_, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-bad",
+		Add: &store.Report{Locator: loc, CommentID: "id-bad", UserID: "user3", Reason: store.ReportSpam, Timestamp: ts(32)}})
+	assert.Error(t, err, "no such comment")
+
+	_, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-2",
+		Add: &store.Report{Locator: loc, CommentID: "id-2", UserID: "user3", Reason: store.ReportOffTopic, Timestamp: ts(29)}})
+	require.NoError(t, err)
+
+	reports, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reports))
+
+	reports, err = e.Report(ReportRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(reports), "all site's reports")
+	assert.Equal(t, "id-2", reports[0].CommentID, "sorted by time")
+	assert.Equal(t, "id-1", reports[1].CommentID)
+
+	_, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-1", Clear: true})
+	require.NoError(t, err)
+	reports, err = e.Report(ReportRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(reports))
+	reports, err = e.Report(ReportRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(reports))
+
+	_, err = e.Report(ReportRequest{Locator: store.Locator{SiteID: "bad"}})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestSQLite_Subscription(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	loc2 := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.UTC) }
+
+	_, err := e.Subscription(SubscriptionRequest{Locator: loc,
+		Set: &store.Subscription{UserID: "user2", Mode: store.SubscriptionPost, Timestamp: ts(30)}})
+	require.NoError(t, err)
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc,
+		Set: &store.Subscription{UserID: "user1", Mode: store.SubscriptionPost, Timestamp: ts(31)}})
+	require.NoError(t, err)
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc2,
+		Set: &store.Subscription{UserID: "user2", Mode: store.SubscriptionPost, Timestamp: ts(29)}})
+	require.NoError(t, err)
+
+	subs, err := e.Subscription(SubscriptionRequest{Locator: loc})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs))
+	assert.Equal(t, "user2", subs[0].UserID, "sorted by time")
+	assert.Equal(t, loc, subs[0].Locator)
+	assert.Equal(t, store.SubscriptionPost, subs[0].Mode)
+
+	// replace subscription with mute
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc,
+		Set: &store.Subscription{UserID: "user2", Mode: store.SubscriptionMute, Timestamp: ts(32)}})
+	require.NoError(t, err)
+	subs, err = e.Subscription(SubscriptionRequest{Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, store.SubscriptionMute, subs[0].Mode)
+
+	// thread mutes kept along with post subscription
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc,
+		Set: &store.Subscription{UserID: "user1", ThreadID: "c1", Mode: store.SubscriptionMute, Timestamp: ts(33)}})
+	require.NoError(t, err)
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc,
+		Set: &store.Subscription{UserID: "user1", ThreadID: "c2", Mode: store.SubscriptionMute, Timestamp: ts(34)}})
+	require.NoError(t, err)
+	subs, err = e.Subscription(SubscriptionRequest{Locator: loc, UserID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(subs))
+	assert.Equal(t, "", subs[0].ThreadID)
+	assert.Equal(t, "c1", subs[1].ThreadID)
+	assert.Equal(t, "c2", subs[2].ThreadID)
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc, UserID: "user1", ThreadID: "c2", Remove: true})
+	require.NoError(t, err)
+	subs, err = e.Subscription(SubscriptionRequest{Locator: loc, UserID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs), "only thread mute removed")
+	assert.Equal(t, "c1", subs[1].ThreadID)
+
+	subs, err = e.Subscription(SubscriptionRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs), "all user's subscriptions")
+	assert.Equal(t, loc2, subs[0].Locator)
+	assert.Equal(t, loc, subs[1].Locator)
+
+	_, err = e.Subscription(SubscriptionRequest{Locator: loc2, UserID: "user2", Remove: true})
+	require.NoError(t, err)
+	subs, err = e.Subscription(SubscriptionRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(subs))
+
+	// user removal drops user's subscriptions, including thread mutes
+	require.NoError(t, e.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", DeleteMode: store.HardDelete}))
+	subs, err = e.Subscription(SubscriptionRequest{Locator: loc})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, "user2", subs[0].UserID)
+
+	require.NoError(t, e.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}}))
+	subs, err = e.Subscription(SubscriptionRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(subs), "removed with site's data")
+
+	_, err = e.Subscription(SubscriptionRequest{Locator: store.Locator{SiteID: "bad"}})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestSQLite_APIToken(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.UTC) }
+	_, err := e.APIToken(APITokenRequest{SiteID: "radio-t", Add: &store.APIToken{ID: "t2", Name: "backup",
+		UserID: "admin1", Hash: "hash2", Scopes: []store.TokenScope{store.TokenScopeMigration}, Timestamp: ts(2)}})
+	require.NoError(t, err)
+	_, err = e.APIToken(APITokenRequest{SiteID: "radio-t", Add: &store.APIToken{ID: "t1", Name: "ci",
+		UserID: "admin1", Hash: "hash1", Expires: ts(10), Timestamp: ts(1)}})
+	require.NoError(t, err)
+
+	tokens, err := e.APIToken(APITokenRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(tokens))
+	assert.Equal(t, "t1", tokens[0].ID, "sorted by time")
+	assert.Equal(t, "radio-t", tokens[0].SiteID)
+	assert.Equal(t, ts(10), tokens[0].Expires.UTC())
+	assert.Equal(t, []store.TokenScope{store.TokenScopeMigration}, tokens[1].Scopes)
+
+	tokens, err = e.APIToken(APITokenRequest{SiteID: "radio-t", Hash: "hash2"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tokens))
+	assert.Equal(t, "backup", tokens[0].Name)
+
+	tokens, err = e.APIToken(APITokenRequest{SiteID: "radio-t", Hash: "bad"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(tokens))
+
+	_, err = e.APIToken(APITokenRequest{SiteID: "radio-t", Revoke: "t2"})
+	require.NoError(t, err)
+	tokens, err = e.APIToken(APITokenRequest{SiteID: "radio-t", Hash: "hash2"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(tokens), "revoked")
+
+	require.NoError(t, e.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}}))
+	tokens, err = e.APIToken(APITokenRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tokens), "kept on site's data removal")
+
+	_, err = e.APIToken(APITokenRequest{SiteID: "bad"})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestSQLite_Audit(t *testing.T) {
+	e, teardown := prepSQLite(t)
+	defer teardown()
+
+	ts := func(sec int) time.Time { return time.Date(2017, 12, 20, 15, 18, sec, 0, time.UTC) }
+	records := []store.AuditRecord{
+		{ID: "r1", SiteID: "radio-t", Actor: "admin1", Action: store.AuditDeleteComment, Target: "id-1",
+			Params: map[string]string{"url": "https://radio-t.com"}, Timestamp: ts(1)},
+		{ID: "r2", SiteID: "radio-t", Actor: "admin2", Action: store.AuditBlock, Target: "user1", Timestamp: ts(2)},
+		{ID: "r3", SiteID: "radio-t", Actor: "admin1", Action: store.AuditBlock, Target: "user2", Timestamp: ts(3)},
+		{ID: "r4", SiteID: "radio-t", Actor: "admin1", Action: store.AuditPin, Target: "id-2", Timestamp: ts(4)},
+	}
+	for i := range records {
+		res, err := e.Audit(AuditRequest{SiteID: "radio-t", Add: &records[i]})
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	}
+	_, err := e.Audit(AuditRequest{SiteID: "radio-t", Add: &records[0]})
+	require.NoError(t, err, "same record added again")
+
+	res, err := e.Audit(AuditRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res), "no duplicates")
+	assert.Equal(t, "r4", res[0].ID, "newest first")
+	assert.Equal(t, "r1", res[3].ID)
+	assert.Equal(t, map[string]string{"url": "https://radio-t.com"}, res[3].Params)
+	assert.True(t, ts(1).Equal(res[3].Timestamp))
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", Actor: "admin1"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res))
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", Action: store.AuditBlock})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "r3", res[0].ID)
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", Target: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "r2", res[0].ID)
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", From: ts(2), To: ts(3)})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "r3", res[0].ID)
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", Actor: "admin1", Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "r3", res[0].ID)
+
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t", Skip: 3})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "r1", res[0].ID)
+
+	// audit log kept on site's data removal
+	require.NoError(t, e.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}}))
+	res, err = e.Audit(AuditRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res))
+
+	_, err = e.Audit(AuditRequest{SiteID: "bad"})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestSQLite_AddRemoveSite(t *testing.T) {
+	s, teardown := prepSQLite(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://example.com", SiteID: "site2"}
+	_, err := s.Create(store.Comment{ID: "c1", Text: "text", Locator: loc, User: store.User{ID: "user1"}})
+	assert.EqualError(t, err, `site "site2" not found`)
+
+	require.NoError(t, s.AddSite("site2"))
+	assert.EqualError(t, s.AddSite("site2"), `site "site2" already opened`)
+	_, err = s.Create(store.Comment{ID: "c1", Text: "text", Locator: loc, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+
+	require.NoError(t, s.RemoveSite("site2"))
+	_, err = s.Get(GetRequest{Locator: loc, CommentID: "c1"})
+	assert.EqualError(t, err, `site "site2" not found`)
+	assert.EqualError(t, s.RemoveSite("site2"), `site "site2" not found`)
+
+	require.NoError(t, s.AddSite("site2"))
+	c, err := s.Get(GetRequest{Locator: loc, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text", c.Text, "data kept")
+}
+
+func prepSQLite(t *testing.T) (s *SQLite, teardown func()) {
+	_ = os.Remove(testSQLite)
+
+	s, err := NewSQLite(testSQLite, "radio-t")
+	require.NoError(t, err)
+
+	comment := store.Comment{
+		ID:        "id-1",
+		Text:      `some text, <a href="http://radio-t.com">link</a>`,
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+	_, err = s.Create(comment)
+	require.NoError(t, err)
+
+	comment = store.Comment{
+		ID:        "id-2",
+		Text:      "some text2",
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+	_, err = s.Create(comment)
+	require.NoError(t, err)
+
+	teardown = func() {
+		require.NoError(t, s.Close())
+		_ = os.Remove(testSQLite)
+		_ = os.Remove(testSQLite + "-wal")
+		_ = os.Remove(testSQLite + "-shm")
+	}
+	return s, teardown
+}
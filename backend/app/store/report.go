@@ -0,0 +1,34 @@
+package store
+
+import (
+	"time"
+)
+
+// ReportReason defines why comment reported by user
+type ReportReason string
+
+// ReportReason enum
+const (
+	ReportAbusive  ReportReason = "abusive"
+	ReportSpam     ReportReason = "spam"
+	ReportOffTopic ReportReason = "off-topic"
+)
+
+// Report is a user's complaint about the comment. Each user can report a comment only once
+type Report struct {
+	Locator   Locator      `json:"locator" bson:"locator"`
+	CommentID string       `json:"comment_id" bson:"comment_id"`
+	UserID    string       `json:"user_id" bson:"user_id"` // reporter, not the author of the comment
+	Reason    ReportReason `json:"reason" bson:"reason"`
+	Text      string       `json:"text,omitempty" bson:"text,omitempty"` // optional details from the reporter
+	Timestamp time.Time    `json:"time" bson:"time"`
+}
+
+// Valid checks if reason is one of supported
+func (r ReportReason) Valid() bool {
+	switch r {
+	case ReportAbusive, ReportSpam, ReportOffTopic:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportReason_Valid(t *testing.T) {
+	tbl := []struct {
+		reason ReportReason
+		valid  bool
+	}{
+		{ReportAbusive, true},
+		{ReportSpam, true},
+		{ReportOffTopic, true},
+		{"", false},
+		{"boring", false},
+		{"SPAM", false},
+	}
+
+	for i, tt := range tbl {
+		assert.Equal(t, tt.valid, tt.reason.Valid(), "case #%d", i)
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+const akismetDefaultURL = "https://rest.akismet.com"
+
+// Akismet implements SpamChecker with akismet-compatible api, see https://akismet.com/development/api/
+// BaseURL allows to use any service speaking the same protocol
+type Akismet struct {
+	BaseURL string // defaults to https://rest.akismet.com
+	Key     string
+	Blog    string // front page of the site, taken from comment's url if empty
+	Client  http.Client
+}
+
+// Check sends comment to comment-check endpoint
+func (a *Akismet) Check(req SpamRequest) (spam bool, err error) {
+	resp, err := a.post("comment-check", req)
+	if err != nil {
+		return false, err
+	}
+	switch resp {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.Errorf("unexpected akismet response %q", resp)
+}
+
+// Feedback sends comment to submit-spam or submit-ham endpoint
+func (a *Akismet) Feedback(req SpamRequest, spam bool) error {
+	method := "submit-ham"
+	if spam {
+		method = "submit-spam"
+	}
+	_, err := a.post(method, req)
+	return err
+}
+
+// post makes request to akismet method and returns response body
+func (a *Akismet) post(method string, req SpamRequest) (string, error) {
+	baseURL := a.BaseURL
+	if baseURL == "" {
+		baseURL = akismetDefaultURL
+	}
+
+	c := req.Comment
+	form := url.Values{
+		"api_key":          {a.Key},
+		"blog":             {a.blog(c.Locator.URL)},
+		"user_ip":          {req.UserIP},
+		"permalink":        {c.Locator.URL},
+		"comment_type":     {"comment"},
+		"comment_author":   {c.User.Name},
+		"comment_content":  {c.Orig},
+		"comment_date_gmt": {c.Timestamp.UTC().Format("2006-01-02T15:04:05Z")},
+	}
+	if c.ParentID != "" {
+		form.Set("comment_type", "reply")
+	}
+	if c.Orig == "" {
+		form.Set("comment_content", c.Text)
+	}
+	if c.Timestamp.IsZero() {
+		form.Del("comment_date_gmt")
+	}
+
+	resp, err := a.Client.PostForm(strings.TrimSuffix(baseURL, "/")+"/1.1/"+method, form)
+	if err != nil {
+		return "", errors.Wrapf(err, "akismet %s request failed", method)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close akismet body, %v", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read akismet %s response", method)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("akismet %s failed, code %d", method, resp.StatusCode)
+	}
+
+	res := strings.TrimSpace(string(body))
+	if res == "invalid" || resp.Header.Get("X-akismet-debug-help") != "" {
+		return "", errors.Errorf("akismet %s rejected, %s %s", method, res, resp.Header.Get("X-akismet-debug-help"))
+	}
+	return res, nil
+}
+
+// blog returns site's front page, scheme and host of the comment's url by default
+func (a *Akismet) blog(postURL string) string {
+	if a.Blog != "" {
+		return a.Blog
+	}
+	u, err := url.Parse(postURL)
+	if err != nil || u.Host == "" {
+		return postURL
+	}
+	return u.Scheme + "://" + u.Host
+}
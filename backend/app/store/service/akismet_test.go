@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestAkismet_Check(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		assert.Equal(t, "/1.1/comment-check", r.URL.Path)
+		if form.Get("comment_content") == "buy viagra" {
+			_, _ = fmt.Fprint(w, "true")
+			return
+		}
+		_, _ = fmt.Fprint(w, "false")
+	}))
+	defer ts.Close()
+
+	a := Akismet{BaseURL: ts.URL, Key: "key123", Client: http.Client{Timeout: time.Second}}
+	var _ SpamChecker = &a
+
+	c := store.Comment{ID: "id-1", Text: "<p>hello</p>", Orig: "hello", User: store.User{Name: "user1"},
+		Locator:   store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/2020/01/01/podcast-1/"},
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	spam, err := a.Check(SpamRequest{Comment: c, UserIP: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, spam)
+	assert.Equal(t, "key123", form.Get("api_key"))
+	assert.Equal(t, "https://radio-t.com", form.Get("blog"))
+	assert.Equal(t, "127.0.0.1", form.Get("user_ip"))
+	assert.Equal(t, "https://radio-t.com/p/2020/01/01/podcast-1/", form.Get("permalink"))
+	assert.Equal(t, "comment", form.Get("comment_type"))
+	assert.Equal(t, "user1", form.Get("comment_author"))
+	assert.Equal(t, "hello", form.Get("comment_content"))
+	assert.Equal(t, "2020-01-02T03:04:05Z", form.Get("comment_date_gmt"))
+
+	c.Orig, c.ParentID = "buy viagra", "id-0"
+	a.Blog = "https://example.com"
+	spam, err = a.Check(SpamRequest{Comment: c, UserIP: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, spam)
+	assert.Equal(t, "reply", form.Get("comment_type"))
+	assert.Equal(t, "https://example.com", form.Get("blog"))
+}
+
+func TestAkismet_CheckFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("api_key") == "bad" {
+			w.Header().Set("X-akismet-debug-help", "invalid key")
+			_, _ = fmt.Fprint(w, "invalid")
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	a := Akismet{BaseURL: ts.URL, Key: "bad"}
+	_, err := a.Check(SpamRequest{})
+	assert.EqualError(t, err, "akismet comment-check rejected, invalid invalid key")
+
+	a.Key = "good"
+	_, err = a.Check(SpamRequest{})
+	assert.EqualError(t, err, "akismet comment-check failed, code 500")
+
+	a.BaseURL = "http://127.0.0.1:1"
+	_, err = a.Check(SpamRequest{})
+	assert.Error(t, err)
+}
+
+func TestAkismet_Feedback(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = fmt.Fprint(w, "Thanks for making the web a better place.")
+	}))
+	defer ts.Close()
+
+	a := Akismet{BaseURL: ts.URL + "/", Key: "key123"}
+	assert.NoError(t, a.Feedback(SpamRequest{}, true))
+	assert.NoError(t, a.Feedback(SpamRequest{}, false))
+	assert.Equal(t, []string{"/1.1/submit-spam", "/1.1/submit-ham"}, paths)
+}
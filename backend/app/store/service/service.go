@@ -3,6 +3,11 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"math"
 	"sort"
 	"strings"
@@ -14,6 +19,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
 
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
@@ -36,7 +42,11 @@ type DataStore struct {
 	TitleExtractor         *TitleExtractor
 	RestrictedWordsMatcher *RestrictedWordsMatcher
 	ImageService           *image.Service
-	AdminEdits             bool // allow admin unlimited edits
+	AdminEdits             bool          // allow admin unlimited edits
+	PreModeration          bool          // keep new comments pending till admin approval, for all posts
+	DeletedRetention       time.Duration // keep content of soft-deleted comments for restore, purged after
+	SpamChecker            SpamChecker   // optional, checks new and edited comments of non-admins
+	SpamAction             SpamAction    // what to do with detected spam, pending by default
 
 	// granular locks
 	scopedLocks struct {
@@ -58,8 +68,9 @@ type UserMetaData struct {
 		Status bool      `json:"status"`
 		Until  time.Time `json:"until"`
 	} `json:"blocked"`
-	Verified bool                   `json:"verified"`
-	Details  engine.UserDetailEntry `json:"details,omitempty"`
+	Verified     bool                   `json:"verified"`
+	ShadowBanned bool                   `json:"shadow_banned,omitempty"`
+	Details      engine.UserDetailEntry `json:"details,omitempty"`
 }
 
 // PostMetaData keeps info about post flags
@@ -79,9 +90,19 @@ var nonAdminUser = store.User{}
 // ErrRestrictedWordsFound returned in case comment text contains restricted words
 var ErrRestrictedWordsFound = errors.New("comment contains restricted words")
 
+// ErrAlreadyReported returned in case user reports the same comment twice
+var ErrAlreadyReported = errors.New("comment already reported by user")
+
+// ReportedComment is a comment with all users' reports about it
+type ReportedComment struct {
+	Comment store.Comment  `json:"comment"`
+	Reports []store.Report `json:"reports"`
+}
+
 // Create prepares comment and forward to Interface.Create
 func (s *DataStore) Create(comment store.Comment) (commentID string, err error) {
 
+	userIP := comment.User.IP // raw ip for spam checker, replaced by hash in prepareNewComment
 	if comment, err = s.prepareNewComment(comment); err != nil {
 		return "", errors.Wrap(err, "failed to prepare comment")
 	}
@@ -90,6 +111,15 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 		return "", ErrRestrictedWordsFound
 	}
 
+	// imported comments keep their original status, admins' comments never wait for moderation
+	if !comment.Imported {
+		comment.Pending = !comment.User.Admin && s.IsModerated(comment.Locator)
+		comment.Shadow = !comment.User.Admin && s.IsShadowBanned(comment.Locator.SiteID, comment.User.ID)
+		if comment, err = s.checkSpam(SpamRequest{Comment: comment, UserIP: userIP}); err != nil {
+			return "", err
+		}
+	}
+
 	func() { // keep input title and set to extracted if missing
 		if s.TitleExtractor == nil || comment.PostTitle != "" {
 			return
@@ -126,17 +156,22 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 	}
 
 	changedSort := false
+	visible := make([]store.Comment, 0, len(comments))
 	// sets votes controversy for comments added prior to #274
 	// also sanitizes locator.URL for comments added prior to #927
-	for i, c := range comments {
+	for _, c := range comments {
+		if !s.isVisible(c, user) {
+			continue
+		}
 		if c.Controversy == 0 && len(c.Votes) > 0 {
 			c.Controversy = s.controversy(s.upsAndDowns(c))
 			if !changedSort && strings.Contains(sortMethod, "controversy") { // trigger sort change
 				changedSort = true
 			}
 		}
-		comments[i] = s.alterComment(c, user)
+		visible = append(visible, s.alterComment(c, user))
 	}
+	comments = visible
 
 	// resort commits if altered
 	if changedSort {
@@ -152,6 +187,9 @@ func (s *DataStore) Get(locator store.Locator, commentID string, user store.User
 	if err != nil {
 		return store.Comment{}, err
 	}
+	if !s.isVisible(c, user) {
+		return store.Comment{}, s.invisibleErr(c)
+	}
 	return s.alterComment(c, user), nil
 }
 
@@ -459,11 +497,13 @@ func (s *DataStore) controversy(ups, downs int) float64 {
 
 // EditRequest contains fields needed for comment update
 type EditRequest struct {
-	Text    string
-	Orig    string
-	Summary string
-	Delete  bool
-	Admin   bool
+	Text     string
+	Orig     string
+	Summary  string
+	Delete   bool
+	Admin    bool
+	EditorID string // user or admin making the edit, recorded in revisions
+	UserIP   string // raw ip of the editor, used by spam checker
 }
 
 // EditComment to edit text and update Edit info
@@ -499,7 +539,7 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 			log.Printf("[WARN] failed to send delete event, %s", e)
 		}
 		comment.Deleted = true
-		delReq := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: store.SoftDelete}
+		delReq := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: store.UserDelete}
 		return comment, s.Engine.Delete(delReq)
 	}
 
@@ -507,20 +547,97 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		return comment, ErrRestrictedWordsFound
 	}
 
+	editorID := req.EditorID
+	if editorID == "" {
+		editorID = comment.User.ID
+	}
+	edit := store.Edit{Timestamp: time.Now(), Summary: req.Summary}
+	comment.Revisions = append(comment.Revisions, store.Revision{Text: comment.Text, Orig: comment.Orig,
+		EditorID: editorID, Timestamp: edit.Timestamp, Summary: edit.Summary})
+
 	comment.Text = req.Text
 	comment.Orig = req.Orig
-	comment.Edit = &store.Edit{Timestamp: time.Now(), Summary: req.Summary}
+	comment.Edit = &edit
 	comment.Locator = locator
 	comment.Sanitize()
 
+	if !req.Admin {
+		if comment, err = s.checkSpam(SpamRequest{Comment: comment, UserIP: req.UserIP}); err != nil {
+			return comment, err
+		}
+	}
+
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvUpdate); e != nil {
 		log.Printf("[WARN] failed to send update event, %s", e)
 	}
 
 	err = s.Engine.Update(comment)
+	comment.Revisions = nil
 	return comment, err
 }
 
+// CommentVersion is a state of the comment after creation or an edit
+type CommentVersion struct {
+	Text      string    `json:"text"`
+	Orig      string    `json:"orig,omitempty"`
+	EditorID  string    `json:"editor_id"`
+	Timestamp time.Time `json:"time"`
+	Summary   string    `json:"summary,omitempty"`
+	Diff      string    `json:"diff,omitempty"` // unified diff with the previous version
+}
+
+// History returns all versions of the comment, from the original to the current one, with diffs between them.
+// Revisions of deleted comment available to admins only, without the current (deleted) version
+func (s *DataStore) History(locator store.Locator, commentID string, user store.User) ([]CommentVersion, error) {
+	c, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return nil, err
+	}
+	if !s.isVisible(c, user) {
+		return nil, s.invisibleErr(c)
+	}
+	if c.Deleted && !user.Admin {
+		return nil, errors.Errorf("comment %s deleted", commentID)
+	}
+
+	// revision keeps the text replaced by the edit, so version is made by the previous edit
+	res := make([]CommentVersion, 0, len(c.Revisions)+1)
+	editorID, ts, summary := c.User.ID, c.Timestamp, ""
+	for _, r := range c.Revisions {
+		res = append(res, CommentVersion{Text: r.Text, Orig: r.Orig, EditorID: editorID, Timestamp: ts, Summary: summary})
+		editorID, ts, summary = r.EditorID, r.Timestamp, r.Summary
+	}
+	if !c.Deleted {
+		res = append(res, CommentVersion{Text: c.Text, Orig: c.Orig, EditorID: editorID, Timestamp: ts, Summary: summary})
+	}
+
+	for i := 1; i < len(res); i++ {
+		diff, e := versionsDiff(res[i-1], res[i], i)
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't make diff for %s", commentID)
+		}
+		res[i].Diff = diff
+	}
+	return res, nil
+}
+
+// versionsDiff makes unified diff between comment versions, uses markdown source if available
+func versionsDiff(prev, curr CommentVersion, num int) (string, error) {
+	text := func(v CommentVersion) string {
+		if v.Orig != "" {
+			return v.Orig
+		}
+		return v.Text
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(text(prev)),
+		B:        difflib.SplitLines(text(curr)),
+		FromFile: fmt.Sprintf("revision %d", num-1),
+		ToFile:   fmt.Sprintf("revision %d", num),
+		Context:  3,
+	})
+}
+
 // HasReplies checks if there is any reply to the comments
 // Loads last maxLastCommentsReply comments and compare parent id to the comment's id
 // Comments with replies cached for 5 minutes
@@ -543,7 +660,7 @@ func (s *DataStore) HasReplies(comment store.Comment) bool {
 	}
 
 	for _, c := range comments {
-		if c.ParentID != "" && !c.Deleted {
+		if c.ParentID != "" && !c.Deleted && !c.Shadow { // shadowed replies invisible to the author of the comment
 			if c.ParentID == comment.ID {
 				// When this code is reached, key "comment.ID" is not in cache.
 				// Calling cache.Get on it will put it in cache with 5 minutes TTL.
@@ -618,8 +735,7 @@ func (s *DataStore) SetTitle(locator store.Locator, commentID string) (comment s
 func (s *DataStore) Counts(siteID string, postIDs []string) ([]store.PostInfo, error) {
 	res := []store.PostInfo{}
 	for _, p := range postIDs {
-		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p}}
-		if c, err := s.Engine.Count(req); err == nil {
+		if c, err := s.Count(store.Locator{SiteID: siteID, URL: p}); err == nil {
 			res = append(res, store.PostInfo{URL: p, Count: c})
 		}
 	}
@@ -659,6 +775,65 @@ func (s *DataStore) IsAdmin(siteID, userID string) bool {
 	return false
 }
 
+// Role returns role of the user on the site. Admins are owners, moderators are taken from admin store
+// implementing admin.RoleStore and from moderators set at runtime.
+func (s *DataStore) Role(siteID, userID string) admin.Role {
+	if s.IsAdmin(siteID, userID) {
+		return admin.RoleOwner
+	}
+	mods, err := s.Moderators(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get moderators for %s, %v", siteID, err)
+		return admin.RoleNone
+	}
+	for _, m := range mods {
+		if m == userID {
+			return admin.RoleModerator
+		}
+	}
+	return admin.RoleNone
+}
+
+// Moderators returns ids of moderators of the site, ones defined by admin store followed by ones set at runtime
+func (s *DataStore) Moderators(siteID string) ([]string, error) {
+	res := []string{}
+	known := map[string]bool{}
+	if rs, ok := s.AdminStore.(admin.RoleStore); ok {
+		mods, err := rs.Moderators(siteID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get moderators of %s from admin store", siteID)
+		}
+		for _, m := range mods {
+			res, known[m] = append(res, m), true
+		}
+	}
+	mods, err := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.Moderator})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get moderators of %s", siteID)
+	}
+	for _, m := range mods {
+		if userID := m.(string); !known[userID] {
+			res, known[userID] = append(res, userID), true
+		}
+	}
+	return res, nil
+}
+
+// SetModerator sets or removes user as moderator of the site at runtime. Moderators defined by admin store
+// can't be removed this way
+func (s *DataStore) SetModerator(siteID, userID string, status bool) error {
+	if userID == "" {
+		return errors.New("user id required")
+	}
+	modStatus := engine.FlagFalse
+	if status {
+		modStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Moderator, Update: modStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
 // IsReadOnly checks if post read-only
 func (s *DataStore) IsReadOnly(locator store.Locator) bool {
 	req := engine.FlagRequest{Locator: locator, Flag: engine.ReadOnly}
@@ -678,6 +853,116 @@ func (s *DataStore) SetReadOnly(locator store.Locator, status bool) error {
 	return err
 }
 
+// IsModerated checks if new comments for the post should wait for admin approval, i.e. pre-moderation
+// enabled globally or for the post
+func (s *DataStore) IsModerated(locator store.Locator) bool {
+	if s.PreModeration {
+		return true
+	}
+	req := engine.FlagRequest{Locator: locator, Flag: engine.Moderated}
+	moderated, err := s.Engine.Flag(req)
+	return err == nil && moderated
+}
+
+// SetModerated sets pre-moderation status for the post
+func (s *DataStore) SetModerated(locator store.Locator, status bool) error {
+	modStatus := engine.FlagFalse
+	if status {
+		modStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: locator, Flag: engine.Moderated, Update: modStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
+// Pending returns comments waiting for moderation, oldest first. Empty locator's URL means all posts of the site
+func (s *DataStore) Pending(locator store.Locator, limit, skip int, user store.User) ([]store.Comment, error) {
+	req := engine.FindRequest{Locator: locator, Pending: true, Limit: limit, Skip: skip}
+	comments, err := s.Engine.Find(req)
+	if err != nil {
+		return comments, err
+	}
+	return s.alterComments(comments, user), nil
+}
+
+// Approve publishes pending comment and returns it
+func (s *DataStore) Approve(locator store.Locator, commentID string) (comment store.Comment, err error) {
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !comment.Pending {
+		return store.Comment{}, errors.Errorf("comment %s is not pending", commentID)
+	}
+	comment.Pending = false
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't approve comment %s", commentID)
+	}
+	return comment, nil
+}
+
+// ReportSpam sends admin's feedback about missed spam (spam=true) or false positive (spam=false)
+// to SpamChecker and sets spam mark of the comment accordingly
+func (s *DataStore) ReportSpam(locator store.Locator, commentID string, spam bool) (comment store.Comment, err error) {
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if s.SpamChecker != nil {
+		if err = s.SpamChecker.Feedback(SpamRequest{Comment: comment}, spam); err != nil {
+			return store.Comment{}, errors.Wrapf(err, "can't send spam feedback for %s", commentID)
+		}
+	}
+	comment.Spam = spam
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't update spam mark of %s", commentID)
+	}
+	return comment, nil
+}
+
+// checkSpam applies SpamAction to the comment detected as spam. Failed checks logged and ignored,
+// i.e. comment accepted if spam checker is unavailable
+func (s *DataStore) checkSpam(req SpamRequest) (store.Comment, error) {
+	comment := req.Comment
+	if s.SpamChecker == nil || comment.User.Admin {
+		return comment, nil
+	}
+	spam, err := s.SpamChecker.Check(req)
+	if err != nil {
+		log.Printf("[WARN] failed to check comment %s for spam, %v", comment.ID, err)
+		return comment, nil
+	}
+	if !spam {
+		return comment, nil
+	}
+
+	log.Printf("[INFO] comment %s from %s detected as spam, action %q", comment.ID, comment.User.ID, s.SpamAction)
+	switch s.SpamAction {
+	case SpamReject:
+		return comment, ErrSpamDetected
+	case SpamMark:
+		comment.Spam = true
+	default:
+		comment.Spam = true
+		comment.Pending = true
+	}
+	return comment, nil
+}
+
+// Reject removes pending comment
+func (s *DataStore) Reject(locator store.Locator, commentID string) error {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	if !comment.Pending || comment.Deleted {
+		return errors.Errorf("comment %s is not pending", commentID)
+	}
+	return s.Delete(locator, commentID, store.HardDelete)
+}
+
 // IsVerified checks if user verified
 func (s *DataStore) IsVerified(siteID, userID string) bool {
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Verified}
@@ -715,6 +1000,97 @@ func (s *DataStore) SetBlock(siteID, userID string, status bool, ttl time.Durati
 	return err
 }
 
+// IsShadowBanned checks if user shadow-banned
+func (s *DataStore) IsShadowBanned(siteID, userID string) bool {
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.ShadowBanned}
+	banned, _ := s.Engine.Flag(req)
+	return banned
+}
+
+// SetShadowBan sets/resets shadow-ban status for user. New comments of shadow-banned user visible to the user
+// and admins only. Removing the ban publishes all user's shadowed comments
+func (s *DataStore) SetShadowBan(siteID, userID string, status bool) error {
+	banStatus := engine.FlagFalse
+	if status {
+		banStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.ShadowBanned, Update: banStatus}
+	if _, err := s.Engine.Flag(req); err != nil {
+		return err
+	}
+	if status {
+		return nil
+	}
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID})
+	if err != nil {
+		return nil // user without comments
+	}
+	errs := new(multierror.Error)
+	for _, c := range comments {
+		if !c.Shadow {
+			continue
+		}
+		c.Shadow = false
+		errs = multierror.Append(errs, errors.Wrapf(s.Engine.Update(c), "can't publish comment %s", c.ID))
+	}
+	return errs.ErrorOrNil()
+}
+
+// ShadowBannedUsers returns list with all shadow-banned users for given siteID, name taken from the last user's comment
+func (s *DataStore) ShadowBannedUsers(siteID string) ([]store.User, error) {
+	banned, err := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.ShadowBanned})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]store.User, 0, len(banned))
+	for _, b := range banned {
+		user := store.User{ID: b.(string)}
+		findReq := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: user.ID, Limit: 1, Sort: "-time"}
+		if comments, e := s.Engine.Find(findReq); e == nil && len(comments) > 0 {
+			user.Name, user.Picture = comments[0].User.Name, comments[0].User.Picture
+		}
+		res = append(res, user)
+	}
+	return res, nil
+}
+
+// IsPostAuthor checks if user is author of the post, authors moderate comments of their posts
+func (s *DataStore) IsPostAuthor(locator store.Locator, userID string) bool {
+	if locator.URL == "" || userID == "" {
+		return false
+	}
+	author, _ := s.Engine.Flag(engine.FlagRequest{Locator: locator, UserID: userID, Flag: engine.PostAuthor})
+	return author
+}
+
+// SetPostAuthor sets or removes user as author of the post
+func (s *DataStore) SetPostAuthor(locator store.Locator, userID string, status bool) error {
+	if locator.URL == "" || userID == "" {
+		return errors.New("post url and user id required")
+	}
+	authorStatus := engine.FlagFalse
+	if status {
+		authorStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: locator, UserID: userID, Flag: engine.PostAuthor, Update: authorStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
+// PostAuthors returns ids of authors of the post
+func (s *DataStore) PostAuthors(locator store.Locator) ([]string, error) {
+	authors, err := s.Engine.ListFlags(engine.FlagRequest{Locator: locator, Flag: engine.PostAuthor})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get authors of %s", locator.URL)
+	}
+	res := make([]string, 0, len(authors))
+	for _, a := range authors {
+		res = append(res, a.(string))
+	}
+	return res, nil
+}
+
 // BlockedUsers returns list with all blocked users for given siteID
 func (s *DataStore) BlockedUsers(siteID string) (res []store.BlockedUser, err error) {
 	blocked, e := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.Blocked})
@@ -737,6 +1113,7 @@ func (s *DataStore) Info(locator store.Locator, readonlyAge int) (store.PostInfo
 	if len(res) == 0 {
 		return store.PostInfo{}, errors.Errorf("post %+v not found", locator)
 	}
+	res[0].Count -= s.shadowCount(locator)
 	return res[0], nil
 }
 
@@ -749,6 +1126,80 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 	return s.Engine.Delete(req)
 }
 
+// Restore brings back soft-deleted comment with its text, score and votes.
+// Works during DeletedRetention window only, retained content purged after by CleanupRetained
+func (s *DataStore) Restore(locator store.Locator, commentID string) (comment store.Comment, err error) {
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !comment.Deleted {
+		return store.Comment{}, errors.Errorf("comment %s is not deleted", commentID)
+	}
+	if comment.Retained == nil || time.Since(comment.Retained.DeletedAt) > s.DeletedRetention {
+		return store.Comment{}, errors.Errorf("content of comment %s not retained", commentID)
+	}
+	if err = comment.Restore(); err != nil {
+		return store.Comment{}, err
+	}
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't restore comment %s", commentID)
+	}
+	return comment, nil
+}
+
+// PurgeRetained clears content of soft-deleted comments retained longer than DeletedRetention. Returns number of purged
+func (s *DataStore) PurgeRetained(siteID string) (count int, err error) {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get list of posts for %s", siteID)
+	}
+
+	errs := new(multierror.Error)
+	for _, p := range posts {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}, Sort: "time"})
+		if e != nil {
+			errs = multierror.Append(errs, errors.Wrapf(e, "can't get comments for %s", p.URL))
+			continue
+		}
+		for _, c := range comments {
+			if !c.Deleted || c.Retained == nil || time.Since(c.Retained.DeletedAt) <= s.DeletedRetention {
+				continue
+			}
+			c.Retained = nil
+			if e = s.Engine.Update(c); e != nil {
+				errs = multierror.Append(errs, errors.Wrapf(e, "can't purge comment %s", c.ID))
+				continue
+			}
+			count++
+		}
+	}
+	return count, errs.ErrorOrNil()
+}
+
+// CleanupRetained runs PurgeRetained for all sites periodically, till context canceled
+func (s *DataStore) CleanupRetained(ctx context.Context, sites []string) {
+	log.Printf("[INFO] start retained comments cleanup, retention=%v", s.DeletedRetention)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] retained comments cleanup terminated, %v", ctx.Err())
+			return
+		case <-time.After(time.Hour):
+			for _, siteID := range sites {
+				count, err := s.PurgeRetained(siteID)
+				if err != nil {
+					log.Printf("[WARN] failed to purge retained comments for %s, %v", siteID, err)
+				}
+				if count > 0 {
+					log.Printf("[INFO] purged %d retained comments for %s", count, siteID)
+				}
+			}
+		}
+	}
+}
+
 // DeleteUser removes all comments from user
 func (s *DataStore) DeleteUser(siteID, userID string, mode store.DeleteMode) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, DeleteMode: mode}
@@ -758,13 +1209,50 @@ func (s *DataStore) DeleteUser(siteID, userID string, mode store.DeleteMode) err
 // List of commented posts
 func (s *DataStore) List(siteID string, limit, skip int) ([]store.PostInfo, error) {
 	req := engine.InfoRequest{Locator: store.Locator{SiteID: siteID}, Limit: limit, Skip: skip}
-	return s.Engine.Info(req)
+	res, err := s.Engine.Info(req)
+	if err != nil {
+		return res, err
+	}
+	if s.hasShadowBanned(siteID) {
+		for i := range res {
+			res[i].Count -= s.shadowCount(store.Locator{SiteID: siteID, URL: res[i].URL})
+		}
+	}
+	return res, nil
 }
 
-// Count gets number of comments for the post
+// Count gets number of comments for the post, comments of shadow-banned users not counted
 func (s *DataStore) Count(locator store.Locator) (int, error) {
 	req := engine.FindRequest{Locator: locator}
-	return s.Engine.Count(req)
+	count, err := s.Engine.Count(req)
+	if err != nil {
+		return count, err
+	}
+	return count - s.shadowCount(locator), nil
+}
+
+// shadowCount returns number of shadowed comments counted by the engine for the post, not deleted and not pending.
+// Shadowed comments exist only for sites with shadow-banned users, so posts of other sites are not loaded
+func (s *DataStore) shadowCount(locator store.Locator) (count int) {
+	if !s.hasShadowBanned(locator.SiteID) {
+		return 0
+	}
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return 0
+	}
+	for _, c := range comments {
+		if c.Shadow && !c.Deleted && !c.Pending {
+			count++
+		}
+	}
+	return count
+}
+
+// hasShadowBanned checks if site has any shadow-banned user
+func (s *DataStore) hasShadowBanned(siteID string) bool {
+	users, err := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.ShadowBanned})
+	return err == nil && len(users) > 0
 }
 
 // Metas returns metadata for users and posts
@@ -817,6 +1305,21 @@ func (s *DataStore) Metas(siteID string) (umetas []UserMetaData, pmetas []PostMe
 		m[v] = val
 	}
 
+	// process shadow-banned users
+	shadowBanned, err := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.ShadowBanned})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get list of shadow-banned users for %s", siteID)
+	}
+	for _, vi := range shadowBanned {
+		v := vi.(string)
+		val, ok := m[v]
+		if !ok {
+			val = UserMetaData{ID: v}
+		}
+		val.ShadowBanned = true
+		m[v] = val
+	}
+
 	// process users details
 	usersDetails, err := s.Engine.UserDetail(engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, Detail: engine.AllUserDetails})
 	if err != nil {
@@ -858,6 +1361,9 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 		if um.Verified {
 			errs = multierror.Append(errs, s.SetVerified(siteID, um.ID, true))
 		}
+		if um.ShadowBanned {
+			errs = multierror.Append(errs, s.SetShadowBan(siteID, um.ID, true))
+		}
 		// this code doesn't delete user details in case they are not set in import but present in DB already
 		if um.Details.Email != "" {
 			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserEmail, Update: um.Details.Email}
@@ -871,13 +1377,10 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 
 // User gets comment for given userID on siteID
 func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error) {
-	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
-		Limit: limit, Skip: skip, Sort: "-time"}
-	comments, err := s.Engine.Find(req)
-	if err != nil {
-		return comments, err
-	}
-	return s.alterComments(comments, user), nil
+	return s.findVisible(skip, limit, user, func(lim int) ([]store.Comment, error) {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Limit: lim, Sort: "-time"}
+		return s.Engine.Find(req)
+	})
 }
 
 // UserCount is comments count by user
@@ -888,12 +1391,280 @@ func (s *DataStore) UserCount(siteID, userID string) (int, error) {
 
 // Last gets last comments for site, cross-post. Limited by count and optional since ts
 func (s *DataStore) Last(siteID string, limit int, since time.Time, user store.User) ([]store.Comment, error) {
-	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, Limit: limit, Since: since, Sort: "-time"}
-	comments, err := s.Engine.Find(req)
+	return s.findVisible(0, limit, user, func(lim int) ([]store.Comment, error) {
+		return s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, Limit: lim, Since: since, Sort: "-time"})
+	})
+}
+
+// Search gets comments matching full-text query, filtered by optional request fields, like user or post url
+func (s *DataStore) Search(req engine.SearchRequest, user store.User) ([]store.Comment, error) {
+	skip := req.Skip
+	return s.findVisible(skip, req.Limit, user, func(lim int) ([]store.Comment, error) {
+		req.Limit, req.Skip = lim, 0
+		return s.Engine.Search(req)
+	})
+}
+
+// findVisible gets up to limit comments visible to the user after skipping first skip visible ones.
+// Engine can't tell hidden comments, so skip applied here and find repeated with doubled limit
+// till it returns enough visible comments or all it has
+func (s *DataStore) findVisible(skip, limit int, user store.User, find func(limit int) ([]store.Comment, error)) ([]store.Comment, error) {
+	if skip < 0 {
+		skip = 0
+	}
+	lim := 0 // no limit
+	if limit > 0 {
+		lim = skip + limit
+	}
+	for {
+		comments, err := find(lim)
+		if err != nil {
+			return comments, err
+		}
+		res := s.alterComments(comments, user)
+		if lim <= 0 || len(res) >= skip+limit || len(comments) < lim {
+			if skip >= len(res) {
+				return []store.Comment{}, nil
+			}
+			res = res[skip:]
+			if limit > 0 && len(res) > limit {
+				res = res[:limit]
+			}
+			return res, nil
+		}
+		lim *= 2
+	}
+}
+
+// Report adds user's report about the comment and returns all reports for the comment.
+// Each user can report comment once, reports for deleted and own comments rejected
+func (s *DataStore) Report(locator store.Locator, commentID string, user store.User, reason store.ReportReason, text string) ([]store.Report, error) {
+	if !reason.Valid() {
+		return nil, errors.Errorf("invalid report reason %q", reason)
+	}
+
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
 	if err != nil {
-		return comments, err
+		return nil, err
 	}
-	return s.alterComments(comments, user), nil
+	if comment.Deleted {
+		return nil, errors.Errorf("comment %s deleted", commentID)
+	}
+	if comment.User.ID == user.ID {
+		return nil, errors.Errorf("can't report own comment %s", commentID)
+	}
+
+	req := engine.ReportRequest{Locator: locator, CommentID: commentID}
+	reports, err := s.Engine.Report(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reports {
+		if r.UserID == user.ID {
+			return nil, ErrAlreadyReported
+		}
+	}
+
+	req.Add = &store.Report{Locator: locator, CommentID: commentID, UserID: user.ID, Reason: reason,
+		Text: text, Timestamp: time.Now()}
+	return s.Engine.Report(req)
+}
+
+// ReportedComments returns reported comments of the site with their reports, in order of the first report
+func (s *DataStore) ReportedComments(siteID string, user store.User) ([]ReportedComment, error) {
+	reports, err := s.Engine.Report(engine.ReportRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []ReportedComment{}
+	idx := map[store.Locator]map[string]int{} // locator -> comment id -> index in res
+	for _, r := range reports {
+		loc := store.Locator{SiteID: siteID, URL: r.Locator.URL}
+		if i, ok := idx[loc][r.CommentID]; ok {
+			res[i].Reports = append(res[i].Reports, r)
+			continue
+		}
+		c, e := s.Engine.Get(engine.GetRequest{Locator: loc, CommentID: r.CommentID})
+		if e != nil {
+			log.Printf("[WARN] can't get reported comment %s, %v", r.CommentID, e)
+			continue
+		}
+		if idx[loc] == nil {
+			idx[loc] = map[string]int{}
+		}
+		idx[loc][r.CommentID] = len(res)
+		res = append(res, ReportedComment{Comment: s.alterComment(c, user), Reports: []store.Report{r}})
+	}
+	return res, nil
+}
+
+// ResolveReports deletes reported comment and clears its reports
+func (s *DataStore) ResolveReports(locator store.Locator, commentID string) error {
+	if err := s.Delete(locator, commentID, store.SoftDelete); err != nil {
+		return errors.Wrapf(err, "can't delete reported comment %s", commentID)
+	}
+	return s.DismissReports(locator, commentID)
+}
+
+// DismissReports clears reports of the comment, keeping the comment as is
+func (s *DataStore) DismissReports(locator store.Locator, commentID string) error {
+	_, err := s.Engine.Report(engine.ReportRequest{Locator: locator, CommentID: commentID, Clear: true})
+	return err
+}
+
+// AddAudit records admin action, sets id and timestamp if not defined
+func (s *DataStore) AddAudit(rec store.AuditRecord) error {
+	if rec.ID == "" {
+		rec.ID = uuid.New().String()
+	}
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	_, err := s.Engine.Audit(engine.AuditRequest{SiteID: rec.SiteID, Add: &rec})
+	return err
+}
+
+// AuditLog returns admin actions of the site, newest first, filtered by request's actor, action, target and time range
+func (s *DataStore) AuditLog(req engine.AuditRequest) ([]store.AuditRecord, error) {
+	req.Add = nil
+	return s.Engine.Audit(req)
+}
+
+// Subscribe sets user's subscription to the whole post, replaces previous one. SubscriptionPost sends all new comments
+// of the post to the user, SubscriptionMute stops all notifications about the post, including replies.
+// Use MuteThread to mute single thread of the post
+func (s *DataStore) Subscribe(locator store.Locator, userID string, mode store.SubscriptionMode) error {
+	if !mode.Valid() {
+		return errors.Errorf("invalid subscription mode %q", mode)
+	}
+	sub := store.Subscription{Locator: locator, UserID: userID, Mode: mode, Timestamp: time.Now()}
+	_, err := s.Engine.Subscription(engine.SubscriptionRequest{Locator: locator, Set: &sub})
+	return err
+}
+
+// Unsubscribe removes user's subscription to the post, replies notified as usual after that
+func (s *DataStore) Unsubscribe(locator store.Locator, userID string) error {
+	_, err := s.Engine.Subscription(engine.SubscriptionRequest{Locator: locator, UserID: userID, Remove: true})
+	return err
+}
+
+// MuteThread stops notifications about the thread of the comment, including replies. Thread is keyed by
+// its root comment, returned as thread id. Subscription to the post and mutes of other threads are kept
+func (s *DataStore) MuteThread(locator store.Locator, userID, commentID string) (threadID string, err error) {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get comment %s", commentID)
+	}
+	for comment.ParentID != "" {
+		if comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: comment.ParentID}); err != nil {
+			return "", errors.Wrapf(err, "can't get parent of %s", commentID)
+		}
+	}
+	sub := store.Subscription{Locator: locator, UserID: userID, ThreadID: comment.ID, Mode: store.SubscriptionMute,
+		Timestamp: time.Now()}
+	_, err = s.Engine.Subscription(engine.SubscriptionRequest{Locator: locator, Set: &sub})
+	return comment.ID, err
+}
+
+// UnmuteThread removes user's mute of the thread with given root comment
+func (s *DataStore) UnmuteThread(locator store.Locator, userID, threadID string) error {
+	_, err := s.Engine.Subscription(engine.SubscriptionRequest{Locator: locator, UserID: userID, ThreadID: threadID, Remove: true})
+	return err
+}
+
+// Subscriptions returns all subscriptions of the post
+func (s *DataStore) Subscriptions(locator store.Locator) ([]store.Subscription, error) {
+	return s.Engine.Subscription(engine.SubscriptionRequest{Locator: locator})
+}
+
+// UserSubscriptions returns user's subscriptions for all posts of the site
+func (s *DataStore) UserSubscriptions(siteID, userID string) ([]store.Subscription, error) {
+	return s.Engine.Subscription(engine.SubscriptionRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID})
+}
+
+// CreateAPIToken makes new API token of the site for the admin and returns its value along with the stored record.
+// The value can't be restored later, only its hash kept. Zero ttl makes token without expiration
+func (s *DataStore) CreateAPIToken(siteID, userID, name string, scopes []store.TokenScope, ttl time.Duration) (value string, tkn store.APIToken, err error) {
+	if strings.TrimSpace(name) == "" {
+		return "", store.APIToken{}, errors.New("token name required")
+	}
+	if ttl < 0 {
+		return "", store.APIToken{}, errors.Errorf("invalid token ttl %v", ttl)
+	}
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return "", store.APIToken{}, errors.Errorf("invalid token scope %q", scope)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", store.APIToken{}, errors.Wrap(err, "can't make token value")
+	}
+	value = hex.EncodeToString(buf)
+
+	tkn = store.APIToken{ID: uuid.New().String(), SiteID: siteID, Name: strings.TrimSpace(name), UserID: userID,
+		Hash: s.apiTokenHash(value), Scopes: scopes, Timestamp: time.Now()}
+	if ttl > 0 {
+		tkn.Expires = tkn.Timestamp.Add(ttl)
+	}
+	if _, err = s.Engine.APIToken(engine.APITokenRequest{SiteID: siteID, Add: &tkn}); err != nil {
+		return "", store.APIToken{}, err
+	}
+	tkn.Hash = ""
+	return value, tkn, nil
+}
+
+// APITokens returns all API tokens of the site, including expired, without hashes
+func (s *DataStore) APITokens(siteID string) ([]store.APIToken, error) {
+	tokens, err := s.Engine.APIToken(engine.APITokenRequest{SiteID: siteID})
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken removes API token of the site, token can't be used after that
+func (s *DataStore) RevokeAPIToken(siteID, id string) error {
+	if id == "" {
+		return errors.New("token id required")
+	}
+	_, err := s.Engine.APIToken(engine.APITokenRequest{SiteID: siteID, Revoke: id})
+	return err
+}
+
+// CheckAPIToken returns API token of the site matching the value, rejects unknown and expired tokens.
+// Token works while its creator is admin of the site, tokens made with admin's basic auth are not limited this way
+func (s *DataStore) CheckAPIToken(siteID, value string) (store.APIToken, error) {
+	if value == "" {
+		return store.APIToken{}, errors.New("empty token")
+	}
+	tokens, err := s.Engine.APIToken(engine.APITokenRequest{SiteID: siteID, Hash: s.apiTokenHash(value)})
+	if err != nil {
+		return store.APIToken{}, err
+	}
+	if len(tokens) == 0 {
+		return store.APIToken{}, errors.New("unknown token")
+	}
+	tkn := tokens[0]
+	if tkn.Expired(time.Now()) {
+		return store.APIToken{}, errors.Errorf("token %s expired", tkn.ID)
+	}
+	if tkn.UserID != "admin" && !s.IsAdmin(siteID, tkn.UserID) {
+		return store.APIToken{}, errors.Errorf("token %s rejected, %s is not admin of %s", tkn.ID, tkn.UserID, siteID)
+	}
+	tkn.Hash = ""
+	return tkn, nil
+}
+
+// apiTokenHash makes hash of the token value, only hash stored by the engine
+func (s *DataStore) apiTokenHash(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(h[:])
 }
 
 // Close store service
@@ -936,13 +1707,30 @@ func (s *DataStore) getScopedLocks(id string) (lock sync.Locker) {
 }
 
 func (s *DataStore) alterComments(cc []store.Comment, user store.User) (res []store.Comment) {
-	res = make([]store.Comment, len(cc))
-	for i, c := range cc {
-		res[i] = s.alterComment(c, user)
+	res = make([]store.Comment, 0, len(cc))
+	for _, c := range cc {
+		if !s.isVisible(c, user) {
+			continue
+		}
+		res = append(res, s.alterComment(c, user))
 	}
 	return res
 }
 
+// isVisible checks if comment can be shown to the user.
+// Pending comments and comments of shadow-banned users visible to author and admins only
+func (s *DataStore) isVisible(c store.Comment, user store.User) bool {
+	return (!c.Pending && !c.Shadow) || user.Admin || (user.ID != "" && user.ID == c.User.ID)
+}
+
+// invisibleErr makes error for the comment hidden from the user, shadowed comment reported as missing
+func (s *DataStore) invisibleErr(c store.Comment) error {
+	if c.Shadow {
+		return errors.Errorf("comment %s not found", c.ID)
+	}
+	return errors.Errorf("comment %s is pending", c.ID)
+}
+
 func (s *DataStore) alterComment(c store.Comment, user store.User) (res store.Comment) {
 
 	blocReq := engine.FlagRequest{Flag: engine.Blocked, Locator: store.Locator{SiteID: c.Locator.SiteID}, UserID: c.User.ID}
@@ -959,12 +1747,16 @@ func (s *DataStore) alterComment(c store.Comment, user store.User) (res store.Co
 		c.User.Verified, _ = s.Engine.Flag(verifReq)
 	}
 
-	// hide info from non-admins
+	// hide info from non-admins, shadow-banned user should not know about the ban
 	if !user.Admin {
 		c.User.IP = ""
+		c.Shadow = false
+		c.Spam = false
 	}
 
 	c = s.prepVotes(c, user)
+	c.Revisions = nil                              // previous versions available via History only
+	c.Retained = nil                               // content of deleted comment available via Restore only
 	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // urls prior to #927
 	return c
 }
@@ -988,10 +1780,10 @@ func (s *DataStore) prepVotes(c store.Comment, user store.User) store.Comment {
 }
 
 // get secret for given siteID
-// Note: secret shared across sites, but some sites can be disabled.
+// Note: secret can be shared across sites, but some sites can be disabled.
 func (s *DataStore) getSecret(siteID string) (secret string, err error) {
 
-	if secret, err = s.AdminStore.Key("any"); err != nil {
+	if secret, err = s.AdminStore.Key(siteID); err != nil {
 		return "", errors.Wrapf(err, "can't get secret for site %s", siteID)
 	}
 
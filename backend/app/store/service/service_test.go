@@ -671,6 +671,159 @@ func TestService_EditComment(t *testing.T) {
 	assert.NoError(t, err, "allow second edit")
 }
 
+func TestService_History(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	versions, err := b.History(locator, "id-2", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(versions), "not edited comment has a single version")
+	assert.Equal(t, "some text2", versions[0].Text)
+	assert.Equal(t, "user1", versions[0].EditorID)
+	assert.Equal(t, "", versions[0].Diff)
+
+	_, err = b.EditComment(locator, "id-2", EditRequest{Orig: "line 1\nline 2", Text: "line 1<br>line 2", Summary: "first edit"})
+	require.NoError(t, err)
+	comment, err := b.EditComment(locator, "id-2", EditRequest{Orig: "line 1\nline 3", Text: "line 1<br>line 3",
+		Summary: "admin edit", Admin: true, EditorID: "admin1"})
+	require.NoError(t, err)
+	assert.Nil(t, comment.Revisions)
+
+	c, err := b.Engine.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(c.Revisions), "revisions stored")
+	c, err = b.Get(locator, "id-2", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Nil(t, c.Revisions, "revisions hidden in comment")
+
+	versions, err = b.History(locator, "id-2", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(versions))
+	assert.Equal(t, "some text2", versions[0].Text)
+	assert.Equal(t, "user1", versions[0].EditorID)
+	assert.Equal(t, "line 1<br>line 2", versions[1].Text)
+	assert.Equal(t, "user1", versions[1].EditorID, "editor defaults to the author")
+	assert.Equal(t, "first edit", versions[1].Summary)
+	assert.Equal(t, "--- revision 0\n+++ revision 1\n@@ -1 +1,2 @@\n-some text2\n+line 1\n+line 2\n", versions[1].Diff)
+	assert.Equal(t, "line 1\nline 3", versions[2].Orig)
+	assert.Equal(t, "admin1", versions[2].EditorID)
+	assert.Equal(t, "admin edit", versions[2].Summary)
+	assert.Equal(t, "--- revision 1\n+++ revision 2\n@@ -1,2 +1,2 @@\n line 1\n-line 2\n+line 3\n", versions[2].Diff)
+	assert.True(t, versions[2].Timestamp.After(versions[1].Timestamp))
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	_, err = b.History(locator, "id-2", store.User{})
+	assert.EqualError(t, err, "comment id-2 deleted")
+	versions, err = b.History(locator, "id-2", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(versions), "admin sees all versions of deleted comment")
+	assert.Equal(t, "line 1<br>line 3", versions[2].Text)
+	assert.Equal(t, "admin1", versions[2].EditorID)
+
+	_, err = b.History(locator, "bad-id", store.User{Admin: true})
+	assert.Error(t, err)
+}
+
+func TestService_Audit(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	require.NoError(t, b.AddAudit(store.AuditRecord{SiteID: "radio-t", Actor: "admin1", Action: store.AuditPin, Target: "id-1"}))
+	ts := time.Date(2017, 12, 20, 15, 18, 22, 0, time.UTC)
+	require.NoError(t, b.AddAudit(store.AuditRecord{ID: "r1", SiteID: "radio-t", Actor: "admin2", Action: store.AuditBlock,
+		Target: "user1", Timestamp: ts}))
+
+	res, err := b.AuditLog(engine.AuditRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.NotEmpty(t, res[0].ID, "id generated")
+	assert.Equal(t, "admin1", res[0].Actor)
+	assert.True(t, time.Since(res[0].Timestamp) < time.Second, "timestamp set")
+	assert.Equal(t, "r1", res[1].ID)
+	assert.True(t, ts.Equal(res[1].Timestamp))
+
+	res, err = b.AuditLog(engine.AuditRequest{SiteID: "radio-t", Actor: "admin2", Add: &store.AuditRecord{ID: "bad"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "listing only")
+
+	assert.Error(t, b.AddAudit(store.AuditRecord{SiteID: "bad", Actor: "admin1", Action: store.AuditPin}))
+}
+
+func TestService_Subscriptions(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	loc := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+	loc2 := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}
+	require.NoError(t, b.Subscribe(loc, "user2", store.SubscriptionPost))
+	require.NoError(t, b.Subscribe(loc, "user3", store.SubscriptionMute))
+	require.NoError(t, b.Subscribe(loc2, "user2", store.SubscriptionMute))
+	assert.EqualError(t, b.Subscribe(loc, "user2", "bad"), `invalid subscription mode "bad"`)
+
+	subs, err := b.Subscriptions(loc)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs))
+	assert.Equal(t, "user2", subs[0].UserID)
+	assert.Equal(t, store.SubscriptionPost, subs[0].Mode)
+	assert.Equal(t, loc, subs[0].Locator)
+	assert.Equal(t, store.SubscriptionMute, subs[1].Mode)
+
+	subs, err = b.UserSubscriptions("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(subs))
+
+	require.NoError(t, b.Unsubscribe(loc, "user2"))
+	subs, err = b.UserSubscriptions("radio-t", "user2")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, loc2, subs[0].Locator)
+}
+
+func TestService_MuteThread(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	loc := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+	_, err := b.Create(store.Comment{ID: "id-3", ParentID: "id-1", Text: "reply", Locator: loc, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-4", ParentID: "id-3", Text: "reply2", Locator: loc, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Subscribe(loc, "user2", store.SubscriptionPost))
+
+	threadID, err := b.MuteThread(loc, "user2", "id-4")
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", threadID, "keyed by root comment")
+	threadID, err = b.MuteThread(loc, "user2", "id-2")
+	require.NoError(t, err)
+	assert.Equal(t, "id-2", threadID)
+	_, err = b.MuteThread(loc, "user2", "bad")
+	assert.Error(t, err)
+
+	subs, err := b.UserSubscriptions("radio-t", "user2")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(subs), "post subscription kept")
+	assert.Equal(t, store.SubscriptionPost, subs[0].Mode)
+	assert.Equal(t, "", subs[0].ThreadID)
+	assert.Equal(t, store.SubscriptionMute, subs[1].Mode)
+	assert.Equal(t, "id-1", subs[1].ThreadID)
+	assert.Equal(t, "id-2", subs[2].ThreadID)
+
+	require.NoError(t, b.UnmuteThread(loc, "user2", "id-1"))
+	subs, err = b.UserSubscriptions("radio-t", "user2")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs))
+	assert.Equal(t, "id-2", subs[1].ThreadID)
+}
+
 func TestService_DeleteComment(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
@@ -964,6 +1117,123 @@ func TestService_IsAdmin(t *testing.T) {
 	assert.False(t, b.IsAdmin("radio-t-bad", "user1"))
 }
 
+func TestService_Role(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	adm := admin.NewStaticStore("secret 123", []string{"radio-t"}, []string{"user2"}, "user@email.com")
+	adm.SetModerators([]string{"user3"})
+	b := DataStore{Engine: eng, AdminStore: adm}
+
+	assert.Equal(t, admin.RoleNone, b.Role("radio-t", "user1"))
+	assert.Equal(t, admin.RoleOwner, b.Role("radio-t", "user2"))
+	assert.Equal(t, admin.RoleModerator, b.Role("radio-t", "user3"))
+
+	require.NoError(t, b.SetModerator("radio-t", "user1", true))
+	require.NoError(t, b.SetModerator("radio-t", "user3", true))
+	assert.Equal(t, admin.RoleModerator, b.Role("radio-t", "user1"), "moderator set at runtime")
+	mods, err := b.Moderators("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user3", "user1"}, mods, "static moderators first, no duplicates")
+	require.NoError(t, b.SetModerator("radio-t", "user1", false))
+	assert.Equal(t, admin.RoleNone, b.Role("radio-t", "user1"), "runtime moderator removed")
+	assert.Error(t, b.SetModerator("radio-t", "", true))
+
+	b.AdminStore = &admin.RPC{}
+	assert.Equal(t, admin.RoleNone, b.Role("radio-t", "user3"), "rpc store failed")
+}
+
+func TestService_APIToken(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"admin1"}, "")}
+	defer b.Close()
+
+	value, tkn, err := b.CreateAPIToken("radio-t", "admin1", " ci ", []store.TokenScope{store.TokenScopeRead}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 64, len(value))
+	assert.Equal(t, "ci", tkn.Name)
+	assert.Equal(t, "admin1", tkn.UserID)
+	assert.Equal(t, "", tkn.Hash, "hash not exposed")
+	assert.True(t, tkn.Expires.IsZero())
+
+	value2, tkn2, err := b.CreateAPIToken("radio-t", "admin1", "short", nil, time.Millisecond*50)
+	require.NoError(t, err)
+	assert.NotEqual(t, value, value2)
+	assert.False(t, tkn2.Expires.IsZero())
+
+	_, _, err = b.CreateAPIToken("radio-t", "admin1", "", nil, 0)
+	assert.EqualError(t, err, "token name required")
+	_, _, err = b.CreateAPIToken("radio-t", "admin1", "bad", []store.TokenScope{"all"}, 0)
+	assert.EqualError(t, err, `invalid token scope "all"`)
+	_, _, err = b.CreateAPIToken("radio-t", "admin1", "bad", nil, -time.Second)
+	assert.EqualError(t, err, "invalid token ttl -1s")
+
+	tokens, err := b.APITokens("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(tokens))
+	assert.Equal(t, tkn.ID, tokens[0].ID)
+	assert.Equal(t, "", tokens[0].Hash)
+
+	res, err := b.CheckAPIToken("radio-t", value)
+	require.NoError(t, err)
+	assert.Equal(t, tkn.ID, res.ID)
+	assert.Equal(t, []store.TokenScope{store.TokenScopeRead}, res.Scopes)
+	assert.Equal(t, "", res.Hash)
+
+	_, err = b.CheckAPIToken("radio-t", "bad")
+	assert.EqualError(t, err, "unknown token")
+	_, err = b.CheckAPIToken("radio-t", "")
+	assert.EqualError(t, err, "empty token")
+
+	_, err = b.CheckAPIToken("radio-t", value2)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 60)
+	_, err = b.CheckAPIToken("radio-t", value2)
+	assert.EqualError(t, err, "token "+tkn2.ID+" expired")
+
+	b.AdminStore = admin.NewStaticStore("secret 123", nil, []string{"admin2"}, "")
+	_, err = b.CheckAPIToken("radio-t", value)
+	assert.EqualError(t, err, "token "+tkn.ID+" rejected, admin1 is not admin of radio-t", "creator removed from admins")
+	basicValue, _, err := b.CreateAPIToken("radio-t", "admin", "basic", nil, 0)
+	require.NoError(t, err)
+	_, err = b.CheckAPIToken("radio-t", basicValue)
+	assert.NoError(t, err, "token made with basic auth not limited")
+	b.AdminStore = admin.NewStaticStore("secret 123", nil, []string{"admin1"}, "")
+
+	require.NoError(t, b.RevokeAPIToken("radio-t", tkn.ID))
+	_, err = b.CheckAPIToken("radio-t", value)
+	assert.EqualError(t, err, "unknown token")
+	assert.EqualError(t, b.RevokeAPIToken("radio-t", ""), "token id required")
+}
+
+func TestService_PostAuthor(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	loc := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+
+	assert.False(t, b.IsPostAuthor(loc, "user1"))
+	require.NoError(t, b.SetPostAuthor(loc, "user1", true))
+	require.NoError(t, b.SetPostAuthor(loc, "user2", true))
+	assert.True(t, b.IsPostAuthor(loc, "user1"))
+	assert.False(t, b.IsPostAuthor(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/other"}, "user1"))
+	assert.False(t, b.IsPostAuthor(store.Locator{SiteID: "radio-t"}, "user1"), "no post")
+
+	authors, err := b.PostAuthors(loc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2"}, authors)
+
+	require.NoError(t, b.SetPostAuthor(loc, "user1", false))
+	assert.False(t, b.IsPostAuthor(loc, "user1"))
+	authors, err = b.PostAuthors(loc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2"}, authors)
+
+	assert.EqualError(t, b.SetPostAuthor(store.Locator{SiteID: "radio-t"}, "user1", true), "post url and user id required")
+	_, err = b.PostAuthors(store.Locator{SiteID: "radio-t"})
+	assert.Error(t, err)
+}
+
 func TestService_HasReplies(t *testing.T) {
 
 	// two comments for https://radio-t.com, no reply
@@ -1325,6 +1595,447 @@ func TestService_UserCount(t *testing.T) {
 	assert.EqualError(t, err, "no comments for user userBad in store for radio-t site")
 }
 
+func TestService_Search(t *testing.T) {
+
+	// two comments for https://radio-t.com, no reply
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, EditDuration: 100 * time.Millisecond,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	comment := store.Comment{
+		ID:        "id-3",
+		Timestamp: time.Date(2018, 12, 20, 15, 18, 22, 0, time.Local),
+		Text:      `some other text`,
+		Locator:   store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		User:      store.User{ID: "user2", Name: "user name"},
+		Votes:     map[string]bool{"user1": true},
+	}
+	_, err := b.Create(comment)
+	require.NoError(t, err)
+
+	cc, err := b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "Some text"}, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cc))
+	assert.Equal(t, "id-3", cc[0].ID, "reverse sort")
+	assert.Equal(t, 1, cc[0].Vote, "altered for the user")
+	assert.Nil(t, cc[0].Votes)
+	assert.Equal(t, "id-1", cc[1].ID)
+
+	cc, err = b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "text", UserID: "user1"}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cc))
+	assert.Equal(t, "id-1", cc[0].ID)
+
+	_, err = b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "bad"}, Query: "text"}, store.User{})
+	assert.Error(t, err)
+}
+
+func TestService_PreModeration(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, PreModeration: true,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	id, err := b.Create(store.Comment{Text: "pending text", Locator: locator, User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "admin text", Locator: locator, User: store.User{ID: "user2", Name: "user2", Admin: true}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "imp-1", Text: "imported text", Locator: locator, Imported: true,
+		User: store.User{ID: "user4", Name: "user4"}})
+	require.NoError(t, err)
+
+	res, err := b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "pending hidden from other users")
+
+	res, err = b.Find(locator, "time", store.User{ID: "user3"})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(res), "pending visible to author")
+	assert.Equal(t, id, res[2].ID)
+	assert.True(t, res[2].Pending)
+
+	res, err = b.Find(locator, "time", store.User{ID: "user5", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(res), "pending visible to admin")
+
+	_, err = b.Get(locator, id, store.User{ID: "user1"})
+	assert.EqualError(t, err, fmt.Sprintf("comment %s is pending", id))
+	_, err = b.Get(locator, id, store.User{ID: "user3"})
+	assert.NoError(t, err)
+
+	pending, err := b.Pending(store.Locator{SiteID: "radio-t"}, 0, 0, store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending), "only regular user's comment pending")
+	assert.Equal(t, id, pending[0].ID)
+
+	c, err := b.Approve(locator, id)
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+	_, err = b.Approve(locator, id)
+	assert.EqualError(t, err, fmt.Sprintf("comment %s is not pending", id))
+
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(res), "approved visible to all")
+
+	id, err = b.Create(store.Comment{Text: "spam", Locator: locator, User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Reject(locator, id))
+	assert.EqualError(t, b.Reject(locator, id), fmt.Sprintf("comment %s is not pending", id))
+	pending, err = b.Pending(store.Locator{SiteID: "radio-t"}, 0, 0, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+	assert.EqualError(t, b.Reject(locator, "id-1"), "comment id-1 is not pending")
+
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	id, err = b.Create(store.Comment{Text: "pending text last", Locator: locator, User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+	c2, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, count, c2, "pending not counted")
+
+	res, err = b.Last("radio-t", 1, time.Time{}, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "limit applied to visible comments")
+	assert.NotEqual(t, id, res[0].ID)
+	res, err = b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "text", Limit: 1}, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "limit applied to visible comments")
+	assert.NotEqual(t, id, res[0].ID)
+
+	all, err := b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "text"}, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.True(t, len(all) > 2)
+	res, err = b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "text", Limit: 1, Skip: 1},
+		store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "skip applied to visible comments")
+	assert.Equal(t, all[1].ID, res[0].ID)
+	res, err = b.Search(engine.SearchRequest{Locator: store.Locator{SiteID: "radio-t"}, Query: "text", Skip: len(all)},
+		store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	all, err = b.User("radio-t", "user3", 0, 0, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(all), "approved comment only")
+	res, err = b.User("radio-t", "user3", 1, 0, store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "limit applied to visible comments")
+	assert.Equal(t, all[0].ID, res[0].ID)
+	res, err = b.User("radio-t", "user3", 1, 1, store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "skip applied to visible comments")
+	res, err = b.User("radio-t", "user3", 1, 1, store.User{ID: "user3"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "pending visible to author")
+	assert.Equal(t, all[0].ID, res[0].ID)
+}
+
+func TestService_ShadowBan(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	assert.False(t, b.IsShadowBanned("radio-t", "user1"))
+	require.NoError(t, b.SetShadowBan("radio-t", "user1", true))
+	assert.True(t, b.IsShadowBanned("radio-t", "user1"))
+
+	id, err := b.Create(store.Comment{Text: "spam text", Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+
+	res, err := b.Find(locator, "time", store.User{ID: "user3"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "shadowed comment hidden from others, comments made before the ban visible")
+
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "shadowed comment visible to author")
+	assert.Equal(t, id, res[2].ID)
+	assert.False(t, res[2].Shadow, "author doesn't know about the ban")
+
+	res, err = b.Find(locator, "time", store.User{ID: "user2", Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "shadowed comment visible to admin")
+	assert.True(t, res[2].Shadow)
+
+	_, err = b.Get(locator, id, store.User{ID: "user3"})
+	assert.EqualError(t, err, fmt.Sprintf("comment %s not found", id))
+	_, err = b.Get(locator, id, store.User{ID: "user1"})
+	assert.NoError(t, err)
+
+	last, err := b.Last("radio-t", 10, time.Time{}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(last), "shadowed excluded from last")
+
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "shadowed not counted")
+	counts, err := b.Counts("radio-t", []string{"https://radio-t.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com", Count: 2}}, counts)
+	info, err := b.Info(locator, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Count)
+
+	users, err := b.ShadowBannedUsers("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []store.User{{ID: "user1", Name: "user name"}}, users)
+
+	umetas, _, err := b.Metas("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(umetas))
+	assert.True(t, umetas[0].ShadowBanned)
+
+	// admin's comments never shadowed
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", true))
+	_, err = b.Create(store.Comment{Text: "admin text", Locator: locator, User: store.User{ID: "user2", Name: "user2", Admin: true}})
+	require.NoError(t, err)
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", false))
+
+	// un-ban publishes shadowed comments
+	require.NoError(t, b.SetShadowBan("radio-t", "user1", false))
+	assert.False(t, b.IsShadowBanned("radio-t", "user1"))
+	res, err = b.Find(locator, "time", store.User{ID: "user3"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "all comments visible")
+	count, err = b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	users, err = b.ShadowBannedUsers("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []store.User{}, users)
+}
+
+func TestService_Restore(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, DeletedRetention: time.Hour, MaxVotes: -1,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	orig, err := b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+
+	_, err = b.Restore(locator, "id-1")
+	assert.EqualError(t, err, "comment id-1 is not deleted")
+
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete))
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	c, err := b.Get(locator, "id-1", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.Equal(t, "", c.Text)
+	assert.Nil(t, c.Retained, "retained content hidden")
+
+	c, err = b.Restore(locator, "id-1")
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	restored, err := b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, orig, restored, "text, score and votes restored")
+	count, err = b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "restored comment counted")
+
+	// retention expired
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	c, err = eng.Get(engine.GetRequest{Locator: locator, CommentID: "id-2"})
+	require.NoError(t, err)
+	require.NotNil(t, c.Retained)
+	c.Retained.DeletedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, eng.Update(c))
+	_, err = b.Restore(locator, "id-2")
+	assert.EqualError(t, err, "content of comment id-2 not retained")
+
+	// pending comment restored as pending
+	pendingLoc := store.Locator{URL: "https://radio-t.com/pending", SiteID: "radio-t"}
+	require.NoError(t, b.SetModerated(pendingLoc, true))
+	_, err = b.Create(store.Comment{ID: "id-p", Text: "pending", Locator: pendingLoc, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(pendingLoc, "id-p", store.SoftDelete))
+	c, err = b.Restore(pendingLoc, "id-p")
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "restored comment still waits for moderation")
+	pending, err := b.Pending(pendingLoc, 0, 0, store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending))
+	assert.Equal(t, "id-p", pending[0].ID)
+	count, err = b.Count(pendingLoc)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "pending comment not counted")
+
+	purged, err := b.PurgeRetained("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	c, err = eng.Get(engine.GetRequest{Locator: locator, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.Nil(t, c.Retained, "expired content purged")
+	purged, err = b.PurgeRetained("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	// hard delete doesn't retain anything
+	require.NoError(t, b.Delete(locator, "id-1", store.HardDelete))
+	_, err = b.Restore(locator, "id-1")
+	assert.EqualError(t, err, "content of comment id-1 not retained")
+}
+
+func TestService_CleanupRetained(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	b.CleanupRetained(ctx, []string{"radio-t"}) // terminated by context
+}
+
+func TestService_ShadowBanPending(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, PreModeration: true,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	require.NoError(t, b.SetShadowBan("radio-t", "user3", true))
+	id, err := b.Create(store.Comment{Text: "spam text", Locator: locator, User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+	c, err := b.Get(locator, id, store.User{Admin: true})
+	require.NoError(t, err)
+	require.True(t, c.Pending)
+	require.True(t, c.Shadow)
+
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "pending shadowed comment not subtracted twice")
+	info, err := b.Info(locator, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Count)
+	list, err := b.List("radio-t", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, 2, list[0].Count)
+
+	// approved shadowed comment counted by the engine and subtracted once
+	_, err = b.Approve(locator, id)
+	require.NoError(t, err)
+	count, err = b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestService_PreModerationPerPost(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	assert.False(t, b.IsModerated(locator))
+	require.NoError(t, b.SetModerated(locator, true))
+	assert.True(t, b.IsModerated(locator))
+	assert.False(t, b.IsModerated(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}))
+
+	_, err := b.Create(store.Comment{Text: "pending text", Locator: locator, User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "some text", Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		User: store.User{ID: "user3", Name: "user3"}})
+	require.NoError(t, err)
+
+	pending, err := b.Pending(store.Locator{SiteID: "radio-t"}, 0, 0, store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending))
+	assert.Equal(t, "pending text", pending[0].Text)
+
+	last, err := b.Last("radio-t", 10, time.Time{}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(last), "pending excluded from last")
+
+	require.NoError(t, b.SetModerated(locator, false))
+	assert.False(t, b.IsModerated(locator))
+}
+
+func TestService_Report(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	reports, err := b.Report(loc, "id-1", store.User{ID: "user2"}, store.ReportSpam, "buy now")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reports))
+	assert.Equal(t, "user2", reports[0].UserID)
+	assert.Equal(t, store.ReportSpam, reports[0].Reason)
+	assert.Equal(t, "buy now", reports[0].Text)
+
+	reports, err = b.Report(loc, "id-1", store.User{ID: "user3"}, store.ReportOffTopic, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reports))
+
+	_, err = b.Report(loc, "id-1", store.User{ID: "user3"}, store.ReportAbusive, "")
+	assert.Equal(t, ErrAlreadyReported, err)
+
+	_, err = b.Report(loc, "id-1", store.User{ID: "user1"}, store.ReportAbusive, "")
+	assert.EqualError(t, err, "can't report own comment id-1")
+
+	_, err = b.Report(loc, "id-1", store.User{ID: "user4"}, "boring", "")
+	assert.EqualError(t, err, `invalid report reason "boring"`)
+
+	_, err = b.Report(loc, "id-bad", store.User{ID: "user4"}, store.ReportSpam, "")
+	assert.Error(t, err)
+
+	require.NoError(t, b.Delete(loc, "id-2", store.SoftDelete))
+	_, err = b.Report(loc, "id-2", store.User{ID: "user4"}, store.ReportSpam, "")
+	assert.EqualError(t, err, "comment id-2 deleted")
+}
+
+func TestService_ReportedComments(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Report(loc, "id-2", store.User{ID: "user2"}, store.ReportSpam, "")
+	require.NoError(t, err)
+	_, err = b.Report(loc, "id-1", store.User{ID: "user2"}, store.ReportAbusive, "")
+	require.NoError(t, err)
+	_, err = b.Report(loc, "id-2", store.User{ID: "user3"}, store.ReportSpam, "")
+	require.NoError(t, err)
+
+	res, err := b.ReportedComments("radio-t", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-2", res[0].Comment.ID, "first reported first")
+	assert.Equal(t, 2, len(res[0].Reports))
+	assert.Equal(t, "id-1", res[1].Comment.ID)
+	assert.Equal(t, 1, len(res[1].Reports))
+
+	require.NoError(t, b.DismissReports(loc, "id-1"))
+	require.NoError(t, b.ResolveReports(loc, "id-2"))
+
+	res, err = b.ReportedComments("radio-t", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	c, err := b.Get(loc, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted, "dismissed, comment kept")
+	c, err = b.Get(loc, "id-2", store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted, "resolved, comment deleted")
+
+	_, err = b.ReportedComments("bad", store.User{Admin: true})
+	assert.Error(t, err)
+}
+
 func TestService_DeleteAll(t *testing.T) {
 
 	// two comments for https://radio-t.com, no reply
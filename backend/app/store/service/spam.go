@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/go-pkgz/jrpc"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// SpamChecker checks comments for spam and accepts feedback about wrong decisions
+type SpamChecker interface {
+	Check(req SpamRequest) (spam bool, err error)
+	Feedback(req SpamRequest, spam bool) error // report missed spam (spam=true) or false positive (spam=false)
+}
+
+// SpamRequest is a comment to check with ip of the user made it
+type SpamRequest struct {
+	Comment store.Comment `json:"comment"`
+	UserIP  string        `json:"user_ip,omitempty"` // raw ip of commenter, comment itself keeps hashed one
+}
+
+// SpamAction defines what to do with comment detected as spam
+type SpamAction string
+
+// SpamAction enum
+const (
+	SpamReject  SpamAction = "reject"  // comment rejected with ErrSpamDetected
+	SpamPending SpamAction = "pending" // comment saved as pending, waits for admin approval
+	SpamMark    SpamAction = "mark"    // comment published and marked as spam, mark visible to admins only
+)
+
+// ErrSpamDetected returned in case spam checker rejected the comment
+var ErrSpamDetected = errors.New("comment detected as spam")
+
+// SpamRPC implements SpamChecker and delegates all calls to remote http server
+type SpamRPC struct {
+	jrpc.Client
+}
+
+// Check sends comment to remote checker
+func (r *SpamRPC) Check(req SpamRequest) (spam bool, err error) {
+	resp, err := r.Call("spam.check", req)
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(*resp.Result, &spam)
+	return spam, err
+}
+
+// Feedback sends admin's decision about the comment to remote checker
+func (r *SpamRPC) Feedback(req SpamRequest, spam bool) error {
+	_, err := r.Call("spam.feedback", req, spam)
+	return err
+}
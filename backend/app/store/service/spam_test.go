@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/jrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestSpamRPC_Check(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `"method":"spam.check"`)
+		assert.Contains(t, string(body), `"user_ip":"127.0.0.1"`)
+		_, _ = fmt.Fprint(w, `{"result":true,"id":1}`)
+	}))
+	defer ts.Close()
+
+	c := SpamRPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+	var _ SpamChecker = &c
+
+	spam, err := c.Check(SpamRequest{Comment: store.Comment{ID: "id-1", Text: "buy viagra"}, UserIP: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, spam)
+}
+
+func TestSpamRPC_Feedback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `"method":"spam.feedback"`)
+		assert.True(t, strings.HasSuffix(string(body), `false],"id":1}`), string(body))
+		_, _ = fmt.Fprint(w, `{"id":1}`)
+	}))
+	defer ts.Close()
+
+	c := SpamRPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+	assert.NoError(t, c.Feedback(SpamRequest{Comment: store.Comment{ID: "id-1"}}, false))
+}
+
+func TestService_SpamCheck(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	checker := &mockSpamChecker{}
+	b := DataStore{Engine: eng, SpamChecker: checker,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	newComment := func(text string) store.Comment {
+		return store.Comment{Text: text, Locator: locator, User: store.User{ID: "user3", IP: "192.168.1.1"}}
+	}
+
+	id, err := b.Create(newComment("buy viagra"))
+	require.NoError(t, err, "pending by default")
+	assert.Equal(t, "192.168.1.1", checker.req.UserIP, "raw ip passed to checker")
+	c, err := b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.True(t, c.Pending)
+	assert.True(t, c.Spam)
+
+	b.SpamAction = SpamReject
+	_, err = b.Create(newComment("buy viagra"))
+	assert.Equal(t, ErrSpamDetected, err)
+
+	b.SpamAction = SpamMark
+	id, err = b.Create(newComment("buy viagra"))
+	require.NoError(t, err)
+	c, err = b.Get(locator, id, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+	assert.True(t, c.Spam, "spam mark visible to admin")
+	c, err = b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Spam, "spam mark hidden from others")
+
+	checker.err = errors.New("failed")
+	id, err = b.Create(newComment("buy viagra"))
+	require.NoError(t, err, "comment accepted if checker failed")
+	c, err = b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.False(t, c.Spam)
+	checker.err = nil
+
+	comment := newComment("buy viagra")
+	comment.User.Admin = true
+	id, err = b.Create(comment)
+	require.NoError(t, err)
+	c, err = b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.False(t, c.Spam, "admin's comments not checked")
+
+	b.SpamAction = SpamReject
+	_, err = b.EditComment(locator, "id-1", EditRequest{Text: "buy viagra", UserIP: "10.0.0.1"})
+	assert.Equal(t, ErrSpamDetected, err)
+	assert.Equal(t, "10.0.0.1", checker.req.UserIP)
+	c, err = b.EditComment(locator, "id-1", EditRequest{Text: "good text"})
+	require.NoError(t, err)
+	assert.Equal(t, "good text", c.Text)
+}
+
+func TestService_ReportSpam(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	checker := &mockSpamChecker{}
+	b := DataStore{Engine: eng, SpamChecker: checker,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.ReportSpam(locator, "id-1", true)
+	require.NoError(t, err)
+	assert.True(t, c.Spam)
+	assert.Equal(t, []bool{true}, checker.feedback)
+	assert.Equal(t, "id-1", checker.req.Comment.ID)
+	c, err = b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.True(t, c.Spam)
+
+	c, err = b.ReportSpam(locator, "id-1", false)
+	require.NoError(t, err)
+	assert.False(t, c.Spam)
+	assert.Equal(t, []bool{true, false}, checker.feedback)
+
+	checker.err = errors.New("failed")
+	_, err = b.ReportSpam(locator, "id-1", true)
+	assert.EqualError(t, err, "can't send spam feedback for id-1: failed")
+
+	_, err = b.ReportSpam(locator, "id-bad", true)
+	assert.Error(t, err)
+
+	b.SpamChecker = nil
+	c, err = b.ReportSpam(locator, "id-2", true)
+	require.NoError(t, err, "mark set without checker")
+	assert.True(t, c.Spam)
+}
+
+// mockSpamChecker detects "viagra" as spam and records the last request
+type mockSpamChecker struct {
+	req      SpamRequest
+	feedback []bool
+	err      error
+}
+
+func (m *mockSpamChecker) Check(req SpamRequest) (bool, error) {
+	m.req = req
+	if m.err != nil {
+		return false, m.err
+	}
+	return strings.Contains(req.Comment.Text, "viagra"), nil
+}
+
+func (m *mockSpamChecker) Feedback(req SpamRequest, spam bool) error {
+	m.req = req
+	if m.err != nil {
+		return m.err
+	}
+	m.feedback = append(m.feedback, spam)
+	return nil
+}
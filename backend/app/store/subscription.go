@@ -0,0 +1,33 @@
+package store
+
+import (
+	"time"
+)
+
+// SubscriptionMode defines which notifications about the post user gets
+type SubscriptionMode string
+
+// SubscriptionMode enum
+const (
+	SubscriptionPost SubscriptionMode = "post" // notified about all new comments of the post
+	SubscriptionMute SubscriptionMode = "mute" // not notified about the post or the thread, including replies to own comments
+)
+
+// Subscription keeps user's choice of notifications for the post or for the thread of the post.
+// Post subscriptions have empty ThreadID, thread ones are always mutes. One per post, thread and user
+type Subscription struct {
+	Locator   Locator          `json:"locator" bson:"locator"`
+	UserID    string           `json:"user_id" bson:"user_id"`
+	ThreadID  string           `json:"thread_id,omitempty" bson:"thread_id"` // root comment of muted thread
+	Mode      SubscriptionMode `json:"mode" bson:"mode"`
+	Timestamp time.Time        `json:"time" bson:"time"`
+}
+
+// Valid checks if mode is one of supported
+func (m SubscriptionMode) Valid() bool {
+	switch m {
+	case SubscriptionPost, SubscriptionMute:
+		return true
+	}
+	return false
+}
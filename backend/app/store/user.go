@@ -24,6 +24,7 @@ type User struct {
 	Verified          bool   `json:"verified,omitempty"`
 	EmailSubscription bool   `json:"email_subscription,omitempty"`
 	SiteID            string `json:"site_id,omitempty"`
+	Role              string `json:"role,omitempty"` // owner or moderator, returned by user info only
 }
 
 var reValidSha = regexp.MustCompile("^[a-fA-F0-9]{40}$")
@@ -0,0 +1,142 @@
+// Package stream provides broker of real-time post updates, consumed by websocket and sse clients.
+// Events published on any instance delivered to subscribers of all instances via optional eventbus.PubSub
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-pkgz/lcw/eventbus"
+	log "github.com/go-pkgz/lgr"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// EventType defines type of post update
+type EventType string
+
+// EventType enum
+const (
+	EvCreated EventType = "created"
+	EvEdited  EventType = "edited"
+	EvDeleted EventType = "deleted"
+	EvVoted   EventType = "voted"
+	EvPinned  EventType = "pinned"
+)
+
+// Event is a single update of the post. Comment is the public view of changed comment, empty for deleted one
+type Event struct {
+	Type      EventType      `json:"type"`
+	Locator   store.Locator  `json:"locator"`
+	CommentID string         `json:"id"`
+	Comment   *store.Comment `json:"comment,omitempty"`
+}
+
+const defaultBufferSize = 100
+
+// Broker delivers events to subscribers of the post. Slow subscribers lose events
+// overflowing their buffer, publisher never blocks
+type Broker struct {
+	BufferSize int // per subscriber, defaultBufferSize if 0
+
+	id     string // instance id, used to skip own events coming back from pubSub
+	pubSub eventbus.PubSub
+
+	lock sync.RWMutex
+	subs map[store.Locator]map[chan Event]struct{}
+}
+
+// NewBroker makes broker and subscribes it to pubSub events of other instances. pubSub is optional
+func NewBroker(pubSub eventbus.PubSub) (*Broker, error) {
+	if pubSub == nil {
+		pubSub = &eventbus.NopPubSub{}
+	}
+	res := &Broker{id: uuid.New().String(), pubSub: pubSub, subs: map[store.Locator]map[chan Event]struct{}{}}
+	if err := pubSub.Subscribe(res.onRemote); err != nil {
+		return nil, errors.Wrap(err, "can't subscribe to stream events")
+	}
+	return res, nil
+}
+
+// Publish delivers event to local subscribers and sends it to other instances
+func (b *Broker) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.deliver(ev)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[WARN] can't marshal stream event %+v, %v", ev, err)
+		return
+	}
+	if err = b.pubSub.Publish(b.id, string(data)); err != nil {
+		log.Printf("[WARN] can't publish stream event for %s, %v", ev.CommentID, err)
+	}
+}
+
+// Subscribe returns channel with events of the post. cancel func should be called to release the subscription
+func (b *Broker) Subscribe(locator store.Locator) (events <-chan Event, cancel func()) {
+	bufSize := b.BufferSize
+	if bufSize <= 0 {
+		bufSize = defaultBufferSize
+	}
+	ch := make(chan Event, bufSize)
+
+	b.lock.Lock()
+	if _, ok := b.subs[locator]; !ok {
+		b.subs[locator] = map[chan Event]struct{}{}
+	}
+	b.subs[locator][ch] = struct{}{}
+	b.lock.Unlock()
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subs[locator], ch)
+			if len(b.subs[locator]) == 0 {
+				delete(b.subs, locator)
+			}
+			b.lock.Unlock()
+		})
+	}
+	return ch, cancel
+}
+
+// Subscribers returns number of active subscribers
+func (b *Broker) Subscribers() (count int) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, s := range b.subs {
+		count += len(s)
+	}
+	return count
+}
+
+// onRemote handles events published by other instances
+func (b *Broker) onRemote(fromID, data string) {
+	if fromID == b.id {
+		return
+	}
+	ev := Event{}
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		log.Printf("[WARN] can't unmarshal stream event from %s, %v", fromID, err)
+		return
+	}
+	b.deliver(ev)
+}
+
+func (b *Broker) deliver(ev Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for ch := range b.subs[ev.Locator] {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("[DEBUG] stream subscriber of %s is too slow, event %s for %s dropped", ev.Locator.URL, ev.Type, ev.CommentID)
+		}
+	}
+}
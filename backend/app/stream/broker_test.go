@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	b, err := NewBroker(nil)
+	require.NoError(t, err)
+
+	post1 := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/p1"}
+	post2 := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/p2"}
+	ch1, cancel1 := b.Subscribe(post1)
+	ch2, cancel2 := b.Subscribe(post1)
+	ch3, cancel3 := b.Subscribe(post2)
+	defer cancel3()
+	assert.Equal(t, 3, b.Subscribers())
+
+	b.Publish(Event{Type: EvCreated, Locator: post1, CommentID: "id-1", Comment: &store.Comment{ID: "id-1", Text: "text"}})
+	for _, ch := range []<-chan Event{ch1, ch2} {
+		select {
+		case ev := <-ch:
+			assert.Equal(t, EvCreated, ev.Type)
+			assert.Equal(t, "id-1", ev.CommentID)
+			assert.Equal(t, "text", ev.Comment.Text)
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+	}
+	assert.Equal(t, 0, len(ch3), "other post not affected")
+
+	cancel1()
+	cancel1()
+	cancel2()
+	assert.Equal(t, 1, b.Subscribers())
+	b.Publish(Event{Type: EvDeleted, Locator: post1, CommentID: "id-1"})
+	assert.Equal(t, 0, len(ch1))
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b, err := NewBroker(nil)
+	require.NoError(t, err)
+	b.BufferSize = 2
+
+	post := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/p1"}
+	ch, cancel := b.Subscribe(post)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Type: EvVoted, Locator: post, CommentID: "id-1"})
+	}
+	assert.Equal(t, 2, len(ch), "overflow dropped")
+}
+
+func TestBroker_FanOut(t *testing.T) {
+	bus := &memPubSub{}
+	b1, err := NewBroker(bus)
+	require.NoError(t, err)
+	b2, err := NewBroker(bus)
+	require.NoError(t, err)
+
+	post := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/p1"}
+	ch1, cancel1 := b1.Subscribe(post)
+	defer cancel1()
+	ch2, cancel2 := b2.Subscribe(post)
+	defer cancel2()
+
+	b1.Publish(Event{Type: EvPinned, Locator: post, CommentID: "id-1", Comment: &store.Comment{ID: "id-1", Pin: true}})
+	ev := <-ch2
+	assert.Equal(t, EvPinned, ev.Type)
+	assert.True(t, ev.Comment.Pin)
+	assert.Equal(t, 1, len(ch1), "delivered locally once, own event from bus ignored")
+}
+
+// memPubSub implements eventbus.PubSub in memory, delivers to all subscribers including publisher
+type memPubSub struct {
+	sync.Mutex
+	fns []func(fromID, key string)
+}
+
+func (m *memPubSub) Publish(fromID, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, fn := range m.fns {
+		fn(fromID, key)
+	}
+	return nil
+}
+
+func (m *memPubSub) Subscribe(fn func(fromID, key string)) error {
+	m.Lock()
+	defer m.Unlock()
+	m.fns = append(m.fns, fn)
+	return nil
+}
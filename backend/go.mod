@@ -21,10 +21,13 @@ require (
 	github.com/go-pkgz/syncs v1.1.1
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/feeds v1.1.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/hashicorp/go-multierror v1.1.0
 	github.com/kyokomi/emoji/v2 v2.2.8
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/microcosm-cc/bluemonday v1.0.9
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/rakyll/statik v0.1.7
 	github.com/rs/xid v1.2.1
 	github.com/russross/blackfriday/v2 v2.1.0
@@ -32,8 +35,11 @@ require (
 	github.com/stretchr/testify v1.7.0
 	github.com/umputun/go-flags v1.5.1
 	go.etcd.io/bbolt v1.3.5
+	go.mongodb.org/mongo-driver v1.5.2
 	go.uber.org/goleak v1.0.0
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	golang.org/x/image v0.0.0-20210504121937-7319ad40d33e
 	golang.org/x/net v0.0.0-20210423184538-5f58ad60dda6
+	golang.org/x/oauth2 v0.0.0-20210427180440-81ed05c6b58c
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )